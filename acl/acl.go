@@ -0,0 +1,233 @@
+package acl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"go-redis/config"
+)
+
+// DefaultUser 默认用户, 没有通过 AUTH 指定用户的客户端都是默认用户
+const DefaultUser = "default"
+
+var (
+	mu    sync.RWMutex
+	users map[string]*User
+)
+
+var (
+	ErrWrongPass       = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	ErrDeleteDefault   = errors.New("The 'default' user cannot be removed")
+	ErrNoACLFile       = errors.New("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+	errInvalidUsername = errors.New("Usernames can't contain spaces or null characters")
+)
+
+func init() {
+	users = map[string]*User{DefaultUser: newDefaultUser()}
+}
+
+// Setup 在加载配置之后初始化用户: 根据 require-pass 重新创建默认用户, 配置了 aclfile 时从文件加载用户
+func Setup() error {
+	mu.Lock()
+	users = map[string]*User{DefaultUser: newDefaultUser()}
+	mu.Unlock()
+	if config.Properties.AclFile == "" {
+		return nil
+	}
+	if _, err := os.Stat(config.Properties.AclFile); os.IsNotExist(err) {
+		return nil
+	}
+	return LoadFile()
+}
+
+// newDefaultUser 创建默认用户, 可以执行所有命令, 配置了 require-pass 时需要密码
+func newDefaultUser() *User {
+	u := newUser(DefaultUser)
+	rules := []string{"on", "nopass", "~*", "&*", "+@all"}
+	if pass := config.RequirePass(); pass != "" {
+		rules = append(rules, ">"+pass)
+	}
+	for _, rule := range rules {
+		_ = u.applyRule(rule)
+	}
+	return u
+}
+
+// UpdateDefaultPassword 修改 require-pass 之后更新默认用户的密码, 为空时默认用户不需要密码
+//
+// 只修改密码, 默认用户的其他规则保持不变
+func UpdateDefaultPassword(password string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	u, ok := users[DefaultUser]
+	if !ok {
+		u = newUser(DefaultUser)
+	} else {
+		u = u.clone()
+	}
+	_ = u.applyRule("resetpass")
+	if password == "" {
+		_ = u.applyRule("nopass")
+	} else {
+		_ = u.applyRule(">" + password)
+	}
+	users[DefaultUser] = u
+}
+
+// GetUser 返回用户, 用户不存在时返回nil
+//
+// 返回的用户不会再被修改, SETUSER 会替换成新的用户
+func GetUser(name string) *User {
+	mu.RLock()
+	defer mu.RUnlock()
+	return users[name]
+}
+
+// Authenticate 验证用户名和密码, 用户不存在, 被禁用或者密码错误时返回 ErrWrongPass
+func Authenticate(name, password string) (*User, error) {
+	u := GetUser(name)
+	if u == nil || !u.enabled || !u.checkPassword(password) {
+		return nil, ErrWrongPass
+	}
+	return u, nil
+}
+
+// SetUser 创建或者修改用户, 所有规则都合法时才会生效
+func SetUser(name string, rules []string) error {
+	if strings.ContainsAny(name, " \x00") {
+		return errInvalidUsername
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	u, ok := users[name]
+	if ok {
+		u = u.clone()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := u.applyRule(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", rule, err)
+		}
+	}
+	users[name] = u
+	return nil
+}
+
+// DeleteUser 删除用户, 返回删除的数量, 不能删除默认用户
+func DeleteUser(names ...string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, name := range names {
+		if name == DefaultUser {
+			return 0, ErrDeleteDefault
+		}
+	}
+	deleted := 0
+	for _, name := range names {
+		if _, ok := users[name]; ok {
+			delete(users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Users 返回所有用户, 按用户名排序
+func Users() []*User {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]*User, 0, len(users))
+	for _, u := range users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+// List 返回所有用户的描述, 格式和ACL文件一致, 例如 user default on nopass ~* &* +@all
+func List() []string {
+	list := Users()
+	lines := make([]string, len(list))
+	for i, u := range list {
+		lines[i] = "user " + u.name + " " + u.Describe()
+	}
+	return lines
+}
+
+// LoadFile 从 aclfile 加载用户, 文件中有错误时不修改已有的用户
+//
+// 每行的格式是 user <username> <rules...>, 文件中没有默认用户时使用根据配置创建的默认用户
+func LoadFile() error {
+	if config.Properties.AclFile == "" {
+		return ErrNoACLFile
+	}
+	file, err := os.Open(config.Properties.AclFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// 1. 解析所有用户
+	loaded := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d should start with user keyword", config.Properties.AclFile, lineNum)
+		}
+		if _, ok := loaded[fields[1]]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", config.Properties.AclFile, lineNum, fields[1])
+		}
+		u := newUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err = u.applyRule(rule); err != nil {
+				return fmt.Errorf("%s:%d: %s. ", config.Properties.AclFile, lineNum, err)
+			}
+		}
+		loaded[u.name] = u
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	// 2. 替换所有用户, 已经存在的用户保持原来的id, 和 SETUSER 一样不影响已经验证的连接
+	if _, ok := loaded[DefaultUser]; !ok {
+		loaded[DefaultUser] = newDefaultUser()
+	}
+	mu.Lock()
+	for name, u := range loaded {
+		if old, ok := users[name]; ok {
+			u.id = old.id
+		}
+	}
+	users = loaded
+	mu.Unlock()
+	return nil
+}
+
+// SaveFile 把所有用户保存到 aclfile, 先写入临时文件再重命名, 避免写入失败时破坏原来的文件
+func SaveFile() error {
+	if config.Properties.AclFile == "" {
+		return ErrNoACLFile
+	}
+	tmp := config.Properties.AclFile + ".tmp"
+	content := strings.Join(List(), "\n") + "\n"
+	if err := os.WriteFile(tmp, []byte(content), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, config.Properties.AclFile)
+}
@@ -0,0 +1,130 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-redis/config"
+)
+
+func TestUserRules(t *testing.T) {
+	defer DeleteUser("alice")
+
+	if err := SetUser("alice", []string{"on", ">secret", "~cache:*", "%R~shared:*", "&news.*", "+@read", "-get", "+client|id"}); err != nil {
+		t.Fatal(err)
+	}
+	u := GetUser("alice")
+	if _, err := Authenticate("alice", "secret"); err != nil {
+		t.Errorf("authenticate failed: %v", err)
+	}
+	if _, err := Authenticate("alice", "wrong"); err != ErrWrongPass {
+		t.Errorf("expected wrong pass, actually %v", err)
+	}
+
+	tests := []struct {
+		cmd, sub string
+		expected bool
+	}{
+		{"mget", "", true},
+		{"GET", "", false},
+		{"set", "", false},
+		{"client", "id", true},
+		{"client", "kill", false},
+	}
+	for _, test := range tests {
+		if u.CanExecute(test.cmd, test.sub) != test.expected {
+			t.Errorf("CanExecute(%s, %s) expected %v", test.cmd, test.sub, test.expected)
+		}
+	}
+	if !u.CanAccessKey("cache:1", true) || !u.CanAccessKey("shared:1", false) {
+		t.Errorf("expected key access")
+	}
+	if u.CanAccessKey("shared:1", true) || u.CanAccessKey("other", false) {
+		t.Errorf("unexpected key access")
+	}
+	if !u.CanAccessChannel("news.tech", false) || u.CanAccessChannel("sport", false) {
+		t.Errorf("unexpected channel access")
+	}
+	if !u.CanAccessChannel("news.*", true) || u.CanAccessChannel("news.t*", true) {
+		t.Errorf("pattern must be identical to the user's pattern")
+	}
+
+	// 规则错误时整个 SETUSER 都不生效
+	if err := SetUser("alice", []string{"off", "+nosuchcommand"}); err == nil {
+		t.Errorf("expected error")
+	}
+	if !GetUser("alice").Enabled() {
+		t.Errorf("user should not change on error")
+	}
+	if _, err := DeleteUser(DefaultUser); err != ErrDeleteDefault {
+		t.Errorf("expected %v, actually %v", ErrDeleteDefault, err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	defer DeleteUser("bob")
+
+	if desc := GetUser(DefaultUser).Describe(); desc != "on nopass ~* &* +@all" {
+		t.Errorf("unexpected default user: %s", desc)
+	}
+	if err := SetUser("bob", []string{"on", ">pass", "~k*", "+@all", "-flushall"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "on #" + hashPassword("pass") + " ~k* resetchannels +@all -flushall"
+	if desc := GetUser("bob").Describe(); desc != expected {
+		t.Errorf("expected %s, actually %s", expected, desc)
+	}
+}
+
+func TestLoadAndSave(t *testing.T) {
+	defer func() {
+		config.Properties.AclFile = ""
+		_ = Setup()
+	}()
+
+	config.Properties.AclFile = filepath.Join(t.TempDir(), "users.acl")
+	if err := SetUser("carol", []string{"on", ">pass", "~*", "+get"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	DeleteUser("carol")
+	if err := LoadFile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate("carol", "pass"); err != nil {
+		t.Errorf("user should be loaded from file: %v", err)
+	}
+
+	// 文件有错误时保留已有的用户
+	if err := os.WriteFile(config.Properties.AclFile, []byte("user dave on +nosuchcommand\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(); err == nil {
+		t.Errorf("expected error")
+	}
+	if GetUser("carol") == nil {
+		t.Errorf("users should not change on error")
+	}
+}
+
+func TestLog(t *testing.T) {
+	defer ResetLog()
+
+	ResetLog()
+	AddLog(ReasonCommand, ContextTopLevel, "flushall", "alice", "id=1")
+	AddLog(ReasonCommand, ContextTopLevel, "flushall", "alice", "id=2")
+	AddLog(ReasonKey, ContextMulti, "secret", "alice", "id=2")
+	entries := Logs(-1)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, actually %d", len(entries))
+	}
+	if entries[0].Reason != ReasonKey || entries[1].Count != 2 || entries[1].ClientInfo != "id=2" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	if len(Logs(1)) != 1 {
+		t.Errorf("expected 1 entry")
+	}
+}
@@ -0,0 +1,104 @@
+package acl
+
+import (
+	"sync"
+	"time"
+
+	"go-redis/config"
+)
+
+// 拒绝的原因
+const (
+	ReasonAuth    = "auth"
+	ReasonCommand = "command"
+	ReasonKey     = "key"
+	ReasonChannel = "channel"
+)
+
+// 被拒绝的命令所在的上下文
+const (
+	ContextTopLevel = "toplevel"
+	ContextMulti    = "multi"
+)
+
+// logGroupWindow 相同的拒绝在这个时间内只更新已有的记录, 和Redis的ACL_LOG_GROUPING_MAX_TIME_DELTA一致
+const logGroupWindow = 60 * time.Second
+
+// LogEntry ACL LOG 中的一条记录, 记录被拒绝的命令和失败的认证
+type LogEntry struct {
+	Count       int64     // 相同的拒绝发生的次数
+	Reason      string    // 拒绝的原因: auth, command, key, channel
+	Context     string    // 拒绝时的上下文: toplevel, multi
+	Object      string    // 被拒绝的命令, 键或者频道, 认证失败时是 AUTH
+	Username    string    // 执行命令或者认证的用户
+	ClientInfo  string    // 客户端信息
+	EntryID     int64     // 递增的记录ID
+	CreatedAt   time.Time // 第一次拒绝的时间
+	LastUpdated time.Time // 最后一次拒绝的时间
+}
+
+var (
+	logMu       sync.Mutex
+	logEntries  []*LogEntry // 最新的记录在最前面
+	nextEntryID int64
+)
+
+// AddLog 添加一条拒绝记录, 和最近60秒内相同的记录合并
+//
+// 记录的数量超过 acllog-max-len 时删除最旧的记录
+func AddLog(reason, context, object, username, clientInfo string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	now := time.Now()
+	// 1. 合并相同的记录
+	for _, entry := range logEntries {
+		if entry.Reason == reason && entry.Context == context && entry.Object == object &&
+			entry.Username == username && now.Sub(entry.LastUpdated) < logGroupWindow {
+			entry.Count++
+			entry.ClientInfo = clientInfo
+			entry.LastUpdated = now
+			return
+		}
+	}
+	// 2. 添加新的记录
+	entry := &LogEntry{
+		Count:       1,
+		Reason:      reason,
+		Context:     context,
+		Object:      object,
+		Username:    username,
+		ClientInfo:  clientInfo,
+		EntryID:     nextEntryID,
+		CreatedAt:   now,
+		LastUpdated: now,
+	}
+	nextEntryID++
+	logEntries = append([]*LogEntry{entry}, logEntries...)
+	// 3. 删除超出长度的记录
+	if maxLen := config.Properties.AclLogMaxLen; maxLen >= 0 && len(logEntries) > maxLen {
+		logEntries = logEntries[:maxLen]
+	}
+}
+
+// Logs 返回最新的count条记录, count小于0时返回所有记录
+func Logs(count int) []LogEntry {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if count < 0 || count > len(logEntries) {
+		count = len(logEntries)
+	}
+	entries := make([]LogEntry, count)
+	for i := range entries {
+		entries[i] = *logEntries[i]
+	}
+	return entries
+}
+
+// ResetLog 清空所有记录
+func ResetLog() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	logEntries = nil
+}
@@ -0,0 +1,441 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"go-redis/enum"
+	"go-redis/lib/wildcard"
+)
+
+// 键的访问权限
+const (
+	permRead  = 1 << iota // %R~ 可以读取
+	permWrite             // %W~ 可以写入
+	permAll   = permRead | permWrite
+)
+
+var (
+	errSyntax         = errors.New("Syntax error")
+	errUnknownCommand = errors.New("Unknown command or category name in ACL")
+	errBadHash        = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errNoSuchPassword = errors.New("no such password")
+)
+
+// keyPattern 用户可以访问的键的模式
+type keyPattern struct {
+	src     string
+	pattern *wildcard.Pattern
+	perm    int
+}
+
+// User 是一个ACL用户, 保存密码的哈希值, 可以执行的命令, 可以访问的键和频道
+//
+// 新用户默认是禁用的, 没有密码, 不能执行任何命令, 不能访问任何键和频道
+type User struct {
+	id        uint64 // 创建用户时分配, 修改用户时不变, 删除之后重新创建的用户id不同
+	name      string
+	enabled   bool
+	nopass    bool                // 不需要密码, 任何密码都可以通过验证
+	passwords map[string]struct{} // 密码的SHA256哈希值
+
+	commands    map[string]struct{}            // 可以执行的命令, 小写的命令名称
+	subcommands map[string]map[string]struct{} // 命令不能执行时, 可以执行的子命令
+	cmdRules    []string                       // 修改命令权限的规则, 用于描述用户
+
+	allKeys     bool
+	keys        []keyPattern
+	allChannels bool
+	channels    []string
+}
+
+// nextUserID 上一个创建的用户的id
+var nextUserID atomic.Uint64
+
+// newUser 创建禁用的新用户
+func newUser(name string) *User {
+	return &User{
+		id:          nextUserID.Add(1),
+		name:        name,
+		passwords:   make(map[string]struct{}),
+		commands:    make(map[string]struct{}),
+		subcommands: make(map[string]map[string]struct{}),
+	}
+}
+
+// clone 复制用户, SETUSER 在副本上修改, 所有规则都合法之后再替换
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = maps.Clone(u.passwords)
+	c.commands = maps.Clone(u.commands)
+	c.subcommands = make(map[string]map[string]struct{}, len(u.subcommands))
+	for cmd, subs := range u.subcommands {
+		c.subcommands[cmd] = maps.Clone(subs)
+	}
+	c.cmdRules = slices.Clone(u.cmdRules)
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+	return &c
+}
+
+// Name 返回用户名
+func (u *User) Name() string {
+	return u.name
+}
+
+// ID 返回用户的id, 连接验证时记录id, 用户被删除之后重新创建时已经验证的连接失效
+func (u *User) ID() uint64 {
+	return u.id
+}
+
+// Enabled 返回用户是否启用
+func (u *User) Enabled() bool {
+	return u.enabled
+}
+
+// NoPass 返回用户是否不需要密码
+func (u *User) NoPass() bool {
+	return u.nopass
+}
+
+// hashPassword 返回密码的SHA256哈希值, 用十六进制表示
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPassword 验证密码
+func (u *User) checkPassword(password string) bool {
+	if u.nopass {
+		return true
+	}
+	_, ok := u.passwords[hashPassword(password)]
+	return ok
+}
+
+// applyRule 应用一条规则, 规则的语法和Redis的 ACL SETUSER 一致
+func (u *User) applyRule(rule string) error {
+	lower := strings.ToLower(rule)
+	switch lower {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass = true
+		clear(u.passwords)
+	case "resetpass":
+		u.nopass = false
+		clear(u.passwords)
+	case "allkeys":
+		u.allKeys, u.keys = true, nil
+	case "resetkeys":
+		u.allKeys, u.keys = false, nil
+	case "allchannels":
+		u.allChannels, u.channels = true, nil
+	case "resetchannels":
+		u.allChannels, u.channels = false, nil
+	case "allcommands", "+@all":
+		u.setAllCommands(true)
+	case "nocommands", "-@all":
+		u.setAllCommands(false)
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			_ = u.applyRule(r)
+		}
+	default:
+		return u.applyComplexRule(rule)
+	}
+	return nil
+}
+
+// applyComplexRule 应用带参数的规则: 密码, 键, 频道和命令
+func (u *User) applyComplexRule(rule string) error {
+	if rule == "" {
+		return errSyntax
+	}
+	switch rule[0] {
+	case '>': // 添加密码
+		u.passwords[hashPassword(rule[1:])] = struct{}{}
+		u.nopass = false
+	case '<': // 删除密码
+		if _, ok := u.passwords[hashPassword(rule[1:])]; !ok {
+			return errNoSuchPassword
+		}
+		delete(u.passwords, hashPassword(rule[1:]))
+	case '#': // 添加密码的哈希值
+		if !isValidHash(rule[1:]) {
+			return errBadHash
+		}
+		u.passwords[rule[1:]] = struct{}{}
+		u.nopass = false
+	case '!': // 删除密码的哈希值
+		if !isValidHash(rule[1:]) {
+			return errBadHash
+		}
+		if _, ok := u.passwords[rule[1:]]; !ok {
+			return errNoSuchPassword
+		}
+		delete(u.passwords, rule[1:])
+	case '~': // 可以读写的键
+		u.addKeyPattern(rule[1:], permAll)
+	case '%': // 只读或者只写的键, 例如 %R~cache:*
+		i := strings.IndexByte(rule, '~')
+		if i < 2 {
+			return errSyntax
+		}
+		perm := 0
+		for _, c := range strings.ToUpper(rule[1:i]) {
+			switch c {
+			case 'R':
+				perm |= permRead
+			case 'W':
+				perm |= permWrite
+			default:
+				return errSyntax
+			}
+		}
+		u.addKeyPattern(rule[i+1:], perm)
+	case '&': // 可以访问的频道
+		if rule[1:] == "*" {
+			u.allChannels, u.channels = true, nil
+		} else if !u.allChannels && !slices.Contains(u.channels, rule[1:]) {
+			u.channels = append(u.channels, rule[1:])
+		}
+	case '+', '-': // 命令和类别
+		return u.applyCommandRule(rule[0] == '+', rule[1:])
+	default:
+		return errSyntax
+	}
+	return nil
+}
+
+func isValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// addKeyPattern 添加键的模式, 可以读写所有键时不需要再添加
+func (u *User) addKeyPattern(pattern string, perm int) {
+	if u.allKeys {
+		return
+	}
+	if pattern == "*" && perm == permAll {
+		u.allKeys, u.keys = true, nil
+		return
+	}
+	u.keys = append(u.keys, keyPattern{src: pattern, pattern: wildcard.CompilePattern(pattern), perm: perm})
+}
+
+// setAllCommands 允许或者禁止所有命令, 之前的命令规则都被覆盖
+func (u *User) setAllCommands(allowed bool) {
+	clear(u.commands)
+	clear(u.subcommands)
+	if allowed {
+		for _, cmd := range enum.Commands() {
+			u.commands[strings.ToLower(cmd.Name())] = struct{}{}
+		}
+		u.cmdRules = []string{"+@all"}
+	} else {
+		u.cmdRules = []string{"-@all"}
+	}
+}
+
+// applyCommandRule 应用 +command, -command, +@category, -@category, +command|subcommand
+func (u *User) applyCommandRule(allowed bool, name string) error {
+	prefix := "-"
+	if allowed {
+		prefix = "+"
+	}
+	name = strings.ToLower(name)
+	// 1. 类别
+	if category, ok := strings.CutPrefix(name, "@"); ok {
+		flag, ok := enum.LookupCategory(category)
+		if !ok {
+			return errUnknownCommand
+		}
+		for _, cmd := range enum.Commands() {
+			if cmd.Categories()&flag != 0 {
+				u.setCommand(strings.ToLower(cmd.Name()), allowed)
+			}
+		}
+		u.cmdRules = append(u.cmdRules, prefix+name)
+		return nil
+	}
+	// 2. 子命令, 只能允许不能禁止
+	if cmdName, sub, ok := strings.Cut(name, "|"); ok {
+		if _, found := enum.LookupCommand(cmdName); !found || sub == "" || strings.Contains(sub, "|") {
+			return errUnknownCommand
+		}
+		if !allowed {
+			return errors.New("Removing a subcommand is not supported")
+		}
+		if _, all := u.commands[cmdName]; !all {
+			if u.subcommands[cmdName] == nil {
+				u.subcommands[cmdName] = make(map[string]struct{})
+			}
+			u.subcommands[cmdName][sub] = struct{}{}
+		}
+		u.cmdRules = append(u.cmdRules, prefix+name)
+		return nil
+	}
+	// 3. 命令
+	if _, found := enum.LookupCommand(name); !found {
+		return errUnknownCommand
+	}
+	u.setCommand(name, allowed)
+	u.cmdRules = append(u.cmdRules, prefix+name)
+	return nil
+}
+
+func (u *User) setCommand(name string, allowed bool) {
+	if allowed {
+		u.commands[name] = struct{}{}
+	} else {
+		delete(u.commands, name)
+	}
+	delete(u.subcommands, name)
+}
+
+// CanExecute 判断用户是否可以执行命令, subcommand为空表示命令没有子命令
+func (u *User) CanExecute(cmdName, subcommand string) bool {
+	cmdName = strings.ToLower(cmdName)
+	if _, ok := u.commands[cmdName]; ok {
+		return true
+	}
+	if subcommand == "" {
+		return false
+	}
+	_, ok := u.subcommands[cmdName][strings.ToLower(subcommand)]
+	return ok
+}
+
+// HasSubcommandRules 判断是否单独允许了命令的某些子命令
+func (u *User) HasSubcommandRules(cmdName string) bool {
+	return len(u.subcommands[strings.ToLower(cmdName)]) > 0
+}
+
+// CanAccessKey 判断用户是否可以读取或者写入键
+func (u *User) CanAccessKey(key string, write bool) bool {
+	if u.allKeys {
+		return true
+	}
+	perm := permRead
+	if write {
+		perm = permWrite
+	}
+	for _, k := range u.keys {
+		if k.perm&perm != 0 && k.pattern.IsMatch(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessChannel 判断用户是否可以访问频道, isPattern表示channel是 PSUBSCRIBE 的模式, 模式必须和用户的模式完全相同
+func (u *User) CanAccessChannel(channel string, isPattern bool) bool {
+	if u.allChannels {
+		return true
+	}
+	for _, pattern := range u.channels {
+		if isPattern {
+			if pattern == channel {
+				return true
+			}
+		} else if wildcard.CompilePattern(pattern).IsMatch(channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flags 返回用户的标志, 用于 ACL GETUSER
+func (u *User) Flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// Passwords 返回密码的哈希值, 按字典序排列
+func (u *User) Passwords() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for hash := range u.passwords {
+		hashes = append(hashes, hash)
+	}
+	slices.Sort(hashes)
+	return hashes
+}
+
+// CommandRules 返回命令权限的描述, 例如 +@all -flushall
+func (u *User) CommandRules() string {
+	if len(u.cmdRules) == 0 {
+		return "-@all"
+	}
+	return strings.Join(u.cmdRules, " ")
+}
+
+// KeyRules 返回键权限的描述, 例如 ~* %R~cache:*
+func (u *User) KeyRules() string {
+	if u.allKeys {
+		return "~*"
+	}
+	rules := make([]string, len(u.keys))
+	for i, k := range u.keys {
+		switch k.perm {
+		case permRead:
+			rules[i] = "%R~" + k.src
+		case permWrite:
+			rules[i] = "%W~" + k.src
+		default:
+			rules[i] = "~" + k.src
+		}
+	}
+	return strings.Join(rules, " ")
+}
+
+// ChannelRules 返回频道权限的描述, 例如 &*
+func (u *User) ChannelRules() string {
+	if u.allChannels {
+		return "&*"
+	}
+	rules := make([]string, len(u.channels))
+	for i, channel := range u.channels {
+		rules[i] = "&" + channel
+	}
+	return strings.Join(rules, " ")
+}
+
+// Describe 返回可以重新创建用户的规则, 例如 on nopass ~* &* +@all, 用于 ACL LIST 和ACL文件
+func (u *User) Describe() string {
+	rules := u.Flags()
+	for _, hash := range u.Passwords() {
+		rules = append(rules, "#"+hash)
+	}
+	if keys := u.KeyRules(); keys != "" {
+		rules = append(rules, keys)
+	}
+	if channels := u.ChannelRules(); channels != "" {
+		rules = append(rules, channels)
+	} else {
+		rules = append(rules, "resetchannels")
+	}
+	rules = append(rules, u.CommandRules())
+	return strings.Join(rules, " ")
+}
@@ -4,11 +4,13 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"time"
 
 	"go-redis/config"
 	"go-redis/enum"
 	"go-redis/interface/db"
 	"go-redis/interface/resp"
+	"go-redis/latency"
 	"go-redis/lib/logger"
 	"go-redis/lib/utils"
 	"go-redis/resp/connection"
@@ -72,7 +74,9 @@ func (handler *Handler) Save() {
 
 		b := reply.NewMultiBulkReply(p.cmdLine).Bytes()
 
+		start := time.Now()
 		_, err := handler.aofFile.Write(b)
+		latency.AddSampleIfNeeded(latency.EventAofWrite, time.Since(start))
 		if err != nil {
 			logger.Error(err)
 		}
@@ -88,25 +92,25 @@ func (handler *Handler) Load() {
 	}
 	defer file.Close()
 
-	ch := parser.ParseStream(file)
+	reader := parser.NewRequestReader(file)
 	fakeConnection := &connection.RespConnection{}
-	for p := range ch {
-		if p.Err != nil {
-			if p.Err == io.EOF {
+	for {
+		data, fatal, err := reader.ReadReply()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			logger.Error(err)
+			if fatal { // aof文件不完整或者已经损坏, 无法继续读取
 				break
 			}
-			logger.Error(p.Err)
-			continue
-		}
-
-		if p.Data == nil {
-			logger.Error("aof load data is nil")
 			continue
 		}
 
-		r, ok := p.Data.(*reply.MultiBulkReply)
+		r, ok := data.(*reply.MultiBulkReply)
 		if !ok {
 			logger.Error("aof load data type error")
+			continue
 		}
 		result := handler.database.Exec(fakeConnection, r.Args)
 		if reply.IsErrReply(result) {
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
 	"runtime/debug"
@@ -20,11 +21,15 @@ import (
 
 // Client 是一个Pipeline模式的客户端
 type Client struct {
-	conn        net.Conn      // 与服务端的链接
-	pendingReqs chan *request // 等待发送的请求队列
-	waitingReqs chan *request // 等待服务器响应的请求队列
-	ticker      *time.Ticker  // 发送心跳的计时器
-	addr        string        // 服务器地址
+	conn        net.Conn               // 与服务端的链接
+	pendingReqs chan *request          // 等待发送的请求队列
+	waitingReqs chan *request          // 等待服务器响应的请求队列
+	ticker      *time.Ticker           // 发送心跳的计时器
+	addr        string                 // 服务器地址
+	tlsConfig   *tls.Config            // 不为nil时使用TLS连接服务器
+	timeout     time.Duration          // 连接服务器和等待回复的最长时间
+	onPush      func(*reply.PushReply) // 处理RESP3推送消息的回调, 推送消息不对应任何请求
+	cluster     *clusterState          // 不为nil时是集群模式, 命令发送给负责key的节点
 
 	working *sync.WaitGroup // 统计未完成的任务, 包括未发送和未响应的请求
 }
@@ -35,31 +40,68 @@ type request struct {
 	args      [][]byte   // 请求参数
 	reply     resp.Reply // 请求回复
 	heartbeat bool       // 标记是否为心跳请求
+	asking    bool       // 在命令之前发送 ASKING, 两条命令一起写入连接
 	waiting   *wait.Wait // 调用协程发送请求后通过 waitgroup 等待请求异步处理完成
 	err       error      // 错误信息
 }
 
 const (
-	chanSize  = 1 << 8           // 请求队列的容量
-	maxWait   = 3 * time.Second  // 最大的等待时间
-	network   = "tcp"            // 网络链接方式
-	heartbeat = 10 * time.Second // 发送心跳的间隔
+	chanSize   = 1 << 8           // 请求队列的容量
+	maxWait    = 3 * time.Second  // 默认的等待时间
+	network    = "tcp"            // 网络链接方式
+	unixPrefix = "unix:"          // unix socket地址的前缀
+	heartbeat  = 10 * time.Second // 发送心跳的间隔
 )
 
 // NewClient creates a new client
 func NewClient(addr string) (client *Client, err error) {
-	conn, err := net.Dial(network, addr)
-	if err != nil {
-		return nil, err
-	}
+	return NewTLSClient(addr, nil)
+}
+
+// NewTLSClient creates a new client, 使用tlsConfig和服务器建立TLS连接, tlsConfig为nil时使用明文连接
+//
+// 服务器要求验证客户端证书时, tlsConfig.Certificates 中需要包含客户端证书
+func NewTLSClient(addr string, tlsConfig *tls.Config) (client *Client, err error) {
+	return NewTimeoutClient(addr, tlsConfig, maxWait)
+}
 
-	return &Client{
+// NewTimeoutClient creates a new client, 连接服务器和等待每个请求的回复最多 timeout, 超时的请求返回错误
+func NewTimeoutClient(addr string, tlsConfig *tls.Config, timeout time.Duration) (client *Client, err error) {
+	client = &Client{
 		addr:        addr,
-		conn:        conn,
+		tlsConfig:   tlsConfig,
+		timeout:     timeout,
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
 		working:     new(sync.WaitGroup),
-	}, nil
+	}
+	if client.conn, err = client.dial(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// dial 连接服务器, TLS连接在返回之前完成握手, 证书错误可以立即返回
+//
+// 以 unix: 开头的地址是unix socket的路径, 例如 unix:/tmp/redis.sock
+func (client *Client) dial() (net.Conn, error) {
+	network, addr := network, client.addr
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		network, addr = "unix", path
+	}
+	netDialer := &net.Dialer{Timeout: client.timeout}
+	if client.tlsConfig == nil {
+		return netDialer.Dial(network, addr)
+	}
+	dialer := &tls.Dialer{NetDialer: netDialer, Config: client.tlsConfig}
+	return dialer.Dial(network, addr)
+}
+
+// SetPushHandler 设置处理推送消息的回调, 需要在 Start 之前调用
+//
+// 协商了RESP3之后, 服务端会主动推送发布订阅和客户端缓存失效的消息
+func (client *Client) SetPushHandler(handler func(*reply.PushReply)) {
+	client.onPush = handler
 }
 
 // Start starts asynchronous goroutines
@@ -77,6 +119,9 @@ func (client *Client) Start() {
 
 // Close stops asynchronous goroutines and close connection
 func (client *Client) Close() {
+	if client.cluster != nil {
+		client.closeCluster()
+	}
 	client.ticker.Stop()
 	// stop new request
 	close(client.pendingReqs)
@@ -99,7 +144,7 @@ func (client *Client) handleConnectionError() error {
 			}
 		}
 	}
-	conn, err1 := net.Dial(network, client.addr)
+	conn, err1 := client.dial()
 	if err1 != nil {
 		logger.Error(err1)
 		return err1
@@ -127,18 +172,29 @@ func (client *Client) handleWrite() {
 	}
 }
 
-// Send sends a request to redis server
+// Send sends a request to redis server, 集群模式下发送给负责key的节点
 func (client *Client) Send(args db.CmdLine) resp.Reply {
-	req := &request{
-		args:      args,
-		heartbeat: false,
-		waiting:   &wait.Wait{},
+	if client.cluster != nil && len(args) > 0 {
+		return client.sendCluster(args)
 	}
+	return client.send(args)
+}
+
+// sendAsking 先发送 ASKING 再发送命令, 返回命令的回复
+func (client *Client) sendAsking(args db.CmdLine) resp.Reply {
+	return client.doSend(&request{args: args, asking: true, waiting: &wait.Wait{}})
+}
+
+func (client *Client) send(args db.CmdLine) resp.Reply {
+	return client.doSend(&request{args: args, waiting: &wait.Wait{}})
+}
+
+func (client *Client) doSend(req *request) resp.Reply {
 	req.waiting.Add(1)
 	client.working.Add(1)
 	defer client.working.Done()
 	client.pendingReqs <- req
-	timeout := req.waiting.WaitWithTimeout(maxWait)
+	timeout := req.waiting.WaitWithTimeout(client.timeout)
 	if timeout {
 		return reply.NewErrReply(enum.SERVER_TIMEOUT.Error())
 	}
@@ -160,7 +216,7 @@ func (client *Client) doHeartbeat() {
 	client.working.Add(1)
 	defer client.working.Done()
 	client.pendingReqs <- req
-	req.waiting.WaitWithTimeout(maxWait)
+	req.waiting.WaitWithTimeout(client.timeout)
 }
 
 func (client *Client) doRequest(req *request) {
@@ -171,6 +227,9 @@ func (client *Client) doRequest(req *request) {
 		reply.NewBulkReply(req.args[0]),
 		reply.NewMultiBulkReply(req.args)).(resp.Reply)
 	bytes := re.Bytes()
+	if req.asking {
+		bytes = append(reply.NewMultiBulkReply(utils.ToCmdLine(enum.ASKING.String())).Bytes(), bytes...)
+	}
 
 	var err error
 	for i := 0; i < 3; i++ { // only retry, waiting for handleRead
@@ -183,6 +242,9 @@ func (client *Client) doRequest(req *request) {
 		logger.Error(err)
 	}
 	if err == nil {
+		if req.asking { // ASKING 的回复直接丢弃
+			client.waitingReqs <- &request{heartbeat: true}
+		}
 		client.waitingReqs <- req
 		return
 	}
@@ -210,14 +272,22 @@ func (client *Client) finishRequest(reply resp.Reply) {
 }
 
 func (client *Client) handleRead() error {
-	ch := parser.ParseStream(client.conn)
-	for payload := range ch {
-		if payload.Err != nil {
-			client.finishRequest(reply.NewErrReply(payload.Err.Error()))
+	reader := parser.NewReader(client.conn)
+	for {
+		data, fatal, err := reader.ReadReply()
+		if err != nil {
+			client.finishRequest(reply.NewErrReply(err.Error()))
+			if fatal { // 连接已经关闭或者无法继续解析
+				return nil
+			}
+			continue
+		}
+		if push, ok := data.(*reply.PushReply); ok { // 推送消息不消耗等待响应的请求
+			if client.onPush != nil {
+				client.onPush(push)
+			}
 			continue
 		}
-		client.finishRequest(payload.Data)
+		client.finishRequest(data)
 	}
-
-	return nil
 }
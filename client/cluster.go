@@ -0,0 +1,225 @@
+package client
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/slot"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+// maxRedirects 跟随 MOVED 和 ASK 重定向的最大次数
+const maxRedirects = 5
+
+// clusterState 集群模式的客户端直接访问负责key的节点
+//
+// 客户端缓存槽到节点的映射, 按照命令的第一个参数计算槽选择节点, 没有参数的命令发送给初始连接的节点.
+// 节点回复 MOVED 时更新缓存并重新加载所有槽的分配, 回复 ASK 时只把这一条命令在 ASKING 之后发送给目标节点
+type clusterState struct {
+	mu      sync.Mutex
+	nodes   map[string]*Client // 节点地址 -> 连接, 第一次访问节点时建立
+	session []db.CmdLine       // 新的连接需要先执行的 AUTH 和 SELECT
+
+	slotsMu sync.RWMutex
+	slots   []string   // 槽 -> 节点地址, 空字符串表示没有缓存
+	loading sync.Mutex // 同一时间只有一个协程重新加载槽的分配
+}
+
+// NewClusterClient 创建集群模式的客户端, addr 是集群中任意一个节点的地址
+//
+// 客户端把命令发送给负责key的节点, 并跟随节点返回的 MOVED 和 ASK 重定向.
+// 服务端开启 cluster-redirect 或者执行 CLUSTER REDIRECT ON 之后才会返回重定向, 否则由服务端转发命令
+func NewClusterClient(addr string, tlsConfig *tls.Config) (*Client, error) {
+	client, err := NewTLSClient(addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	client.cluster = &clusterState{
+		nodes: make(map[string]*Client),
+		slots: make([]string, slot.Count),
+	}
+	return client, nil
+}
+
+// sendCluster 把命令发送给负责key的节点, 跟随重定向直到节点执行命令
+func (client *Client) sendCluster(args db.CmdLine) resp.Reply {
+	cmdName := strings.ToUpper(string(args[0]))
+	if cmdName == enum.SYS_AUTH.String() || cmdName == enum.SELECT.String() {
+		return client.sendSession(args)
+	}
+
+	node := ""
+	if len(args) > 1 {
+		node = client.cluster.nodeOf(slot.KeySlot(string(args[1])))
+	}
+	asking := false
+	for i := 0; ; i++ {
+		target, err := client.nodeClient(node)
+		if err != nil {
+			return reply.NewErrReply(err.Error())
+		}
+		var result resp.Reply
+		if asking {
+			result = target.sendAsking(args)
+		} else {
+			result = target.send(args)
+		}
+		redirect, s, to := parseRedirect(result)
+		if redirect == "" || i >= maxRedirects {
+			return result
+		}
+		if redirect == "MOVED" {
+			client.cluster.setNode(s, to)
+			client.refreshSlots(target)
+		}
+		node, asking = to, redirect == "ASK"
+	}
+}
+
+// sendSession 在所有节点的连接上执行 AUTH 或 SELECT, 成功之后新建立的连接也会执行
+func (client *Client) sendSession(args db.CmdLine) resp.Reply {
+	cluster := client.cluster
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	result := client.send(args)
+	if reply.IsErrReply(result) {
+		return result
+	}
+	for _, node := range cluster.nodes {
+		node.send(args)
+	}
+	name := strings.ToUpper(string(args[0]))
+	session := make([]db.CmdLine, 0, len(cluster.session)+1)
+	for _, line := range cluster.session {
+		if strings.ToUpper(string(line[0])) != name {
+			session = append(session, line)
+		}
+	}
+	cluster.session = append(session, args)
+	return result
+}
+
+// nodeClient 返回节点的连接, node 为空时返回初始连接
+func (client *Client) nodeClient(node string) (*Client, error) {
+	if node == "" || node == client.addr {
+		return client, nil
+	}
+	cluster := client.cluster
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	if nodeClient, ok := cluster.nodes[node]; ok {
+		return nodeClient, nil
+	}
+	nodeClient, err := NewTLSClient(node, client.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	nodeClient.onPush = client.onPush
+	nodeClient.Start()
+	for _, line := range cluster.session {
+		if result := nodeClient.send(line); reply.IsErrReply(result) {
+			logger.Warn("cluster client: " + node + ": " + strings.TrimSpace(string(result.Bytes())))
+		}
+	}
+	cluster.nodes[node] = nodeClient
+	return nodeClient, nil
+}
+
+// refreshSlots 通过 CLUSTER SLOTS 重新加载槽的分配, 已经有协程在加载时直接返回
+func (client *Client) refreshSlots(from *Client) {
+	cluster := client.cluster
+	if !cluster.loading.TryLock() {
+		return
+	}
+	defer cluster.loading.Unlock()
+	result := from.send(utils.ToCmdLine(enum.CLUSTER.String(), enum.CLUSTER_SLOTS))
+	slots, ok := parseSlots(result)
+	if !ok {
+		return
+	}
+	cluster.slotsMu.Lock()
+	cluster.slots = slots
+	cluster.slotsMu.Unlock()
+}
+
+// closeCluster 关闭所有节点的连接
+func (client *Client) closeCluster() {
+	cluster := client.cluster
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	for node, nodeClient := range cluster.nodes {
+		nodeClient.Close()
+		delete(cluster.nodes, node)
+	}
+}
+
+func (cluster *clusterState) nodeOf(s int) string {
+	cluster.slotsMu.RLock()
+	defer cluster.slotsMu.RUnlock()
+	return cluster.slots[s]
+}
+
+func (cluster *clusterState) setNode(s int, node string) {
+	cluster.slotsMu.Lock()
+	defer cluster.slotsMu.Unlock()
+	cluster.slots[s] = node
+}
+
+// parseRedirect 解析 -MOVED slot node 和 -ASK slot node, 不是重定向时 kind 为空
+func parseRedirect(r resp.Reply) (kind string, s int, node string) {
+	if !reply.IsErrReply(r) {
+		return "", 0, ""
+	}
+	msg := strings.TrimPrefix(strings.TrimSpace(string(r.Bytes())), "-")
+	fields := strings.Fields(strings.TrimPrefix(msg, "ERR "))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", 0, ""
+	}
+	s, err := strconv.Atoi(fields[1])
+	if err != nil || s < 0 || s >= slot.Count {
+		return "", 0, ""
+	}
+	return fields[0], s, fields[2]
+}
+
+// parseSlots 解析 CLUSTER SLOTS 的结果: [start, end, [ip, port, id]]
+func parseSlots(r resp.Reply) ([]string, bool) {
+	slots := make([]string, slot.Count)
+	if _, ok := r.(*reply.EmptyMultiBulkReply); ok {
+		return slots, true
+	}
+	items, ok := r.(*reply.MultiRawReply)
+	if !ok {
+		return nil, false
+	}
+	for _, item := range items.Replies {
+		fields, ok := item.(*reply.MultiRawReply)
+		if !ok || len(fields.Replies) < 3 {
+			return nil, false
+		}
+		start, ok1 := fields.Replies[0].(*reply.IntReply)
+		end, ok2 := fields.Replies[1].(*reply.IntReply)
+		node, ok3 := fields.Replies[2].(*reply.MultiRawReply)
+		if !ok1 || !ok2 || !ok3 || len(node.Replies) < 2 {
+			return nil, false
+		}
+		ip, ok1 := node.Replies[0].(*reply.BulkReply)
+		port, ok2 := node.Replies[1].(*reply.IntReply)
+		if !ok1 || !ok2 || start.Code() < 0 || end.Code() >= slot.Count || start.Code() > end.Code() {
+			return nil, false
+		}
+		addr := net.JoinHostPort(string(ip.Arg), strconv.FormatInt(port.Code(), 10))
+		for s := start.Code(); s <= end.Code(); s++ {
+			slots[s] = addr
+		}
+	}
+	return slots, true
+}
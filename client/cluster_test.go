@@ -0,0 +1,145 @@
+package client
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go-redis/interface/resp"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+)
+
+// fakeNode 模拟集群节点, handler 处理一条命令, asking 表示上一条命令是 ASKING
+type fakeNode struct {
+	listener net.Listener
+	mu       sync.Mutex
+	commands []string // 收到的命令, 不包括 ASKING
+}
+
+func newFakeNode(t *testing.T, handler func(args []string, asking bool) resp.Reply) *fakeNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &fakeNode{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go node.serve(conn, handler)
+		}
+	}()
+	t.Cleanup(func() { _ = listener.Close() })
+	return node
+}
+
+func (node *fakeNode) serve(conn net.Conn, handler func(args []string, asking bool) resp.Reply) {
+	defer func() { _ = conn.Close() }()
+	reader := parser.NewReader(conn)
+	asking := false
+	for {
+		data, fatal, err := reader.ReadReply()
+		if fatal {
+			return
+		}
+		cmd, ok := data.(*reply.MultiBulkReply)
+		if err != nil || !ok {
+			continue
+		}
+		args := make([]string, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			args[i] = string(arg)
+		}
+		var result resp.Reply = reply.NewOKReply()
+		if strings.ToUpper(args[0]) == "ASKING" {
+			asking = true
+		} else {
+			node.mu.Lock()
+			node.commands = append(node.commands, strings.Join(args, " "))
+			node.mu.Unlock()
+			result = handler(args, asking)
+			asking = false
+		}
+		if _, err = conn.Write(result.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (node *fakeNode) addr() string {
+	return node.listener.Addr().String()
+}
+
+func (node *fakeNode) received() []string {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return append([]string(nil), node.commands...)
+}
+
+func TestClusterClient(t *testing.T) {
+	// foo 在槽 12182, bar 在槽 5061; a 负责所有的槽, foo 已经迁移到 b, bar 正在迁移到 b
+	var a, b *fakeNode
+	a = newFakeNode(t, func(args []string, asking bool) resp.Reply {
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			host, port, _ := net.SplitHostPort(b.addr())
+			p, _ := strconv.Atoi(port)
+			return reply.NewMultiRawReply([]resp.Reply{reply.NewMultiRawReply([]resp.Reply{
+				reply.NewIntReply(12182), reply.NewIntReply(12182),
+				reply.NewMultiRawReply([]resp.Reply{reply.NewBulkReply([]byte(host)), reply.NewIntReply(int64(p))}),
+			})})
+		case "GET":
+			if args[1] == "foo" {
+				return &reply.NormalErrReply{Status: "MOVED 12182 " + b.addr()}
+			}
+			return &reply.NormalErrReply{Status: "ASK 5061 " + b.addr()}
+		}
+		return reply.NewOKReply()
+	})
+	b = newFakeNode(t, func(args []string, asking bool) resp.Reply {
+		if strings.ToUpper(args[0]) == "GET" {
+			if args[1] == "bar" && !asking {
+				return &reply.NormalErrReply{Status: "MOVED 5061 " + a.addr()}
+			}
+			return reply.NewBulkReply([]byte(args[1] + "-value"))
+		}
+		return reply.NewOKReply()
+	})
+
+	client, err := NewClusterClient(a.addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Start()
+	defer client.Close()
+
+	if r := client.Send([][]byte{[]byte("AUTH"), []byte("secret")}); reply.IsErrReply(r) {
+		t.Fatalf("auth failed: %s", r.Bytes())
+	}
+	// 跟随 MOVED 之后缓存槽的分配, 再次访问时直接发送给 b
+	for i := 0; i < 2; i++ {
+		if r := client.Send([][]byte{[]byte("GET"), []byte("foo")}); string(r.Bytes()) != "$9\r\nfoo-value\r\n" {
+			t.Fatalf("unexpected reply %q", r.Bytes())
+		}
+	}
+	// ASK 只对一条命令有效, 不修改缓存
+	for i := 0; i < 2; i++ {
+		if r := client.Send([][]byte{[]byte("GET"), []byte("bar")}); string(r.Bytes()) != "$9\r\nbar-value\r\n" {
+			t.Fatalf("unexpected reply %q", r.Bytes())
+		}
+	}
+
+	expectA := []string{"AUTH secret", "GET foo", "CLUSTER SLOTS", "GET bar", "GET bar"}
+	expectB := []string{"AUTH secret", "GET foo", "GET foo", "GET bar", "GET bar"}
+	if got := a.received(); strings.Join(got, ",") != strings.Join(expectA, ",") {
+		t.Errorf("node a: expected %q, actually %q", expectA, got)
+	}
+	if got := b.received(); strings.Join(got, ",") != strings.Join(expectB, ",") {
+		t.Errorf("node b: expected %q, actually %q", expectB, got)
+	}
+}
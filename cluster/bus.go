@@ -0,0 +1,200 @@
+package cluster_database
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"go-redis/client"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+)
+
+// 集群总线: 每个节点在 port+10000 上接收其他节点的消息
+//
+// 消息使用RESP数组发送, 第一个元素是消息类型, 第二个元素是JSON编码的 busMessage, 接收者回复JSON编码的 PONG 消息.
+// 单独的 PING 是连接的心跳, 回复 +PONG. Raft消息的第二个元素是JSON编码的RPC参数, 接收者回复JSON编码的结果
+
+// busPortOffset 集群总线的端口和客户端端口的差
+const busPortOffset = 10000
+
+// 消息类型
+const (
+	msgPing = "PING" // 定时发送, 接收者回复 PONG
+	msgPong = "PONG"
+	msgMeet = "MEET" // CLUSTER MEET 发送, 接收者即使禁止了发送者也会把它加入集群
+	msgFail = "FAIL" // 节点被标记为下线之后通知所有节点
+
+	msgRaftVote     = "RAFTVOTE"     // RequestVote
+	msgRaftAppend   = "RAFTAPPEND"   // AppendEntries
+	msgRaftSnapshot = "RAFTSNAPSHOT" // InstallSnapshot
+	msgRaftPropose  = "RAFTPROPOSE"  // 转发给leader的元数据修改, 回复修改在日志中的位置
+)
+
+// busMessage 节点之间交换的消息, 携带发送者的纪元和负责的槽, 以及发送者对其他节点状态的描述
+type busMessage struct {
+	Type         string        `json:"type"`
+	Sender       string        `json:"sender"` // 发送者的地址
+	ID           string        `json:"id"`     // 发送者的节点ID
+	CurrentEpoch uint64        `json:"currentEpoch"`
+	ConfigEpoch  uint64        `json:"configEpoch"`
+	Slots        [][2]int      `json:"slots"` // 发送者负责的槽区间, 包括两端
+	Gossip       []gossipEntry `json:"gossip"`
+	Failing      string        `json:"failing,omitempty"` // FAIL 消息中下线的节点
+}
+
+// gossipEntry 发送者看到的其他节点的状态
+type gossipEntry struct {
+	Node  string    `json:"node"`
+	ID    string    `json:"id"`
+	Flags nodeFlags `json:"flags"` // 只包括 flagPFail 和 flagFail
+}
+
+// busAddr 返回节点的集群总线地址
+func busAddr(node string) string {
+	host, port := splitAddr(node)
+	return net.JoinHostPort(host, strconv.Itoa(port+busPortOffset))
+}
+
+// listen 在 bind 地址和本节点端口+10000 上监听集群总线, 开启 tls-cluster 时使用TLS
+func (g *gossip) listen() error {
+	_, port := splitAddr(g.cluster.self)
+	addr := net.JoinHostPort(config.Properties.Bind, strconv.Itoa(port+busPortOffset))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if config.Properties.TlsCluster {
+		tlsConfig, err := config.ServerTLSConfig()
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	g.listener = listener
+	logger.Info("cluster bus listening on " + addr)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go g.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// serve 处理一个连接上的所有消息
+func (g *gossip) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := parser.NewRequestReader(conn)
+	for {
+		data, fatal, err := reader.ReadReply()
+		if err != nil {
+			if fatal {
+				return
+			}
+			_, _ = conn.Write(reply.NewErrReply(err.Error()).Bytes())
+			continue
+		}
+		var args [][]byte
+		switch data := data.(type) {
+		case *reply.MultiBulkReply:
+			args = data.Args
+		case *reply.BulkReply: // 客户端把只有一个参数的命令发送为字符串
+			args = [][]byte{data.Arg}
+		}
+		if _, err := conn.Write(g.handle(args).Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// handle 处理其他节点发送的消息, 回复本节点的 PONG
+func (g *gossip) handle(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.NewUnknownErrReply()
+	}
+	msgType := strings.ToUpper(utils.Bytes2String(args[0]))
+	if len(args) == 1 && msgType == msgPing {
+		return reply.NewStatusReply(msgPong)
+	}
+	if len(args) == 2 && strings.HasPrefix(msgType, "RAFT") {
+		if g.cluster.consensus == nil {
+			return reply.NewErrReply("cluster consensus is disabled")
+		}
+		return g.cluster.consensus.handle(msgType, args[1])
+	}
+	if len(args) != 2 || (msgType != msgPing && msgType != msgMeet && msgType != msgFail) {
+		return reply.NewErrReply("unknown cluster bus message")
+	}
+	msg := &busMessage{}
+	if err := json.Unmarshal(args[1], msg); err != nil {
+		return reply.NewErrReply("invalid cluster bus message: " + err.Error())
+	}
+	if msg.ID != nodeID(msg.Sender) {
+		return reply.NewErrReply("node id mismatch for " + msg.Sender)
+	}
+	msg.Type = msgType
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.processLocked(msg)
+	body, err := json.Marshal(g.newMessageLocked(msgPong))
+	if err != nil {
+		return reply.NewErrReplyByError(err)
+	}
+	return reply.NewBulkReply(body)
+}
+
+// send 使用连接 link 发送消息并等待 PONG, link 为nil时建立新的连接
+//
+// 返回可以继续使用的连接, 发送失败时关闭连接并返回nil
+func (g *gossip) send(node string, link *client.Client, msg *busMessage) (*busMessage, *client.Client, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, link, err
+	}
+	if link == nil {
+		if link, err = client.NewTLSClient(busAddr(node), g.cluster.tlsConfig); err != nil {
+			return nil, nil, err
+		}
+		link.Start()
+	}
+	r := link.Send(utils.ToCmdLine2(msg.Type, body))
+	result, ok := r.(*reply.BulkReply)
+	if !ok {
+		link.Close()
+		return nil, nil, errors.New(strings.TrimSpace(string(r.Bytes())))
+	}
+	pong := &busMessage{}
+	if err := json.Unmarshal(result.Arg, pong); err != nil {
+		link.Close()
+		return nil, nil, err
+	}
+	if pong.ID != nodeID(pong.Sender) || pong.Sender != node {
+		link.Close()
+		return nil, nil, errors.New("unexpected pong from " + pong.Sender)
+	}
+	pong.Type = msgPong
+	return pong, link, nil
+}
+
+// sendOnce 使用临时连接发送消息, 忽略回复, 用于广播 FAIL
+func (g *gossip) sendOnce(node string, msg *busMessage) {
+	_, link, err := g.send(node, nil, msg)
+	if err != nil {
+		logger.Debug("cluster bus: send " + msg.Type + " to " + node + ": " + err.Error())
+		return
+	}
+	link.Close()
+}
@@ -2,30 +2,64 @@ package cluster_database
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"strconv"
+	"strings"
 
 	pool "github.com/jolestar/go-commons-pool/v2"
 	"go-redis/client"
+	"go-redis/config"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
 )
 
 // ConnectionFactory is a factory to create connection
 type connectionFactory struct {
-	Peer string // Peer is the address of the peer
+	Peer      string      // Peer is the address of the peer
+	TLSConfig *tls.Config // TLSConfig is used for mutual TLS with the peer, nil means plaintext
 }
 
-func newConnectionFactory(peer string) pool.PooledObjectFactory {
-	return &connectionFactory{Peer: peer}
+func newConnectionFactory(peer string, tlsConfig *tls.Config) pool.PooledObjectFactory {
+	return &connectionFactory{Peer: peer, TLSConfig: tlsConfig}
 }
 
+// MakeObject creates a new connection in redirect mode, so the peer replies MOVED or ASK
+// instead of relaying the command again when the slot tables of the two nodes disagree.
+// The connection speaks RESP3, so relayed replies keep their types (maps, sets, doubles)
+// and are marshalled with the protocol of the client connection like local replies
 func (factory *connectionFactory) MakeObject(_ context.Context) (*pool.PooledObject, error) {
-	// MakeObject creates a new connectionFactory
-	oneClient, err := client.NewClient(factory.Peer)
+	oneClient, err := dialPeer(factory.Peer, factory.TLSConfig)
 	if err != nil {
 		return nil, err
 	}
-	oneClient.Start()
+	for _, cmdLine := range []db.CmdLine{
+		utils.ToCmdLine(enum.CLUSTER.String(), enum.CLUSTER_REDIRECT, "ON"),
+		utils.ToCmdLine(enum.HELLO.String(), strconv.Itoa(enum.RESP3)),
+	} {
+		if r := oneClient.Send(cmdLine); reply.IsErrReply(r) {
+			oneClient.Close()
+			return nil, errors.New(strings.TrimSpace(string(r.Bytes())))
+		}
+	}
 	return pool.NewPooledObject(oneClient), nil
 }
 
+// dialPeer connects to the peer and authenticates with require-pass, all nodes of a cluster share the same password
+func dialPeer(peer string, tlsConfig *tls.Config) (*client.Client, error) {
+	oneClient, err := client.NewTLSClient(peer, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	oneClient.Start()
+	if password := config.RequirePass(); password != "" {
+		oneClient.Send(utils.ToCmdLine(enum.SYS_AUTH.String(), password))
+	}
+	return oneClient, nil
+}
+
 func (factory *connectionFactory) DestroyObject(_ context.Context, object *pool.PooledObject) error {
 	c, ok := object.Object.(*client.Client)
 	if !ok {
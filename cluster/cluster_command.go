@@ -0,0 +1,472 @@
+package cluster_database
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-redis/config"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/slot"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+// execCluster 执行 CLUSTER 命令
+//
+// # CLUSTER SLOTS | SHARDS | KEYSLOT key | COUNTKEYSINSLOT slot | GETKEYSINSLOT slot count
+//
+// # CLUSTER NODES | MEET ip port | FORGET node-id | REPLICATE node-id
+//
+// # CLUSTER ADDSLOTS slot [slot ...] | SETSLOT slot MIGRATING|IMPORTING|NODE node | SETSLOT slot STABLE
+//
+// # CLUSTER REDIRECT ON|OFF | REBALANCE
+func execCluster(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) < 2 {
+		return reply.NewArgNumErrReply(enum.CLUSTER.String())
+	}
+	subCmd := strings.ToUpper(utils.Bytes2String(args[1]))
+	switch subCmd {
+	case enum.CLUSTER_SLOTS:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("cluster|slots")
+		}
+		return cluster.execClusterSlots()
+	case enum.CLUSTER_SHARDS:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("cluster|shards")
+		}
+		return cluster.execClusterShards()
+	case enum.CLUSTER_KEYSLOT:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply("cluster|keyslot")
+		}
+		return reply.NewIntReply(int64(slot.KeySlot(utils.Bytes2String(args[2]))))
+	case enum.CLUSTER_COUNTKEYSINSLOT:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply("cluster|countkeysinslot")
+		}
+		s, errReply := parseSlot(args[2])
+		if errReply != nil {
+			return errReply
+		}
+		return reply.NewIntReply(int64(len(cluster.keysInSlot(conn.GetDBIndex(), s, -1))))
+	case enum.CLUSTER_GETKEYSINSLOT:
+		if len(args) != 4 {
+			return reply.NewArgNumErrReply("cluster|getkeysinslot")
+		}
+		s, errReply := parseSlot(args[2])
+		if errReply != nil {
+			return errReply
+		}
+		count, err := strconv.Atoi(utils.Bytes2String(args[3]))
+		if err != nil || count < 0 {
+			return reply.NewErrReply("Invalid number of keys")
+		}
+		return reply.NewMultiBulkReply(utils.ToCmdLine(cluster.keysInSlot(conn.GetDBIndex(), s, count)...))
+	case enum.CLUSTER_NODES:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("cluster|nodes")
+		}
+		return cluster.execClusterNodes()
+	case enum.CLUSTER_MEET:
+		if len(args) != 4 {
+			return reply.NewArgNumErrReply("cluster|meet")
+		}
+		return cluster.execClusterMeet(args[2], args[3])
+	case enum.CLUSTER_FORGET:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply("cluster|forget")
+		}
+		return cluster.execClusterForget(utils.Bytes2String(args[2]))
+	case enum.CLUSTER_REPLICATE:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply("cluster|replicate")
+		}
+		return cluster.execClusterReplicate(utils.Bytes2String(args[2]))
+	case enum.CLUSTER_ADDSLOTS:
+		if len(args) < 3 {
+			return reply.NewArgNumErrReply("cluster|addslots")
+		}
+		return cluster.execClusterAddSlots(args[2:])
+	case enum.CLUSTER_SETSLOT:
+		if len(args) < 4 || len(args) > 5 {
+			return reply.NewArgNumErrReply("cluster|setslot")
+		}
+		return cluster.execClusterSetSlot(args[2:])
+	case enum.CLUSTER_REDIRECT:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply("cluster|redirect")
+		}
+		switch strings.ToUpper(utils.Bytes2String(args[2])) {
+		case "ON":
+			conn.SetRedirect(true)
+		case "OFF":
+			conn.SetRedirect(false)
+		default:
+			return reply.NewSyntaxErrReply()
+		}
+		return reply.NewOKReply()
+	case enum.CLUSTER_REBALANCE:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("cluster|rebalance")
+		}
+		return cluster.execClusterRebalance(conn)
+	default:
+		return reply.NewErrReply("unknown subcommand '" + subCmd + "'")
+	}
+}
+
+// execClusterMeet 把节点加入集群, 本节点通过集群总线异步地向节点发送 MEET
+//
+// 节点收到 MEET 之后把本节点加入集群, 之后两个节点通过 PING 把对方介绍给其他节点
+func (cd *ClusterDatabase) execClusterMeet(host, port []byte) resp.Reply {
+	p, err := strconv.Atoi(utils.Bytes2String(port))
+	if err != nil || p <= 0 || p+busPortOffset > 65535 {
+		return reply.NewErrReply("Invalid node address specified: " + string(host) + ":" + string(port))
+	}
+	node := net.JoinHostPort(utils.Bytes2String(host), strconv.Itoa(p))
+	if node != cd.self {
+		cd.gossip.meet(node)
+	}
+	return reply.NewOKReply()
+}
+
+// execClusterForget 删除节点, 节点负责的槽变为没有分配, 60秒内不会通过其他节点的消息重新加入
+func (cd *ClusterDatabase) execClusterForget(arg string) resp.Reply {
+	node := ""
+	for _, known := range cd.slots.knownNodes() {
+		if known == arg || nodeID(known) == arg {
+			node = known
+		}
+	}
+	if node == "" {
+		return reply.NewErrReply("Unknown node " + arg)
+	}
+	if node == cd.self {
+		return reply.NewErrReply("I tried hard but I can't forget myself...")
+	}
+	cd.gossip.forget(node)
+	cd.closePeerPool(node)
+	return reply.NewOKReply()
+}
+
+// execClusterNodes 返回本节点看到的所有节点, 每行一个节点, 格式和Redis一致:
+//
+//	<id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> <slot> ...
+//
+// 本节点正在迁移的槽显示为 [slot->-id] 和 [slot-<-id]
+func (cd *ClusterDatabase) execClusterNodes() resp.Reply {
+	slotsOf := make(map[string][]string)
+	for _, r := range cd.slots.ranges() {
+		slotsOf[r.node] = append(slotsOf[r.node], utils.If(r.start == r.end,
+			strconv.Itoa(r.start), strconv.Itoa(r.start)+"-"+strconv.Itoa(r.end)))
+	}
+	migrating, importing := cd.slots.migrations()
+	for s, target := range migrating {
+		slotsOf[cd.self] = append(slotsOf[cd.self], "["+strconv.Itoa(s)+"->-"+nodeID(target)+"]")
+	}
+	for s, source := range importing {
+		slotsOf[cd.self] = append(slotsOf[cd.self], "["+strconv.Itoa(s)+"-<-"+nodeID(source)+"]")
+	}
+
+	var builder strings.Builder
+	for _, node := range cd.slots.knownNodes() {
+		state := cd.gossip.nodeState(node)
+		_, port := splitAddr(node)
+		master := cd.slots.masterOf(node)
+		flags := utils.If(master == "", "master", "slave")
+		switch {
+		case state.flags&flagMyself != 0:
+			flags = "myself," + flags
+		case state.flags&flagFail != 0:
+			flags += ",fail"
+		case state.flags&flagPFail != 0:
+			flags += ",fail?"
+		}
+		fields := []string{
+			nodeID(node),
+			node + "@" + strconv.Itoa(port+busPortOffset),
+			flags,
+			utils.If(master == "", "-", nodeID(master)),
+			strconv.FormatInt(unixMilli(state.pingSent), 10),
+			strconv.FormatInt(unixMilli(state.pongReceived), 10),
+			formatEpoch(state.configEpoch),
+			utils.If(state.link != nil || state.flags&flagMyself != 0, "connected", "disconnected"),
+		}
+		builder.WriteString(strings.Join(append(fields, slotsOf[node]...), " "))
+		builder.WriteString("\n")
+	}
+	return reply.NewBulkReply([]byte(builder.String()))
+}
+
+// unixMilli 返回毫秒时间戳, 零值返回0
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// execClusterAddSlots 把没有分配的槽分配给本节点
+func (cd *ClusterDatabase) execClusterAddSlots(args db.CmdLine) resp.Reply {
+	slots := make([]int, 0, len(args))
+	for _, arg := range args {
+		s, errReply := parseSlot(arg)
+		if errReply != nil {
+			return errReply
+		}
+		slots = append(slots, s)
+	}
+	for _, s := range slots {
+		if cd.slots.owner(s) != "" {
+			return reply.NewErrReply("Slot " + strconv.Itoa(s) + " is already busy")
+		}
+	}
+	if err := cd.proposeMeta(&metaCommand{Op: metaSetSlot, Node: cd.self, Slots: slots}); err != nil {
+		return &reply.NormalErrReply{Status: "CLUSTERDOWN " + err.Error()}
+	}
+	return reply.NewOKReply()
+}
+
+// execClusterReplicate 本节点成为 node 的副本, 主节点把所有数据同步到本节点之后复制它执行的写命令
+//
+// 只有没有数据并且不负责任何槽的节点可以成为副本
+func (cd *ClusterDatabase) execClusterReplicate(arg string) resp.Reply {
+	master := ""
+	for _, known := range cd.slots.knownNodes() {
+		if known == arg || nodeID(known) == arg {
+			master = known
+		}
+	}
+	if master == "" {
+		return reply.NewErrReply("Unknown node " + arg)
+	}
+	if master == cd.self {
+		return reply.NewErrReply("Can't replicate myself")
+	}
+	if cd.slots.masterOf(master) != "" {
+		return reply.NewErrReply("I can only replicate a master, not a replica.")
+	}
+	if cd.slots.masterOf(cd.self) != master && (cd.ownsSlots() || !cd.empty()) {
+		return reply.NewErrReply("To set a master the node must be empty and without assigned slots.")
+	}
+	if err := cd.proposeMeta(&metaCommand{Op: metaReplicate, Node: cd.self, Master: master}); err != nil {
+		return &reply.NormalErrReply{Status: "CLUSTERDOWN " + err.Error()}
+	}
+	return reply.NewOKReply()
+}
+
+// empty 本节点的所有数据库都没有key
+func (cd *ClusterDatabase) empty() bool {
+	for i := 0; i < config.Properties.Databases; i++ {
+		empty := true
+		cd.db.ForEach(i, func(string, *db.DataEntity, *time.Time) bool {
+			empty = false
+			return false
+		})
+		if !empty {
+			return false
+		}
+	}
+	return true
+}
+
+// execClusterSetSlot 修改槽的迁移状态或者负责的节点, args 不包括 CLUSTER SETSLOT
+//
+//	MIGRATING node: 本节点负责的槽开始迁移到 node
+//	IMPORTING node: 槽开始从 node 迁移到本节点
+//	STABLE:         取消槽的迁移状态
+//	NODE node:      槽由 node 负责, 迁移结束
+func (cd *ClusterDatabase) execClusterSetSlot(args db.CmdLine) resp.Reply {
+	s, errReply := parseSlot(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	action := strings.ToUpper(utils.Bytes2String(args[1]))
+	if action == enum.SETSLOT_STABLE {
+		if len(args) != 2 {
+			return reply.NewSyntaxErrReply()
+		}
+		cd.slots.setStable(s)
+		return reply.NewOKReply()
+	}
+	if len(args) != 3 {
+		return reply.NewSyntaxErrReply()
+	}
+	node, ok := cd.resolveNode(utils.Bytes2String(args[2]))
+	if !ok {
+		return reply.NewErrReply("I don't know about node " + string(args[2]))
+	}
+	owner := cd.slots.owner(s)
+	switch action {
+	case enum.SETSLOT_MIGRATING:
+		if owner != cd.self {
+			return reply.NewErrReply("I'm not the owner of hash slot " + strconv.Itoa(s))
+		}
+		cd.slots.setMigrating(s, node)
+	case enum.SETSLOT_IMPORTING:
+		if owner == cd.self {
+			return reply.NewErrReply("I'm already the owner of hash slot " + strconv.Itoa(s))
+		}
+		cd.slots.setImporting(s, node)
+	case enum.SETSLOT_NODE:
+		if owner == node {
+			cd.slots.setStable(s)
+			break
+		}
+		if err := cd.proposeMeta(&metaCommand{Op: metaSetSlot, Node: node, Slots: []int{s}}); err != nil {
+			return &reply.NormalErrReply{Status: "CLUSTERDOWN " + err.Error()}
+		}
+	default:
+		return reply.NewSyntaxErrReply()
+	}
+	return reply.NewOKReply()
+}
+
+// resolveNode 把节点ID或者地址转换为节点地址, 未知的节点ID返回false
+func (cd *ClusterDatabase) resolveNode(arg string) (string, bool) {
+	for _, node := range cd.slots.knownNodes() {
+		if node == arg || nodeID(node) == arg {
+			return node, true
+		}
+	}
+	if _, _, err := net.SplitHostPort(arg); err == nil {
+		return arg, true
+	}
+	return "", false
+}
+
+// execClusterSlots 返回每个槽区间和负责的节点: [start, end, [ip, port, id]]
+func (cd *ClusterDatabase) execClusterSlots() resp.Reply {
+	ranges := cd.slots.ranges()
+	replies := make([]resp.Reply, len(ranges))
+	for i, r := range ranges {
+		ip, port := splitAddr(r.node)
+		replies[i] = reply.NewMultiRawReply([]resp.Reply{
+			reply.NewIntReply(int64(r.start)),
+			reply.NewIntReply(int64(r.end)),
+			reply.NewMultiRawReply([]resp.Reply{
+				reply.NewBulkReply([]byte(ip)),
+				reply.NewIntReply(int64(port)),
+				reply.NewBulkReply([]byte(nodeID(r.node))),
+			}),
+		})
+	}
+	return reply.NewMultiRawReply(replies)
+}
+
+// parseClusterSlots 解析其他节点返回的 CLUSTER SLOTS 结果
+func parseClusterSlots(r resp.Reply) ([]slotRange, bool) {
+	if _, ok := r.(*reply.EmptyMultiBulkReply); ok { // 空数组, 没有分配任何槽
+		return nil, true
+	}
+	items, ok := r.(*reply.MultiRawReply)
+	if !ok {
+		return nil, false
+	}
+	ranges := make([]slotRange, 0, len(items.Replies))
+	for _, item := range items.Replies {
+		fields, ok := item.(*reply.MultiRawReply)
+		if !ok || len(fields.Replies) < 3 {
+			return nil, false
+		}
+		start, ok1 := fields.Replies[0].(*reply.IntReply)
+		end, ok2 := fields.Replies[1].(*reply.IntReply)
+		node, ok3 := fields.Replies[2].(*reply.MultiRawReply)
+		if !ok1 || !ok2 || !ok3 || len(node.Replies) < 2 {
+			return nil, false
+		}
+		ip, ok1 := node.Replies[0].(*reply.BulkReply)
+		port, ok2 := node.Replies[1].(*reply.IntReply)
+		if !ok1 || !ok2 || start.Code() < 0 || end.Code() >= slot.Count || start.Code() > end.Code() {
+			return nil, false
+		}
+		ranges = append(ranges, slotRange{
+			start: int(start.Code()),
+			end:   int(end.Code()),
+			node:  net.JoinHostPort(string(ip.Arg), strconv.FormatInt(port.Code(), 10)),
+		})
+	}
+	return ranges, true
+}
+
+// execClusterShards 返回每个分片的槽区间和节点信息, 每个分片由一个主节点和它的副本组成
+func (cd *ClusterDatabase) execClusterShards() resp.Reply {
+	// 1. 按照节点合并槽区间
+	shardSlots := make(map[string][]resp.Reply)
+	for _, r := range cd.slots.ranges() {
+		shardSlots[r.node] = append(shardSlots[r.node], reply.NewIntReply(int64(r.start)), reply.NewIntReply(int64(r.end)))
+	}
+	// 2. 每个主节点是一个分片
+	masters := cd.slots.masters()
+	replies := make([]resp.Reply, 0, len(masters))
+	for _, master := range masters {
+		nodes := []resp.Reply{cd.shardNode(master, "master")}
+		for _, replica := range cd.slots.replicasOf(master) {
+			nodes = append(nodes, cd.shardNode(replica, "replica"))
+		}
+		replies = append(replies, reply.NewMapReply([]resp.Reply{
+			reply.NewBulkReply([]byte("slots")), reply.NewMultiRawReply(shardSlots[master]),
+			reply.NewBulkReply([]byte("nodes")), reply.NewMultiRawReply(nodes),
+		}))
+	}
+	return reply.NewMultiRawReply(replies)
+}
+
+// shardNode 返回 CLUSTER SHARDS 中一个节点的信息
+func (cd *ClusterDatabase) shardNode(node string, role string) resp.Reply {
+	ip, port := splitAddr(node)
+	return reply.NewMapReply([]resp.Reply{
+		reply.NewBulkReply([]byte("id")), reply.NewBulkReply([]byte(nodeID(node))),
+		reply.NewBulkReply([]byte("port")), reply.NewIntReply(int64(port)),
+		reply.NewBulkReply([]byte("ip")), reply.NewBulkReply([]byte(ip)),
+		reply.NewBulkReply([]byte("endpoint")), reply.NewBulkReply([]byte(ip)),
+		reply.NewBulkReply([]byte("role")), reply.NewBulkReply([]byte(role)),
+		reply.NewBulkReply([]byte("replication-offset")), reply.NewIntReply(0),
+		reply.NewBulkReply([]byte("health")), reply.NewBulkReply([]byte(utils.If(cd.gossip.isFailed(node), "failed", "online"))),
+	})
+}
+
+// keysInSlot 返回本节点上在槽s中的key, count小于0时返回所有的key
+//
+// 没有维护槽到key的索引, 需要遍历数据库中所有的key
+func (cd *ClusterDatabase) keysInSlot(dbIndex int, s int, count int) []string {
+	keys := make([]string, 0)
+	if count == 0 {
+		return keys
+	}
+	cd.db.ForEach(dbIndex, func(key string, _ *db.DataEntity, _ *time.Time) bool {
+		if slot.KeySlot(key) == s {
+			keys = append(keys, key)
+		}
+		return count < 0 || len(keys) < count
+	})
+	return keys
+}
+
+// parseSlot 解析槽的编号, 超出范围时返回错误
+func parseSlot(arg []byte) (int, resp.ErrorReply) {
+	s, err := strconv.Atoi(utils.Bytes2String(arg))
+	if err != nil || s < 0 || s >= slot.Count {
+		return 0, reply.NewErrReply("Invalid slot")
+	}
+	return s, nil
+}
+
+// splitAddr 把节点地址拆分为ip和端口
+func splitAddr(node string) (string, int) {
+	host, portStr, err := net.SplitHostPort(node)
+	if err != nil {
+		return node, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+func init() {
+	registerRouter(enum.CLUSTER, execCluster)
+}
@@ -2,9 +2,12 @@ package cluster_database
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	pool "github.com/jolestar/go-commons-pool/v2"
 	"go-redis/client"
@@ -14,11 +17,11 @@ import (
 	"go-redis/enum"
 	"go-redis/interface/db"
 	"go-redis/interface/resp"
-	"go-redis/lib/consistenthash"
 	"go-redis/lib/id_generator"
 	"go-redis/lib/logger"
 	"go-redis/lib/utils"
 	"go-redis/resp/reply"
+	"go-redis/slowlog"
 )
 
 // if only one node involved in a transaction, just execute the command don't apply tcc procedure
@@ -30,37 +33,111 @@ var router = newRouter()
 type ClusterDatabase struct {
 	self string // self is the address of the self
 
-	nodes          []string                    // nodes is the address of the peers
-	peerPicker     *consistenthash.NodeMap     // peerPicker is the picker of the peers
-	peerConnection map[string]*pool.ObjectPool // peerConnection is the connection pool of the peers
+	slots          *slotTable                  // slots is the slot -> node table
+	peerConnection map[string]*pool.ObjectPool // peerConnection is the connection pool of the peers, created on first use
+	poolMu         sync.Mutex                  // poolMu protects peerConnection
+	tlsConfig      *tls.Config                 // tlsConfig is used for mutual TLS with the peers, nil means plaintext
+	gossip         *gossip                     // gossip is the cluster bus, exchanges nodes, epochs and slots with the peers
+	consensus      *consensus                  // consensus replicates the slot assignment and node roles by raft, nil when disabled
+	replication    *replication                // replication pushes the writes of a master to its replicas
 	db             db.DBEngine                 // db is the standalone database
 
 	// distributed transaction
 	idGenerator  *id_generator.IDGenerator // generate transaction id
 	transactions dict.Dict
 	txMutex      sync.RWMutex
+	txLog        *txLog // txLog 保存协调者的决定和参与者准备好的事务, 重启之后恢复
 }
 
 func NewClusterDatabase() *ClusterDatabase {
-	peerConnection := make(map[string]*pool.ObjectPool)
-
-	nodes := make([]string, 0, len(config.Properties.Peers)+1)
-	nodes = append(append(nodes, config.Properties.Peers...), config.Properties.Self)
+	self := config.Properties.Self
+	if self == "" {
+		self = net.JoinHostPort(config.Properties.Bind, strconv.Itoa(config.Properties.Port))
+	}
+	var slots *slotTable
+	if len(config.Properties.Peers) > 0 {
+		nodes := make([]string, 0, len(config.Properties.Peers)+1)
+		slots = newSlotTable(append(append(nodes, config.Properties.Peers...), self))
+	} else {
+		// 没有配置 peers 的节点不负责任何槽, 通过 CLUSTER MEET 加入集群之后由其他节点传播槽的分配
+		slots = newSlotTable(nil)
+		slots.addNode(self)
+	}
 
-	ctx := context.Background()
+	// tls-cluster 开启时节点之间使用双向TLS认证
+	var tlsConfig *tls.Config
+	if config.Properties.TlsCluster {
+		var err error
+		if tlsConfig, err = config.ClientTLSConfig(); err != nil {
+			panic(err)
+		}
+	}
 
-	for _, peer := range config.Properties.Peers {
-		peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, newConnectionFactory(peer))
+	txLog, err := openTxLog(config.Properties.ClusterTxLogFile)
+	if err != nil {
+		panic(err)
 	}
 
-	return &ClusterDatabase{
-		self:           config.Properties.Self,
-		nodes:          nodes,
-		peerPicker:     consistenthash.NewNodeMap(nil).Add(nodes...),
-		peerConnection: peerConnection,
-		db:             database.NewStandaloneDatabase(),
-		idGenerator:    id_generator.NewGenerator(config.Properties.Self),
+	// 慢查询由路由记录, 包括转发到其他节点的时间
+	localDB := database.NewStandaloneDatabase()
+	localDB.DisableSlowLog()
+
+	cluster := &ClusterDatabase{
+		self:           self,
+		slots:          slots,
+		peerConnection: make(map[string]*pool.ObjectPool),
+		tlsConfig:      tlsConfig,
+		db:             localDB,
+		idGenerator:    id_generator.NewGenerator(self),
 		transactions:   dict.NewNormalDict(),
+		txLog:          txLog,
+	}
+	cluster.replication = newReplication(cluster)
+	localDB.SetWriteListener(cluster.replication.onWrite)
+	cluster.syncSlots(config.Properties.Peers)
+	cluster.gossip = newGossip(cluster)
+
+	// 配置了 peers 的节点组成初始的Raft组, 其他节点等待 leader 把它加入Raft组
+	var members []string
+	if len(config.Properties.Peers) > 0 {
+		members = append(append(members, config.Properties.Peers...), self)
+	}
+	consensus, err := newConsensus(cluster, members, newPersister())
+	if err != nil {
+		logger.Error("cluster consensus is disabled: " + err.Error())
+	} else {
+		cluster.consensus = consensus
+	}
+	cluster.gossip.start()
+	if cluster.consensus != nil {
+		if err = cluster.consensus.start(); err != nil {
+			panic(err)
+		}
+	}
+	cluster.recoverTransactions()
+
+	return cluster
+}
+
+// syncSlots 加入已经在运行的集群: 从第一个可以连接的节点获取槽的分配, 之后通过集群总线和所有节点交换消息
+//
+// 所有节点都无法连接时说明集群刚刚启动, 使用根据配置计算的分配结果
+func (cd *ClusterDatabase) syncSlots(peers []string) {
+	for _, peer := range peers {
+		peerClient, err := dialPeer(peer, cd.tlsConfig)
+		if err != nil {
+			continue
+		}
+		result := peerClient.Send(utils.ToCmdLine(enum.CLUSTER.String(), enum.CLUSTER_SLOTS))
+		ranges, ok := parseClusterSlots(result)
+		if ok && len(ranges) > 0 {
+			cd.slots.load(ranges)
+		}
+		peerClient.Close()
+		if ok {
+			logger.Info("load slots from peer " + peer)
+			return
+		}
 	}
 }
 
@@ -82,20 +159,53 @@ func (cd *ClusterDatabase) Exec(client resp.Connection, args db.CmdLine) (result
 	if cmdName == enum.SYS_AUTH.String() {
 		return database.Auth(client, args[1:])
 	}
+	if cmdName == enum.HELLO.String() {
+		return database.Hello(client, args[1:], "cluster")
+	}
 	if !database.IsAuthenticated(client) {
 		return &reply.NormalErrReply{Status: "NOAUTH Authentication required"}
 	}
+	if errReply := database.CheckPermission(client, cmdName, args); errReply != nil {
+		return errReply
+	}
+	if cmdName == enum.TX_EXEC.String() && client.InMultiState() {
+		if errReply := database.CheckQueuedPermission(client); errReply != nil {
+			return errReply
+		}
+	}
+	// RESP2客户端在订阅状态下只能执行订阅相关的命令, 交给本节点处理
+	if client.SubsCount() > 0 && client.GetProtocol() < enum.RESP3 {
+		return cd.db.Exec(client, args)
+	}
+
+	// 开启事务之后命令加入队列, EXEC 时按照key分配到节点执行
+	if client.InMultiState() && !isTxControlCmd(cmdName) {
+		return database.EnqueueCmd(client, args)
+	}
+
+	// ASKING 只对下一条命令有效
+	if cmdName != enum.ASKING.String() {
+		defer client.SetAsking(false)
+	}
 
 	execCmdFunc, ok := router[cmdName]
 	if !ok {
 		return reply.NewErrReplyByError(enum.NOT_SUPPORTED_CMD)
 	}
+	start := time.Now()
 	result = execCmdFunc(cd, client, args)
+	slowlog.Record(client, args, start)
 
 	return
 }
 
 func (cd *ClusterDatabase) Close() error {
+	if cd.consensus != nil {
+		cd.consensus.close()
+	}
+	cd.replication.close()
+	cd.gossip.close()
+	cd.txLog.close()
 	return cd.db.Close()
 }
 
@@ -103,13 +213,36 @@ func (cd *ClusterDatabase) AfterClientClose(client resp.Connection) {
 	cd.db.AfterClientClose(client)
 }
 
-// getPeerClient get a client from the pool
-func (cd *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
+// peerPool returns the connection pool of the peer, the pool is created on first use
+// because nodes can join the cluster by CLUSTER MEET at runtime
+func (cd *ClusterDatabase) peerPool(peer string) *pool.ObjectPool {
+	cd.poolMu.Lock()
+	defer cd.poolMu.Unlock()
 	connectionPool, ok := cd.peerConnection[peer]
 	if !ok {
+		connectionPool = pool.NewObjectPoolWithDefaultConfig(context.Background(), newConnectionFactory(peer, cd.tlsConfig))
+		cd.peerConnection[peer] = connectionPool
+	}
+	return connectionPool
+}
+
+// closePeerPool closes the connection pool of the peer, used when the peer is removed by CLUSTER FORGET
+func (cd *ClusterDatabase) closePeerPool(peer string) {
+	cd.poolMu.Lock()
+	connectionPool, ok := cd.peerConnection[peer]
+	delete(cd.peerConnection, peer)
+	cd.poolMu.Unlock()
+	if ok {
+		connectionPool.Close(context.Background())
+	}
+}
+
+// getPeerClient get a client from the pool
+func (cd *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
+	if peer == "" {
 		return nil, PEER_NOT_FOUND
 	}
-	object, err := connectionPool.BorrowObject(context.Background())
+	object, err := cd.peerPool(peer).BorrowObject(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -124,7 +257,9 @@ func (cd *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
 
 // returnPeerClient returns the client to the pool
 func (cd *ClusterDatabase) returnPeerClient(peer string, oneClient *client.Client) error {
+	cd.poolMu.Lock()
 	objectPool, ok := cd.peerConnection[peer]
+	cd.poolMu.Unlock()
 	if !ok {
 		return PEER_NOT_FOUND
 	}
@@ -134,9 +269,16 @@ func (cd *ClusterDatabase) returnPeerClient(peer string, oneClient *client.Clien
 
 // relay relays the command to the peer
 func (cd *ClusterDatabase) relay(peer string, conn resp.Connection, args db.CmdLine) resp.Reply {
+	return cd.relayTo(peer, conn, args, false)
+}
+
+// relayTo relays the command to the peer, asking means sending ASKING before the command
+// so the peer executes it even though the slot is still being imported
+func (cd *ClusterDatabase) relayTo(peer string, conn resp.Connection, args db.CmdLine, asking bool) resp.Reply {
 	if peer == cd.self {
 		cmdName := string(args[0])
 		if cmdName == enum.TCC_PREPARE.String() ||
+			cmdName == enum.TCC_PREPARE_MULTI.String() ||
 			cmdName == enum.TCC_ROLLBACK.String() ||
 			cmdName == enum.TCC_COMMIT.String() {
 
@@ -145,6 +287,10 @@ func (cd *ClusterDatabase) relay(peer string, conn resp.Connection, args db.CmdL
 
 		return cd.db.Exec(conn, args)
 	}
+	// 下线的节点直接返回错误, 不需要等待连接超时
+	if cd.gossip.isFailed(peer) {
+		return &reply.NormalErrReply{Status: "CLUSTERDOWN Node " + peer + " is failing"}
+	}
 
 	oneClient, err := cd.getPeerClient(peer)
 	if err != nil {
@@ -158,19 +304,22 @@ func (cd *ClusterDatabase) relay(peer string, conn resp.Connection, args db.CmdL
 		cmdLine := utils.ToCmdLine(enum.SELECT.String(), strconv.Itoa(dbIndex))
 		oneClient.Send(cmdLine)
 	}
+	if asking {
+		oneClient.Send(utils.ToCmdLine(enum.ASKING.String()))
+	}
 
 	return oneClient.Send(args)
 }
 
-// broadcast 把指令广播给所有节点, 除了发送给本节点请求的节点
+// broadcast 把指令并行地广播给所有主节点, 除了发送给本节点请求的节点, 副本通过复制执行主节点的写命令
 func (cd *ClusterDatabase) broadcast(connection resp.Connection, args db.CmdLine) map[string]resp.Reply {
-	results := make(map[string]resp.Reply)
-	for _, peer := range cd.nodes {
+	cmdLines := make(map[string]db.CmdLine)
+	for _, peer := range cd.slots.masters() {
 		if peer == cd.self {
-			results[peer] = cd.relay(peer, connection, args)
+			cmdLines[peer] = args
 		} else {
-			results[peer] = cd.relay(peer, connection, modifyCmd(args, string(args[0])+"_"))
+			cmdLines[peer] = modifyCmd(args, string(args[0])+"_")
 		}
 	}
-	return results
+	return cd.relayAll(connection, cmdLines)
 }
@@ -20,7 +20,7 @@ func makeArgs(cmd string, args ...string) [][]byte {
 func (cd *ClusterDatabase) groupBy(keys []string) map[string][]string {
 	result := make(map[string][]string)
 	for _, key := range keys {
-		peer := cd.peerPicker.Pick(key)
+		peer := cd.slots.pick(key)
 		group, ok := result[peer]
 		if !ok {
 			group = make([]string, 0)
@@ -0,0 +1,366 @@
+package cluster_database
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go-redis/client"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/raft"
+	"go-redis/resp/reply"
+)
+
+const (
+	raftTickInterval      = 50 * time.Millisecond
+	raftElectionTicks     = 10   // 选举超时 500ms ~ 1s
+	raftHeartbeatTicks    = 2    // 心跳间隔 100ms
+	raftSnapshotThreshold = 1000 // 快照之后应用了多少条日志时生成新的快照
+	proposeTimeout        = 2 * time.Second
+	consensusInterval     = time.Second // leader 检查Raft成员和故障转移的周期
+)
+
+// errNoLeader Raft组正在选举或者没有多数派
+var errNoLeader = errors.New("no raft leader")
+
+// consensus 使用Raft管理集群的元数据: 槽的分配和节点的角色
+//
+// 启动时配置了 peers 的节点组成初始的Raft组, leader 把集群总线上可以访问的其他节点逐个加入Raft组.
+// leader 还负责故障转移: 主节点被集群总线标记为下线之后, 提交由它的一个副本接替它的修改
+type consensus struct {
+	cluster   *ClusterDatabase
+	node      *raft.Node
+	transport *busTransport
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConsensus(cluster *ClusterDatabase, members []string, persister raft.Persister) (*consensus, error) {
+	transport := newBusTransport(cluster)
+	node, err := raft.NewNode(raft.Config{
+		ID:                cluster.self,
+		Members:           members,
+		Transport:         transport,
+		StateMachine:      &metaMachine{cluster: cluster},
+		Persister:         persister,
+		TickInterval:      raftTickInterval,
+		ElectionTicks:     raftElectionTicks,
+		HeartbeatTicks:    raftHeartbeatTicks,
+		SnapshotThreshold: raftSnapshotThreshold,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &consensus{
+		cluster:   cluster,
+		node:      node,
+		transport: transport,
+		closed:    make(chan struct{}),
+	}, nil
+}
+
+// newPersister 配置了 cluster-config-file 时把Raft的状态保存在文件中
+func newPersister() raft.Persister {
+	if config.Properties.ClusterConfigFile == "" {
+		return nil
+	}
+	return raft.NewFilePersister(config.Properties.ClusterConfigFile)
+}
+
+func (c *consensus) start() error {
+	if err := c.node.Start(); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(consensusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.closed:
+				return
+			case <-ticker.C:
+				c.cron()
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *consensus) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.node.Stop()
+		c.transport.close()
+	})
+}
+
+// joined 本节点是否已经加入Raft组
+func (c *consensus) joined() bool {
+	return len(c.node.Status().Members) > 0
+}
+
+// propose 提交元数据的修改并等待应用到本节点, 不是leader时转发给leader
+//
+// 没有leader或者转发失败时重试, 直到超过 proposeTimeout
+func (c *consensus) propose(cmd *metaCommand) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(proposeTimeout)
+	for {
+		status := c.node.Status()
+		switch status.Leader {
+		case "":
+			err = errNoLeader
+		case c.cluster.self:
+			if _, err = c.node.Propose(data, time.Until(deadline)); err == nil || err == raft.ErrTimeout {
+				return err
+			}
+		default:
+			var index uint64
+			if index, err = c.transport.forward(status.Leader, data); err == nil {
+				return c.node.WaitApplied(index, time.Until(deadline))
+			}
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(raftTickInterval)
+	}
+}
+
+// cron leader 的定时任务
+func (c *consensus) cron() {
+	status := c.node.Status()
+	if status.State != raft.Leader {
+		return
+	}
+	c.reconcileMembers(status.Members)
+	c.checkFailover()
+}
+
+// reconcileMembers 每次增加或删除一个成员: 集群总线上回复过 PONG 的节点加入Raft组, CLUSTER FORGET 删除的节点离开Raft组
+func (c *consensus) reconcileMembers(members []string) {
+	known := make(map[string]bool)
+	for _, node := range c.cluster.slots.knownNodes() {
+		known[node] = true
+	}
+	isMember := make(map[string]bool, len(members))
+	for _, member := range members {
+		isMember[member] = true
+	}
+	var changed []string
+	for _, member := range members {
+		if !known[member] {
+			changed = removeString(members, member)
+			break
+		}
+	}
+	if changed == nil {
+		for node := range known {
+			state := c.cluster.gossip.nodeState(node)
+			if !isMember[node] && !state.pongReceived.IsZero() && state.flags&(flagPFail|flagFail) == 0 {
+				changed = append(append([]string(nil), members...), node)
+				break
+			}
+		}
+	}
+	if changed == nil {
+		return
+	}
+	if _, err := c.node.ProposeMembers(changed, proposeTimeout); err != nil {
+		logger.Warn("cluster: change raft members: " + err.Error())
+		return
+	}
+	logger.Info("cluster: raft members changed to " + strings.Join(changed, ","))
+}
+
+// checkFailover 负责槽的主节点下线之后, 选择地址最小的健康的副本接替它
+func (c *consensus) checkFailover() {
+	owners := make(map[string]bool)
+	for _, r := range c.cluster.slots.ranges() {
+		owners[r.node] = true
+	}
+	for master := range owners {
+		if !c.cluster.gossip.isFailed(master) {
+			continue
+		}
+		for _, replica := range c.cluster.slots.replicasOf(master) {
+			state := c.cluster.gossip.nodeState(replica)
+			healthy := state.flags&flagMyself != 0 || (!state.pongReceived.IsZero() && state.flags&(flagPFail|flagFail) == 0)
+			if !healthy {
+				continue
+			}
+			cmd := &metaCommand{Op: metaFailover, Node: replica, Master: master, Epoch: c.cluster.gossip.epoch() + 1}
+			data, _ := json.Marshal(cmd)
+			if _, err := c.node.Propose(data, proposeTimeout); err != nil {
+				logger.Warn("cluster: failover " + master + " -> " + replica + ": " + err.Error())
+			}
+			break
+		}
+	}
+}
+
+// handle 处理集群总线上的Raft消息, 回复JSON
+func (c *consensus) handle(msgType string, body []byte) resp.Reply {
+	var result any
+	switch msgType {
+	case msgRaftVote:
+		args := &raft.RequestVoteArgs{}
+		if err := json.Unmarshal(body, args); err != nil {
+			return reply.NewErrReplyByError(err)
+		}
+		result = c.node.HandleRequestVote(args)
+	case msgRaftAppend:
+		args := &raft.AppendEntriesArgs{}
+		if err := json.Unmarshal(body, args); err != nil {
+			return reply.NewErrReplyByError(err)
+		}
+		result = c.node.HandleAppendEntries(args)
+	case msgRaftSnapshot:
+		args := &raft.InstallSnapshotArgs{}
+		if err := json.Unmarshal(body, args); err != nil {
+			return reply.NewErrReplyByError(err)
+		}
+		result = c.node.HandleInstallSnapshot(args)
+	case msgRaftPropose:
+		index, err := c.node.Propose(body, proposeTimeout)
+		if err != nil {
+			return reply.NewErrReplyByError(err)
+		}
+		result = &proposeReply{Index: index}
+	default:
+		return reply.NewErrReply("unknown cluster bus message")
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return reply.NewErrReplyByError(err)
+	}
+	return reply.NewBulkReply(data)
+}
+
+// proposeReply leader 提交转发的修改之后回复日志的位置
+type proposeReply struct {
+	Index uint64 `json:"index"`
+}
+
+// busTransport 通过集群总线发送Raft消息
+//
+// 每个节点使用两个连接: 一个发送Raft的RPC, 一个转发修改, 避免等待提交的修改阻塞心跳.
+// 同一个连接同一时间只发送一条消息, 关闭连接时不会有正在等待回复的请求
+type busTransport struct {
+	cluster *ClusterDatabase
+	mu      sync.Mutex
+	links   map[string]*busLink // 节点地址 + 消息类型 -> 连接
+	closed  bool
+}
+
+type busLink struct {
+	mu     sync.Mutex
+	client *client.Client // 为nil时下一次发送之前建立
+}
+
+func newBusTransport(cluster *ClusterDatabase) *busTransport {
+	return &busTransport{cluster: cluster, links: make(map[string]*busLink)}
+}
+
+func (t *busTransport) RequestVote(to string, args *raft.RequestVoteArgs) (*raft.RequestVoteReply, error) {
+	result := &raft.RequestVoteReply{}
+	return result, t.call(to, msgRaftVote, args, result)
+}
+
+func (t *busTransport) AppendEntries(to string, args *raft.AppendEntriesArgs) (*raft.AppendEntriesReply, error) {
+	result := &raft.AppendEntriesReply{}
+	return result, t.call(to, msgRaftAppend, args, result)
+}
+
+func (t *busTransport) InstallSnapshot(to string, args *raft.InstallSnapshotArgs) (*raft.InstallSnapshotReply, error) {
+	result := &raft.InstallSnapshotReply{}
+	return result, t.call(to, msgRaftSnapshot, args, result)
+}
+
+// forward 把修改转发给leader, 返回修改在日志中的位置
+func (t *busTransport) forward(leader string, data []byte) (uint64, error) {
+	result := &proposeReply{}
+	if err := t.send(leader, msgRaftPropose, data, result); err != nil {
+		return 0, err
+	}
+	return result.Index, nil
+}
+
+func (t *busTransport) call(to, msgType string, args, result any) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	return t.send(to, msgType, body, result)
+}
+
+// send 发送消息并解析回复, 失败时关闭连接, 下一次发送时重新连接
+func (t *busTransport) send(to, msgType string, body []byte, result any) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return raft.ErrStopped
+	}
+	key := to + "/" + utils.If(msgType == msgRaftPropose, msgRaftPropose, "RPC")
+	link, ok := t.links[key]
+	if !ok {
+		link = &busLink{}
+		t.links[key] = link
+	}
+	t.mu.Unlock()
+
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	if link.client == nil {
+		peerClient, err := client.NewTLSClient(busAddr(to), t.cluster.tlsConfig)
+		if err != nil {
+			return err
+		}
+		peerClient.Start()
+		link.client = peerClient
+	}
+	r := link.client.Send(utils.ToCmdLine2(msgType, body))
+	bulk, ok := r.(*reply.BulkReply)
+	if !ok {
+		link.client.Close()
+		link.client = nil
+		return errors.New(msgType + " " + to + ": " + strings.TrimSpace(string(r.Bytes())))
+	}
+	return json.Unmarshal(bulk.Arg, result)
+}
+
+// close 关闭所有连接, 等待正在发送的消息返回
+func (t *busTransport) close() {
+	t.mu.Lock()
+	t.closed = true
+	links := t.links
+	t.links = make(map[string]*busLink)
+	t.mu.Unlock()
+	for _, link := range links {
+		link.mu.Lock()
+		if link.client != nil {
+			link.client.Close()
+			link.client = nil
+		}
+		link.mu.Unlock()
+	}
+}
+
+// removeString 返回删除 s 之后的副本
+func removeString(values []string, s string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != s {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -28,22 +28,17 @@ func execDel(clusterDatabase *ClusterDatabase, connection resp.Connection, args
 			return clusterDatabase.relay(peer, connection, args)
 		}
 	}
-	// prepare
-	var errReply resp.Reply
+	// prepare, send to all nodes concurrently
 	txID := clusterDatabase.idGenerator.NextID()
 	txIDStr := strconv.FormatInt(txID, 10)
-	rollback := false
+	cmdLines := make(map[string]db.CmdLine, len(groupMap))
 	for peer, peerKeys := range groupMap {
-		peerArgs := []string{txIDStr, enum.DEL.String()}
+		peerArgs := []string{txIDStr, clusterDatabase.self, enum.DEL.String()}
 		peerArgs = append(peerArgs, peerKeys...)
-		var resp resp.Reply
-		resp = clusterDatabase.relay(peer, connection, makeArgs(enum.TCC_PREPARE.String(), peerArgs...))
-		if reply.IsErrReply(resp) {
-			errReply = resp
-			rollback = true
-			break
-		}
+		cmdLines[peer] = makeArgs(enum.TCC_PREPARE.String(), peerArgs...)
 	}
+	errReply := fanOutError(clusterDatabase.relayAll(connection, cmdLines))
+	rollback := errReply != nil
 	var respList []resp.Reply
 	if rollback {
 		// rollback
@@ -68,4 +63,5 @@ func execDel(clusterDatabase *ClusterDatabase, connection resp.Connection, args
 
 func init() {
 	registerRouter(enum.DEL, execDel)
+	registerRouter(enum.UNLINK, execDel)
 }
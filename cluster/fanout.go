@@ -0,0 +1,98 @@
+package cluster_database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go-redis/config"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+)
+
+// requestTimeout 返回 cluster-request-timeout
+func requestTimeout() time.Duration {
+	return time.Duration(config.ClusterRequestTimeout()) * time.Millisecond
+}
+
+// fanOut 并行地对每个节点执行 call, 返回每个节点的回复, 耗时是最慢的节点而不是所有节点之和
+//
+// 超过 cluster-request-timeout 没有回复的节点返回超时错误, 不再等待这个节点, 之后的回复被丢弃
+func (cd *ClusterDatabase) fanOut(nodes []string, call func(node string) resp.Reply) map[string]resp.Reply {
+	type result struct {
+		node  string
+		reply resp.Reply
+	}
+	// 缓冲区可以放下所有的回复, 超时之后回复的节点不会阻塞
+	results := make(chan result, len(nodes))
+	for _, node := range nodes {
+		go func(node string) {
+			results <- result{node: node, reply: call(node)}
+		}(node)
+	}
+	replies := make(map[string]resp.Reply, len(nodes))
+	timer := time.NewTimer(requestTimeout())
+	defer timer.Stop()
+	for len(replies) < len(nodes) {
+		select {
+		case r := <-results:
+			replies[r.node] = r.reply
+		case <-timer.C:
+			for _, node := range nodes {
+				if _, ok := replies[node]; !ok {
+					replies[node] = &reply.NormalErrReply{Status: "TIMEOUT node " + node + " did not reply in time"}
+				}
+			}
+		}
+	}
+	return replies
+}
+
+// relayAll 并行地把每个节点的命令转发给这个节点
+func (cd *ClusterDatabase) relayAll(conn resp.Connection, cmdLines map[string]db.CmdLine) map[string]resp.Reply {
+	nodes := make([]string, 0, len(cmdLines))
+	for node := range cmdLines {
+		nodes = append(nodes, node)
+	}
+	return cd.fanOut(nodes, func(node string) resp.Reply {
+		return cd.relay(node, conn, cmdLines[node])
+	})
+}
+
+// fanOutError 返回失败的节点的错误, 都成功时返回 nil
+//
+// 只有一个节点失败时原样返回这个节点的错误, 多个节点失败时按照节点地址的顺序返回每个节点的错误
+func fanOutError(replies map[string]resp.Reply) resp.Reply {
+	var failed []string
+	for node, r := range replies {
+		if reply.IsErrReply(r) {
+			failed = append(failed, node)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	if len(failed) == 1 {
+		return replies[failed[0]]
+	}
+	sort.Strings(failed)
+	errs := make([]string, len(failed))
+	for i, node := range failed {
+		errs[i] = node + ": " + replies[node].(resp.ErrorReply).Error()
+	}
+	return reply.NewErrReply(fmt.Sprintf("%d of %d nodes failed: %s", len(failed), len(replies), strings.Join(errs, "; ")))
+}
+
+// groupIndexes 根据keys选取存储数据的节点, 返回节点地址 -> 存储在此节点的key在keys中的下标
+//
+// 和 groupBy 不同, 合并每个节点的回复时可以按照下标恢复keys的顺序
+func (cd *ClusterDatabase) groupIndexes(keys []string) map[string][]int {
+	result := make(map[string][]int)
+	for i, key := range keys {
+		node := cd.slots.pick(key)
+		result[node] = append(result[node], i)
+	}
+	return result
+}
@@ -0,0 +1,171 @@
+package cluster_database
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-redis/config"
+	"go-redis/enum"
+	"go-redis/interface/resp"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+)
+
+func TestFanOut(t *testing.T) {
+	timeout := config.ClusterRequestTimeout()
+	if err := config.Set("cluster-request-timeout", "100"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = config.Set("cluster-request-timeout", strconv.Itoa(timeout)) }()
+
+	// 节点并行执行, 超时的节点返回错误
+	node := newGossipTestNode("127.0.0.1:7000", "127.0.0.1:7000")
+	start := time.Now()
+	replies := node.fanOut([]string{"a", "b", "c"}, func(peer string) resp.Reply {
+		switch peer {
+		case "a":
+			time.Sleep(50 * time.Millisecond)
+			return reply.NewOKReply()
+		case "b":
+			time.Sleep(50 * time.Millisecond)
+			return reply.NewErrReply("b failed")
+		}
+		time.Sleep(time.Second)
+		return reply.NewOKReply()
+	})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("fan out should not wait for the slow node, took %v", elapsed)
+	}
+	asserts.AssertStatusReply(t, replies["a"], "OK")
+	asserts.AssertErrReply(t, replies["c"], "TIMEOUT node c did not reply in time")
+
+	// 多个节点失败时返回每个节点的错误
+	errReply := fanOutError(replies)
+	asserts.AssertErrReply(t, errReply, "ERR 2 of 3 nodes failed: b: ERR b failed; c: TIMEOUT node c did not reply in time")
+	delete(replies, "c")
+	asserts.AssertErrReply(t, fanOutError(replies), "ERR b failed")
+	delete(replies, "b")
+	if fanOutError(replies) != nil {
+		t.Fatal("expected no error")
+	}
+}
+
+// newFakePeer 模拟集群中的另一个节点, handler 处理一条命令, HELLO 3 之后按照RESP3编码回复
+func newFakePeer(t *testing.T, handler func(args []string) resp.Reply) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func() { _ = conn.Close() }()
+				reader := parser.NewRequestReader(conn)
+				protocol := enum.RESP2
+				for {
+					data, fatal, err := reader.ReadReply()
+					if fatal {
+						return
+					}
+					cmd, ok := data.(*reply.MultiBulkReply)
+					if err != nil || !ok {
+						continue
+					}
+					args := utils.CmdLine2Strings(cmd.Args)
+					var r resp.Reply
+					if strings.ToUpper(args[0]) == enum.HELLO.String() && len(args) > 1 && args[1] == "3" {
+						protocol = enum.RESP3
+						r = reply.NewMapReply([]resp.Reply{reply.NewBulkReply([]byte("proto")), reply.NewIntReply(3)})
+					} else {
+						r = handler(args)
+					}
+					if _, err = conn.Write(reply.Marshal(r, protocol)); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// newMGetPeer 模拟集群中的另一个节点, MGET 返回 peer:key, 其他命令返回 OK
+func newMGetPeer(t *testing.T) string {
+	return newFakePeer(t, func(args []string) resp.Reply {
+		if strings.ToUpper(args[0]) != "MGET" {
+			return reply.NewOKReply()
+		}
+		values := make([][]byte, 0, len(args)-1)
+		for _, key := range args[1:] {
+			values = append(values, []byte("peer:"+key))
+		}
+		return reply.NewMultiBulkReply(values)
+	})
+}
+
+func TestMGet(t *testing.T) {
+	self, peer := "127.0.0.1:7000", newMGetPeer(t)
+	node := newGossipTestNode(self, self, peer)
+	conn := connection.NewFakeConn()
+	node.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+
+	// 按照参数中key的顺序合并两个节点的回复, 本节点上不存在的key返回nil
+	keys := []string{"foo", "bar", "a", "b", "c", "d"}
+	expected := make([]string, len(keys))
+	for i, key := range keys {
+		node.db.Exec(conn, utils.ToCmdLine("DEL", key))
+		if node.slots.pick(key) == peer {
+			expected[i] = "peer:" + key
+		} else if i%2 == 0 {
+			node.db.Exec(conn, utils.ToCmdLine("SET", key, "self:"+key))
+			expected[i] = "self:" + key
+		}
+	}
+	if len(node.groupIndexes(keys)) != 2 {
+		t.Fatalf("keys should be on both nodes")
+	}
+	asserts.AssertMultiBulkReply(t, node.Exec(conn, utils.ToCmdLine(append([]string{"MGET"}, keys...)...)), expected)
+	node.db.Exec(conn, utils.ToCmdLine(append([]string{"DEL"}, keys...)...))
+}
+
+func TestRelayResp3(t *testing.T) {
+	peer := newFakePeer(t, func(args []string) resp.Reply {
+		switch strings.ToUpper(args[0]) {
+		case "HGETALL":
+			return reply.NewBulkMapReply(utils.ToCmdLine("f", "v"))
+		case "SMEMBERS":
+			return reply.NewBulkSetReply(utils.ToCmdLine("peer:" + args[1]))
+		}
+		return reply.NewOKReply()
+	})
+	self := "127.0.0.1:7000"
+	node := newGossipTestNode(self, self, peer)
+	conn := connection.NewFakeConn()
+	node.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+
+	// 和其他节点的连接使用RESP3, 转发的回复保留原来的类型
+	if _, ok := node.relay(peer, conn, utils.ToCmdLine("HGETALL", "h")).(*reply.MapReply); !ok {
+		t.Fatal("relayed reply should keep the map type")
+	}
+	var peerKey string
+	for i := 0; peerKey == ""; i++ {
+		if key := "relay:" + strconv.Itoa(i); node.slots.pick(key) == peer {
+			peerKey = key
+		}
+	}
+	sets, errReply := node.fetchSets(conn, []string{peerKey})
+	if errReply != nil || sets[0].Len() != 1 || !sets[0].Contains("peer:"+peerKey) {
+		t.Fatalf("unexpected sets %v %v", sets, errReply)
+	}
+}
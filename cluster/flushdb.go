@@ -13,10 +13,8 @@ func execFlushDB(clusterDatabase *ClusterDatabase, connection resp.Connection, a
 	}
 
 	results := clusterDatabase.broadcast(connection, args)
-	for _, r := range results {
-		if reply.IsErrReply(r) {
-			return r
-		}
+	if errReply := fanOutError(results); errReply != nil {
+		return errReply
 	}
 
 	return reply.NewOKReply()
@@ -0,0 +1,488 @@
+package cluster_database
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-redis/client"
+	"go-redis/config"
+	"go-redis/lib/logger"
+	"go-redis/lib/slot"
+	"go-redis/lib/utils"
+)
+
+const (
+	gossipInterval = 100 * time.Millisecond // 定时任务的周期, 每10次随机 PING 一个节点
+	gossipSample   = 5                      // 随机 PING 时的候选节点数量, 选择最久没有收到 PONG 的节点
+	gossipFanout   = 3                      // 每条消息最少携带的其他节点的数量
+	failReportTTL  = 2                      // 下线报告的有效期是 cluster-node-timeout 的倍数
+	forgetBanTime  = 60 * time.Second       // CLUSTER FORGET 之后多长时间内不会通过 PING 重新加入
+)
+
+// nodeFlags 节点的状态
+type nodeFlags uint8
+
+const (
+	flagMyself nodeFlags = 1 << iota // 本节点
+	flagPFail                        // 疑似下线: 本节点超过 cluster-node-timeout 没有收到 PONG
+	flagFail                         // 下线: 超过半数负责槽的节点认为疑似下线
+	flagMeet                         // CLUSTER MEET 添加的节点, 收到 PONG 之前发送 MEET 而不是 PING
+)
+
+// clusterNode 本节点看到的其他节点的状态
+type clusterNode struct {
+	addr         string
+	configEpoch  uint64 // 节点的配置纪元, 两个节点声明负责同一个槽时纪元大的节点生效
+	flags        nodeFlags
+	pingSent     time.Time            // 最早的还没有收到 PONG 的 PING 的发送时间, 零值表示没有等待的 PING
+	pongReceived time.Time            // 最近一次收到 PONG 的时间
+	inflight     bool                 // 是否正在发送 PING, 同一时间每个节点只发送一个 PING
+	failReports  map[string]time.Time // 报告节点疑似下线的节点 -> 最近一次报告的时间
+	link         *client.Client       // 到节点集群总线的连接, 为nil时下一次发送之前建立
+}
+
+// gossip 集群总线的状态, 节点之间定时交换 PING/PONG 传播节点、配置纪元和槽的分配, 并检测节点下线
+//
+// 节点的集合和 slotTable 一致: 通过 MEET、PING 或者其他节点的消息知道的节点会加入 slotTable,
+// 通过 MOVED 或者 SETSLOT 加入 slotTable 的节点在下一次定时任务中开始交换消息
+type gossip struct {
+	cluster      *ClusterDatabase
+	mu           sync.Mutex
+	currentEpoch uint64                  // 集群中已知的最大纪元
+	nodes        map[string]*clusterNode // 节点地址 -> 节点状态, 包括本节点
+	banned       map[string]time.Time    // CLUSTER FORGET 的节点 -> 禁止重新加入的截止时间
+	listener     net.Listener
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+func newGossip(cluster *ClusterDatabase) *gossip {
+	g := &gossip{
+		cluster: cluster,
+		nodes:   make(map[string]*clusterNode),
+		banned:  make(map[string]time.Time),
+		closed:  make(chan struct{}),
+	}
+	g.addNodeLocked(cluster.self).flags = flagMyself
+	for _, node := range cluster.slots.knownNodes() {
+		if node != cluster.self {
+			g.addNodeLocked(node)
+		}
+	}
+	return g
+}
+
+// nodeTimeout 返回 cluster-node-timeout
+func nodeTimeout() time.Duration {
+	return time.Duration(config.ClusterNodeTimeout()) * time.Millisecond
+}
+
+// start 监听集群总线并开始定时任务, 监听失败时仍然可以主动向其他节点发送消息
+func (g *gossip) start() {
+	if err := g.listen(); err != nil {
+		logger.Error("cluster bus: " + err.Error())
+	}
+	go func() {
+		ticker := time.NewTicker(gossipInterval)
+		defer ticker.Stop()
+		for tick := 0; ; tick++ {
+			select {
+			case <-g.closed:
+				return
+			case <-ticker.C:
+				g.cron(tick)
+			}
+		}
+	}()
+}
+
+// close 停止定时任务, 关闭监听和所有连接
+func (g *gossip) close() {
+	g.closeOnce.Do(func() {
+		close(g.closed)
+		if g.listener != nil {
+			_ = g.listener.Close()
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for _, node := range g.nodes {
+			// 正在发送的连接在 ping 返回时关闭
+			if node.link != nil && !node.inflight {
+				node.link.Close()
+				node.link = nil
+			}
+		}
+	})
+}
+
+func (g *gossip) addNodeLocked(addr string) *clusterNode {
+	node := &clusterNode{addr: addr, failReports: make(map[string]time.Time)}
+	g.nodes[addr] = node
+	delete(g.banned, addr)
+	g.cluster.slots.addNode(addr)
+	return node
+}
+
+func (g *gossip) isBanned(addr string, now time.Time) bool {
+	deadline, ok := g.banned[addr]
+	if ok && now.After(deadline) {
+		delete(g.banned, addr)
+		return false
+	}
+	return ok
+}
+
+// meet 添加节点并在下一次定时任务中向它发送 MEET, 被禁止的节点也可以重新加入
+func (g *gossip) meet(addr string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	node, ok := g.nodes[addr]
+	if !ok {
+		node = g.addNodeLocked(addr)
+	}
+	if node.flags&flagMyself == 0 && node.pongReceived.IsZero() {
+		node.flags |= flagMeet
+	}
+}
+
+// forget 删除节点, 节点负责的槽变为没有分配, forgetBanTime 内忽略这个节点的 PING 和其他节点对它的传播
+func (g *gossip) forget(addr string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if node, ok := g.nodes[addr]; ok && node.link != nil && !node.inflight {
+		node.link.Close()
+	}
+	delete(g.nodes, addr)
+	for _, node := range g.nodes {
+		delete(node.failReports, addr)
+	}
+	g.banned[addr] = time.Now().Add(forgetBanTime)
+	g.cluster.slots.removeNode(addr)
+}
+
+// nodeState 返回节点状态的副本, 用于 CLUSTER NODES
+func (g *gossip) nodeState(addr string) clusterNode {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if node, ok := g.nodes[addr]; ok {
+		return *node
+	}
+	return clusterNode{addr: addr}
+}
+
+// isFailed 节点是否已经下线, 发送给下线节点的命令直接返回错误
+func (g *gossip) isFailed(addr string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	node, ok := g.nodes[addr]
+	return ok && node.flags&flagFail != 0
+}
+
+// bumpEpoch 本节点通过 SETSLOT NODE 获得槽时增加配置纪元, 其他节点通过 PING 收到更大的纪元之后接受新的分配
+//
+// 本节点的纪元已经是集群中最大的时不需要增加
+func (g *gossip) bumpEpoch() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	self := g.nodes[g.cluster.self]
+	if self.configEpoch == 0 || self.configEpoch < g.currentEpoch {
+		g.currentEpoch++
+		self.configEpoch = g.currentEpoch
+		logger.Info("config epoch bumped to " + formatEpoch(self.configEpoch))
+	}
+}
+
+// observeEpoch 通过Raft提交的故障转移使用的配置纪元, 所有节点立即更新新主节点的纪元, 不需要等待它的 PING
+func (g *gossip) observeEpoch(addr string, epoch uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.currentEpoch = max(g.currentEpoch, epoch)
+	if node, ok := g.nodes[addr]; ok && node.configEpoch < epoch {
+		node.configEpoch = epoch
+	}
+}
+
+// epoch 返回集群中已知的最大纪元
+func (g *gossip) epoch() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.currentEpoch
+}
+
+// cron 定时任务
+//
+//  1. 同步 slotTable 中新增的节点
+//  2. 每秒随机选择几个节点, PING 其中最久没有收到 PONG 的节点
+//  3. 超过一半的超时时间没有收到 PONG 的节点立即 PING, 上一次 PING 失败的节点每秒重试
+//  4. 超过超时时间没有收到 PONG 的节点标记为疑似下线
+func (g *gossip) cron(tick int) {
+	now := time.Now()
+	timeout := nodeTimeout()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// 1. 同步新增的节点
+	for _, addr := range g.cluster.slots.knownNodes() {
+		if _, ok := g.nodes[addr]; !ok && !g.isBanned(addr, now) {
+			g.addNodeLocked(addr)
+		}
+	}
+	// 2. 随机 PING
+	targets := make([]*clusterNode, 0)
+	if tick%10 == 0 {
+		if node := g.randomNodeLocked(); node != nil {
+			targets = append(targets, node)
+			node.inflight = true
+		}
+	}
+	for _, node := range g.nodes {
+		if node.flags&flagMyself != 0 {
+			continue
+		}
+		// 4. 疑似下线
+		if !node.pingSent.IsZero() && now.Sub(node.pingSent) > timeout && node.flags&(flagPFail|flagFail) == 0 {
+			node.flags |= flagPFail
+			logger.Warn("*** NODE " + node.addr + " possibly failing")
+			g.checkFailingLocked(node, now)
+		}
+		// 3. 超时和重试
+		if node.inflight {
+			continue
+		}
+		if (node.pingSent.IsZero() && now.Sub(node.pongReceived) > timeout/2) || (!node.pingSent.IsZero() && tick%10 == 0) {
+			targets = append(targets, node)
+			node.inflight = true
+		}
+	}
+	for _, node := range targets {
+		if node.pingSent.IsZero() {
+			node.pingSent = now
+		}
+		go g.ping(node.addr)
+	}
+}
+
+// randomNodeLocked 随机选择 gossipSample 个没有等待 PONG 的节点, 返回最久没有收到 PONG 的节点
+func (g *gossip) randomNodeLocked() *clusterNode {
+	var oldest *clusterNode
+	candidates := 0
+	for _, node := range g.nodes { // map 的遍历顺序是随机的
+		if node.flags&flagMyself != 0 || node.inflight || !node.pingSent.IsZero() {
+			continue
+		}
+		if oldest == nil || node.pongReceived.Before(oldest.pongReceived) {
+			oldest = node
+		}
+		if candidates++; candidates >= gossipSample {
+			break
+		}
+	}
+	return oldest
+}
+
+// ping 向节点发送 PING 或者 MEET, 收到 PONG 之后清除节点的下线状态
+func (g *gossip) ping(addr string) {
+	g.mu.Lock()
+	node, ok := g.nodes[addr]
+	if !ok {
+		g.mu.Unlock()
+		return
+	}
+	msg := g.newMessageLocked(utils.If(node.flags&flagMeet != 0, msgMeet, msgPing))
+	link := node.link
+	g.mu.Unlock()
+
+	pong, link, err := g.send(addr, link, msg)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	node, ok = g.nodes[addr]
+	if !ok || g.isClosed() { // 发送期间节点被删除或者集群总线已经关闭
+		if link != nil {
+			link.Close()
+		}
+		return
+	}
+	node.inflight = false
+	node.link = link
+	if err != nil {
+		logger.Debug("cluster bus: ping " + addr + ": " + err.Error())
+		return
+	}
+	node.pingSent = time.Time{}
+	node.pongReceived = time.Now()
+	node.flags &^= flagMeet
+	if node.flags&(flagPFail|flagFail) != 0 {
+		node.flags &^= flagPFail | flagFail
+		logger.Info("clear FAIL state for node " + addr + ": is reachable again")
+	}
+	g.processLocked(pong)
+}
+
+// processLocked 处理其他节点发送的消息: 更新纪元和槽的分配, 处理消息携带的其他节点的状态
+func (g *gossip) processLocked(msg *busMessage) {
+	now := time.Now()
+	if msg.Sender == g.cluster.self {
+		return
+	}
+	sender, ok := g.nodes[msg.Sender]
+	if !ok {
+		// 被禁止的节点只能通过 MEET 重新加入
+		if msg.Type != msgMeet && g.isBanned(msg.Sender, now) {
+			return
+		}
+		sender = g.addNodeLocked(msg.Sender)
+		logger.Info("cluster bus: node " + msg.Sender + " joined")
+	}
+	g.currentEpoch = max(g.currentEpoch, msg.CurrentEpoch)
+	sender.configEpoch = msg.ConfigEpoch
+	g.updateSlotsLocked(sender, msg.Slots)
+
+	if msg.Type == msgFail {
+		if node, ok := g.nodes[msg.Failing]; ok && node.flags&(flagMyself|flagFail) == 0 {
+			node.flags = node.flags&^flagPFail | flagFail
+			logger.Warn("FAIL message received from " + msg.Sender + " about " + msg.Failing)
+		}
+	}
+	for _, entry := range msg.Gossip {
+		g.processGossipLocked(sender, entry, now)
+	}
+}
+
+// updateSlotsLocked 发送者声明负责的槽没有分配, 或者当前负责的节点的纪元更小时, 改为由发送者负责
+func (g *gossip) updateSlotsLocked(sender *clusterNode, ranges [][2]int) {
+	for _, r := range ranges {
+		for s := max(r[0], 0); s <= min(r[1], slot.Count-1); s++ {
+			owner := g.cluster.slots.owner(s)
+			if owner == sender.addr {
+				continue
+			}
+			if current, ok := g.nodes[owner]; owner == "" || (ok && current.configEpoch < sender.configEpoch) {
+				g.cluster.slots.setOwner(s, sender.addr)
+			}
+		}
+	}
+}
+
+// processGossipLocked 处理发送者对其他节点的描述: 发现新的节点, 记录或者撤销下线报告
+func (g *gossip) processGossipLocked(sender *clusterNode, entry gossipEntry, now time.Time) {
+	if entry.Node == g.cluster.self || entry.ID != nodeID(entry.Node) {
+		return
+	}
+	node, ok := g.nodes[entry.Node]
+	if !ok {
+		if !g.isBanned(entry.Node, now) {
+			g.addNodeLocked(entry.Node)
+			logger.Info("cluster bus: discovered node " + entry.Node + " from " + sender.addr)
+		}
+		return
+	}
+	// 只有负责槽的节点的报告参与下线判断
+	if !g.ownsSlots(sender.addr) {
+		return
+	}
+	if entry.Flags&(flagPFail|flagFail) != 0 {
+		node.failReports[sender.addr] = now
+		g.checkFailingLocked(node, now)
+	} else {
+		delete(node.failReports, sender.addr)
+	}
+}
+
+// checkFailingLocked 疑似下线的节点收到超过半数负责槽的节点的报告时标记为下线, 并通知所有节点
+//
+// 本节点负责槽时本节点的判断也算作一个报告, 超过有效期的报告不计算在内
+func (g *gossip) checkFailingLocked(node *clusterNode, now time.Time) {
+	if node.flags&flagPFail == 0 {
+		return
+	}
+	voters := make(map[string]bool)
+	for _, r := range g.cluster.slots.ranges() {
+		voters[r.node] = true
+	}
+	reports := 0
+	if voters[g.cluster.self] {
+		reports++
+	}
+	validity := nodeTimeout() * failReportTTL
+	for reporter, reported := range node.failReports {
+		if now.Sub(reported) > validity {
+			delete(node.failReports, reporter)
+		} else if voters[reporter] {
+			reports++
+		}
+	}
+	if reports < len(voters)/2+1 {
+		return
+	}
+	node.flags = node.flags&^flagPFail | flagFail
+	logger.Warn("marking node " + node.addr + " as failing (quorum reached)")
+	msg := g.newMessageLocked(msgFail)
+	msg.Failing = node.addr
+	for addr := range g.nodes {
+		if addr != g.cluster.self && addr != node.addr {
+			go g.sendOnce(addr, msg)
+		}
+	}
+}
+
+// ownsSlots 节点是否负责至少一个槽
+func (g *gossip) ownsSlots(addr string) bool {
+	for _, r := range g.cluster.slots.ranges() {
+		if r.node == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// newMessageLocked 创建本节点发送的消息, 携带本节点负责的槽和随机选择的其他节点, 疑似下线和下线的节点总是包括在内
+func (g *gossip) newMessageLocked(msgType string) *busMessage {
+	self := g.nodes[g.cluster.self]
+	msg := &busMessage{
+		Type:         msgType,
+		Sender:       self.addr,
+		ID:           nodeID(self.addr),
+		CurrentEpoch: g.currentEpoch,
+		ConfigEpoch:  self.configEpoch,
+		Slots:        make([][2]int, 0),
+		Gossip:       make([]gossipEntry, 0),
+	}
+	for _, r := range g.cluster.slots.ranges() {
+		if r.node == self.addr {
+			msg.Slots = append(msg.Slots, [2]int{r.start, r.end})
+		}
+	}
+	others := make([]*clusterNode, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		if node != self {
+			others = append(others, node)
+		}
+	}
+	rand.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	wanted := max(gossipFanout, len(others)/10)
+	for i, node := range others {
+		flags := node.flags & (flagPFail | flagFail)
+		if i < wanted || flags != 0 {
+			msg.Gossip = append(msg.Gossip, gossipEntry{Node: node.addr, ID: nodeID(node.addr), Flags: flags})
+		}
+	}
+	return msg
+}
+
+func (g *gossip) isClosed() bool {
+	select {
+	case <-g.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// formatEpoch 格式化纪元, 用于日志和 CLUSTER NODES
+func formatEpoch(epoch uint64) string {
+	return strconv.FormatUint(epoch, 10)
+}
@@ -0,0 +1,153 @@
+package cluster_database
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	pool "github.com/jolestar/go-commons-pool/v2"
+	"go-redis/config"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+// newGossipTestNode 创建没有启动集群总线的节点, nodes 平均分配所有的槽
+func newGossipTestNode(self string, nodes ...string) *ClusterDatabase {
+	cluster := &ClusterDatabase{
+		self:           self,
+		slots:          newSlotTable(nodes),
+		peerConnection: make(map[string]*pool.ObjectPool),
+		db:             testNodeA.db,
+	}
+	cluster.slots.addNode(self)
+	cluster.gossip = newGossip(cluster)
+	return cluster
+}
+
+// waitFor 等待条件成立, 超时之后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestGossipMessages(t *testing.T) {
+	a, b, c, d := "127.0.0.1:7000", "127.0.0.1:7001", "127.0.0.1:7002", "127.0.0.1:7003"
+	cluster := newGossipTestNode(a, a, b, c)
+	g := cluster.gossip
+	message := func(sender string, configEpoch uint64, slots [][2]int, entries ...gossipEntry) *busMessage {
+		return &busMessage{Type: msgPing, Sender: sender, ID: nodeID(sender), CurrentEpoch: configEpoch,
+			ConfigEpoch: configEpoch, Slots: slots, Gossip: entries}
+	}
+
+	// 纪元更大的节点声明的槽生效
+	g.processLocked(message(b, 1, [][2]int{{0, 1}}))
+	g.processLocked(message(c, 0, [][2]int{{0, 0}}))
+	if cluster.slots.owner(0) != b || cluster.slots.owner(1) != b || g.currentEpoch != 1 {
+		t.Errorf("expected slots 0-1 on %s with epoch 1, actually %s, %s, %d",
+			b, cluster.slots.owner(0), cluster.slots.owner(1), g.currentEpoch)
+	}
+	g.bumpEpoch()
+	if epoch := g.nodes[a].configEpoch; epoch != 2 {
+		t.Errorf("expected config epoch 2, actually %d", epoch)
+	}
+
+	// 通过其他节点的消息发现新节点, 节点ID不一致的描述被忽略
+	g.processLocked(message(c, 0, nil, gossipEntry{Node: d, ID: nodeID(d)}, gossipEntry{Node: "127.0.0.1:7004", ID: "x"}))
+	if nodes := cluster.slots.knownNodes(); len(nodes) != 4 || nodes[3] != d {
+		t.Errorf("expected %s to be discovered, actually %v", d, nodes)
+	}
+
+	// 疑似下线的节点收到超过半数负责槽的节点的报告之后下线
+	g.nodes[b].flags |= flagPFail
+	g.processLocked(message(d, 0, nil, gossipEntry{Node: b, ID: nodeID(b), Flags: flagPFail}))
+	if g.isFailed(b) {
+		t.Errorf("reports from nodes without slots should be ignored")
+	}
+	g.processLocked(message(c, 0, nil, gossipEntry{Node: b, ID: nodeID(b), Flags: flagPFail}))
+	if !g.isFailed(b) {
+		t.Fatalf("expected %s to be failing", b)
+	}
+	conn := connection.NewFakeConn()
+	asserts.AssertErrReply(t, cluster.relay(b, conn, utils.ToCmdLine("get", "a")), "CLUSTERDOWN Node "+b+" is failing")
+	nodes := execCluster(cluster, conn, utils.ToCmdLine("cluster", "nodes")).(*reply.BulkReply)
+	if !strings.Contains(string(nodes.Arg), nodeID(b)+" "+b+"@17001 master,fail - ") ||
+		!strings.Contains(string(nodes.Arg), nodeID(a)+" "+a+"@17000 myself,master - 0 0 2 connected 2-5461") {
+		t.Errorf("unexpected cluster nodes %q", nodes.Arg)
+	}
+
+	// 删除的节点只能通过 MEET 重新加入
+	asserts.AssertStatusReply(t, execCluster(cluster, conn, utils.ToCmdLine("cluster", "forget", nodeID(d))), "OK")
+	asserts.AssertErrReply(t, execCluster(cluster, conn, utils.ToCmdLine("cluster", "forget", nodeID(d))), "ERR Unknown node "+nodeID(d))
+	asserts.AssertErrReply(t, execCluster(cluster, conn, utils.ToCmdLine("cluster", "forget", a)), "ERR I tried hard but I can't forget myself...")
+	g.processLocked(message(c, 0, nil, gossipEntry{Node: d, ID: nodeID(d)}))
+	g.processLocked(message(d, 0, nil))
+	if len(cluster.slots.knownNodes()) != 3 {
+		t.Errorf("forgotten node should not join by gossip, actually %v", cluster.slots.knownNodes())
+	}
+	meet := message(d, 0, nil)
+	meet.Type = msgMeet
+	g.processLocked(meet)
+	if len(cluster.slots.knownNodes()) != 4 {
+		t.Errorf("forgotten node should join by MEET, actually %v", cluster.slots.knownNodes())
+	}
+
+	r := g.handle(utils.ToCmdLine(msgPing, `{"sender":"127.0.0.1:7005","id":"x"}`))
+	asserts.AssertErrReply(t, r, "ERR node id mismatch for 127.0.0.1:7005")
+	asserts.AssertStatusReply(t, g.handle(utils.ToCmdLine(msgPing)), msgPong)
+}
+
+// freeNodeAddr 返回集群总线端口空闲的节点地址
+func freeNodeAddr(t *testing.T) string {
+	for {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		_ = listener.Close()
+		if port > busPortOffset {
+			return net.JoinHostPort("127.0.0.1", strconv.Itoa(port-busPortOffset))
+		}
+	}
+}
+
+func TestGossipBus(t *testing.T) {
+	timeout := config.ClusterNodeTimeout()
+	if err := config.Set("cluster-node-timeout", "300"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = config.Set("cluster-node-timeout", strconv.Itoa(timeout)) }()
+
+	x, y := freeNodeAddr(t), freeNodeAddr(t)
+	nodeX := newGossipTestNode(x, x)
+	nodeY := newGossipTestNode(y)
+	nodeX.gossip.start()
+	nodeY.gossip.start()
+	defer nodeX.gossip.close()
+
+	// 新节点通过 MEET 加入集群, 从 PONG 中获得槽的分配
+	host, port := splitAddr(x)
+	conn := connection.NewFakeConn()
+	r := execCluster(nodeY, conn, utils.ToCmdLine("cluster", "meet", host, strconv.Itoa(port)))
+	asserts.AssertStatusReply(t, r, "OK")
+	waitFor(t, "node x to know node y", func() bool { return len(nodeX.slots.knownNodes()) == 2 })
+	waitFor(t, "node y to load slots", func() bool { return nodeY.slots.owner(0) == x })
+	waitFor(t, "node y to connect node x", func() bool {
+		nodes := execCluster(nodeY, conn, utils.ToCmdLine("cluster", "nodes")).(*reply.BulkReply)
+		return strings.Contains(string(nodes.Arg), x+"@"+strconv.Itoa(port+busPortOffset)+" master - ")
+	})
+
+	// 停止的节点超过超时时间之后疑似下线, 只有一个节点负责槽时立即下线
+	nodeY.gossip.close()
+	waitFor(t, "node y to fail", func() bool { return nodeX.gossip.isFailed(y) })
+}
@@ -13,17 +13,10 @@ import (
 // flushDB removes all data in current database
 func flushDB(cluster *ClusterDatabase, conn resp.Connection, cmdLine db.CmdLine) resp.Reply {
 	replies := cluster.broadcast(conn, cmdLine)
-	var errReply resp.ErrorReply
-	for _, v := range replies {
-		if reply.IsErrReply(v) {
-			errReply = v.(resp.ErrorReply)
-			break
-		}
-	}
-	if errReply == nil {
-		return reply.NewOKReply()
+	if errReply := fanOutError(replies); errReply != nil {
+		return &reply.NormalErrReply{Status: "error occurs: " + errReply.(resp.ErrorReply).Error()}
 	}
-	return &reply.NormalErrReply{Status: "error occurs: " + errReply.Error()}
+	return reply.NewOKReply()
 }
 
 // execFlushAll removes all data in cluster
@@ -45,33 +38,24 @@ func execExists(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine)
 			return cluster.relay(peer, conn, args)
 		}
 	}
-	// 3. 发送命令给各节点, 计算存在的key的数量
-	var errReply resp.Reply
-	counter := int64(0)
+	// 3. 并行发送命令给各节点
+	cmdLines := make(map[string]db.CmdLine, len(groupMap))
 	for peer, group := range groupMap {
-		peerArgs := make([]string, len(group)+1)
-		peerArgs[0] = enum.EXISTS.String()
-		for i, k := range group {
-			peerArgs[i+1] = k
-		}
-		// 3.1 发送命令
-		r := cluster.relay(peer, conn, utils.ToCmdLine(peerArgs...))
-		if reply.IsErrReply(r) {
-			errReply = r
-			break
-		}
-		// 3.2 处理结果, 累加计数器
-		if intReply, ok := r.(*reply.IntReply); !ok {
-			errReply = reply.NewErrReply("reply is not IntReply")
-			break
-		} else {
-			counter += intReply.Code()
-		}
+		cmdLines[peer] = makeArgs(enum.EXISTS.String(), group...)
 	}
-
-	if errReply != nil {
+	replies := cluster.relayAll(conn, cmdLines)
+	if errReply := fanOutError(replies); errReply != nil {
 		return errReply
 	}
+	// 4. 处理结果, 累加计数器
+	counter := int64(0)
+	for _, r := range replies {
+		intReply, ok := r.(*reply.IntReply)
+		if !ok {
+			return reply.NewErrReply("reply is not IntReply")
+		}
+		counter += intReply.Code()
+	}
 
 	return reply.NewIntReply(counter)
 }
@@ -84,17 +68,14 @@ func execKeys(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) r
 	}
 	// 2. 转发指令给所有节点
 	replies := cluster.broadcast(conn, args)
+	// 3. 判断是否有节点失败
+	if errReply := fanOutError(replies); errReply != nil {
+		return errReply
+	}
+	// 4. 处理结果列表
 	keys := make([]string, 0)
-	// 3. 处理结果列表
-	var errReply resp.ErrorReply
 	for _, r := range replies {
-		// 3.1 判断是否为错误回复
-		if reply.IsErrReply(r) {
-			errReply = r.(resp.ErrorReply)
-			break
-		}
 		logger.Debug("reply:", string(r.Bytes()))
-		// 3.2 处理一条回复
 		switch re := r.(type) {
 		case *reply.MultiBulkReply:
 			keys = append(keys, utils.CmdLine2Strings(re.Args)...)
@@ -102,9 +83,6 @@ func execKeys(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) r
 			keys = append(keys, utils.Bytes2String(re.Arg))
 		}
 	}
-	if errReply != nil {
-		return errReply
-	}
 	return reply.NewMultiBulkReply(utils.ToCmdLine(keys...))
 }
 
@@ -112,5 +90,6 @@ func init() {
 	registerRouter(enum.FLUSHDB, execFlushDB)
 	registerRouter(enum.FLUSHALL, execFlushAll)
 	registerRouter(enum.EXISTS, execExists)
+	registerRouter(enum.TOUCH, execExists)
 	registerRouter(enum.KEYS, execKeys)
 }
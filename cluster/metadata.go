@@ -0,0 +1,132 @@
+package cluster_database
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"go-redis/lib/logger"
+)
+
+// 集群元数据的修改: 槽的分配和节点的角色通过Raft日志提交, 所有节点按照相同的顺序应用
+const (
+	metaSetSlot   = "setslot"   // 槽由 Node 负责
+	metaReplicate = "replicate" // Node 成为 Master 的副本
+	metaFailover  = "failover"  // 副本 Node 接替下线的主节点 Master, 配置纪元为 Epoch
+)
+
+// metaCommand 一条元数据的修改
+type metaCommand struct {
+	Op     string `json:"op"`
+	Node   string `json:"node"`
+	Slots  []int  `json:"slots,omitempty"`
+	Master string `json:"master,omitempty"`
+	Epoch  uint64 `json:"epoch,omitempty"`
+}
+
+// metaRange 快照中的槽区间, 包括两端
+type metaRange struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Node  string `json:"node"`
+}
+
+// metaSnapshot 元数据的快照
+type metaSnapshot struct {
+	Ranges []metaRange       `json:"ranges"`
+	Roles  map[string]string `json:"roles"` // 副本 -> 主节点
+}
+
+// metaMachine Raft日志应用到的状态机, 修改本节点的 slotTable
+type metaMachine struct {
+	cluster *ClusterDatabase
+}
+
+func (m *metaMachine) Apply(data []byte) {
+	cmd := &metaCommand{}
+	if err := json.Unmarshal(data, cmd); err != nil {
+		logger.Error("cluster metadata: " + err.Error())
+		return
+	}
+	m.cluster.applyMeta(cmd)
+}
+
+func (m *metaMachine) Snapshot() ([]byte, error) {
+	snapshot := &metaSnapshot{Roles: m.cluster.slots.roles()}
+	for _, r := range m.cluster.slots.ranges() {
+		snapshot.Ranges = append(snapshot.Ranges, metaRange{Start: r.start, End: r.end, Node: r.node})
+	}
+	return json.Marshal(snapshot)
+}
+
+func (m *metaMachine) Restore(data []byte) error {
+	snapshot := &metaSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return err
+	}
+	cd := m.cluster
+	owned := cd.ownsSlots()
+	ranges := make([]slotRange, 0, len(snapshot.Ranges))
+	for _, r := range snapshot.Ranges {
+		ranges = append(ranges, slotRange{start: r.Start, end: r.End, node: r.Node})
+	}
+	cd.slots.load(ranges)
+	cd.slots.setRoles(snapshot.Roles)
+	if !owned && cd.ownsSlots() {
+		cd.gossip.bumpEpoch()
+	}
+	cd.replication.reconcile()
+	return nil
+}
+
+// applyMeta 应用一条元数据的修改
+//
+// 本节点获得新的槽时增加配置纪元, 让没有参与Raft的节点也通过集群总线接受新的分配
+func (cd *ClusterDatabase) applyMeta(cmd *metaCommand) {
+	switch cmd.Op {
+	case metaSetSlot:
+		changed := false
+		for _, s := range cmd.Slots {
+			if cd.slots.owner(s) != cmd.Node {
+				changed = true
+			}
+			cd.slots.setOwner(s, cmd.Node)
+		}
+		cd.slots.setMaster(cmd.Node, "")
+		if changed && cmd.Node == cd.self {
+			cd.gossip.bumpEpoch()
+		}
+	case metaReplicate:
+		cd.slots.setMaster(cmd.Node, cmd.Master)
+		logger.Info("cluster: node " + cmd.Node + " replicates " + cmd.Master)
+	case metaFailover:
+		moved := cd.slots.failover(cmd.Master, cmd.Node)
+		cd.gossip.observeEpoch(cmd.Node, cmd.Epoch)
+		logger.Warn("cluster: failover " + cmd.Master + " -> " + cmd.Node + ", " + strconv.Itoa(moved) +
+			" slots, config epoch " + formatEpoch(cmd.Epoch))
+	default:
+		logger.Error("cluster metadata: unknown op " + cmd.Op)
+		return
+	}
+	cd.replication.reconcile()
+}
+
+// proposeMeta 提交元数据的修改, 等待修改应用到本节点之后返回
+//
+// 本节点没有加入Raft组时(例如通过 CLUSTER MEET 组成的集群)直接应用到本节点, 由集群总线传播
+func (cd *ClusterDatabase) proposeMeta(cmd *metaCommand) error {
+	if cd.consensus == nil || !cd.consensus.joined() {
+		cd.applyMeta(cmd)
+		return nil
+	}
+	return cd.consensus.propose(cmd)
+}
+
+// ownsSlots 本节点是否负责至少一个槽
+func (cd *ClusterDatabase) ownsSlots() bool {
+	for _, r := range cd.slots.ranges() {
+		if r.node == cd.self {
+			return true
+		}
+	}
+	return false
+}
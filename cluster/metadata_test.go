@@ -0,0 +1,63 @@
+package cluster_database
+
+import (
+	"strings"
+	"testing"
+
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+func TestMetaMachine(t *testing.T) {
+	a, b, c := "127.0.0.1:7000", "127.0.0.1:7001", "127.0.0.1:7002"
+	node := newGossipTestNode(c, a, b)
+	machine := &metaMachine{cluster: node}
+
+	// 没有加入Raft组的节点直接应用修改
+	conn := connection.NewFakeConn()
+	asserts.AssertStatusReply(t, execCluster(node, conn, utils.ToCmdLine("cluster", "replicate", nodeID(a))), "OK")
+	if node.slots.masterOf(c) != a {
+		t.Fatalf("node c should replicate node a, got %q", node.slots.masterOf(c))
+	}
+	nodes := string(execCluster(node, conn, utils.ToCmdLine("cluster", "nodes")).(*reply.BulkReply).Arg)
+	if !strings.Contains(nodes, nodeID(c)+" "+c+"@17002 myself,slave "+nodeID(a)+" ") {
+		t.Errorf("unexpected cluster nodes:\n%s", nodes)
+	}
+	r := execCluster(node, conn, utils.ToCmdLine("cluster", "replicate", nodeID(c)))
+	asserts.AssertErrReply(t, r, "ERR Can't replicate myself")
+	r = execCluster(node, conn, utils.ToCmdLine("cluster", "replicate", "unknown"))
+	asserts.AssertErrReply(t, r, "ERR Unknown node unknown")
+
+	// 主节点下线之后副本接替它负责的槽, 配置纪元随之增加
+	epoch := node.gossip.epoch()
+	machine.Apply([]byte(`{"op":"failover","node":"` + c + `","master":"` + a + `","epoch":` + formatEpoch(epoch+1) + `}`))
+	if node.slots.owner(0) != c || node.slots.masterOf(c) != "" || node.slots.masterOf(a) != c {
+		t.Fatalf("failover not applied: owner %s, master of c %q, master of a %q",
+			node.slots.owner(0), node.slots.masterOf(c), node.slots.masterOf(a))
+	}
+	if node.gossip.epoch() != epoch+1 {
+		t.Errorf("expected epoch %d, got %d", epoch+1, node.gossip.epoch())
+	}
+	r = execCluster(node, conn, utils.ToCmdLine("cluster", "replicate", nodeID(a)))
+	asserts.AssertErrReply(t, r, "ERR I can only replicate a master, not a replica.")
+
+	// 快照恢复槽的分配和节点的角色
+	snapshot, err := machine.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := newGossipTestNode(b)
+	if err = (&metaMachine{cluster: restored}).Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []int{0, 8191, 8192, 16383} {
+		if restored.slots.owner(s) != node.slots.owner(s) {
+			t.Errorf("slot %d: expected %s, got %s", s, node.slots.owner(s), restored.slots.owner(s))
+		}
+	}
+	if restored.slots.masterOf(a) != c {
+		t.Errorf("roles not restored: %v", restored.slots.roles())
+	}
+}
@@ -0,0 +1,345 @@
+package cluster_database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"go-redis/database"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+// errWatchChanged 观察的key在 EXEC 之前被修改, 事务不执行
+var errWatchChanged = errors.New("WATCHCHANGED watched keys changed")
+
+// 事务中的命令在 EXEC 之前不执行, 按照命令中的key分配到节点:
+//
+//  1. 所有的key(包括 WATCH 的key)都在同一个节点时, 由这个节点的 DBEngine.ExecMulti 执行
+//  2. key分布在多个节点时, 使用TCC: 每个节点 PREPAREMULTI 锁住key并检查观察的key的版本号, 全部成功之后 COMMIT
+//
+// WATCH 从负责key的节点获取版本号, 保存在客户端的连接上
+
+// execWatch 观察key, 记录负责key的节点上的版本号
+//
+// # WATCH key [key ...]
+func execWatch(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) < 2 {
+		return reply.NewArgNumErrReply(enum.TX_WATCH.String())
+	}
+	if conn.InMultiState() {
+		return reply.NewErrReply("WATCH inside MULTI is not allowed")
+	}
+	keys := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		keys = append(keys, string(arg))
+	}
+	watching := conn.GetWatching()
+	for node, nodeKeys := range cluster.groupBy(keys) {
+		versions, errReply := cluster.versionsOf(node, conn, nodeKeys)
+		if errReply != nil {
+			return errReply
+		}
+		for i, key := range nodeKeys {
+			watching[key] = versions[i]
+		}
+	}
+	return reply.NewOKReply()
+}
+
+// versionsOf 返回节点上key的版本号
+func (cd *ClusterDatabase) versionsOf(node string, conn resp.Connection, keys []string) ([]uint32, resp.ErrorReply) {
+	versions := make([]uint32, len(keys))
+	if node == cd.self {
+		for i, key := range keys {
+			versions[i] = cd.db.GetVersion(conn.GetDBIndex(), key)
+		}
+		return versions, nil
+	}
+	r := cd.relay(node, conn, makeArgs(enum.MULTI_WATCH.String(), keys...))
+	if errReply, ok := r.(resp.ErrorReply); ok {
+		return nil, errReply
+	}
+	result, ok := r.(*reply.MultiBulkReply)
+	if !ok || len(result.Args) != len(keys) {
+		return nil, reply.NewErrReply("invalid WATCH_ response from " + node)
+	}
+	for i, arg := range result.Args {
+		version, err := strconv.ParseUint(string(arg), 10, 32)
+		if err != nil {
+			return nil, reply.NewErrReply("invalid WATCH_ response from " + node)
+		}
+		versions[i] = uint32(version)
+	}
+	return versions, nil
+}
+
+// execWatchVersions 返回本节点上key的版本号, 由客户端连接的节点在 WATCH 时发送
+//
+// # WATCH_ key [key ...]
+func execWatchVersions(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) < 2 {
+		return reply.NewArgNumErrReply(enum.MULTI_WATCH.String())
+	}
+	versions := make([][]byte, 0, len(args)-1)
+	for _, key := range args[1:] {
+		version := cluster.db.GetVersion(conn.GetDBIndex(), string(key))
+		versions = append(versions, []byte(strconv.FormatUint(uint64(version), 10)))
+	}
+	return reply.NewMultiBulkReply(versions)
+}
+
+// execExec 执行事务中的命令
+//
+// # EXEC
+//
+// 观察的key被修改时返回空数组, 命令执行出错时回滚已经执行的命令
+func execExec(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) != 1 {
+		return reply.NewArgNumErrReply(enum.TX_EXEC.String())
+	}
+	if !conn.InMultiState() {
+		return reply.NewErrReply("EXEC without MULTI")
+	}
+	if len(conn.GetTxErrors()) > 0 {
+		conn.SetMultiState(false)
+		return &reply.NormalErrReply{Status: "EXECABORT Transaction discarded because of previous errors."}
+	}
+	cmdLines := conn.GetQueuedCmdLine()
+	watching := make(map[string]uint32, len(conn.GetWatching()))
+	for key, version := range conn.GetWatching() {
+		watching[key] = version
+	}
+	// 结束事务状态之后再转发, 转发给本节点的命令不会再加入队列
+	conn.SetMultiState(false)
+
+	// 1. 每条命令的key必须在同一个节点上, 没有key的命令由本节点执行
+	cmdNodes := make([]string, len(cmdLines))
+	for i, cmdLine := range cmdLines {
+		writeKeys, readKeys := database.GetRelatedKeys(cmdLine)
+		groups := cluster.groupBy(append(writeKeys, readKeys...))
+		if len(groups) > 1 {
+			return &reply.NormalErrReply{Status: "CROSSSLOT Keys in command '" + string(cmdLine[0]) + "' don't hash to the same node"}
+		}
+		cmdNodes[i] = cluster.self
+		for node := range groups {
+			cmdNodes[i] = node
+		}
+	}
+	// 2. 按照节点分组命令和观察的key
+	nodeCmds := make(map[string][]int) // 节点 -> 命令的下标
+	for i, node := range cmdNodes {
+		nodeCmds[node] = append(nodeCmds[node], i)
+	}
+	nodeWatching := make(map[string]map[string]uint32)
+	for key, version := range watching {
+		node := cluster.slots.pick(key)
+		if nodeWatching[node] == nil {
+			nodeWatching[node] = make(map[string]uint32)
+		}
+		nodeWatching[node][key] = version
+		if _, ok := nodeCmds[node]; !ok {
+			nodeCmds[node] = nil
+		}
+	}
+	if len(cmdLines) == 0 && len(nodeCmds) > 0 {
+		// 只有 WATCH 没有命令时不需要访问其他节点
+		return reply.NewEmptyMultiBulkReply()
+	}
+	// 3. 只涉及一个节点时直接执行
+	if len(nodeCmds) <= 1 {
+		node := cluster.self
+		for n := range nodeCmds {
+			node = n
+		}
+		return cluster.execMultiOn(node, conn, cmdLines, nodeWatching[node])
+	}
+	// 4. 涉及多个节点时使用TCC
+	return cluster.execMultiTCC(conn, cmdLines, nodeCmds, nodeWatching)
+}
+
+// execMultiOn 在一个节点上执行事务
+func (cd *ClusterDatabase) execMultiOn(node string, conn resp.Connection, cmdLines []db.CmdLine, watching map[string]uint32) resp.Reply {
+	if node == cd.self {
+		// ExecMulti 检查连接上观察的key
+		for key, version := range watching {
+			conn.GetWatching()[key] = version
+		}
+		defer conn.ClearWatching()
+		return cd.db.ExecMulti(conn, cmdLines)
+	}
+	args := append(utils.ToCmdLine(enum.MULTI_EXEC.String()), encodeMulti(cmdLines, watching)...)
+	return cd.relay(node, conn, args)
+}
+
+// execMultiTCC 在多个节点上执行事务, 所有节点都准备成功之后提交, 任何一个节点失败时回滚所有节点
+func (cd *ClusterDatabase) execMultiTCC(conn resp.Connection, cmdLines []db.CmdLine,
+	nodeCmds map[string][]int, nodeWatching map[string]map[string]uint32) resp.Reply {
+	txID := cd.idGenerator.NextID()
+	txIDStr := strconv.FormatInt(txID, 10)
+	groupMap := make(map[string][]string, len(nodeCmds))
+	for node := range nodeCmds {
+		groupMap[node] = nil
+	}
+	// 1. prepare, 并行发送给所有节点
+	prepares := make(map[string]db.CmdLine, len(nodeCmds))
+	for node, indexes := range nodeCmds {
+		lines := make([]db.CmdLine, 0, len(indexes))
+		for _, i := range indexes {
+			lines = append(lines, cmdLines[i])
+		}
+		prepares[node] = append(utils.ToCmdLine(enum.TCC_PREPARE_MULTI.String(), txIDStr, cd.self), encodeMulti(lines, nodeWatching[node])...)
+	}
+	replies := cd.relayAll(conn, prepares)
+	if errReply := fanOutError(replies); errReply != nil {
+		requestRollback(cd, conn, txID, groupMap)
+		for _, r := range replies {
+			if isWatchChanged(r) {
+				return reply.NewEmptyMultiBulkReply()
+			}
+		}
+		return errReply
+	}
+	// 2. commit, 提交的决定先写入事务日志, 每个节点返回自己执行的命令的结果
+	respList, errReply := requestCommit(cd, conn, txID, groupMap)
+	if errReply != nil {
+		return errReply
+	}
+	results := make([]resp.Reply, len(cmdLines))
+	for k, node := range nodesOf(groupMap) {
+		indexes := nodeCmds[node]
+		nodeResults, ok := respList[k].(*reply.MultiRawReply)
+		if !ok || len(nodeResults.Replies) != len(indexes) {
+			return reply.NewErrReply("invalid COMMIT response from " + node)
+		}
+		for j, i := range indexes {
+			results[i] = nodeResults.Replies[j]
+		}
+	}
+	return reply.NewMultiRawReply(results)
+}
+
+// execPrepareMulti 锁住事务在本节点上的key, 并检查观察的key的版本号
+//
+// # PREPAREMULTI txID coordinator watchCount [key version ...] cmdCount [argc arg ...] ...
+func execPrepareMulti(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) < 5 {
+		return reply.NewArgNumErrReply(enum.TCC_PREPARE_MULTI.String())
+	}
+	txID := string(args[1])
+	cmdLines, watching, ok := decodeMulti(args[3:])
+	if !ok {
+		return reply.NewSyntaxErrReply()
+	}
+	tx := newMultiTransaction(cluster, conn, txID, cmdLines, watching)
+	tx.coordinator = string(args[2])
+	cluster.txMutex.Lock()
+	cluster.transactions.Set(txID, tx)
+	cluster.txMutex.Unlock()
+	if err := tx.prepare(); err != nil {
+		return &reply.NormalErrReply{Status: err.Error()}
+	}
+	return reply.NewOKReply()
+}
+
+// execMultiLocal 在本节点执行只涉及本节点的事务
+//
+// # EXEC_ watchCount [key version ...] cmdCount [argc arg ...] ...
+func execMultiLocal(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	cmdLines, watching, ok := decodeMulti(args[1:])
+	if !ok {
+		return reply.NewSyntaxErrReply()
+	}
+	local := connection.NewFakeConn()
+	local.SetUser(conn.GetUser())
+	local.SelectDB(conn.GetDBIndex())
+	for key, version := range watching {
+		local.GetWatching()[key] = version
+	}
+	return cluster.db.ExecMulti(local, cmdLines)
+}
+
+// isTxControlCmd 事务状态下不加入队列的命令, PING 是客户端的心跳
+func isTxControlCmd(cmdName string) bool {
+	switch cmdName {
+	case enum.TX_MULTI.String(), enum.TX_EXEC.String(), enum.TX_DISCARD.String(),
+		enum.TX_WATCH.String(), enum.TX_UNWATCH.String(), enum.PING.String():
+		return true
+	}
+	return false
+}
+
+// isWatchChanged 其他节点的回复是否表示观察的key已经被修改
+func isWatchChanged(r resp.Reply) bool {
+	errReply, ok := r.(resp.ErrorReply)
+	return ok && strings.Contains(errReply.Error(), errWatchChanged.Error())
+}
+
+// encodeMulti 把观察的key和多条命令编码为参数: watchCount [key version ...] cmdCount [argc arg ...] ...
+func encodeMulti(cmdLines []db.CmdLine, watching map[string]uint32) db.CmdLine {
+	args := make(db.CmdLine, 0, 2+2*len(watching)+2*len(cmdLines))
+	args = append(args, []byte(strconv.Itoa(len(watching))))
+	for key, version := range watching {
+		args = append(args, []byte(key), []byte(strconv.FormatUint(uint64(version), 10)))
+	}
+	args = append(args, []byte(strconv.Itoa(len(cmdLines))))
+	for _, cmdLine := range cmdLines {
+		args = append(args, []byte(strconv.Itoa(len(cmdLine))))
+		args = append(args, cmdLine...)
+	}
+	return args
+}
+
+// decodeMulti 解析 encodeMulti 编码的参数
+func decodeMulti(args db.CmdLine) ([]db.CmdLine, map[string]uint32, bool) {
+	next := func() (int, bool) {
+		if len(args) == 0 {
+			return 0, false
+		}
+		n, err := strconv.Atoi(string(args[0]))
+		args = args[1:]
+		return n, err == nil && n >= 0
+	}
+	watchCount, ok := next()
+	if !ok || len(args) < 2*watchCount {
+		return nil, nil, false
+	}
+	watching := make(map[string]uint32, watchCount)
+	for i := 0; i < watchCount; i++ {
+		version, err := strconv.ParseUint(string(args[1]), 10, 32)
+		if err != nil {
+			return nil, nil, false
+		}
+		watching[string(args[0])] = uint32(version)
+		args = args[2:]
+	}
+	cmdCount, ok := next()
+	if !ok {
+		return nil, nil, false
+	}
+	cmdLines := make([]db.CmdLine, 0, cmdCount)
+	for i := 0; i < cmdCount; i++ {
+		argc, ok := next()
+		if !ok || argc == 0 || len(args) < argc {
+			return nil, nil, false
+		}
+		cmdLines = append(cmdLines, args[:argc])
+		args = args[argc:]
+	}
+	return cmdLines, watching, len(args) == 0
+}
+
+func init() {
+	registerRouter(enum.TX_MULTI, execLocal)
+	registerRouter(enum.TX_DISCARD, execLocal)
+	registerRouter(enum.TX_UNWATCH, execLocal)
+	registerRouter(enum.TX_WATCH, execWatch)
+	registerRouter(enum.TX_EXEC, execExec)
+	registerRouter(enum.TCC_PREPARE_MULTI, execPrepareMulti)
+	registerRouter(enum.MULTI_EXEC, execMultiLocal)
+	registerRouter(enum.MULTI_WATCH, execWatchVersions)
+}
@@ -0,0 +1,125 @@
+package cluster_database
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"go-redis/acl"
+	"go-redis/config"
+	"go-redis/interface/db"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+func TestMultiExec(t *testing.T) {
+	self := "127.0.0.1:7100"
+	node := newGossipTestNode(self, self)
+	conn := connection.NewFakeConn()
+	other := connection.NewFakeConn()
+	node.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	node.Exec(other, utils.ToCmdLine("auth", config.RequirePass()))
+	node.db.Exec(conn, utils.ToCmdLine("DEL", "multi:a", "multi:b"))
+
+	// 所有的key都在本节点上时由 ExecMulti 执行
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("WATCH", "multi:a")), "OK")
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("MULTI")), "OK")
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("SET", "multi:a", "1")), "QUEUED")
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("INCR", "multi:b")), "QUEUED")
+	asserts.AssertErrReply(t, node.Exec(conn, utils.ToCmdLine("WATCH", "multi:a")), "ERR WATCH inside MULTI is not allowed")
+	r, ok := node.Exec(conn, utils.ToCmdLine("EXEC")).(*reply.MultiRawReply)
+	if !ok || len(r.Replies) != 2 {
+		t.Fatalf("unexpected exec reply %v", r)
+	}
+	asserts.AssertStatusReply(t, r.Replies[0], "OK")
+	asserts.AssertIntReply(t, r.Replies[1], 1)
+	asserts.AssertBulkReply(t, node.db.Exec(conn, utils.ToCmdLine("GET", "multi:a")), "1")
+
+	// 观察的key被其他连接修改之后不执行
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("WATCH", "multi:a")), "OK")
+	node.Exec(other, utils.ToCmdLine("SET", "multi:a", "2"))
+	node.Exec(conn, utils.ToCmdLine("MULTI"))
+	node.Exec(conn, utils.ToCmdLine("SET", "multi:a", "3"))
+	asserts.AssertMultiBulkReplySize(t, node.Exec(conn, utils.ToCmdLine("EXEC")), 0)
+	asserts.AssertBulkReply(t, node.db.Exec(conn, utils.ToCmdLine("GET", "multi:a")), "2")
+
+	// 排队时出错的事务不执行
+	node.Exec(conn, utils.ToCmdLine("MULTI"))
+	node.Exec(conn, utils.ToCmdLine("SET", "multi:a"))
+	asserts.AssertErrReply(t, node.Exec(conn, utils.ToCmdLine("EXEC")),
+		"EXECABORT Transaction discarded because of previous errors.")
+	asserts.AssertErrReply(t, node.Exec(conn, utils.ToCmdLine("EXEC")), "ERR EXEC without MULTI")
+
+	// 命令排队之后收回权限, EXEC 时重新检查
+	defer acl.DeleteUser("multi:writer")
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("ACL", "SETUSER", "multi:writer", "on", ">pw", "~multi:*", "+@all")), "OK")
+	writer := connection.NewFakeConn()
+	asserts.AssertStatusReply(t, node.Exec(writer, utils.ToCmdLine("AUTH", "multi:writer", "pw")), "OK")
+	node.Exec(writer, utils.ToCmdLine("MULTI"))
+	asserts.AssertStatusReply(t, node.Exec(writer, utils.ToCmdLine("SET", "multi:a", "4")), "QUEUED")
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("ACL", "SETUSER", "multi:writer", "-set")), "OK")
+	asserts.AssertErrReply(t, node.Exec(writer, utils.ToCmdLine("EXEC")), "NOPERM this user has no permissions to run the 'set' command")
+	asserts.AssertBulkReply(t, node.db.Exec(conn, utils.ToCmdLine("GET", "multi:a")), "2")
+}
+
+func TestPrepareMulti(t *testing.T) {
+	conn := connection.NewFakeConn()
+	testNodeA.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	testNodeA.db.Exec(conn, utils.ToCmdLine("SET", "pmulti:a", "a"))
+	cmdLines := []db.CmdLine{
+		utils.ToCmdLine("SET", "pmulti:a", "b"),
+		utils.ToCmdLine("GET", "pmulti:a"),
+	}
+	version := testNodeA.db.GetVersion(conn.GetDBIndex(), "pmulti:a")
+
+	// 提交时返回每条命令的结果
+	txIDStr := strconv.FormatInt(rand.Int63(), 10)
+	watching := map[string]uint32{"pmulti:a": version}
+	args := append(utils.ToCmdLine("PREPAREMULTI", txIDStr, testNodeA.self), encodeMulti(cmdLines, watching)...)
+	asserts.AssertStatusReply(t, execPrepareMulti(testNodeA, conn, args), "OK")
+	r, ok := execCommit(testNodeA, conn, utils.ToCmdLine("COMMIT", txIDStr)).(*reply.MultiRawReply)
+	if !ok || len(r.Replies) != 2 {
+		t.Fatalf("unexpected commit reply %v", r)
+	}
+	asserts.AssertBulkReply(t, r.Replies[1], "b")
+
+	// 回滚时按照相反的顺序执行 undo log
+	execRollback(testNodeA, conn, utils.ToCmdLine("ROLLBACK", txIDStr))
+	asserts.AssertBulkReply(t, testNodeA.db.Exec(conn, utils.ToCmdLine("GET", "pmulti:a")), "a")
+
+	// 观察的key的版本号变化时准备失败
+	txIDStr = strconv.FormatInt(rand.Int63(), 10)
+	args = append(utils.ToCmdLine("PREPAREMULTI", txIDStr, testNodeA.self), encodeMulti(cmdLines, watching)...)
+	r2 := execPrepareMulti(testNodeA, conn, args)
+	if !isWatchChanged(r2) {
+		t.Fatalf("expected watch changed, got %s", r2.Bytes())
+	}
+	execRollback(testNodeA, conn, utils.ToCmdLine("ROLLBACK", txIDStr))
+	asserts.AssertBulkReply(t, testNodeA.db.Exec(conn, utils.ToCmdLine("GET", "pmulti:a")), "a")
+}
+
+func TestEncodeMulti(t *testing.T) {
+	cmdLines := []db.CmdLine{
+		utils.ToCmdLine("SET", "a", "1"),
+		utils.ToCmdLine("PING"),
+	}
+	watching := map[string]uint32{"a": 3}
+	decoded, decodedWatching, ok := decodeMulti(encodeMulti(cmdLines, watching))
+	if !ok || len(decoded) != 2 || decodedWatching["a"] != 3 {
+		t.Fatalf("decode failed: %v %v %v", decoded, decodedWatching, ok)
+	}
+	if utils.CmdLine2String(decoded[0]) != utils.CmdLine2String(cmdLines[0]) {
+		t.Errorf("expected %s, got %s", utils.CmdLine2String(cmdLines[0]), utils.CmdLine2String(decoded[0]))
+	}
+	for _, args := range []db.CmdLine{
+		utils.ToCmdLine("1", "a"),
+		utils.ToCmdLine("0", "1", "3", "SET", "a"),
+		utils.ToCmdLine("0", "0", "extra"),
+	} {
+		if _, _, ok = decodeMulti(args); ok {
+			t.Errorf("%s should be invalid", utils.CmdLine2String(args))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package cluster_database
+
+import (
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+)
+
+// execLocal 订阅和客户端相关的命令只在本节点执行, 客户端的状态保存在所连接的节点上
+func execLocal(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	return cluster.db.Exec(conn, args)
+}
+
+// execPublish 把消息广播给所有节点, 返回所有节点上收到消息的客户端数量之和
+func execPublish(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	// 1. 校验参数合法性
+	if len(args) != enum.PUBLISH.Arity() {
+		return reply.NewArgNumErrReply(enum.PUBLISH.String())
+	}
+	// 2. 转发指令给所有节点
+	replies := cluster.broadcast(conn, args)
+	if errReply := fanOutError(replies); errReply != nil {
+		return errReply
+	}
+	// 3. 累加计数器
+	var counter int64
+	for _, r := range replies {
+		intReply, ok := r.(*reply.IntReply)
+		if !ok {
+			return reply.NewErrReply("reply is not IntReply")
+		}
+		counter += intReply.Code()
+	}
+	return reply.NewIntReply(counter)
+}
+
+func init() {
+	registerRouter(enum.SUBSCRIBE, execLocal)
+	registerRouter(enum.UNSUBSCRIBE, execLocal)
+	registerRouter(enum.PSUBSCRIBE, execLocal)
+	registerRouter(enum.PUNSUBSCRIBE, execLocal)
+	registerRouter(enum.PUBSUB, execLocal)
+	registerRouter(enum.PUBLISH, execPublish)
+	registerRouter(enum.MULTI_PUBLISH, genPenetratingExecutor(enum.PUBLISH.String()))
+	// 客户端缓存只追踪本节点上的key
+	registerRouter(enum.CLIENT, execLocal)
+	registerRouter(enum.INFO, execLocal)
+	// ACL 用户, 配置, 慢查询日志, 延迟监控和快照只保存在本节点上
+	registerRouter(enum.ACL, execLocal)
+	registerRouter(enum.CONFIG, execLocal)
+	registerRouter(enum.SLOWLOG, execLocal)
+	registerRouter(enum.LATENCY, execLocal)
+	registerRouter(enum.SAVE, execLocal)
+	registerRouter(enum.BGSAVE, execLocal)
+}
@@ -0,0 +1,207 @@
+package cluster_database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"go-redis/client"
+	"go-redis/config"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+const (
+	migrateBatch   = 100    // 每次 MIGRATE 迁移的key的数量
+	migrateTimeout = "5000" // MIGRATE 的超时时间(ms)
+)
+
+// nodeSession 在一个节点上依次执行多条命令, 用于需要 SELECT 的命令序列
+//
+// 本节点的命令使用发起命令的用户直接执行, 其他节点使用连接池中的同一个连接
+type nodeSession struct {
+	cluster    *ClusterDatabase
+	node       string
+	local      resp.Connection
+	peerClient *client.Client
+	selected   bool // 是否在连接上执行过 SELECT, 归还连接之前需要切换回0号数据库
+}
+
+// openSession 打开在节点 node 上执行命令的会话, 本节点以 caller 的用户执行命令
+func (cd *ClusterDatabase) openSession(node string, caller resp.Connection) (*nodeSession, error) {
+	session := &nodeSession{cluster: cd, node: node}
+	if node == cd.self {
+		local := connection.NewFakeConn()
+		local.SetUser(caller.GetUser())
+		session.local = local
+		return session, nil
+	}
+	peerClient, err := cd.getPeerClient(node)
+	if err != nil {
+		return nil, err
+	}
+	session.peerClient = peerClient
+	return session, nil
+}
+
+// send 执行命令, 错误回复转换为error
+func (session *nodeSession) send(args db.CmdLine) (resp.Reply, error) {
+	var r resp.Reply
+	if session.local != nil {
+		r = session.cluster.Exec(session.local, args)
+	} else {
+		r = session.peerClient.Send(args)
+		session.selected = session.selected || strings.EqualFold(string(args[0]), enum.SELECT.String())
+	}
+	if reply.IsErrReply(r) {
+		return r, errors.New(session.node + ": " + strings.TrimSpace(string(r.Bytes())))
+	}
+	return r, nil
+}
+
+// close 归还连接
+func (session *nodeSession) close() {
+	if session.peerClient == nil {
+		return
+	}
+	if session.selected {
+		session.peerClient.Send(utils.ToCmdLine(enum.SELECT.String(), "0"))
+	}
+	_ = session.cluster.returnPeerClient(session.node, session.peerClient)
+}
+
+// execOn 在节点 node 上执行一条命令
+func (cd *ClusterDatabase) execOn(node string, caller resp.Connection, args db.CmdLine) error {
+	session, err := cd.openSession(node, caller)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+	_, err = session.send(args)
+	return err
+}
+
+// execClusterRebalance 在线重新分片, 让所有主节点负责的槽数量相差不超过1, 副本负责的槽迁移到主节点
+//
+// 迁移过程中集群继续提供服务, 访问迁移中的槽的命令通过 ASK 重定向到目标节点
+//
+// 返回: 迁移的槽的数量
+func (cd *ClusterDatabase) execClusterRebalance(conn resp.Connection) resp.Reply {
+	moves := cd.slots.rebalancePlan(cd.slots.masters())
+	for i, move := range moves {
+		if err := cd.moveSlot(conn, move); err != nil {
+			return reply.NewErrReply("rebalance stopped after moving " + strconv.Itoa(i) +
+				" slots, slot " + strconv.Itoa(move.slot) + ": " + err.Error())
+		}
+	}
+	logger.Info("rebalance moved " + strconv.Itoa(len(moves)) + " slots")
+	return reply.NewIntReply(int64(len(moves)))
+}
+
+// moveSlot 把槽迁移到新的节点
+//
+//  1. 目标节点标记槽正在迁入, 源节点标记槽正在迁出
+//  2. 把所有数据库中槽内的key迁移到目标节点
+//  3. 通知所有节点槽由目标节点负责
+func (cd *ClusterDatabase) moveSlot(caller resp.Connection, move slotMove) error {
+	s := strconv.Itoa(move.slot)
+	if move.from != "" {
+		// 1. 标记迁移状态
+		if err := cd.execOn(move.to, caller, utils.ToCmdLine(enum.CLUSTER.String(), enum.CLUSTER_SETSLOT,
+			s, enum.SETSLOT_IMPORTING, move.from)); err != nil {
+			return err
+		}
+		if err := cd.execOn(move.from, caller, utils.ToCmdLine(enum.CLUSTER.String(), enum.CLUSTER_SETSLOT,
+			s, enum.SETSLOT_MIGRATING, move.to)); err != nil {
+			return err
+		}
+		// 2. 迁移key
+		if err := cd.migrateSlotKeys(caller, move); err != nil {
+			return err
+		}
+	}
+	// 3. 先通知源节点和目标节点, 再通知其他节点
+	nodes := append([]string{move.to}, utils.If(move.from == "", []string{}, []string{move.from})...)
+	for _, node := range cd.slots.knownNodes() {
+		if node != move.to && node != move.from {
+			nodes = append(nodes, node)
+		}
+	}
+	for _, node := range nodes {
+		if err := cd.execOn(node, caller, utils.ToCmdLine(enum.CLUSTER.String(), enum.CLUSTER_SETSLOT,
+			s, enum.SETSLOT_NODE, move.to)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSlotKeys 在源节点上分批执行 GETKEYSINSLOT 和 MIGRATE, 直到所有数据库中都没有槽内的key
+//
+// 源节点标记迁出之后新的key都写入目标节点, 所以只需要处理 INFO keyspace 中的非空数据库
+func (cd *ClusterDatabase) migrateSlotKeys(caller resp.Connection, move slotMove) error {
+	session, err := cd.openSession(move.from, caller)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+
+	r, err := session.send(utils.ToCmdLine(enum.INFO.String(), "keyspace"))
+	if err != nil {
+		return err
+	}
+	host, port := splitAddr(move.to)
+	s := strconv.Itoa(move.slot)
+	for _, dbIndex := range nonEmptyDBs(r) {
+		if _, err := session.send(utils.ToCmdLine(enum.SELECT.String(), strconv.Itoa(dbIndex))); err != nil {
+			return err
+		}
+		for {
+			r, err := session.send(utils.ToCmdLine(enum.CLUSTER.String(), enum.CLUSTER_GETKEYSINSLOT, s, strconv.Itoa(migrateBatch)))
+			if err != nil {
+				return err
+			}
+			keys, ok := r.(*reply.MultiBulkReply)
+			if !ok || len(keys.Args) == 0 {
+				break
+			}
+			args := utils.ToCmdLine(enum.MIGRATE.String(), host, strconv.Itoa(port), "", strconv.Itoa(dbIndex), migrateTimeout)
+			if password := config.RequirePass(); password != "" {
+				args = append(args, []byte(enum.MIGRATE_AUTH), []byte(password))
+			}
+			args = append(append(args, []byte(enum.MIGRATE_KEYS)), keys.Args...)
+			if _, err := session.send(args); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nonEmptyDBs 从 INFO keyspace 的结果中解析非空数据库的编号, 例如 db0:keys=1,expires=0,avg_ttl=0
+func nonEmptyDBs(r resp.Reply) []int {
+	var text []byte
+	switch info := r.(type) {
+	case *reply.VerbatimReply: // 本节点和使用RESP3的连接
+		text = info.Text
+	case *reply.BulkReply:
+		text = info.Arg
+	default:
+		return nil
+	}
+	dbs := make([]int, 0)
+	for _, line := range strings.Split(string(text), "\n") {
+		name, _, found := strings.Cut(strings.TrimSpace(line), ":")
+		if index, ok := strings.CutPrefix(name, "db"); ok && found {
+			if dbIndex, err := strconv.Atoi(index); err == nil {
+				dbs = append(dbs, dbIndex)
+			}
+		}
+	}
+	return dbs
+}
@@ -0,0 +1,193 @@
+package cluster_database
+
+import (
+	"strings"
+
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/timewheel"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+// recoverTransactions 恢复重启之前没有完成的分布式事务
+//
+// 参与者重新给准备好的事务上锁, 向协调者查询结果之后提交或回滚;
+// 协调者重新通知参与者执行已经写入日志的决定
+func (cd *ClusterDatabase) recoverTransactions() {
+	decisions, prepared := cd.txLog.pending()
+	for _, record := range prepared {
+		conn := connection.NewFakeConn()
+		conn.SelectDB(record.DB)
+		tx := NewTransaction(cd, conn, record.ID, nil)
+		tx.coordinator = record.Coordinator
+		tx.cmdLines = record.Cmds
+		tx.multi = record.Multi
+		tx.mu.Lock()
+		tx.lockAndBuildUndoLog()
+		tx.status = preparedStatus
+		tx.mu.Unlock()
+		cd.txMutex.Lock()
+		cd.transactions.Set(tx.id, tx)
+		cd.txMutex.Unlock()
+		logger.Info("recover in-doubt transaction: " + tx.id)
+		go tx.resolve()
+	}
+	for _, record := range decisions {
+		logger.Info("recover transaction decision: " + record.Op + " " + record.ID)
+		go cd.finishDecision(record)
+	}
+}
+
+// finishDecision 重新通知参与者执行协调者的决定, 有参与者无法访问时稍后重试
+func (cd *ClusterDatabase) finishDecision(record *txRecord) {
+	conn := connection.NewFakeConn()
+	local, cmd := execRollback, utils.ToCmdLine(enum.TCC_ROLLBACK.String(), record.ID)
+	if record.Op == txOpCommit {
+		local, cmd = execCommit, utils.ToCmdLine(enum.TCC_COMMIT.String(), record.ID)
+	}
+	for _, node := range record.Nodes {
+		var r resp.Reply
+		if node == cd.self {
+			r = local(cd, conn, cmd)
+		} else {
+			r = cd.relay(node, conn, cmd)
+		}
+		if errReply, ok := r.(resp.ErrorReply); ok {
+			// 参与者只有在协调者写入回滚的决定之后才会自己回滚, 已经回滚说明协调者被移出了集群, 无法再提交
+			if strings.Contains(errReply.Error(), errTxRolledBack.Error()) {
+				logger.Warn("transaction " + record.ID + " has been rolled back by " + node)
+				continue
+			}
+			logger.Warn("transaction " + record.ID + ": " + node + ": " + errReply.Error())
+			timewheel.Delay(maxLockTime, "", func() { cd.finishDecision(record) })
+			return
+		}
+	}
+	endTransaction(cd, record.ID)
+}
+
+// queryDecision 向协调者查询事务的结果, 返回 txOpCommit, txOpRollback, 协调者无法访问或者还没有决定时返回空字符串
+//
+// 协调者的地址由 PREPARE 的参数传入. 协调者还没有决定时写入回滚的决定再回复,
+// 之后不能再提交这个事务, 参与者自己回滚之后其他参与者也不会提交
+func (cd *ClusterDatabase) queryDecision(txID string, coordinator string) string {
+	if !cd.isKnownNode(coordinator) {
+		// 协调者已经被移出了集群, 不会再提交
+		return txOpRollback
+	}
+	if coordinator == cd.self {
+		op, err := cd.txLog.presumeRollback(txID)
+		if err != nil {
+			logger.Error("write transaction log: " + err.Error())
+			return ""
+		}
+		return op
+	}
+	r := cd.relay(coordinator, connection.NewFakeConn(), utils.ToCmdLine(enum.TCC.String(), enum.TCC_STATUS, txID))
+	status, ok := r.(*reply.BulkReply)
+	if !ok {
+		return ""
+	}
+	switch string(status.Arg) {
+	case txOpCommit, txOpCommitted:
+		return txOpCommit
+	case txOpRollback, txOpRolledBack:
+		return txOpRollback
+	}
+	// 协调者本身也是参与者, 还在准备
+	return ""
+}
+
+// isKnownNode 判断节点是否还在集群中
+func (cd *ClusterDatabase) isKnownNode(node string) bool {
+	for _, known := range cd.slots.knownNodes() {
+		if known == node {
+			return true
+		}
+	}
+	return false
+}
+
+// txStatus 返回事务在本节点的状态: 协调者没有完成的决定, 或者参与者的状态
+//
+// 都没有时说明协调者还没有决定或者已经完成, 写入回滚的决定之后返回 rollback
+func (cd *ClusterDatabase) txStatus(txID string) (string, error) {
+	if record, ok := cd.txLog.decision(txID); ok {
+		return record.Op, nil
+	}
+	cd.txMutex.RLock()
+	raw, ok := cd.transactions.Get(txID)
+	cd.txMutex.RUnlock()
+	if ok {
+		return raw.(*Transaction).statusName(), nil
+	}
+	return cd.txLog.presumeRollback(txID)
+}
+
+// execTcc 查看分布式事务的状态
+//
+// # TCC LIST
+//
+// 返回本节点没有完成的决定和最近的参与者事务, 每一项是 [id, role, status]
+//
+// # TCC STATUS txID
+//
+// 返回事务在本节点的状态: commit, rollback, created, prepared, committed 或 rolledback.
+// 参与者通过 TCC STATUS 向协调者查询超时的事务, 协调者还没有决定的事务之后不能再提交
+func execTcc(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) < 2 {
+		return reply.NewArgNumErrReply(enum.TCC.String())
+	}
+	subCommand := strings.ToUpper(string(args[1]))
+	switch subCommand {
+	case enum.TCC_LIST:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply(enum.TCC.String() + "|" + subCommand)
+		}
+		return cluster.listTransactions()
+	case enum.TCC_STATUS:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply(enum.TCC.String() + "|" + subCommand)
+		}
+		status, err := cluster.txStatus(string(args[2]))
+		if err != nil {
+			return reply.NewErrReply("write transaction log: " + err.Error())
+		}
+		return reply.NewBulkReply([]byte(status))
+	}
+	return reply.NewErrReply("unknown subcommand '" + subCommand + "'. Try TCC LIST or TCC STATUS.")
+}
+
+// listTransactions 返回协调者没有完成的决定和参与者的事务
+func (cd *ClusterDatabase) listTransactions() resp.Reply {
+	item := func(id, role, status string) resp.Reply {
+		return reply.NewMultiBulkReply(utils.ToCmdLine(id, role, status))
+	}
+	decisions, _ := cd.txLog.pending()
+	items := make([]resp.Reply, 0, len(decisions))
+	for _, record := range decisions {
+		items = append(items, item(record.ID, "coordinator", record.Op))
+	}
+	cd.txMutex.RLock()
+	var txs []*Transaction
+	cd.transactions.ForEach(func(key string, val interface{}) bool {
+		txs = append(txs, val.(*Transaction))
+		return true
+	})
+	cd.txMutex.RUnlock()
+	for _, tx := range txs {
+		items = append(items, item(tx.id, "participant", tx.statusName()))
+	}
+	if len(items) == 0 {
+		return reply.NewEmptyMultiBulkReply()
+	}
+	return reply.NewMultiRawReply(items)
+}
+
+func init() {
+	registerRouter(enum.TCC, execTcc)
+}
@@ -0,0 +1,115 @@
+package cluster_database
+
+import (
+	"strconv"
+	"strings"
+
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/slot"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+// maxRedirects 跟随 MOVED 和 ASK 重定向的最大次数, 防止节点的槽分配不一致时无限转发
+const maxRedirects = 5
+
+// redirection 表示 MOVED 或者 ASK 重定向
+//
+// MOVED 表示槽已经由 node 负责; ASK 表示槽正在迁移到 node, 只有这一条命令需要在 node 上先执行 ASKING 再执行
+type redirection struct {
+	ask  bool
+	slot int
+	node string
+}
+
+// reply 转换为返回给客户端的错误回复, 例如 -MOVED 3999 127.0.0.1:6381
+func (r *redirection) reply() resp.Reply {
+	kind := utils.If(r.ask, "ASK", "MOVED")
+	return &reply.NormalErrReply{Status: kind + " " + strconv.Itoa(r.slot) + " " + r.node}
+}
+
+// parseRedirection 从其他节点返回的错误中解析重定向, 不是重定向时返回nil
+func parseRedirection(r resp.Reply) *redirection {
+	if !reply.IsErrReply(r) {
+		return nil
+	}
+	msg := strings.TrimSuffix(strings.TrimPrefix(utils.Bytes2String(r.Bytes()), "-"), enum.CRLF)
+	fields := strings.Fields(strings.TrimPrefix(msg, "ERR "))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return nil
+	}
+	s, err := strconv.Atoi(fields[1])
+	if err != nil || s < 0 || s >= slot.Count {
+		return nil
+	}
+	return &redirection{ask: fields[0] == "ASK", slot: s, node: fields[2]}
+}
+
+// locate 找到执行key相关命令的节点, 需要重定向时返回重定向, 槽没有分配时都返回空值
+//
+//  1. 槽由本节点负责, 正在迁出并且key已经不在本节点时, 重定向到目标节点 (ASK)
+//  2. 槽不由本节点负责, 正在迁入并且客户端执行了 ASKING 时, 由本节点执行
+//  3. 槽不由本节点负责时, 重定向到负责的节点 (MOVED)
+func (cd *ClusterDatabase) locate(conn resp.Connection, key string) (string, *redirection) {
+	s := slot.KeySlot(key)
+	owner := cd.slots.owner(s)
+	if owner == cd.self {
+		if target, ok := cd.slots.migratingTo(s); ok {
+			if _, exists := cd.db.GetEntity(conn.GetDBIndex(), key); !exists {
+				return "", &redirection{ask: true, slot: s, node: target}
+			}
+		}
+		return cd.self, nil
+	}
+	if _, ok := cd.slots.importingFrom(s); ok && conn.IsAsking() {
+		return cd.self, nil
+	}
+	if owner == "" {
+		return "", nil
+	}
+	return "", &redirection{slot: s, node: owner}
+}
+
+// relayByKey 把命令转发给负责key的节点
+//
+// 重定向模式的连接(其他节点的转发)直接返回 MOVED 或 ASK; 普通客户端的连接由本节点跟随重定向,
+// 收到其他节点的 MOVED 时更新本节点的槽分配
+func (cd *ClusterDatabase) relayByKey(conn resp.Connection, key string, args db.CmdLine) resp.Reply {
+	peer, redirect := cd.locate(conn, key)
+	if peer == "" && redirect == nil {
+		return &reply.NormalErrReply{Status: "CLUSTERDOWN Hash slot not served"}
+	}
+	asking := false
+	for i := 0; ; i++ {
+		if redirect != nil {
+			if conn.IsRedirect() || i > maxRedirects {
+				return redirect.reply()
+			}
+			peer, asking = redirect.node, redirect.ask
+		}
+		result := cd.relayTo(peer, conn, args, asking)
+		if redirect = parseRedirection(result); redirect == nil {
+			return result
+		}
+		if !redirect.ask {
+			cd.slots.setOwner(redirect.slot, redirect.node)
+		}
+	}
+}
+
+// execAsking 允许下一条命令访问本节点正在迁入的槽
+//
+// # ASKING
+func execAsking(_ *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) != 1 {
+		return reply.NewArgNumErrReply(enum.ASKING.String())
+	}
+	conn.SetAsking(true)
+	return reply.NewOKReply()
+}
+
+func init() {
+	registerRouter(enum.ASKING, execAsking)
+}
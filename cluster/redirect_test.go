@@ -0,0 +1,56 @@
+package cluster_database
+
+import (
+	"testing"
+
+	"go-redis/config"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+func TestRedirect(t *testing.T) {
+	// foo 在槽 12182, bar 在槽 5061
+	cluster := &ClusterDatabase{
+		self:  "127.0.0.1:7000",
+		slots: newSlotTable([]string{"127.0.0.1:7000", "127.0.0.1:7001"}),
+		db:    testNodeA.db,
+	}
+	cluster.gossip = newGossip(cluster)
+	conn := connection.NewFakeConn()
+	cluster.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	cluster.Exec(conn, utils.ToCmdLine("cluster", "redirect", "on"))
+	defer testNodeA.db.Exec(conn, utils.ToCmdLine("del", "foo", "bar"))
+
+	r := cluster.Exec(conn, utils.ToCmdLine("get", "foo"))
+	asserts.AssertErrReply(t, r, "MOVED 12182 127.0.0.1:7001")
+
+	// 迁入的槽只接受 ASKING 之后的一条命令
+	asserts.AssertStatusReply(t, cluster.Exec(conn, utils.ToCmdLine("cluster", "setslot", "12182", "importing", nodeID("127.0.0.1:7001"))), "OK")
+	asserts.AssertStatusReply(t, cluster.Exec(conn, utils.ToCmdLine("asking")), "OK")
+	asserts.AssertStatusReply(t, cluster.Exec(conn, utils.ToCmdLine("set", "foo", "1")), "OK")
+	asserts.AssertErrReply(t, cluster.Exec(conn, utils.ToCmdLine("get", "foo")), "MOVED 12182 127.0.0.1:7001")
+	asserts.AssertStatusReply(t, cluster.Exec(conn, utils.ToCmdLine("cluster", "setslot", "12182", "node", "127.0.0.1:7000")), "OK")
+	asserts.AssertBulkReply(t, cluster.Exec(conn, utils.ToCmdLine("get", "foo")), "1")
+
+	// 迁出的槽中已经不在本节点的key重定向到目标节点
+	r = cluster.Exec(conn, utils.ToCmdLine("cluster", "setslot", "12182", "importing", "127.0.0.1:7001"))
+	asserts.AssertErrReply(t, r, "ERR I'm already the owner of hash slot 12182")
+	asserts.AssertStatusReply(t, cluster.Exec(conn, utils.ToCmdLine("cluster", "setslot", "5061", "migrating", "127.0.0.1:7001")), "OK")
+	asserts.AssertErrReply(t, cluster.Exec(conn, utils.ToCmdLine("get", "bar")), "ASK 5061 127.0.0.1:7001")
+	testNodeA.db.Exec(conn, utils.ToCmdLine("set", "bar", "2"))
+	asserts.AssertBulkReply(t, cluster.Exec(conn, utils.ToCmdLine("get", "bar")), "2")
+	asserts.AssertStatusReply(t, cluster.Exec(conn, utils.ToCmdLine("cluster", "setslot", "5061", "stable")), "OK")
+
+	r = cluster.Exec(conn, utils.ToCmdLine("cluster", "setslot", "1", "node", "unknown"))
+	asserts.AssertErrReply(t, r, "ERR I don't know about node unknown")
+
+	redirect := parseRedirection(reply.NewErrReply("ASK 5061 127.0.0.1:7001"))
+	if redirect == nil || !redirect.ask || redirect.slot != 5061 || redirect.node != "127.0.0.1:7001" {
+		t.Errorf("unexpected redirection %v", redirect)
+	}
+	if parseRedirection(reply.NewErrReply("MOVED x")) != nil {
+		t.Errorf("invalid redirection should be ignored")
+	}
+}
@@ -24,8 +24,8 @@ func execRename(clusterDatabase *ClusterDatabase, connection resp.Connection, ar
 	// 2. 取出新key和旧key以及存储它们的节点
 	srcKey := utils.Bytes2String(args[1])
 	destKey := utils.Bytes2String(args[2])
-	srcNode := clusterDatabase.peerPicker.Pick(srcKey)
-	destNode := clusterDatabase.peerPicker.Pick(destKey)
+	srcNode := clusterDatabase.slots.pick(srcKey)
+	destNode := clusterDatabase.slots.pick(destKey)
 	if srcNode == destNode { // 2.1 新key的旧key的节点是同一个, 直接执行
 		return clusterDatabase.relay(srcNode, connection, args)
 	}
@@ -39,7 +39,7 @@ func execRename(clusterDatabase *ClusterDatabase, connection resp.Connection, ar
 	txIDStr := strconv.FormatInt(txID, 10)
 	// 5. 删除原key之前先上写锁, 并且保存原key对应的值
 	srcPrepareResp := clusterDatabase.relay(srcNode, connection, makeArgs(enum.TCC_PREPARE.String(), txIDStr,
-		enum.MULTI_RENAMEFROM.String(), srcKey))
+		clusterDatabase.self, enum.MULTI_RENAMEFROM.String(), srcKey))
 
 	// 6. 如果删除操作会失败, 回滚
 	if reply.IsErrReply(srcPrepareResp) {
@@ -51,12 +51,12 @@ func execRename(clusterDatabase *ClusterDatabase, connection resp.Connection, ar
 		requestRollback(clusterDatabase, connection, txID, map[string][]string{srcNode: {srcKey}})
 		return reply.NewErrReply("invalid prepare response")
 	}
-	// 7. 保存新key时上写锁
+	// 7. 保存新key时上写锁, 使用RESTORE还原DUMP得到的值和绝对过期时间
 	destCmd := utils.ToCmdLine2(enum.TCC_PREPARE.String(), utils.String2Bytes(txIDStr),
-		enum.MULTI_RENAMETO.Bytes(), utils.String2Bytes(destKey), srcPrepareMBR.Args[0], srcPrepareMBR.Args[1])
-	var destPrepareResp resp.Reply
-	destPrepareResp = clusterDatabase.relay(destNode,
-		connection, destCmd)
+		utils.String2Bytes(clusterDatabase.self), enum.RESTORE.Bytes(), utils.String2Bytes(destKey),
+		srcPrepareMBR.Args[1], srcPrepareMBR.Args[0],
+		utils.String2Bytes(enum.RESTORE_REPLACE), utils.String2Bytes(enum.RESTORE_ABSTTL))
+	destPrepareResp := clusterDatabase.relay(destNode, connection, destCmd)
 
 	if reply.IsErrReply(destPrepareResp) {
 		requestRollback(clusterDatabase, connection, txID, groupMap)
@@ -85,8 +85,20 @@ func prepareRenameFrom(cluster *ClusterDatabase, conn resp.Connection, cmdLine d
 	if existIntResp.Code() == 0 {
 		return reply.NewNoSuchKeyErrReply()
 	}
-	// 3. 把旧key的值序列化并返回
-	return cluster.db.ExecWithoutLock(conn, utils.ToCmdLine(enum.MULTI_DUMPKEY.String(), key))
+	// 3. 把旧key的值序列化, 和过期时间(ms)一起返回, 没有过期时间则为0
+	dumpResp := cluster.db.ExecWithoutLock(conn, utils.ToCmdLine(enum.DUMP.String(), key))
+	dumpBulkResp, ok := dumpResp.(*reply.BulkReply)
+	if !ok {
+		return utils.If[resp.Reply](reply.IsErrReply(dumpResp), dumpResp, reply.NewNoSuchKeyErrReply())
+	}
+	expireAt := int64(0)
+	if expiration := cluster.db.GetExpiration(conn.GetDBIndex(), key); expiration != nil {
+		expireAt = expiration.UnixMilli()
+	}
+	return reply.NewMultiBulkReply([][]byte{
+		dumpBulkResp.Arg,
+		utils.String2Bytes(strconv.FormatInt(expireAt, 10)),
+	})
 }
 
 func init() {
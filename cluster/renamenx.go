@@ -14,7 +14,7 @@ var execRenameNx = execFunc(func(clusterDatabase *ClusterDatabase, connection re
 	}
 	// 1. check if the newKey exists in the dst node
 	newKey := utils.Bytes2String(args[2])
-	dst := clusterDatabase.peerPicker.Pick(newKey)
+	dst := clusterDatabase.slots.pick(newKey)
 
 	dstClient, err := clusterDatabase.getPeerClient(dst)
 	if err != nil {
@@ -0,0 +1,292 @@
+package cluster_database
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"go-redis/client"
+	"go-redis/config"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/rdb"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+const (
+	replBacklog    = 1 << 16     // 复制队列的最大长度, 副本跟不上时丢弃队列并重新全量同步
+	replRetryDelay = time.Second // 连接副本失败之后重试的间隔
+)
+
+// replication 主节点把写命令复制到副本
+//
+// 主节点为每个副本维护一个复制队列, 执行命令的协程把写命令放入队列, 每个副本一个协程按顺序发送.
+// 建立连接或者队列溢出之后先全量同步: 清空副本, 然后把所有的key以 RESTORE 的形式放入队列.
+// 复制的命令使用 REPLAPPLY master db command... 发送, 副本只接受它当前的主节点发送的命令
+type replication struct {
+	cluster *ClusterDatabase
+	mu      sync.RWMutex
+	links   map[string]*replicaLink // 副本 -> 复制队列
+}
+
+// replicaLink 到一个副本的复制队列
+type replicaLink struct {
+	addr    string
+	mu      sync.Mutex
+	queue   []db.CmdLine
+	resync  bool // 需要全量同步
+	syncing bool // 正在全量同步, 队列不受长度限制
+	notify  chan struct{}
+	stop    chan struct{}
+}
+
+func newReplication(cluster *ClusterDatabase) *replication {
+	return &replication{cluster: cluster, links: make(map[string]*replicaLink)}
+}
+
+// reconcile 根据 slotTable 中的角色启动和停止到副本的复制
+func (r *replication) reconcile() {
+	if r == nil {
+		return
+	}
+	replicas := make(map[string]bool)
+	if r.cluster.slots.masterOf(r.cluster.self) == "" {
+		for _, replica := range r.cluster.slots.replicasOf(r.cluster.self) {
+			replicas[replica] = true
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for addr, link := range r.links {
+		if !replicas[addr] {
+			close(link.stop)
+			delete(r.links, addr)
+			logger.Info("replication: stop replicating to " + addr)
+		}
+	}
+	for addr := range replicas {
+		if _, ok := r.links[addr]; !ok {
+			link := &replicaLink{addr: addr, resync: true, notify: make(chan struct{}, 1), stop: make(chan struct{})}
+			r.links[addr] = link
+			go r.serve(link)
+			logger.Info("replication: start replicating to " + addr)
+		}
+	}
+}
+
+// onWrite 数据库的写命令监听者, 把命令放入所有副本的复制队列
+func (r *replication) onWrite(dbIndex int, cmdLine db.CmdLine) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.links) == 0 {
+		return
+	}
+	line := r.applyCmdLine(dbIndex, cmdLine)
+	for _, link := range r.links {
+		link.push(line, false)
+	}
+}
+
+// applyCmdLine 生成副本执行的命令 REPLAPPLY master db command...
+func (r *replication) applyCmdLine(dbIndex int, cmdLine db.CmdLine) db.CmdLine {
+	line := make(db.CmdLine, 0, len(cmdLine)+3)
+	line = append(line, []byte(enum.REPL_APPLY.String()), []byte(r.cluster.self), []byte(strconv.Itoa(dbIndex)))
+	return append(line, cmdLine...)
+}
+
+func (r *replication) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for addr, link := range r.links {
+		close(link.stop)
+		delete(r.links, addr)
+	}
+}
+
+// push 放入队列, force 为true时不受长度限制, 用于全量同步
+func (link *replicaLink) push(line db.CmdLine, force bool) {
+	link.mu.Lock()
+	if link.resync {
+		link.mu.Unlock()
+		return
+	}
+	if !force && !link.syncing && len(link.queue) >= replBacklog {
+		logger.Warn("replication: backlog of " + link.addr + " overflowed, full resync")
+		link.resync = true
+		link.queue = nil
+	} else {
+		link.queue = append(link.queue, line)
+	}
+	link.mu.Unlock()
+	select {
+	case link.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take 等待并取出队列中所有的命令, 需要全量同步或者停止时返回nil
+func (link *replicaLink) take() []db.CmdLine {
+	for {
+		link.mu.Lock()
+		if link.resync {
+			link.mu.Unlock()
+			return nil
+		}
+		if len(link.queue) > 0 {
+			lines := link.queue
+			link.queue = nil
+			link.mu.Unlock()
+			return lines
+		}
+		link.mu.Unlock()
+		select {
+		case <-link.stop:
+			return nil
+		case <-link.notify:
+		}
+	}
+}
+
+func (link *replicaLink) stopped() bool {
+	select {
+	case <-link.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// serve 向副本发送复制队列中的命令, 连接断开或者副本返回错误时重新连接并全量同步
+func (r *replication) serve(link *replicaLink) {
+	var peerClient *client.Client
+	defer func() {
+		if peerClient != nil {
+			peerClient.Close()
+		}
+	}()
+	for !link.stopped() {
+		link.mu.Lock()
+		resync := link.resync
+		link.mu.Unlock()
+		if resync || peerClient == nil {
+			if peerClient == nil {
+				var err error
+				if peerClient, err = dialPeer(link.addr, r.cluster.tlsConfig); err != nil {
+					logger.Debug("replication: connect " + link.addr + ": " + err.Error())
+					r.wait(link)
+					continue
+				}
+			}
+			r.fullSync(link)
+		}
+		for _, line := range link.take() {
+			if result := peerClient.Send(line); reply.IsErrReply(result) {
+				logger.Warn("replication: " + link.addr + ": " + string(result.Bytes()))
+				peerClient.Close()
+				peerClient = nil
+				link.mu.Lock()
+				link.resync = true
+				link.queue = nil
+				link.mu.Unlock()
+				r.wait(link)
+				break
+			}
+		}
+	}
+}
+
+func (r *replication) wait(link *replicaLink) {
+	select {
+	case <-link.stop:
+	case <-time.After(replRetryDelay):
+	}
+}
+
+// fullSync 把所有数据放入复制队列: 先清空副本, 然后每个key在持有读锁的时候生成 RESTORE,
+// 保证同一个key的写命令和 RESTORE 在队列中的顺序与执行的顺序一致
+func (r *replication) fullSync(link *replicaLink) {
+	link.mu.Lock()
+	link.resync = false
+	link.syncing = true
+	link.queue = nil
+	link.mu.Unlock()
+	defer func() {
+		link.mu.Lock()
+		link.syncing = false
+		link.mu.Unlock()
+	}()
+
+	logger.Info("replication: full resync to " + link.addr)
+	link.push(r.applyCmdLine(0, utils.ToCmdLine(enum.FLUSHALL.String())), true)
+	for dbIndex := 0; dbIndex < config.Properties.Databases; dbIndex++ {
+		keys := make([]string, 0)
+		r.cluster.db.ForEach(dbIndex, func(key string, _ *db.DataEntity, _ *time.Time) bool {
+			keys = append(keys, key)
+			return true
+		})
+		for _, key := range keys {
+			if line := r.restoreCmdLine(dbIndex, key); line != nil {
+				link.push(r.applyCmdLine(dbIndex, line), true)
+			}
+		}
+	}
+}
+
+// restoreCmdLine 生成重建key的 RESTORE key ttl value REPLACE ABSTTL, key不存在时返回nil
+func (r *replication) restoreCmdLine(dbIndex int, key string) db.CmdLine {
+	keys := []string{key}
+	r.cluster.db.RWLocks(dbIndex, nil, keys)
+	defer r.cluster.db.RWUnLocks(dbIndex, nil, keys)
+	entity, ok := r.cluster.db.GetEntity(dbIndex, key)
+	if !ok {
+		return nil
+	}
+	payload, err := rdb.Dump(entity)
+	if err != nil {
+		logger.Error("replication: dump " + key + ": " + err.Error())
+		return nil
+	}
+	ttl := int64(0)
+	if expiration := r.cluster.db.GetExpiration(dbIndex, key); expiration != nil {
+		ttl = max(expiration.UnixMilli(), 1)
+	}
+	return utils.ToCmdLine2(enum.RESTORE.String(), []byte(key), []byte(strconv.FormatInt(ttl, 10)), payload,
+		[]byte(enum.RESTORE_REPLACE), []byte(enum.RESTORE_ABSTTL))
+}
+
+// execReplApply 副本执行主节点复制的命令
+//
+// # REPLAPPLY master db command [arg ...]
+//
+// 发送者不是本节点当前的主节点时返回错误, 主节点收到错误之后重新连接并全量同步
+func execReplApply(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) < 4 {
+		return reply.NewArgNumErrReply(enum.REPL_APPLY.String())
+	}
+	master := string(args[1])
+	if cluster.slots.masterOf(cluster.self) != master {
+		return reply.NewErrReply("I'm not a replica of " + master)
+	}
+	dbIndex, err := strconv.Atoi(string(args[2]))
+	if err != nil {
+		return reply.NewErrReply("invalid DB index")
+	}
+	local := connection.NewFakeConn()
+	local.SetUser(conn.GetUser())
+	local.SelectDB(dbIndex)
+	if result := cluster.db.Exec(local, args[3:]); reply.IsErrReply(result) {
+		logger.Warn("replication: apply " + utils.CmdLine2String(args[3:]) + ": " + string(result.Bytes()))
+	}
+	return reply.NewOKReply()
+}
+
+func init() {
+	registerRouter(enum.REPL_APPLY, execReplApply)
+}
@@ -0,0 +1,54 @@
+package cluster_database
+
+import (
+	"testing"
+
+	"go-redis/config"
+	"go-redis/database"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+)
+
+func TestReplication(t *testing.T) {
+	m, r := "127.0.0.1:7000", "127.0.0.1:7001"
+	master := newGossipTestNode(m, m)
+	master.db = database.NewStandaloneDatabase()
+	master.replication = newReplication(master)
+	master.db.SetWriteListener(master.replication.onWrite)
+	replica := newGossipTestNode(r, m)
+	replica.db = database.NewStandaloneDatabase()
+	defer master.db.Close()
+	defer replica.db.Close()
+	master.slots.setMaster(r, m)
+	replica.slots.setMaster(r, m)
+
+	conn := connection.NewFakeConn()
+	master.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	replica.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	master.Exec(conn, utils.ToCmdLine("set", "foo", "1"))
+	master.Exec(conn, utils.ToCmdLine("rpush", "list", "a", "b"))
+	master.Exec(conn, utils.ToCmdLine("expire", "list", "100"))
+	replica.db.Exec(conn, utils.ToCmdLine("set", "stale", "1"))
+
+	// 全量同步清空副本, 之后的写命令按顺序复制; 副本不负责任何槽, 直接读取它的数据库
+	link := &replicaLink{addr: r, notify: make(chan struct{}, 1), stop: make(chan struct{})}
+	master.replication.links[r] = link
+	master.replication.fullSync(link)
+	master.Exec(conn, utils.ToCmdLine("incr", "foo"))
+	for _, line := range link.take() {
+		asserts.AssertStatusReply(t, execReplApply(replica, conn, line), "OK")
+	}
+	asserts.AssertBulkReply(t, replica.db.Exec(conn, utils.ToCmdLine("get", "foo")), "2")
+	asserts.AssertMultiBulkReply(t, replica.db.Exec(conn, utils.ToCmdLine("lrange", "list", "0", "-1")), []string{"a", "b"})
+	asserts.AssertNullBulk(t, replica.db.Exec(conn, utils.ToCmdLine("get", "stale")))
+	if ttl := replica.db.GetExpiration(0, "list"); ttl == nil {
+		t.Errorf("expiration of list should be replicated")
+	}
+
+	// 副本只接受当前主节点复制的命令
+	line := master.replication.applyCmdLine(0, utils.ToCmdLine("set", "foo", "3"))
+	replica.slots.setMaster(r, "")
+	asserts.AssertErrReply(t, execReplApply(replica, conn, line), "ERR I'm not a replica of "+m)
+	master.replication.close()
+}
@@ -14,11 +14,9 @@ type execFunc func(cluster *ClusterDatabase, connection resp.Connection, args db
 
 // newRouter returns a router map for commands
 func newRouter() (routerMap map[string]execFunc) {
+	// TCC 的命令在 tcc.go 中注册, 超时的事务会通过路由向协调者查询结果
 	cmdMap := map[string]execFunc{
 		// enum.RENAMENX.String():     execRenameNx,
-		enum.TCC_PREPARE.String():  execPrepare,
-		enum.TCC_COMMIT.String():   execCommit,
-		enum.TCC_ROLLBACK.String(): execRollback,
 	}
 
 	return cmdMap
@@ -42,10 +40,8 @@ func defaultFunc(clusterDatabase *ClusterDatabase, connection resp.Connection, a
 		return reply.NewArgNumErrReply(utils.Bytes2String(args[0]))
 	}
 	key := utils.Bytes2String(args[1])
-	peer := clusterDatabase.peerPicker.Pick(key)
 
-	r := clusterDatabase.relay(peer, connection, args)
-	return r
+	return clusterDatabase.relayByKey(connection, key, args)
 }
 
 func init() {
@@ -53,6 +49,8 @@ func init() {
 		router[cmd] = defaultFunc
 	}
 	registerRouter(enum.MULTI_KEYS, genPenetratingExecutor(enum.KEYS.String()))
+	// MIGRATE 迁移的是本节点上的key, 由 CLUSTER REBALANCE 或者管理员发送给迁出槽的节点
+	registerRouter(enum.MIGRATE, execLocal)
 }
 
 var defaultCmds = []string{
@@ -64,6 +62,8 @@ var defaultCmds = []string{
 	enum.PTTL.String(),
 	enum.PERSIST.String(),
 	enum.TYPE.String(),
+	enum.DUMP.String(),
+	enum.RESTORE.String(),
 	enum.SET.String(),
 	enum.SETNX.String(),
 	"setEx",
@@ -109,12 +109,6 @@ var defaultCmds = []string{
 	enum.SPOP.String(),
 	enum.SCARD.String(),
 	enum.SMEMBERS.String(),
-	// enum.SINTER.String(),
-	// enum.SINTERSTORE.String(),
-	// enum.SUNION.String(),
-	// enum.SUNIONSTORE.String(),
-	// enum.SDIFF.String(),
-	// enum.SDIFFSTORE.String(),
 	enum.SRANDMEMBER.String(),
 	enum.ZADD.String(),
 	enum.ZSCORE.String(),
@@ -0,0 +1,264 @@
+package cluster_database
+
+import (
+	"strconv"
+
+	"go-redis/datastruct/set"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+// setOperation 对多个集合求交集, 并集或差集
+type setOperation func(sets ...set.Set) set.Set
+
+// genSetCalculate 生成 SINTER, SUNION, SDIFF 的执行函数
+//
+// 所有key都在同一个节点时直接转发, 否则从每个节点并行获取集合的成员, 在本节点计算结果
+//
+// # SINTER key [key ...]
+func genSetCalculate(cmd *enum.Command, operation setOperation) execFunc {
+	return func(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+		if len(args) < 2 {
+			return reply.NewArgNumErrReply(cmd.String())
+		}
+		keys := utils.CmdLine2Strings(args[1:])
+		if len(cluster.groupBy(keys)) == 1 {
+			return cluster.relayByKey(conn, keys[0], args)
+		}
+		sets, errReply := cluster.fetchSets(conn, keys)
+		if errReply != nil {
+			return errReply
+		}
+		return reply.NewMultiBulkReply(setMembers(operation(sets...)))
+	}
+}
+
+// genSetCalculateStore 生成 SINTERSTORE, SUNIONSTORE, SDIFFSTORE 的执行函数
+//
+// 计算结果之后通过TCC替换 destination 中的集合, 结果为空时删除 destination
+//
+// # SINTERSTORE destination key [key ...]
+func genSetCalculateStore(cmd *enum.Command, operation setOperation) execFunc {
+	return func(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+		if len(args) < 3 {
+			return reply.NewArgNumErrReply(cmd.String())
+		}
+		dest := string(args[1])
+		keys := utils.CmdLine2Strings(args[2:])
+		if len(cluster.groupBy(append([]string{dest}, keys...))) == 1 {
+			return cluster.relayByKey(conn, dest, args)
+		}
+		sets, errReply := cluster.fetchSets(conn, keys)
+		if errReply != nil {
+			return errReply
+		}
+		members := setMembers(operation(sets...))
+		if errReply = cluster.storeSet(conn, dest, members); errReply != nil {
+			return errReply
+		}
+		return reply.NewIntReply(int64(len(members)))
+	}
+}
+
+// fetchSets 从负责key的节点并行获取集合的成员, 不存在的key是空集合, 返回的集合和keys的顺序相同
+func (cd *ClusterDatabase) fetchSets(conn resp.Connection, keys []string) ([]set.Set, resp.Reply) {
+	indexes := cd.groupIndexes(keys)
+	nodes := make([]string, 0, len(indexes))
+	for node := range indexes {
+		nodes = append(nodes, node)
+	}
+	// 每个节点依次获取这个节点上的集合, 返回每个key的回复
+	replies := cd.fanOut(nodes, func(node string) resp.Reply {
+		members := make([]resp.Reply, len(indexes[node]))
+		for i, index := range indexes[node] {
+			members[i] = cd.relayByKey(conn, keys[index], utils.ToCmdLine(enum.SMEMBERS.String(), keys[index]))
+			if reply.IsErrReply(members[i]) {
+				return members[i]
+			}
+		}
+		return reply.NewMultiRawReply(members)
+	})
+	if errReply := fanOutError(replies); errReply != nil {
+		return nil, errReply
+	}
+	sets := make([]set.Set, len(keys))
+	for node, nodeIndexes := range indexes {
+		members := replies[node].(*reply.MultiRawReply)
+		for i, index := range nodeIndexes {
+			sets[index] = toSet(members.Replies[i])
+		}
+	}
+	return sets, nil
+}
+
+// toSet 把 SMEMBERS 的回复转化为集合, 和其他节点的连接使用RESP3, 所以回复都是 SetReply
+func toSet(r resp.Reply) set.Set {
+	st := set.NewHashSet()
+	members, ok := r.(*reply.SetReply)
+	if !ok {
+		return st
+	}
+	for _, member := range members.Members {
+		if bulk, ok := member.(*reply.BulkReply); ok {
+			st.Add(string(bulk.Arg))
+		}
+	}
+	return st
+}
+
+// storeSet 通过TCC把 members 保存为 dest 中的集合, 原来的值被覆盖
+func (cd *ClusterDatabase) storeSet(conn resp.Connection, dest string, members [][]byte) resp.Reply {
+	node := cd.slots.pick(dest)
+	groupMap := map[string][]string{node: {dest}}
+	cmdLines := []db.CmdLine{utils.ToCmdLine(enum.DEL.String(), dest)}
+	if len(members) > 0 {
+		cmdLines = append(cmdLines, append(utils.ToCmdLine(enum.SADD.String(), dest), members...))
+	}
+	txID := cd.idGenerator.NextID()
+	txIDStr := strconv.FormatInt(txID, 10)
+	args := append(utils.ToCmdLine(enum.TCC_PREPARE_MULTI.String(), txIDStr, cd.self), encodeMulti(cmdLines, nil)...)
+	if r := cd.relay(node, conn, args); reply.IsErrReply(r) {
+		requestRollback(cd, conn, txID, groupMap)
+		return r
+	}
+	if _, errReply := requestCommit(cd, conn, txID, groupMap); errReply != nil {
+		return errReply
+	}
+	return nil
+}
+
+// setMembers 返回集合中的所有成员
+func setMembers(st set.Set) [][]byte {
+	members := make([][]byte, 0, st.Len())
+	st.ForEach(func(member any) bool {
+		members = append(members, set.ToBytes(member))
+		return true
+	})
+	return members
+}
+
+// execSMove 把成员从 source 移动到 destination, 两个key在不同的节点时使用TCC
+//
+// 1. source 上锁并检查是否包含成员, 提交时 SREM
+//
+// 2. destination 上锁并检查类型, 提交时 SADD
+//
+// # SMOVE source destination member
+func execSMove(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) != 4 {
+		return reply.NewArgNumErrReply(enum.SMOVE.String())
+	}
+	src, dest, member := string(args[1]), string(args[2]), string(args[3])
+	srcNode, destNode := cluster.slots.pick(src), cluster.slots.pick(dest)
+	if srcNode == destNode {
+		return cluster.relayByKey(conn, src, args)
+	}
+	groupMap := map[string][]string{srcNode: {src}, destNode: {dest}}
+	txID := cluster.idGenerator.NextID()
+	txIDStr := strconv.FormatInt(txID, 10)
+	srcResp := cluster.relay(srcNode, conn, makeArgs(enum.TCC_PREPARE.String(), txIDStr, cluster.self, enum.SREM.String(), src, member))
+	if reply.IsErrReply(srcResp) {
+		requestRollback(cluster, conn, txID, groupMap)
+		return srcResp
+	}
+	destResp := cluster.relay(destNode, conn, makeArgs(enum.TCC_PREPARE.String(), txIDStr, cluster.self, enum.SADD.String(), dest, member))
+	if reply.IsErrReply(destResp) {
+		requestRollback(cluster, conn, txID, groupMap)
+		return destResp
+	}
+	// source 中没有这个成员时不移动
+	if isMember, ok := srcResp.(*reply.IntReply); !ok || isMember.Code() == 0 {
+		requestRollback(cluster, conn, txID, groupMap)
+		return reply.NewIntReply(0)
+	}
+	if _, errReply := requestCommit(cluster, conn, txID, groupMap); errReply != nil {
+		return errReply
+	}
+	return reply.NewIntReply(1)
+}
+
+// execRPopLPush 弹出 source 的最后一个元素, 插入到 destination 的头部, 两个key在不同的节点时使用TCC
+//
+// 1. source 上锁并返回最后一个元素, 提交时 RPOP
+//
+// 2. destination 上锁并检查类型, 提交时 LPUSH 第一步返回的元素
+//
+// # RPOPLPUSH source destination
+func execRPopLPush(cluster *ClusterDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if len(args) != 3 {
+		return reply.NewArgNumErrReply(enum.RPOPLPUSH.String())
+	}
+	src, dest := string(args[1]), string(args[2])
+	srcNode, destNode := cluster.slots.pick(src), cluster.slots.pick(dest)
+	if srcNode == destNode {
+		return cluster.relayByKey(conn, src, args)
+	}
+	groupMap := map[string][]string{srcNode: {src}, destNode: {dest}}
+	txID := cluster.idGenerator.NextID()
+	txIDStr := strconv.FormatInt(txID, 10)
+	srcResp := cluster.relay(srcNode, conn, makeArgs(enum.TCC_PREPARE.String(), txIDStr, cluster.self, enum.RPOP.String(), src))
+	if reply.IsErrReply(srcResp) {
+		requestRollback(cluster, conn, txID, groupMap)
+		return srcResp
+	}
+	value, ok := srcResp.(*reply.BulkReply)
+	if !ok { // source 不存在
+		requestRollback(cluster, conn, txID, groupMap)
+		return reply.NewNullBulkReply()
+	}
+	destCmd := utils.ToCmdLine2(enum.TCC_PREPARE.String(), []byte(txIDStr), []byte(cluster.self), enum.LPUSH.Bytes(), []byte(dest), value.Arg)
+	if destResp := cluster.relay(destNode, conn, destCmd); reply.IsErrReply(destResp) {
+		requestRollback(cluster, conn, txID, groupMap)
+		return destResp
+	}
+	if _, errReply := requestCommit(cluster, conn, txID, groupMap); errReply != nil {
+		return errReply
+	}
+	return reply.NewBulkReply(value.Arg)
+}
+
+// prepareSRem 返回 source 是否包含要移动的成员
+func prepareSRem(cluster *ClusterDatabase, conn resp.Connection, cmdLine db.CmdLine) resp.Reply {
+	if len(cmdLine) != 3 {
+		return reply.NewArgNumErrReply(enum.SREM.String())
+	}
+	return cluster.db.ExecWithoutLock(conn, utils.ToCmdLine2(enum.SISMEMBER.String(), cmdLine[1], cmdLine[2]))
+}
+
+// prepareRPop 返回 source 的最后一个元素, source 不存在时返回空
+func prepareRPop(cluster *ClusterDatabase, conn resp.Connection, cmdLine db.CmdLine) resp.Reply {
+	if len(cmdLine) != 2 {
+		return reply.NewArgNumErrReply(enum.RPOP.String())
+	}
+	return cluster.db.ExecWithoutLock(conn, utils.ToCmdLine2(enum.LINDEX.String(), cmdLine[1], []byte("-1")))
+}
+
+// genPrepareType 生成检查 destination 类型的准备函数, key不存在或者类型相同时可以提交
+func genPrepareType(typeName string) execFunc {
+	return func(cluster *ClusterDatabase, conn resp.Connection, cmdLine db.CmdLine) resp.Reply {
+		r := cluster.db.ExecWithoutLock(conn, utils.ToCmdLine2(enum.TYPE.String(), cmdLine[1]))
+		if status, ok := r.(*reply.StatusReply); ok && status.Status() != "none" && status.Status() != typeName {
+			return reply.NewWrongTypeErrReply()
+		}
+		return reply.NewOKReply()
+	}
+}
+
+func init() {
+	registerRouter(enum.SINTER, genSetCalculate(enum.SINTER, set.Intersect))
+	registerRouter(enum.SUNION, genSetCalculate(enum.SUNION, set.Union))
+	registerRouter(enum.SDIFF, genSetCalculate(enum.SDIFF, set.Diff))
+	registerRouter(enum.SINTERSTORE, genSetCalculateStore(enum.SINTERSTORE, set.Intersect))
+	registerRouter(enum.SUNIONSTORE, genSetCalculateStore(enum.SUNIONSTORE, set.Union))
+	registerRouter(enum.SDIFFSTORE, genSetCalculateStore(enum.SDIFFSTORE, set.Diff))
+	registerRouter(enum.SMOVE, execSMove)
+	registerRouter(enum.RPOPLPUSH, execRPopLPush)
+	// 跨节点的 SMOVE 和 RPOPLPUSH 在 PREPARE 时读取 source, 检查 destination 的类型
+	registerPrepareFunc(enum.SREM.String(), prepareSRem)
+	registerPrepareFunc(enum.RPOP.String(), prepareRPop)
+	registerPrepareFunc(enum.SADD.String(), genPrepareType("set"))
+	registerPrepareFunc(enum.LPUSH.String(), genPrepareType("list"))
+}
@@ -0,0 +1,96 @@
+package cluster_database
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"go-redis/config"
+	"go-redis/datastruct/set"
+	"go-redis/interface/resp"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+)
+
+func TestSetCalculate(t *testing.T) {
+	node := newRecoveryTestNode(t, "127.0.0.1:7300", "")
+	conn := connection.NewFakeConn()
+	node.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	node.db.Exec(conn, utils.ToCmdLine("DEL", "set:a", "set:b", "set:c", "set:dest", "set:str"))
+	node.db.Exec(conn, utils.ToCmdLine("SADD", "set:a", "1", "2", "3"))
+	node.db.Exec(conn, utils.ToCmdLine("SADD", "set:b", "2", "3", "4"))
+	node.db.Exec(conn, utils.ToCmdLine("SET", "set:str", "1"))
+
+	// 不存在的key是空集合, 返回的集合和key的顺序相同
+	sets, errReply := node.fetchSets(conn, []string{"set:a", "set:c", "set:b"})
+	if errReply != nil || len(sets) != 3 || sets[0].Len() != 3 || sets[1].Len() != 0 || sets[2].Len() != 3 {
+		t.Fatalf("unexpected sets %v %v", sets, errReply)
+	}
+	members := utils.CmdLine2Strings(setMembers(set.Intersect(sets[0], sets[2])))
+	sort.Strings(members)
+	if len(members) != 2 || members[0] != "2" || members[1] != "3" {
+		t.Fatalf("unexpected intersection %v", members)
+	}
+	if _, errReply = node.fetchSets(conn, []string{"set:a", "set:str"}); errReply == nil {
+		t.Fatal("expected wrong type error")
+	}
+
+	// 通过TCC保存结果, 结果为空时删除 destination
+	if errReply = node.storeSet(conn, "set:dest", setMembers(set.Union(sets[0], sets[2]))); errReply != nil {
+		t.Fatal(errReply)
+	}
+	asserts.AssertIntReply(t, node.db.Exec(conn, utils.ToCmdLine("SCARD", "set:dest")), 4)
+	if errReply = node.storeSet(conn, "set:dest", nil); errReply != nil {
+		t.Fatal(errReply)
+	}
+	asserts.AssertIntReply(t, node.db.Exec(conn, utils.ToCmdLine("EXISTS", "set:dest")), 0)
+
+	// 所有的key都在本节点上时直接执行
+	asserts.AssertMultiBulkReply(t, node.Exec(conn, utils.ToCmdLine("SDIFF", "set:a", "set:b")), []string{"1"})
+	asserts.AssertIntReply(t, node.Exec(conn, utils.ToCmdLine("SINTERSTORE", "set:dest", "set:a", "set:b")), 2)
+	node.db.Exec(conn, utils.ToCmdLine("DEL", "set:a", "set:b", "set:dest", "set:str"))
+}
+
+func TestCrossNodeMove(t *testing.T) {
+	node := newRecoveryTestNode(t, "127.0.0.1:7300", "")
+	conn := connection.NewFakeConn()
+	node.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	node.db.Exec(conn, utils.ToCmdLine("DEL", "move:src", "move:dest", "move:list", "move:str"))
+	node.db.Exec(conn, utils.ToCmdLine("SADD", "move:src", "a"))
+	node.db.Exec(conn, utils.ToCmdLine("RPUSH", "move:list", "x", "y"))
+	node.db.Exec(conn, utils.ToCmdLine("SET", "move:str", "1"))
+	var txIDs []int64
+	prepare := func(args ...string) resp.Reply {
+		txID := node.idGenerator.NextID()
+		txIDs = append(txIDs, txID)
+		return execPrepare(node, conn, makeArgs("PREPARE", append([]string{strconv.FormatInt(txID, 10), node.self}, args...)...))
+	}
+	groupMap := map[string][]string{node.self: nil}
+	wrongType := "ERR Operation against a key holding the wrong kind of value"
+
+	// SMOVE: source 返回成员是否存在, destination 检查类型
+	asserts.AssertIntReply(t, prepare("SREM", "move:src", "a"), 1)
+	asserts.AssertStatusReply(t, prepare("SADD", "move:dest", "a"), "OK")
+	asserts.AssertErrReply(t, prepare("SADD", "move:str", "a"), wrongType)
+	for _, txID := range txIDs {
+		_, _ = requestCommit(node, conn, txID, groupMap)
+	}
+	asserts.AssertIntReply(t, node.db.Exec(conn, utils.ToCmdLine("SISMEMBER", "move:src", "a")), 0)
+	asserts.AssertIntReply(t, node.db.Exec(conn, utils.ToCmdLine("SISMEMBER", "move:dest", "a")), 1)
+
+	// RPOPLPUSH: source 返回最后一个元素, 不存在时返回空
+	txIDs = nil
+	asserts.AssertBulkReply(t, prepare("RPOP", "move:list"), "y")
+	asserts.AssertNullBulk(t, prepare("RPOP", "move:none"))
+	asserts.AssertErrReply(t, prepare("LPUSH", "move:dest", "y"), wrongType)
+	for _, txID := range txIDs {
+		requestRollback(node, conn, txID, groupMap)
+	}
+	asserts.AssertIntReply(t, node.db.Exec(conn, utils.ToCmdLine("LLEN", "move:list")), 2)
+
+	// 两个key在同一个节点上时直接执行
+	asserts.AssertIntReply(t, node.Exec(conn, utils.ToCmdLine("SMOVE", "move:dest", "move:src", "a")), 1)
+	asserts.AssertBulkReply(t, node.Exec(conn, utils.ToCmdLine("RPOPLPUSH", "move:list", "move:list")), "y")
+	node.db.Exec(conn, utils.ToCmdLine("DEL", "move:src", "move:dest", "move:list", "move:str"))
+}
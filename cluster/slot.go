@@ -0,0 +1,409 @@
+package cluster_database
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go-redis/lib/slot"
+)
+
+// slotRange 连续的由同一个节点负责的槽, 包括 start 和 end
+type slotRange struct {
+	start, end int
+	node       string
+}
+
+// slotMove 重新分片时把槽 slot 从节点 from 迁移到节点 to, from 为空表示槽还没有分配
+type slotMove struct {
+	slot     int
+	from, to string
+}
+
+// slotTable 槽和节点的对应关系
+//
+// 启动时所有节点按照地址排序之后平均分配连续的槽, 每个节点根据相同的 peers 和 self 配置得到相同的分配结果.
+// 之后可以通过 CLUSTER ADDSLOTS / SETSLOT 在线修改, 或者由集群总线收到的配置纪元更大的节点的声明修改,
+// 迁移中的槽记录在 migrating 和 importing 中. replicaOf 记录副本节点复制的主节点, 副本不负责任何槽
+type slotTable struct {
+	mu        sync.RWMutex
+	nodes     []string           // 按照地址排序的节点
+	slots     [slot.Count]string // slot -> node, 为空表示槽没有分配
+	migrating map[int]string     // 本节点正在迁出的槽 -> 目标节点
+	importing map[int]string     // 本节点正在迁入的槽 -> 源节点
+	replicaOf map[string]string  // 副本 -> 主节点
+}
+
+// newSlotTable 把所有的槽平均分配给节点, 前 slot.Count % len(nodes) 个节点多分配一个槽
+func newSlotTable(nodes []string) *slotTable {
+	table := &slotTable{
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+		replicaOf: make(map[string]string),
+	}
+	for _, node := range nodes {
+		table.addNode(node)
+	}
+	if len(table.nodes) == 0 {
+		return table
+	}
+
+	size, extra := slot.Count/len(table.nodes), slot.Count%len(table.nodes)
+	start := 0
+	for i, node := range table.nodes {
+		end := start + size
+		if i < extra {
+			end++
+		}
+		for s := start; s < end; s++ {
+			table.slots[s] = node
+		}
+		start = end
+	}
+	return table
+}
+
+// pick 返回负责key所在的槽的节点
+func (table *slotTable) pick(key string) string {
+	return table.owner(slot.KeySlot(key))
+}
+
+// owner 返回负责槽s的节点, 槽没有分配时返回空字符串
+func (table *slotTable) owner(s int) string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.slots[s]
+}
+
+// knownNodes 返回所有已知的节点, 按照地址排序
+func (table *slotTable) knownNodes() []string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	nodes := make([]string, len(table.nodes))
+	copy(nodes, table.nodes)
+	return nodes
+}
+
+// addNode 添加节点, 节点已经存在时返回false
+func (table *slotTable) addNode(node string) bool {
+	if node == "" {
+		return false
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.addNodeLocked(node)
+}
+
+func (table *slotTable) addNodeLocked(node string) bool {
+	i := sort.SearchStrings(table.nodes, node)
+	if i < len(table.nodes) && table.nodes[i] == node {
+		return false
+	}
+	table.nodes = append(table.nodes, "")
+	copy(table.nodes[i+1:], table.nodes[i:])
+	table.nodes[i] = node
+	return true
+}
+
+// removeNode 删除节点, 节点负责的槽变为没有分配, 节点不存在时返回false
+func (table *slotTable) removeNode(node string) bool {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	i := sort.SearchStrings(table.nodes, node)
+	if i >= len(table.nodes) || table.nodes[i] != node {
+		return false
+	}
+	table.nodes = append(table.nodes[:i], table.nodes[i+1:]...)
+	for s := range table.slots {
+		if table.slots[s] == node {
+			table.slots[s] = ""
+		}
+	}
+	for s, target := range table.migrating {
+		if target == node {
+			delete(table.migrating, s)
+		}
+	}
+	for s, source := range table.importing {
+		if source == node {
+			delete(table.importing, s)
+		}
+	}
+	delete(table.replicaOf, node)
+	for replica, master := range table.replicaOf {
+		if master == node {
+			delete(table.replicaOf, replica)
+		}
+	}
+	return true
+}
+
+// assign 把没有分配的槽分配给节点, 任意一个槽已经分配时不做任何修改并返回错误
+func (table *slotTable) assign(node string, slots []int) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, s := range slots {
+		if table.slots[s] != "" {
+			return fmt.Errorf("Slot %d is already busy", s)
+		}
+	}
+	table.addNodeLocked(node)
+	for _, s := range slots {
+		table.slots[s] = node
+	}
+	return nil
+}
+
+// setOwner 把槽分配给节点, 同时结束槽的迁移状态
+func (table *slotTable) setOwner(s int, node string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.addNodeLocked(node)
+	table.slots[s] = node
+	delete(table.migrating, s)
+	delete(table.importing, s)
+}
+
+// setMigrating 标记槽正在从本节点迁移到节点 to
+func (table *slotTable) setMigrating(s int, to string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.migrating[s] = to
+}
+
+// setImporting 标记槽正在从节点 from 迁移到本节点
+func (table *slotTable) setImporting(s int, from string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.importing[s] = from
+}
+
+// setStable 清除槽的迁移状态
+func (table *slotTable) setStable(s int) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	delete(table.migrating, s)
+	delete(table.importing, s)
+}
+
+// migratingTo 返回槽迁移的目标节点
+func (table *slotTable) migratingTo(s int) (string, bool) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	node, ok := table.migrating[s]
+	return node, ok
+}
+
+// importingFrom 返回槽迁入的源节点
+func (table *slotTable) importingFrom(s int) (string, bool) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	node, ok := table.importing[s]
+	return node, ok
+}
+
+// migrations 返回本节点正在迁出和迁入的槽
+func (table *slotTable) migrations() (migrating, importing map[int]string) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	migrating = make(map[int]string, len(table.migrating))
+	for s, node := range table.migrating {
+		migrating[s] = node
+	}
+	importing = make(map[int]string, len(table.importing))
+	for s, node := range table.importing {
+		importing[s] = node
+	}
+	return migrating, importing
+}
+
+// load 使用其他节点的槽区间替换整个分配表, 迁移状态只属于本节点, 不受影响
+func (table *slotTable) load(ranges []slotRange) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.slots = [slot.Count]string{}
+	for _, r := range ranges {
+		table.addNodeLocked(r.node)
+		for s := r.start; s <= r.end; s++ {
+			table.slots[s] = r.node
+		}
+	}
+}
+
+// masterOf 返回副本复制的主节点, 主节点返回空字符串
+func (table *slotTable) masterOf(node string) string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.replicaOf[node]
+}
+
+// replicasOf 返回主节点的所有副本, 按照地址排序
+func (table *slotTable) replicasOf(master string) []string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	replicas := make([]string, 0)
+	for replica, m := range table.replicaOf {
+		if m == master {
+			replicas = append(replicas, replica)
+		}
+	}
+	sort.Strings(replicas)
+	return replicas
+}
+
+// masters 返回所有不是副本的节点, 按照地址排序
+func (table *slotTable) masters() []string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	masters := make([]string, 0, len(table.nodes))
+	for _, node := range table.nodes {
+		if _, ok := table.replicaOf[node]; !ok {
+			masters = append(masters, node)
+		}
+	}
+	return masters
+}
+
+// roles 返回所有副本和主节点的对应关系的副本
+func (table *slotTable) roles() map[string]string {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	roles := make(map[string]string, len(table.replicaOf))
+	for replica, master := range table.replicaOf {
+		roles[replica] = master
+	}
+	return roles
+}
+
+// setMaster 把节点设置为 master 的副本, master 为空时节点成为主节点
+func (table *slotTable) setMaster(node, master string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.addNodeLocked(node)
+	if master == "" {
+		delete(table.replicaOf, node)
+		return
+	}
+	table.addNodeLocked(master)
+	table.replicaOf[node] = master
+}
+
+// failover 副本接替主节点: 主节点负责的槽改为由副本负责, 主节点和它的其他副本都成为新主节点的副本
+//
+// 返回副本获得的槽的数量
+func (table *slotTable) failover(master, replica string) int {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.addNodeLocked(replica)
+	moved := 0
+	for s := range table.slots {
+		if table.slots[s] == master {
+			table.slots[s] = replica
+			delete(table.migrating, s)
+			delete(table.importing, s)
+			moved++
+		}
+	}
+	delete(table.replicaOf, replica)
+	for node, m := range table.replicaOf {
+		if m == master {
+			table.replicaOf[node] = replica
+		}
+	}
+	table.replicaOf[master] = replica
+	return moved
+}
+
+// setRoles 使用其他节点的副本关系替换本节点的记录
+func (table *slotTable) setRoles(roles map[string]string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.replicaOf = make(map[string]string, len(roles))
+	for replica, master := range roles {
+		table.addNodeLocked(replica)
+		table.addNodeLocked(master)
+		table.replicaOf[replica] = master
+	}
+}
+
+// ranges 返回所有连续的槽区间, 按照槽的顺序排列
+func (table *slotTable) ranges() []slotRange {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	result := make([]slotRange, 0, len(table.nodes))
+	for s := 0; s < slot.Count; s++ {
+		node := table.slots[s]
+		if node == "" {
+			continue
+		}
+		if n := len(result); n > 0 && result[n-1].node == node && result[n-1].end == s-1 {
+			result[n-1].end = s
+			continue
+		}
+		result = append(result, slotRange{start: s, end: s, node: node})
+	}
+	return result
+}
+
+// rebalancePlan 计算让 nodes 负责的槽数量相差不超过1所需的最少迁移, 其他节点负责的槽全部迁出
+//
+// 槽数量最多的节点优先保留多出来的一个槽, 超出目标数量的节点从编号最大的槽开始迁出, 没有分配的槽直接分配
+func (table *slotTable) rebalancePlan(nodes []string) []slotMove {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	// 1. 统计每个节点负责的槽
+	owned := make(map[string][]int, len(table.nodes))
+	var unassigned []int
+	for s := slot.Count - 1; s >= 0; s-- {
+		if node := table.slots[s]; node != "" {
+			owned[node] = append(owned[node], s)
+		} else {
+			unassigned = append(unassigned, s)
+		}
+	}
+	// 2. 计算每个节点的目标数量
+	nodes = append([]string(nil), nodes...)
+	sort.SliceStable(nodes, func(i, j int) bool { return len(owned[nodes[i]]) > len(owned[nodes[j]]) })
+	size, extra := slot.Count/len(nodes), slot.Count%len(nodes)
+	target := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		target[node] = size
+		if i < extra {
+			target[node]++
+		}
+	}
+	// 3. 多出来的槽和没有分配的槽依次分配给不足目标数量的节点
+	type donation struct {
+		slot int
+		from string
+	}
+	donations := make([]donation, 0, len(unassigned))
+	for _, s := range unassigned {
+		donations = append(donations, donation{slot: s})
+	}
+	for _, node := range table.nodes {
+		for _, s := range owned[node][:max(len(owned[node])-target[node], 0)] {
+			donations = append(donations, donation{slot: s, from: node})
+		}
+	}
+	moves := make([]slotMove, 0, len(donations))
+	for _, node := range nodes {
+		for need := target[node] - len(owned[node]); need > 0 && len(donations) > 0; need-- {
+			moves = append(moves, slotMove{slot: donations[0].slot, from: donations[0].from, to: node})
+			donations = donations[1:]
+		}
+	}
+	return moves
+}
+
+// nodeID 根据节点的地址生成40个字符的节点ID, 和Redis Cluster的节点ID格式一致
+func nodeID(node string) string {
+	sum := sha1.Sum([]byte(node))
+	return hex.EncodeToString(sum[:])
+}
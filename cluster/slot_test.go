@@ -0,0 +1,171 @@
+package cluster_database
+
+import (
+	"strconv"
+	"testing"
+
+	"go-redis/config"
+	"go-redis/lib/asserts"
+	"go-redis/lib/slot"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+func TestSlotTable(t *testing.T) {
+	table := newSlotTable([]string{"127.0.0.1:7002", "127.0.0.1:7000", "127.0.0.1:7001", "127.0.0.1:7000", ""})
+	ranges := table.ranges()
+	expected := []slotRange{
+		{0, 5461, "127.0.0.1:7000"},
+		{5462, 10922, "127.0.0.1:7001"},
+		{10923, 16383, "127.0.0.1:7002"},
+	}
+	if len(ranges) != len(expected) {
+		t.Fatalf("expected %v, actually %v", expected, ranges)
+	}
+	for i := range expected {
+		if ranges[i] != expected[i] {
+			t.Errorf("expected %v, actually %v", expected[i], ranges[i])
+		}
+	}
+	// 相同hash tag的key分配到同一个节点
+	if table.pick("{user1000}.following") != table.pick("{user1000}.followers") {
+		t.Errorf("keys with the same hash tag should be on the same node")
+	}
+	if node := table.pick("foo"); node != "127.0.0.1:7002" {
+		t.Errorf("expected foo on 127.0.0.1:7002, actually %s", node)
+	}
+	if id := nodeID("127.0.0.1:7000"); len(id) != 40 {
+		t.Errorf("unexpected node id %s", id)
+	}
+}
+
+func TestSlotMigration(t *testing.T) {
+	table := newSlotTable(nil)
+	if err := table.assign("127.0.0.1:7000", []int{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.assign("127.0.0.1:7001", []int{2, 3}); err == nil || err.Error() != "Slot 2 is already busy" {
+		t.Errorf("expected busy slot, actually %v", err)
+	}
+	if table.owner(3) != "" || len(table.knownNodes()) != 1 {
+		t.Errorf("failed assign should not change the table")
+	}
+
+	table.setMigrating(1, "127.0.0.1:7001")
+	table.setImporting(1, "127.0.0.1:7001")
+	if node, ok := table.migratingTo(1); !ok || node != "127.0.0.1:7001" {
+		t.Errorf("expected slot 1 migrating to 127.0.0.1:7001")
+	}
+	table.setOwner(1, "127.0.0.1:7001")
+	_, migrating := table.migratingTo(1)
+	_, importing := table.importingFrom(1)
+	if migrating || importing || table.owner(1) != "127.0.0.1:7001" {
+		t.Errorf("setting the owner should finish the migration")
+	}
+	if nodes := table.knownNodes(); len(nodes) != 2 || nodes[1] != "127.0.0.1:7001" {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+}
+
+func TestRebalancePlan(t *testing.T) {
+	if moves := newSlotTable([]string{"a", "b", "c"}).rebalancePlan([]string{"a", "b", "c"}); len(moves) != 0 {
+		t.Errorf("balanced table should not move any slot, actually %d", len(moves))
+	}
+
+	// 新加入的节点从两个节点各获得一部分槽
+	table := newSlotTable([]string{"127.0.0.1:7000", "127.0.0.1:7001"})
+	table.addNode("127.0.0.1:7002")
+	moves := table.rebalancePlan(table.knownNodes())
+	if len(moves) != slot.Count/3 {
+		t.Fatalf("expected %d moves, actually %d", slot.Count/3, len(moves))
+	}
+	for _, move := range moves {
+		if move.to != "127.0.0.1:7002" || table.owner(move.slot) != move.from {
+			t.Fatalf("unexpected move %v", move)
+		}
+		table.setOwner(move.slot, move.to)
+	}
+	counts := make(map[string]int)
+	for _, r := range table.ranges() {
+		counts[r.node] += r.end - r.start + 1
+	}
+	for node, count := range counts {
+		if count < slot.Count/3 || count > slot.Count/3+1 {
+			t.Errorf("%s has %d slots", node, count)
+		}
+	}
+	if moves = table.rebalancePlan(table.knownNodes()); len(moves) != 0 {
+		t.Errorf("expected balanced table, actually %d moves", len(moves))
+	}
+
+	// 没有分配的槽直接分配
+	table = newSlotTable(nil)
+	table.addNode("a")
+	if moves = table.rebalancePlan(table.knownNodes()); len(moves) != slot.Count || moves[0].from != "" {
+		t.Errorf("expected all slots assigned to a")
+	}
+
+	// 不参与分配的节点负责的槽全部迁出
+	table = newSlotTable([]string{"a", "b"})
+	moves = table.rebalancePlan([]string{"a"})
+	if len(moves) != slot.Count/2 || moves[0].from != "b" || moves[0].to != "a" {
+		t.Errorf("expected slots of b moved to a, actually %d moves", len(moves))
+	}
+}
+
+func TestSlotRoles(t *testing.T) {
+	table := newSlotTable([]string{"a", "b"})
+	table.setMaster("c", "a")
+	table.setMaster("d", "a")
+	if masters := table.masters(); len(masters) != 2 || table.masterOf("c") != "a" {
+		t.Errorf("unexpected masters %v", masters)
+	}
+
+	// 副本接替主节点之后, 旧的主节点和其他副本复制新的主节点
+	if moved := table.failover("a", "c"); moved != slot.Count/2 {
+		t.Errorf("expected %d slots moved, actually %d", slot.Count/2, moved)
+	}
+	if table.owner(0) != "c" || table.masterOf("c") != "" || table.masterOf("a") != "c" || table.masterOf("d") != "c" {
+		t.Errorf("unexpected roles %v", table.roles())
+	}
+	if replicas := table.replicasOf("c"); len(replicas) != 2 || replicas[0] != "a" {
+		t.Errorf("unexpected replicas %v", replicas)
+	}
+
+	// 删除主节点时它的副本成为主节点
+	table.removeNode("c")
+	if roles := table.roles(); len(roles) != 0 {
+		t.Errorf("expected no replicas, actually %v", roles)
+	}
+}
+
+func TestClusterCommand(t *testing.T) {
+	conn := connection.NewFakeConn()
+	testNodeA.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	result := testNodeA.Exec(conn, utils.ToCmdLine("cluster", "keyslot", "{user1000}.following"))
+	asserts.AssertIntReply(t, result, 3443)
+
+	result = testNodeA.Exec(conn, utils.ToCmdLine("cluster", "slots"))
+	if slots, ok := result.(*reply.MultiRawReply); !ok || len(slots.Replies) != len(testNodeA.slots.ranges()) {
+		t.Errorf("unexpected cluster slots result %q", result.Bytes())
+	}
+	result = testNodeA.Exec(conn, utils.ToCmdLine("cluster", "shards"))
+	if shards, ok := result.(*reply.MultiRawReply); !ok || len(shards.Replies) != len(testNodeA.slots.masters()) {
+		t.Errorf("unexpected cluster shards result %q", result.Bytes())
+	}
+
+	// 只统计本节点上的key
+	testNodeA.db.Exec(conn, utils.ToCmdLine("set", "{slot}a", "1"))
+	testNodeA.db.Exec(conn, utils.ToCmdLine("set", "{slot}b", "1"))
+	defer testNodeA.db.Exec(conn, utils.ToCmdLine("del", "{slot}a", "{slot}b"))
+	s := slot.KeySlot("slot")
+	result = testNodeA.Exec(conn, utils.ToCmdLine("cluster", "countkeysinslot", strconv.Itoa(s)))
+	asserts.AssertIntReply(t, result, 2)
+	result = testNodeA.Exec(conn, utils.ToCmdLine("cluster", "getkeysinslot", strconv.Itoa(s), "1"))
+	asserts.AssertMultiBulkReplySize(t, result, 1)
+	result = testNodeA.Exec(conn, utils.ToCmdLine("cluster", "countkeysinslot", "16384"))
+	asserts.AssertErrReply(t, result, "ERR Invalid slot")
+	result = testNodeA.Exec(conn, utils.ToCmdLine("cluster", "getkeysinslot", "0", "-1"))
+	asserts.AssertErrReply(t, result, "ERR Invalid number of keys")
+}
@@ -34,23 +34,19 @@ func execMSet(cluster *ClusterDatabase, conn resp.Connection, cmdLine db.CmdLine
 	}
 
 	// 4. 开始事务之前的准备工作
-	var errReply resp.Reply
 	txID := cluster.idGenerator.NextID() // 4.1 生成分布式事务id
 	txIDStr := strconv.FormatInt(txID, 10)
-	rollback := false
+	cmdLines := make(map[string]db.CmdLine, len(groupMap))
 	for peer, group := range groupMap { // 4.2 组装参数, 发送给每一个跟此事务相关的节点
-		peerArgs := []string{txIDStr, enum.MSET.String()}
+		peerArgs := []string{txIDStr, cluster.self, enum.MSET.String()}
 		for _, k := range group {
 			peerArgs = append(peerArgs, k, valueMap[k])
 		}
-		// 4.3 发送命令
-		r := cluster.relay(peer, conn, makeArgs(enum.TCC_PREPARE.String(), peerArgs...))
-		if reply.IsErrReply(r) {
-			errReply = r
-			rollback = true
-			break
-		}
+		cmdLines[peer] = makeArgs(enum.TCC_PREPARE.String(), peerArgs...)
 	}
+	// 4.3 并行发送命令, 有节点失败时回滚
+	errReply := fanOutError(cluster.relayAll(conn, cmdLines))
+	rollback := errReply != nil
 	// 5. 判断是否需要回滚
 	if rollback {
 		// 5.1 回滚
@@ -66,6 +62,47 @@ func execMSet(cluster *ClusterDatabase, conn resp.Connection, cmdLine db.CmdLine
 	return errReply
 }
 
+// execMGet 从多个节点并行地获取多个key的值, 按照参数中key的顺序返回
+//
+// # MGET key [key ...]
+func execMGet(cluster *ClusterDatabase, conn resp.Connection, cmdLine db.CmdLine) resp.Reply {
+	if len(cmdLine) < 2 {
+		return reply.NewArgNumErrReply(enum.MGET.String())
+	}
+	// 1. 所有key都存储在一个节点内时直接转发
+	keys := utils.CmdLine2Strings(cmdLine[1:])
+	indexes := cluster.groupIndexes(keys)
+	if len(indexes) == 1 {
+		return cluster.relayByKey(conn, keys[0], cmdLine)
+	}
+	// 2. 并行发送每个节点上的key
+	cmdLines := make(map[string]db.CmdLine, len(indexes))
+	for peer, group := range indexes {
+		peerArgs := make([]string, len(group))
+		for i, index := range group {
+			peerArgs[i] = keys[index]
+		}
+		cmdLines[peer] = makeArgs(enum.MGET.String(), peerArgs...)
+	}
+	replies := cluster.relayAll(conn, cmdLines)
+	if errReply := fanOutError(replies); errReply != nil {
+		return errReply
+	}
+	// 3. 按照下标把每个节点的回复放回原来的位置
+	values := make([][]byte, len(keys))
+	for peer, group := range indexes {
+		peerValues, ok := replies[peer].(*reply.MultiBulkReply)
+		if !ok || len(peerValues.Args) != len(group) {
+			return reply.NewErrReply("unexpected MGET reply from " + peer)
+		}
+		for i, index := range group {
+			values[index] = peerValues.Args[i]
+		}
+	}
+	return reply.NewMultiBulkReply(values)
+}
+
 func init() {
 	registerRouter(enum.MSET, execMSet)
+	registerRouter(enum.MGET, execMGet)
 }
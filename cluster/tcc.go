@@ -1,7 +1,9 @@
 package cluster_database
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,11 +29,14 @@ func registerPrepareFunc(cmdName string, fn execFunc) {
 
 // Transaction stores state and data for a try-commit-catch distributed transaction
 type Transaction struct {
-	id      string     // transaction id
-	cmdLine db.CmdLine // cmd cmdLine
-	cluster *ClusterDatabase
-	conn    resp.Connection
-	dbIndex int
+	id          string       // transaction id
+	coordinator string       // 协调者的地址, 准备之后超时没有收到决定时向它查询
+	cmdLines    []db.CmdLine // cmd cmdLines, MULTI/EXEC 的事务有多条命令
+	multi       bool         // 是否是 MULTI/EXEC 的事务, 提交时返回每条命令的结果
+	watching    map[string]uint32
+	cluster     *ClusterDatabase
+	conn        resp.Connection
+	dbIndex     int
 
 	writeKeys  []string
 	readKeys   []string
@@ -39,6 +44,7 @@ type Transaction struct {
 	undoLog    []db.CmdLine
 
 	status int8
+	result resp.Reply // 提交的结果, 重复提交时直接返回
 	mu     *sync.Mutex
 }
 
@@ -59,16 +65,26 @@ func genTaskKey(txID string) string {
 // NewTransaction creates a try-commit-catch distributed transaction
 func NewTransaction(cluster *ClusterDatabase, conn resp.Connection, id string, cmdLine db.CmdLine) *Transaction {
 	return &Transaction{
-		id:      id,
-		cmdLine: cmdLine,
-		cluster: cluster,
-		conn:    conn,
-		dbIndex: conn.GetDBIndex(),
-		status:  createdStatus,
-		mu:      new(sync.Mutex),
+		id:       id,
+		cmdLines: []db.CmdLine{cmdLine},
+		cluster:  cluster,
+		conn:     conn,
+		dbIndex:  conn.GetDBIndex(),
+		status:   createdStatus,
+		mu:       new(sync.Mutex),
 	}
 }
 
+// newMultiTransaction 创建 MULTI/EXEC 在一个节点上的分布式事务, watching 是这个节点上观察的key和版本号
+func newMultiTransaction(cluster *ClusterDatabase, conn resp.Connection, id string,
+	cmdLines []db.CmdLine, watching map[string]uint32) *Transaction {
+	tx := NewTransaction(cluster, conn, id, nil)
+	tx.cmdLines = cmdLines
+	tx.multi = true
+	tx.watching = watching
+	return tx
+}
+
 // Reentrant
 // invoker should hold tx.mu
 func (tx *Transaction) lockKeys() {
@@ -85,53 +101,146 @@ func (tx *Transaction) unLockKeys() {
 	}
 }
 
+// errTxRolledBack 事务已经回滚, 不能再提交
+var errTxRolledBack = errors.New("transaction has been rolled back")
+
+// lockAndBuildUndoLog 给事务中的命令和观察的key上锁, 并生成回滚用的undo log
+func (tx *Transaction) lockAndBuildUndoLog() {
+	for _, cmdLine := range tx.cmdLines {
+		writeKeys, readKeys := database.GetRelatedKeys(cmdLine)
+		tx.writeKeys = append(tx.writeKeys, writeKeys...)
+		tx.readKeys = append(tx.readKeys, readKeys...)
+	}
+	for key := range tx.watching {
+		tx.readKeys = append(tx.readKeys, key)
+	}
+	tx.lockKeys()
+
+	// build undoLog, 回滚时按照命令相反的顺序执行
+	tx.undoLog = nil
+	for i := len(tx.cmdLines) - 1; i >= 0; i-- {
+		tx.undoLog = append(tx.undoLog, tx.cluster.db.GetUndoLogs(tx.dbIndex, tx.cmdLines[i])...)
+	}
+}
+
 // prepare 在做事务之前, 准备给要读写的键上锁
+//
+// 观察的key的版本号已经变化时释放锁并返回 errWatchChanged.
+// 准备好的事务写入事务日志之后才返回, 节点重启之后可以恢复
 func (tx *Transaction) prepare() error {
 	tx.mu.Lock()
 	defer tx.mu.Unlock()
 
-	tx.writeKeys, tx.readKeys = database.GetRelatedKeys(tx.cmdLine)
-	// lock writeKeys
-	tx.lockKeys()
-
-	// build undoLog
-	tx.undoLog = tx.cluster.db.GetUndoLogs(tx.dbIndex, tx.cmdLine)
-	tx.status = preparedStatus
-	taskKey := genTaskKey(tx.id)
-	timewheel.Delay(maxLockTime, taskKey, func() {
-		if tx.status == preparedStatus { // rollback transaction uncommitted until expire
-			logger.Info("abort transaction: " + tx.id)
-			tx.mu.Lock()
-			defer tx.mu.Unlock()
-			_ = tx.rollbackWithLock()
+	tx.lockAndBuildUndoLog()
+	for key, version := range tx.watching {
+		if tx.cluster.db.GetVersion(tx.dbIndex, key) != version {
+			tx.unLockKeys()
+			tx.status = rolledBackStatus
+			return errWatchChanged
 		}
-	})
+	}
+	if err := tx.cluster.txLog.prepare(tx); err != nil {
+		tx.unLockKeys()
+		tx.status = rolledBackStatus
+		return err
+	}
+	tx.status = preparedStatus
+	// 超过 maxLockTime 没有收到提交或回滚时向协调者查询结果
+	timewheel.Delay(maxLockTime, genTaskKey(tx.id), tx.resolve)
 	return nil
 }
 
 func (tx *Transaction) commit() (result resp.Reply, err resp.ErrorReply) {
 	tx.mu.Lock()
 	defer tx.mu.Unlock()
+	return tx.commitWithLock()
+}
 
-	result = tx.cluster.db.ExecWithoutLock(tx.conn, tx.cmdLine)
-
-	if reply.IsErrReply(result) {
-		// failed
-		err2 := tx.rollbackWithLock()
-		return nil, reply.NewErrReply(fmt.Sprintf("occurs when rollback: %v, origin err: %s", err2, result))
+// commitWithLock 提交事务, 已经提交的事务返回上次提交的结果
+// invoker should hold tx.mu
+func (tx *Transaction) commitWithLock() (result resp.Reply, err resp.ErrorReply) {
+	switch tx.status {
+	case committedStatus:
+		return tx.result, nil
+	case rolledBackStatus:
+		return nil, reply.NewErrReply(errTxRolledBack.Error())
+	}
+	timewheel.Cancel(genTaskKey(tx.id))
+
+	results := make([]resp.Reply, 0, len(tx.cmdLines))
+	for _, cmdLine := range tx.cmdLines {
+		result = tx.cluster.db.ExecWithoutLock(tx.conn, cmdLine)
+		if reply.IsErrReply(result) {
+			// failed, 前面的命令已经执行, 按照提交过的事务执行undo log
+			tx.status = committedStatus
+			err2 := tx.rollbackWithLock()
+			return nil, reply.NewErrReply(fmt.Sprintf("occurs when rollback: %v, origin err: %s", err2, result))
+		}
+		results = append(results, result)
+	}
+	if tx.multi {
+		result = reply.NewMultiRawReply(results)
 	}
 	// after committed
 	tx.unLockKeys()
 	tx.status = committedStatus
+	tx.result = result
+	if err := tx.cluster.txLog.finish(tx.id, true); err != nil {
+		logger.Error("write transaction log: " + err.Error())
+	}
 	// clean finished transaction
 	// do not clean immediately, in case rollback
+	tx.cleanLater()
+
+	return result, nil
+}
+
+// cleanLater 事务完成之后等待一段时间再删除, 期间可以回滚或者查询状态
+func (tx *Transaction) cleanLater() {
 	timewheel.Delay(waitBeforeCleanTx, "", func() {
 		tx.cluster.txMutex.Lock()
 		tx.cluster.transactions.Remove(tx.id)
 		tx.cluster.txMutex.Unlock()
 	})
+}
 
-	return result, nil
+// resolve 事务准备之后超过 maxLockTime 没有提交或回滚, 向协调者查询结果
+//
+// 协调者无法访问或者还没有决定时稍后再次查询, 一直持有锁, 保证参与者和协调者的决定一致
+func (tx *Transaction) resolve() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.status != preparedStatus {
+		return
+	}
+	switch tx.cluster.queryDecision(tx.id, tx.coordinator) {
+	case txOpCommit:
+		logger.Info("commit in-doubt transaction: " + tx.id)
+		if _, err := tx.commitWithLock(); err != nil {
+			logger.Error("commit in-doubt transaction " + tx.id + ": " + err.Error())
+		}
+	case txOpRollback:
+		logger.Info("abort transaction: " + tx.id)
+		_ = tx.rollbackWithLock()
+		tx.cleanLater()
+	default:
+		timewheel.Delay(maxLockTime, genTaskKey(tx.id), tx.resolve)
+	}
+}
+
+// statusName 返回事务的状态, 用于 TCC STATUS 和 TCC LIST
+func (tx *Transaction) statusName() string {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	switch tx.status {
+	case preparedStatus:
+		return "prepared"
+	case committedStatus:
+		return txOpCommitted
+	case rolledBackStatus:
+		return txOpRolledBack
+	}
+	return "created"
 }
 
 func (tx *Transaction) rollbackWithLock() error {
@@ -143,25 +252,32 @@ func (tx *Transaction) rollbackWithLock() error {
 	if tx.status == rolledBackStatus { // no need to rollback a rolled-back transaction
 		return nil
 	}
-	// 执行本地的undo log
+	timewheel.Cancel(genTaskKey(tx.id))
+	// 执行本地的undo log, 没有提交的事务没有修改数据, 不需要执行
 	tx.lockKeys()
-	for _, cmdLine := range tx.undoLog {
-		tx.cluster.db.ExecWithoutLock(tx.conn, cmdLine)
+	if tx.status == committedStatus {
+		for _, cmdLine := range tx.undoLog {
+			tx.cluster.db.ExecWithoutLock(tx.conn, cmdLine)
+		}
 	}
 	tx.unLockKeys()
 	tx.status = rolledBackStatus
+	if err := tx.cluster.txLog.finish(tx.id, false); err != nil {
+		logger.Error("write transaction log: " + err.Error())
+	}
 	return nil
 }
 
-// cmdLine: Prepare id cmdName args...
+// cmdLine: Prepare id coordinator cmdName args...
 func execPrepare(cluster *ClusterDatabase, conn resp.Connection, cmdLine db.CmdLine) resp.Reply {
-	if len(cmdLine) < 3 { // args >= 3
+	if len(cmdLine) < 4 { // args >= 4
 		return reply.NewArgNumErrReply(enum.TCC_PREPARE.String())
 	}
 	// 1. 取出参数
 	txID := utils.Bytes2String(cmdLine[1])
-	cmdName := strings.ToUpper(utils.Bytes2String(cmdLine[2]))
-	tx := NewTransaction(cluster, conn, txID, cmdLine[2:])
+	cmdName := strings.ToUpper(utils.Bytes2String(cmdLine[3]))
+	tx := NewTransaction(cluster, conn, txID, cmdLine[3:])
+	tx.coordinator = string(cmdLine[2])
 	// 2. 设置事务id
 	cluster.txMutex.Lock()
 	cluster.transactions.Set(txID, tx)
@@ -174,7 +290,7 @@ func execPrepare(cluster *ClusterDatabase, conn resp.Connection, cmdLine db.CmdL
 	// 4. 可能有除了上锁之外的逻辑处理
 	prepareFunc, ok := prepareFuncMap[cmdName]
 	if ok {
-		return prepareFunc(cluster, conn, cmdLine[2:])
+		return prepareFunc(cluster, conn, cmdLine[3:])
 	}
 	return reply.NewOKReply()
 }
@@ -200,11 +316,7 @@ func execRollback(cluster *ClusterDatabase, _ resp.Connection, cmdLine db.CmdLin
 		return &reply.NormalErrReply{Status: err.Error()}
 	}
 	// clean transaction
-	timewheel.Delay(waitBeforeCleanTx, "", func() {
-		cluster.txMutex.Lock()
-		cluster.transactions.Remove(tx.id)
-		cluster.txMutex.Unlock()
-	})
+	tx.cleanLater()
 	return reply.NewIntReply(1)
 }
 
@@ -249,34 +361,43 @@ func execCommit(cluster *ClusterDatabase, _ resp.Connection, cmdLine db.CmdLine)
 	return result
 }
 
-// requestCommit 给事务相关的节点发送commit指令, 返回各节点的回复, 解除相关的读写锁
+// requestCommit 并行地给事务相关的节点发送commit指令, 按照节点地址的顺序返回各节点的回复, 解除相关的读写锁
+//
+// 提交的决定在发送commit之前写入事务日志, 协调者重启之后重新通知没有完成的节点.
+// 参与者已经查询过结果的事务被决定回滚, 不能再提交
 func requestCommit(
 	cluster *ClusterDatabase,
 	conn resp.Connection,
 	txID int64,
 	groupMap map[string][]string) ([]resp.Reply, resp.ErrorReply) {
 
-	var errReply resp.ErrorReply
 	txIDStr := strconv.FormatInt(txID, 10)
-	respList := make([]resp.Reply, 0, len(groupMap))
+	if err := cluster.txLog.decide(txIDStr, true, nodesOf(groupMap)); err != nil {
+		requestRollback(cluster, conn, txID, groupMap)
+		if errors.Is(err, errTxRolledBack) {
+			// 有参与者等待超时, 查询之后已经回滚了
+			return nil, reply.NewErrReply(err.Error())
+		}
+		return nil, reply.NewErrReply("write transaction log: " + err.Error())
+	}
 	cmd := utils.ToCmdLine(enum.TCC_COMMIT.String(), txIDStr)
-
-	for node := range groupMap {
+	// 并行通知所有参与者提交
+	nodes := nodesOf(groupMap)
+	replies := cluster.fanOut(nodes, func(node string) resp.Reply {
 		if node == cluster.self {
-			execCommit(cluster, conn, cmd)
-			continue
-		}
-		r := cluster.relay(node, conn, cmd)
-		if reply.IsErrReply(r) {
-			errReply = r.(resp.ErrorReply)
-			break
+			return execCommit(cluster, conn, cmd)
 		}
-		respList = append(respList, r)
-	}
-	if errReply != nil {
+		return cluster.relay(node, conn, cmd)
+	})
+	if r := fanOutError(replies); r != nil {
 		requestRollback(cluster, conn, txID, groupMap)
-		return nil, errReply
+		return nil, r.(resp.ErrorReply)
 	}
+	respList := make([]resp.Reply, 0, len(nodes))
+	for _, node := range nodes {
+		respList = append(respList, replies[node])
+	}
+	endTransaction(cluster, txIDStr)
 	return respList, nil
 }
 
@@ -284,13 +405,40 @@ func requestCommit(
 // groupMap: node -> keys
 func requestRollback(cluster *ClusterDatabase, conn resp.Connection, txID int64, groupMap map[string][]string) {
 	txIDStr := strconv.FormatInt(txID, 10)
+	// 没有决定的事务按照回滚处理, 写入失败时参与者查询到的结果也是回滚
+	if err := cluster.txLog.decide(txIDStr, false, nodesOf(groupMap)); err != nil {
+		logger.Error("write transaction log: " + err.Error())
+	}
 	cmd := utils.ToCmdLine(enum.TCC_ROLLBACK.String(), txIDStr)
 
-	for node := range groupMap {
+	cluster.fanOut(nodesOf(groupMap), func(node string) resp.Reply {
 		if node == cluster.self {
-			execRollback(cluster, conn, cmd)
-			continue
+			return execRollback(cluster, conn, cmd)
 		}
-		cluster.relay(node, conn, cmd)
+		return cluster.relay(node, conn, cmd)
+	})
+	endTransaction(cluster, txIDStr)
+}
+
+// endTransaction 记录所有参与者都已经完成了协调者的决定
+func endTransaction(cluster *ClusterDatabase, txID string) {
+	if err := cluster.txLog.end(txID); err != nil {
+		logger.Error("write transaction log: " + err.Error())
+	}
+}
+
+// nodesOf 返回参与事务的节点, 按照地址排序
+func nodesOf(groupMap map[string][]string) []string {
+	nodes := make([]string, 0, len(groupMap))
+	for node := range groupMap {
+		nodes = append(nodes, node)
 	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func init() {
+	registerRouter(enum.TCC_PREPARE, execPrepare)
+	registerRouter(enum.TCC_COMMIT, execCommit)
+	registerRouter(enum.TCC_ROLLBACK, execRollback)
 }
@@ -36,7 +36,7 @@ func TestRollback(t *testing.T) {
 	groupMap := map[string][]string{
 		testNodeA.self: keys,
 	}
-	args := []string{txIDStr, "DEL"}
+	args := []string{txIDStr, testNodeA.self, "DEL"}
 	args = append(args, keys...)
 	testNodeA.db.Exec(conn, utils.ToCmdLine("SET", "a", "a"))
 	ret := execPrepare(testNodeA, conn, makeArgs("Prepare", args...))
@@ -50,7 +50,7 @@ func TestRollback(t *testing.T) {
 	testNodeA.db.Exec(conn, utils.ToCmdLine("SET", "a", "a"))
 	txID = rand.Int63()
 	txIDStr = strconv.FormatInt(txID, 10)
-	args = []string{txIDStr, "DEL"}
+	args = []string{txIDStr, testNodeA.self, "DEL"}
 	args = append(args, keys...)
 	ret = execPrepare(testNodeA, conn, makeArgs("Prepare", args...))
 	asserts.AssertNotError(t, ret)
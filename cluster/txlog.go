@@ -0,0 +1,290 @@
+package cluster_database
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"go-redis/interface/db"
+)
+
+// 事务日志中记录的操作
+const (
+	// 协调者: 提交或回滚的决定在通知参与者之前写入, 所有参与者都完成之后写入 end
+	txOpCommit   = "commit"
+	txOpRollback = "rollback"
+	txOpEnd      = "end"
+	// 参与者: 上锁之后写入 prepare, 提交或回滚之后写入结果
+	txOpPrepare    = "prepare"
+	txOpCommitted  = "committed"
+	txOpRolledBack = "rolledback"
+)
+
+// compactThreshold 完成的事务超过这个数量时重写日志文件, 只保留未完成的事务
+const compactThreshold = 1024
+
+// txRecord 是事务日志中的一条记录, 每条记录是一行JSON
+type txRecord struct {
+	Op          string       `json:"op"`
+	ID          string       `json:"id"`
+	Nodes       []string     `json:"nodes,omitempty"`       // 协调者: 参与事务的节点
+	Coordinator string       `json:"coordinator,omitempty"` // 参与者: 协调者的地址, 重启之后向它查询事务的结果
+	DB          int          `json:"db,omitempty"`          // 参与者: 事务所在的数据库
+	Cmds        []db.CmdLine `json:"cmds,omitempty"`        // 参与者: 事务在本节点上执行的命令
+	Multi       bool         `json:"multi,omitempty"`       // 参与者: 是否是 MULTI/EXEC 的事务
+}
+
+// txLog 是分布式事务的预写日志
+//
+// 协调者在通知参与者提交之前写入决定, 重启之后重新通知没有完成的决定;
+// 参与者在回复 PREPARE 之前写入准备好的命令, 重启之后重新上锁并向协调者查询事务的结果.
+// path 为空时日志只保存在内存中, 节点重启之后丢失
+type txLog struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	decisions map[string]*txRecord // 协调者没有完成的决定
+	prepared  map[string]*txRecord // 参与者没有完成的事务
+	finished  int                  // 上次重写之后完成的事务数量
+}
+
+// openTxLog 读取日志文件中未完成的事务, 并重写日志文件
+func openTxLog(path string) (*txLog, error) {
+	log := &txLog{
+		path:      path,
+		decisions: make(map[string]*txRecord),
+		prepared:  make(map[string]*txRecord),
+	}
+	if path == "" {
+		return log, nil
+	}
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1<<30)
+		for scanner.Scan() {
+			record := &txRecord{}
+			// 崩溃时没有写完的最后一行无法解析, 这条记录没有生效
+			if json.Unmarshal(scanner.Bytes(), record) != nil {
+				break
+			}
+			log.apply(record)
+		}
+		_ = file.Close()
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err = log.rewriteLocked(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// apply 根据记录修改未完成的事务
+func (log *txLog) apply(record *txRecord) {
+	switch record.Op {
+	case txOpCommit, txOpRollback:
+		log.decisions[record.ID] = record
+	case txOpEnd:
+		delete(log.decisions, record.ID)
+	case txOpPrepare:
+		log.prepared[record.ID] = record
+	case txOpCommitted, txOpRolledBack:
+		delete(log.prepared, record.ID)
+	}
+}
+
+// append 写入一条记录, 返回之前记录已经同步到磁盘
+func (log *txLog) append(record *txRecord) error {
+	if log == nil {
+		return nil
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.appendLocked(record)
+}
+
+// appendLocked 写入一条记录, 调用者需要持有 log.mu
+//
+// 记录同步到磁盘之后才修改内存中的事务, 写入失败时内存和磁盘保持一致
+func (log *txLog) appendLocked(record *txRecord) error {
+	if log.file == nil {
+		log.apply(record)
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = log.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err = log.file.Sync(); err != nil {
+		return err
+	}
+	log.apply(record)
+	if record.Op == txOpEnd || record.Op == txOpCommitted || record.Op == txOpRolledBack {
+		log.finished++
+		if log.finished >= compactThreshold {
+			return log.rewriteLocked()
+		}
+	}
+	return nil
+}
+
+// rewriteLocked 把未完成的事务写入新的日志文件, 替换原来的文件
+func (log *txLog) rewriteLocked() error {
+	if log.path == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(log.path), filepath.Base(log.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	writer := bufio.NewWriter(tmp)
+	for _, records := range []map[string]*txRecord{log.decisions, log.prepared} {
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				_ = tmp.Close()
+				return err
+			}
+			_, _ = writer.Write(append(data, '\n'))
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), log.path); err != nil {
+		return err
+	}
+	if log.file != nil {
+		_ = log.file.Close()
+	}
+	log.file, err = os.OpenFile(log.path, os.O_WRONLY|os.O_APPEND, 0600)
+	log.finished = 0
+	return err
+}
+
+// decide 记录协调者提交或回滚的决定, 之后的决定覆盖之前的决定
+//
+// 已经决定回滚的事务不能再提交, 返回 errTxRolledBack: 参与者可能已经查询到回滚的结果并且回滚了
+func (log *txLog) decide(txID string, commit bool, nodes []string) error {
+	if log == nil {
+		return nil
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	op := txOpRollback
+	if commit {
+		if record, ok := log.decisions[txID]; ok && record.Op == txOpRollback {
+			return errTxRolledBack
+		}
+		op = txOpCommit
+	}
+	return log.appendLocked(&txRecord{Op: op, ID: txID, Nodes: nodes})
+}
+
+// presumeRollback 返回协调者的决定, 还没有决定时写入回滚的决定, 之后协调者不能再提交这个事务
+//
+// 写入的决定没有参与的节点, 协调者回滚事务或者重启之后删除
+func (log *txLog) presumeRollback(txID string) (string, error) {
+	if log == nil {
+		return txOpRollback, nil
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if record, ok := log.decisions[txID]; ok {
+		return record.Op, nil
+	}
+	return txOpRollback, log.appendLocked(&txRecord{Op: txOpRollback, ID: txID})
+}
+
+// end 记录所有参与者都已经完成了协调者的决定
+func (log *txLog) end(txID string) error {
+	if _, ok := log.decision(txID); !ok {
+		return nil
+	}
+	return log.append(&txRecord{Op: txOpEnd, ID: txID})
+}
+
+// decision 返回协调者没有完成的决定
+func (log *txLog) decision(txID string) (*txRecord, bool) {
+	if log == nil {
+		return nil, false
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	record, ok := log.decisions[txID]
+	return record, ok
+}
+
+// prepare 记录参与者准备好的事务
+func (log *txLog) prepare(tx *Transaction) error {
+	return log.append(&txRecord{Op: txOpPrepare, ID: tx.id, Coordinator: tx.coordinator,
+		DB: tx.dbIndex, Cmds: tx.cmdLines, Multi: tx.multi})
+}
+
+// finish 记录参与者提交或回滚了事务, 没有准备过的事务不需要记录
+func (log *txLog) finish(txID string, committed bool) error {
+	if log == nil {
+		return nil
+	}
+	log.mu.Lock()
+	_, ok := log.prepared[txID]
+	log.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	op := txOpRolledBack
+	if committed {
+		op = txOpCommitted
+	}
+	return log.append(&txRecord{Op: op, ID: txID})
+}
+
+// pending 返回没有完成的决定和准备好的事务, 按照事务id排序
+func (log *txLog) pending() (decisions, prepared []*txRecord) {
+	if log == nil {
+		return nil, nil
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	for _, record := range log.decisions {
+		decisions = append(decisions, record)
+	}
+	for _, record := range log.prepared {
+		prepared = append(prepared, record)
+	}
+	sort.Slice(decisions, func(i, j int) bool { return decisions[i].ID < decisions[j].ID })
+	sort.Slice(prepared, func(i, j int) bool { return prepared[i].ID < prepared[j].ID })
+	return decisions, prepared
+}
+
+func (log *txLog) close() {
+	if log == nil {
+		return
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.file != nil {
+		_ = log.file.Close()
+		log.file = nil
+	}
+}
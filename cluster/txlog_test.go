@@ -0,0 +1,246 @@
+package cluster_database
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go-redis/config"
+	"go-redis/datastruct/dict"
+	"go-redis/enum"
+	"go-redis/interface/resp"
+	"go-redis/lib/asserts"
+	"go-redis/lib/id_generator"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+func TestTxLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tx.log")
+	log, err := openTxLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := NewTransaction(testNodeA, connection.NewFakeConn(), "1", utils.ToCmdLine("SET", "a", "1"))
+	_ = log.decide("1", true, []string{"127.0.0.1:7000", "127.0.0.1:7001"})
+	_ = log.prepare(tx)
+	_ = log.decide("2", false, []string{"127.0.0.1:7000"})
+	_ = log.end("2")
+	log.close()
+
+	// 重启之后恢复没有完成的事务, 没有写完的最后一行被忽略
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	_, _ = file.WriteString(`{"op":"end","id":"1"`)
+	_ = file.Close()
+	log, err = openTxLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decisions, prepared := log.pending()
+	if len(decisions) != 1 || decisions[0].Op != txOpCommit || len(decisions[0].Nodes) != 2 {
+		t.Fatalf("unexpected decisions %+v", decisions)
+	}
+	if len(prepared) != 1 || utils.CmdLine2String(prepared[0].Cmds[0]) != "SET a 1" {
+		t.Fatalf("unexpected prepared %+v", prepared)
+	}
+	// 写入失败时不修改内存中的事务
+	file = log.file
+	_ = file.Close()
+	if err = log.finish("1", true); err == nil {
+		t.Fatal("expected write error")
+	}
+	if _, prepared = log.pending(); len(prepared) != 1 {
+		t.Fatalf("failed record should not be applied, got %+v", prepared)
+	}
+	log.file, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	_ = log.end("1")
+	_ = log.finish("1", true)
+	log.close()
+
+	log, _ = openTxLog(path)
+	defer log.close()
+	if decisions, prepared = log.pending(); len(decisions)+len(prepared) != 0 {
+		t.Fatalf("finished transactions should be removed, got %+v %+v", decisions, prepared)
+	}
+}
+
+// newRecoveryTestNode 创建本节点和 peers 组成的集群, 节点重启时使用同一个事务日志文件
+func newRecoveryTestNode(t *testing.T, self, path string, peers ...string) *ClusterDatabase {
+	node := newGossipTestNode(self, append([]string{self}, peers...)...)
+	node.idGenerator = id_generator.NewGenerator(self)
+	node.transactions = dict.NewNormalDict()
+	log, err := openTxLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.txLog = log
+	t.Cleanup(log.close)
+	return node
+}
+
+func TestTransactionRecovery(t *testing.T) {
+	self := "127.0.0.1:7200"
+	path := filepath.Join(t.TempDir(), "tx.log")
+	conn := connection.NewFakeConn()
+	testNodeA.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	testNodeA.db.Exec(conn, utils.ToCmdLine("DEL", "recover:a", "recover:b"))
+
+	// 另一个协调者已经决定提交 recover:c, 事务id由本节点生成, 只能根据 PREPARE 中的地址找到协调者
+	var remoteID string
+	coordinator := newFakePeer(t, func(args []string) resp.Reply {
+		if len(args) == 3 && strings.ToUpper(args[1]) == enum.TCC_STATUS && args[2] == remoteID {
+			return reply.NewBulkReply([]byte(txOpCommit))
+		}
+		return reply.NewBulkReply([]byte("unknown"))
+	})
+	testNodeA.db.Exec(conn, utils.ToCmdLine("DEL", "recover:c"))
+
+	// 参与者准备好之后崩溃, 协调者已经决定提交 recover:a, 没有决定 recover:b
+	node := newRecoveryTestNode(t, self, path, coordinator)
+	commitID := strconv.FormatInt(node.idGenerator.NextID(), 10)
+	abortID := strconv.FormatInt(node.idGenerator.NextID(), 10)
+	remoteID = strconv.FormatInt(node.idGenerator.NextID(), 10)
+	asserts.AssertStatusReply(t, execPrepare(node, conn, utils.ToCmdLine("PREPARE", commitID, self, "SET", "recover:a", "1")), "OK")
+	asserts.AssertStatusReply(t, execPrepare(node, conn, utils.ToCmdLine("PREPARE", abortID, self, "SET", "recover:b", "1")), "OK")
+	asserts.AssertStatusReply(t, execPrepare(node, conn, utils.ToCmdLine("PREPARE", remoteID, coordinator, "SET", "recover:c", "1")), "OK")
+	// 准备的记录中保存协调者的地址
+	_, prepared := node.txLog.pending()
+	coordinators := make(map[string]string, len(prepared))
+	for _, record := range prepared {
+		coordinators[record.ID] = record.Coordinator
+	}
+	if len(prepared) != 3 || coordinators[commitID] != self || coordinators[remoteID] != coordinator {
+		t.Fatalf("unexpected prepared transactions %v", coordinators)
+	}
+	_ = node.txLog.decide(commitID, true, []string{self})
+	asserts.AssertBulkReply(t, execTcc(node, conn, utils.ToCmdLine("TCC", "STATUS", commitID)), txOpCommit)
+	asserts.AssertBulkReply(t, execTcc(node, conn, utils.ToCmdLine("TCC", "STATUS", abortID)), "prepared")
+	if list, ok := execTcc(node, conn, utils.ToCmdLine("TCC", "LIST")).(*reply.MultiRawReply); !ok || len(list.Replies) != 4 {
+		t.Fatalf("expected 4 transactions, got %v", list)
+	}
+	// 进程退出时释放锁
+	for _, id := range []string{commitID, abortID, remoteID} {
+		raw, _ := node.transactions.Get(id)
+		tx := raw.(*Transaction)
+		tx.mu.Lock()
+		tx.unLockKeys()
+		tx.status = rolledBackStatus
+		tx.mu.Unlock()
+	}
+	node.txLog.close()
+
+	// 重启之后提交已经决定的事务, 回滚没有决定的事务, 回滚的决定保留到下次重启, 防止协调者再提交
+	restarted := newRecoveryTestNode(t, self, path, coordinator)
+	restarted.recoverTransactions()
+	waitFor(t, "transactions recovered", func() bool {
+		decisions, prepared := restarted.txLog.pending()
+		return len(prepared) == 0 && len(decisions) == 1 &&
+			decisions[0].ID == abortID && decisions[0].Op == txOpRollback && len(decisions[0].Nodes) == 0
+	})
+	asserts.AssertBulkReply(t, testNodeA.db.Exec(conn, utils.ToCmdLine("GET", "recover:a")), "1")
+	asserts.AssertNullBulk(t, testNodeA.db.Exec(conn, utils.ToCmdLine("GET", "recover:b")))
+	asserts.AssertBulkReply(t, testNodeA.db.Exec(conn, utils.ToCmdLine("GET", "recover:c")), "1")
+	asserts.AssertBulkReply(t, execTcc(restarted, conn, utils.ToCmdLine("TCC", "STATUS", commitID)), txOpCommitted)
+	asserts.AssertBulkReply(t, execTcc(restarted, conn, utils.ToCmdLine("TCC", "STATUS", abortID)), txOpRollback)
+	asserts.AssertBulkReply(t, execTcc(restarted, conn, utils.ToCmdLine("TCC", "STATUS", "0")), txOpRollback)
+
+	// 重复提交返回上次的结果, 回滚之后不能提交
+	asserts.AssertStatusReply(t, execCommit(restarted, conn, utils.ToCmdLine("COMMIT", commitID)), "OK")
+	asserts.AssertErrReply(t, execCommit(restarted, conn, utils.ToCmdLine("COMMIT", abortID)), "ERR "+errTxRolledBack.Error())
+	testNodeA.db.Exec(conn, utils.ToCmdLine("DEL", "recover:a", "recover:c"))
+}
+
+func TestResolveBeforeDecision(t *testing.T) {
+	self := "127.0.0.1:7500"
+	node := newRecoveryTestNode(t, self, filepath.Join(t.TempDir(), "tx.log"))
+	conn := connection.NewFakeConn()
+	node.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	node.db.Exec(conn, utils.ToCmdLine("DEL", "resolve:a"))
+	groupMap := map[string][]string{self: {"resolve:a"}}
+
+	// 参与者等待超时的时候协调者还没有决定, 参与者回滚之后协调者不能再提交
+	txID := node.idGenerator.NextID()
+	txIDStr := strconv.FormatInt(txID, 10)
+	asserts.AssertStatusReply(t, execPrepare(node, conn, utils.ToCmdLine("PREPARE", txIDStr, self, "SET", "resolve:a", "1")), "OK")
+	raw, _ := node.transactions.Get(txIDStr)
+	raw.(*Transaction).resolve()
+	asserts.AssertBulkReply(t, execTcc(node, conn, utils.ToCmdLine("TCC", "STATUS", txIDStr)), txOpRollback)
+	if _, errReply := requestCommit(node, conn, txID, groupMap); errReply == nil || errReply.Error() != "ERR "+errTxRolledBack.Error() {
+		t.Fatalf("expected rolled back error, got %v", errReply)
+	}
+	asserts.AssertNullBulk(t, node.db.Exec(conn, utils.ToCmdLine("GET", "resolve:a")))
+	if decisions, prepared := node.txLog.pending(); len(decisions)+len(prepared) != 0 {
+		t.Fatalf("unexpected pending transactions %+v %+v", decisions, prepared)
+	}
+
+	// 其他参与者查询之后协调者也不能再提交
+	txID = node.idGenerator.NextID()
+	txIDStr = strconv.FormatInt(txID, 10)
+	asserts.AssertBulkReply(t, execTcc(node, conn, utils.ToCmdLine("TCC", "STATUS", txIDStr)), txOpRollback)
+	if err := node.txLog.decide(txIDStr, true, []string{self}); err != errTxRolledBack {
+		t.Fatalf("expected %v, got %v", errTxRolledBack, err)
+	}
+
+	// 没有参与者的回滚决定在重启之后删除
+	node.txLog.close()
+	restarted := newRecoveryTestNode(t, self, node.txLog.path)
+	restarted.recoverTransactions()
+	waitFor(t, "presumed rollback removed", func() bool {
+		decisions, _ := restarted.txLog.pending()
+		return len(decisions) == 0
+	})
+}
+
+func TestMultiTransactionLog(t *testing.T) {
+	// 另一个节点提交时协调者已经写入了提交的决定
+	var node *ClusterDatabase
+	decided := make(chan bool, 1)
+	peer := newFakePeer(t, func(args []string) resp.Reply {
+		switch strings.ToUpper(args[0]) {
+		case enum.TCC_PREPARE_MULTI.String():
+			// 参与者从参数中得到协调者的地址
+			if args[2] != node.self {
+				return reply.NewErrReply("unexpected coordinator " + args[2])
+			}
+		case enum.TCC_COMMIT.String():
+			record, ok := node.txLog.decision(args[1])
+			decided <- ok && record.Op == txOpCommit && len(record.Nodes) == 2
+			return reply.NewMultiRawReply([]resp.Reply{reply.NewOKReply()})
+		}
+		return reply.NewOKReply()
+	})
+	self := "127.0.0.1:7400"
+	node = newRecoveryTestNode(t, self, filepath.Join(t.TempDir(), "tx.log"), peer)
+	conn := connection.NewFakeConn()
+	node.Exec(conn, utils.ToCmdLine("auth", config.RequirePass()))
+	var selfKey, peerKey string
+	for i := 0; selfKey == "" || peerKey == ""; i++ {
+		key := "multi:log:" + strconv.Itoa(i)
+		if node.slots.pick(key) == self {
+			selfKey = key
+		} else {
+			peerKey = key
+		}
+	}
+	node.db.Exec(conn, utils.ToCmdLine("DEL", selfKey))
+
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("MULTI")), "OK")
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("SET", peerKey, "1")), "QUEUED")
+	asserts.AssertStatusReply(t, node.Exec(conn, utils.ToCmdLine("SET", selfKey, "1")), "QUEUED")
+	r, ok := node.Exec(conn, utils.ToCmdLine("EXEC")).(*reply.MultiRawReply)
+	if !ok || len(r.Replies) != 2 {
+		t.Fatalf("unexpected exec reply %v", r)
+	}
+	if !<-decided {
+		t.Fatal("commit decision should be logged before participants commit")
+	}
+	// 所有参与者都提交之后决定被删除
+	if decisions, prepared := node.txLog.pending(); len(decisions)+len(prepared) != 0 {
+		t.Fatalf("unexpected pending transactions %+v %+v", decisions, prepared)
+	}
+	asserts.AssertBulkReply(t, node.db.Exec(conn, utils.ToCmdLine("GET", selfKey)), "1")
+	node.db.Exec(conn, utils.ToCmdLine("DEL", selfKey))
+}
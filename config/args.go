@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Usage 命令行参数的说明
+const Usage = `Usage: go-redis [/path/to/redis.conf] [--name value ...] [--test-config]
+
+Examples:
+  go-redis                                  (read redis.conf in the working directory if it exists)
+  go-redis /etc/redis/node1.conf
+  go-redis node1.conf --port 7000 --peers 127.0.0.1:7001,127.0.0.1:7002
+  go-redis --port 7000 --append-only yes
+  go-redis node1.conf --test-config         (check the configuration and exit)
+
+Options given on the command line override the values in the config file.
+`
+
+// Options 命令行参数
+type Options struct {
+	ConfigFile string   // 配置文件的路径, 为空时读取工作目录下存在的 redis.conf
+	Overrides  []string // 命令行中的配置, 名称和值交替排列, 覆盖配置文件中的值
+	TestConfig bool     // 只检查配置, 不启动服务器
+	Help       bool     // 打印使用说明
+}
+
+// ParseArgs 解析命令行参数, args 不包括程序名
+//
+// 第一个参数不以 -- 开头时是配置文件的路径, 之后的参数都是 --name value 的形式
+func ParseArgs(args []string) (*Options, error) {
+	opts := &Options{}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		opts.ConfigFile = args[0]
+		args = args[1:]
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "--test-config":
+			opts.TestConfig = true
+			continue
+		case "-h", "--help":
+			opts.Help = true
+			continue
+		}
+		name, ok := strings.CutPrefix(arg, "--")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("unexpected argument '%s', options must be given as --name value", arg)
+		}
+		if i+1 >= len(args) || strings.HasPrefix(args[i+1], "--") {
+			return nil, fmt.Errorf("option '%s' requires a value", arg)
+		}
+		opts.Overrides = append(opts.Overrides, name, args[i+1])
+		i++
+	}
+	return opts, nil
+}
+
+// Load 按照命令行参数加载配置: 先读取配置文件, 再使用命令行中的配置覆盖
+//
+// 指定的配置文件不存在, 配置文件或者命令行中的配置不合法时返回错误
+func Load(opts *Options) error {
+	// 1. 读取配置文件
+	filename := opts.ConfigFile
+	if filename == "" && fileExists(defaultConfigFile) {
+		filename = defaultConfigFile
+	}
+	if filename != "" {
+		if err := SetupConfig(filename); err != nil {
+			return err
+		}
+	}
+	// 2. 命令行中的配置覆盖配置文件
+	for i := 0; i < len(opts.Overrides); i += 2 {
+		name, value := opts.Overrides[i], opts.Overrides[i+1]
+		if err := setParam(name, value); err != nil {
+			return fmt.Errorf("command line option '--%s %s': %w", name, value, err)
+		}
+	}
+	return nil
+}
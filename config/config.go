@@ -2,6 +2,7 @@ package config
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,7 +11,8 @@ import (
 	"strings"
 )
 
-const configFile = "redis.conf"
+// defaultConfigFile 没有指定配置文件时读取工作目录下的 redis.conf
+const defaultConfigFile = "redis.conf"
 
 func fileExists(filename string) bool {
 	stat, err := os.Stat(filename)
@@ -18,21 +20,54 @@ func fileExists(filename string) bool {
 }
 
 // serverProperties 是服务器的配置, 可以通过配置文件设置
+//
+// 可以通过 CONFIG SET 修改的配置在运行时需要通过 MaxClients 等函数读取, 其他配置只在启动时修改, 可以直接读取
 type serverProperties struct {
-	Bind                string `cfg:"bind"`                   // 绑定的ip, 默认127.0.0.1
-	Port                int    `cfg:"port"`                   // 端口, 默认6379
-	AppendOnly          bool   `cfg:"append-only"`            // 是否启动aof, 默认不启动
-	AppendFilename      string `cfg:"append-filename"`        // aof文件名
-	MaxClients          int    `cfg:"max-clients"`            // 最大客户端数
-	RequirePass         string `cfg:"require-pass"`           // 是否需要密码
-	Databases           int    `cfg:"databases"`              // 数据库量, 默认16
-	Cycle               int    `cfg:"cycle"`                  // 清理过期数据的周期, 单位是s, 默认1s
-	Buckets             int    `cfg:"buckets"`                // 放数据的桶的数量, 默认65536
-	ListMaxShardSize    int    `cfg:"list-max-shard-size"`    // quicklist中每一个分片所存储的数据最大容量, 默认512
-	SetMaxIntSetEntries int    `cfg:"set-max-intset-entries"` // intset中可以存储的最大元素个数, 默认为512
+	Bind                 string `cfg:"bind"`                   // 绑定的ip, 默认127.0.0.1
+	Port                 int    `cfg:"port"`                   // 端口, 默认6379
+	AppendOnly           bool   `cfg:"append-only"`            // 是否启动aof, 默认不启动
+	AppendFilename       string `cfg:"append-filename"`        // aof文件名
+	DbFilename           string `cfg:"dbfilename"`             // 快照文件名, SAVE 写入这个文件, 没有开启aof时启动时从这个文件加载, 为空时不使用快照
+	MaxClients           int    `cfg:"max-clients"`            // 最大客户端数, 小于等于0时不限制
+	MaxClientsPerIP      int    `cfg:"max-clients-per-ip"`     // 每个IP的最大客户端数, 小于等于0时不限制, 不限制unix socket
+	Timeout              int    `cfg:"timeout"`                // 客户端空闲多少秒之后断开连接, 为0时不断开, 订阅状态的客户端不会断开
+	TcpKeepAlive         int    `cfg:"tcp-keepalive"`          // TCP keepalive的间隔, 单位是s, 为0时关闭, 默认300s
+	RequirePass          string `cfg:"require-pass"`           // 默认用户的密码, 为空时默认用户不需要密码
+	AclFile              string `cfg:"aclfile"`                // 保存ACL用户的文件, 为空时不使用文件
+	AclLogMaxLen         int    `cfg:"acllog-max-len"`         // ACL LOG 保存的最大条数, 默认128
+	Databases            int    `cfg:"databases"`              // 数据库量, 默认16
+	Cycle                int    `cfg:"cycle"`                  // 清理过期数据的周期, 单位是s, 默认1s
+	Buckets              int    `cfg:"buckets"`                // 放数据的桶的数量, 默认65536
+	ListMaxShardSize     int    `cfg:"list-max-shard-size"`    // quicklist中每一个分片所存储的数据最大容量, 默认512
+	SetMaxIntSetEntries  int    `cfg:"set-max-intset-entries"` // intset中可以存储的最大元素个数, 默认为512
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"` // 键空间通知的事件类型, 默认为空即不发送通知
+	LogLevel             string `cfg:"loglevel"`               // 日志级别: debug, info, warning, error, 为空时Dev模式是debug, 否则是info
+	ProtoMaxBulkLen      int    `cfg:"proto-max-bulk-len"`     // 协议中单个字符串的最大长度, 默认512MB
+	// 慢查询和延迟监控
+	SlowlogLogSlowerThan    int `cfg:"slowlog-log-slower-than"`   // 执行时间超过多少微秒的命令记录到慢查询日志, 小于0时不记录, 默认10000
+	SlowlogMaxLen           int `cfg:"slowlog-max-len"`           // 慢查询日志的最大条数, 默认128
+	LatencyMonitorThreshold int `cfg:"latency-monitor-threshold"` // 延迟超过多少毫秒的事件记录到延迟监控, 为0时关闭, 默认0
+	// 客户端输出缓冲区的限制, 格式为 <class> <hard limit> <soft limit> <soft seconds>, class是normal, replica或pubsub
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"`
+	// unix socket
+	UnixSocket     string `cfg:"unixsocket"`     // unix socket的路径, 为空时不监听unix socket
+	UnixSocketPerm string `cfg:"unixsocketperm"` // unix socket文件的权限, 八进制, 例如700
+	// tls
+	TlsPort        int    `cfg:"tls-port"`         // TLS端口, 为0时不监听TLS连接
+	TlsCertFile    string `cfg:"tls-cert-file"`    // 服务端证书, 同时作为连接集群节点时的客户端证书
+	TlsKeyFile     string `cfg:"tls-key-file"`     // 证书的私钥
+	TlsCaCertFile  string `cfg:"tls-ca-cert-file"` // 用于验证对方证书的CA证书
+	TlsAuthClients string `cfg:"tls-auth-clients"` // 是否验证客户端证书: yes, no, optional, 默认yes
+	TlsCluster     bool   `cfg:"tls-cluster"`      // 集群节点之间是否使用TLS连接, 默认不使用
 	// cluster
-	Peers []string `cfg:"peers"` // 所有集群节点的地址
-	Self  string   `cfg:"self"`  // 本身的地址
+	Peers                 []string `cfg:"peers"`                   // 启动时已知的集群节点的地址, 开启tls-cluster时是节点的TLS地址
+	Self                  string   `cfg:"self"`                    // 本身的地址, 为空时使用 bind:port
+	ClusterEnabled        bool     `cfg:"cluster-enabled"`         // 没有配置 peers 时也以集群模式启动, 之后通过 CLUSTER MEET 加入集群
+	ClusterNodeTimeout    int      `cfg:"cluster-node-timeout"`    // 节点超过多少毫秒没有回复 PING 时标记为疑似下线, 默认15000
+	ClusterConfigFile     string   `cfg:"cluster-config-file"`     // 保存集群元数据的Raft日志和快照的文件, 为空时不持久化
+	ClusterRedirect       bool     `cfg:"cluster-redirect"`        // 不负责的key回复 MOVED 或 ASK 而不是转发给负责的节点, 只影响之后建立的连接
+	ClusterTxLogFile      string   `cfg:"cluster-tx-log-file"`     // 保存分布式事务的提交决定和准备状态的日志文件, 为空时不持久化
+	ClusterRequestTimeout int      `cfg:"cluster-request-timeout"` // 并行发送给多个节点的命令等待每个节点回复的毫秒数, 默认5000
 	// dev
 	Dev bool // 是否在测试状态, 如果在测试会开启Debug输出, 否则关闭Debug, 默认开启
 }
@@ -45,96 +80,167 @@ func (properties *serverProperties) String() string {
 var Properties *serverProperties
 
 func init() {
-	// default config
-	Properties = &serverProperties{
-		Bind:                "127.0.0.1",
-		Port:                6379,
-		AppendOnly:          false,
-		Cycle:               1,
-		Buckets:             1 << 16,
-		ListMaxShardSize:    1 << 9,
-		Databases:           1 << 4,
-		SetMaxIntSetEntries: 512,
-		Dev:                 true,
-		MaxClients:          -1,
+	// default config, 配置文件和命令行参数在 main 中通过 Load 加载
+	Properties = defaultProperties()
+}
+
+func defaultProperties() *serverProperties {
+	return &serverProperties{
+		Bind:                    "127.0.0.1",
+		Port:                    6379,
+		AppendOnly:              false,
+		Cycle:                   1,
+		Buckets:                 1 << 16,
+		ListMaxShardSize:        1 << 9,
+		Databases:               1 << 4,
+		SetMaxIntSetEntries:     512,
+		Dev:                     true,
+		MaxClients:              -1,
+		TcpKeepAlive:            300,
+		AclLogMaxLen:            128,
+		ProtoMaxBulkLen:         512 << 20,
+		SlowlogLogSlowerThan:    10000,
+		SlowlogMaxLen:           128,
+		ClientOutputBufferLimit: "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60",
+		TlsAuthClients:          "yes",
+		ClusterNodeTimeout:      15000,
+		ClusterRequestTimeout:   5000,
 	}
-	// read config file to rewrite `Properties`
-	if fileExists(configFile) {
-		SetupConfig(configFile)
+}
+
+// keyOf 返回字段在配置文件中的名称, 没有 cfg 标签时使用小写的字段名
+func keyOf(field reflect.StructField) string {
+	if key, ok := field.Tag.Lookup("cfg"); ok {
+		return key
 	}
+	return strings.ToLower(field.Name)
 }
 
-func parse(src io.Reader) *serverProperties {
-	config := Properties
+// lookup 根据配置名称查找字段, 包括没有 cfg 标签的字段
+func lookup(name string) (reflect.Value, bool) {
+	v := reflect.ValueOf(Properties).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if keyOf(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
 
-	// read config file
-	rawMap := make(map[string]string)
+// parse 读取配置文件, 每一行是 "名称 值", #开头的行是注释, 同一个配置出现多次时使用最后一个值
+//
+// 出现未知的配置, 缺少值或者值不合法时返回包含行号的错误
+func parse(src io.Reader, filename string) error {
 	scanner := bufio.NewScanner(src)
+	lineNo := 0
 	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) > 0 && line[0] == '#' {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
 			continue
 		}
-		pivot := strings.IndexAny(line, " ")
-		if pivot > 0 && pivot < len(line)-1 { // separator found
-			key := line[0:pivot]
-			value := strings.Trim(line[pivot+1:], " ")
-			rawMap[strings.ToLower(key)] = value
+		name := strings.Fields(line)[0]
+		value := strings.TrimSpace(line[len(name):])
+		if err := setParam(name, value); err != nil {
+			return fmt.Errorf("%s:%d: '%s': %w", filename, lineNo, line, err)
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		panic(err)
+		return fmt.Errorf("read config file %s: %w", filename, err)
 	}
+	return nil
+}
+
+// setParam 检查并修改一个配置, 用于配置文件和命令行参数
+func setParam(name, value string) error {
+	fieldVal, ok := lookup(strings.ToLower(name))
+	if !ok {
+		return errors.New("unknown config")
+	}
+	if value == "" {
+		return errors.New("missing value")
+	}
+	if err := validate(strings.ToLower(name), value); err != nil {
+		return err
+	}
+	return setValue(fieldVal, value)
+}
 
-	// parse format
-	t := reflect.TypeOf(config)
-	v := reflect.ValueOf(config)
-	n := t.Elem().NumField()
-	for i := 0; i < n; i++ {
-		field := t.Elem().Field(i)
-		fieldVal := v.Elem().Field(i)
-		key, ok := field.Tag.Lookup("cfg")
-		if !ok {
-			key = field.Name
+// setValue 把配置文件中的字符串转换为字段的类型, 转换失败时返回错误, 不修改字段
+func setValue(fieldVal reflect.Value, value string) error {
+	switch fieldVal.Kind() {
+	case reflect.String:
+		fieldVal.SetString(value)
+	case reflect.Int:
+		intValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("argument couldn't be parsed into an integer")
 		}
-		value, ok := rawMap[strings.ToLower(key)]
-		if ok {
-			// fill config
-			switch field.Type.Kind() {
-			case reflect.String:
-				fieldVal.SetString(value)
-			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
-				if err == nil {
-					fieldVal.SetInt(intValue)
-				}
-			case reflect.Bool:
-				boolValue := "yes" == value
-				fieldVal.SetBool(boolValue)
-			case reflect.Slice:
-				if field.Type.Elem().Kind() == reflect.String {
-					slice := strings.Split(value, ",")
-
-					for j := range slice {
-						slice[j] = strings.TrimSpace(slice[j])
-					}
-
-					fieldVal.Set(reflect.ValueOf(slice))
-				}
-			default:
-				panic("unhandled default case")
+		fieldVal.SetInt(intValue)
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "yes", "true": // 兼容以前使用 true/false 的配置文件
+			fieldVal.SetBool(true)
+		case "no", "false":
+			fieldVal.SetBool(false)
+		default:
+			return errors.New("argument must be 'yes' or 'no'")
+		}
+	case reflect.Slice:
+		if fieldVal.Type().Elem().Kind() == reflect.String {
+			slice := strings.Split(value, ",")
+
+			for j := range slice {
+				slice[j] = strings.TrimSpace(slice[j])
 			}
+
+			fieldVal.Set(reflect.ValueOf(slice))
+		}
+	default:
+		panic("unhandled default case")
+	}
+	return nil
+}
+
+// formatValue 把字段转换为配置文件中的字符串, 是 setValue 的逆操作
+func formatValue(fieldVal reflect.Value) string {
+	switch fieldVal.Kind() {
+	case reflect.Int:
+		return strconv.FormatInt(fieldVal.Int(), 10)
+	case reflect.Bool:
+		if fieldVal.Bool() {
+			return "yes"
 		}
+		return "no"
+	case reflect.Slice:
+		return strings.Join(fieldVal.Interface().([]string), ",")
 	}
-	return config
+	return fieldVal.String()
 }
 
 // SetupConfig read config file and store properties into Properties
-func SetupConfig(configFilename string) {
+func SetupConfig(configFilename string) error {
 	file, err := os.Open(configFilename)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer file.Close()
-	Properties = parse(file)
+	if err = parse(file, configFilename); err != nil {
+		return err
+	}
+	configFilePath = configFilename
+	return nil
+}
+
+// ParseUnixSocketPerm 解析八进制的 unixsocketperm, 例如700, 为空时返回0表示使用默认权限
+func ParseUnixSocketPerm(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	perm, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("invalid unixsocketperm '%s', must be an octal number such as 700", value)
+	}
+	return os.FileMode(perm), nil
 }
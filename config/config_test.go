@@ -1,6 +1,9 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -9,3 +12,174 @@ func TestDemo(t *testing.T) {
 	milli := time.Now().Add(100 * time.Second).UnixMilli()
 	t.Log(milli)
 }
+
+func TestParseClientOutputBufferLimit(t *testing.T) {
+	limits, err := ParseClientOutputBufferLimit("normal 0 0 0 slave 256mb 64mb 60 pubsub 32mb 8mb 60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit := limits[ClientClassPubSub]; limit.Hard != 32<<20 || limit.Soft != 8<<20 || limit.SoftSeconds != time.Minute {
+		t.Errorf("unexpected pubsub limit %+v", limit)
+	}
+	if limits[ClientClassReplica].Hard != 256<<20 {
+		t.Errorf("unexpected replica limit %+v", limits[ClientClassReplica])
+	}
+	if _, err = ParseClientOutputBufferLimit("normal 0 0"); err == nil {
+		t.Error("expected error")
+	}
+	if _, err = ParseClientOutputBufferLimit("foo 0 0 0"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseUnixSocketPerm(t *testing.T) {
+	if perm, err := ParseUnixSocketPerm("770"); err != nil || perm != 0770 {
+		t.Errorf("expected 0770, actually %o %v", perm, err)
+	}
+	if perm, err := ParseUnixSocketPerm(""); err != nil || perm != 0 {
+		t.Errorf("expected 0, actually %o %v", perm, err)
+	}
+	for _, value := range []string{"800", "abc", "7777"} {
+		if _, err := ParseUnixSocketPerm(value); err == nil {
+			t.Errorf("expected error for %s", value)
+		}
+	}
+}
+
+func TestGetAndSet(t *testing.T) {
+	old := *Properties
+	defer func() { *Properties = old }()
+
+	if got := Get("max-*"); len(got) != 4 || got[0] != "max-clients" || got[2] != "max-clients-per-ip" {
+		t.Errorf("unexpected config get result %v", got)
+	}
+	if err := Set("max-clients", "100", "loglevel", "warning"); err != nil {
+		t.Fatal(err)
+	}
+	if MaxClients() != 100 || LogLevel() != "warning" {
+		t.Errorf("config should be changed, max-clients %d loglevel %s", MaxClients(), LogLevel())
+	}
+
+	// 任意一个配置不合法时都不修改
+	tests := []struct {
+		params []string
+		err    string
+	}{
+		{[]string{"port", "1"}, "CONFIG SET failed (possibly related to argument 'port') - can't set immutable config"},
+		{[]string{"foo", "1"}, "CONFIG SET failed (possibly related to argument 'foo') - Unsupported CONFIG parameter"},
+		{[]string{"max-clients", "1", "cycle", "x"}, "CONFIG SET failed (possibly related to argument 'cycle') - argument couldn't be parsed into an integer"},
+		{[]string{"max-clients", "1", "cycle", "0"}, "CONFIG SET failed (possibly related to argument 'cycle') - argument must be greater than or equal to 1"},
+		{[]string{"max-clients", "1", "loglevel", "loud"}, "CONFIG SET failed (possibly related to argument 'loglevel') - argument(s) must be one of the following: debug, info, warning, error"},
+	}
+	for _, test := range tests {
+		if err := Set(test.params...); err == nil || err.Error() != test.err {
+			t.Errorf("expected %q, actually %v", test.err, err)
+		}
+	}
+	if MaxClients() != 100 {
+		t.Errorf("config should not change on error")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	old, oldPath := *Properties, configFilePath
+	defer func() {
+		*Properties, configFilePath = old, oldPath
+		clear(modified)
+	}()
+
+	configFilePath = filepath.Join(t.TempDir(), "redis.conf")
+	content := "# server\nport 6380\n\n# limits\nmax-clients 10\nunknown-option foo\n"
+	if err := os.WriteFile(configFilePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	Properties.Port = 6380
+	if err := Set("max-clients", "20", "timeout", "30"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(configFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# server\nport 6380\n\n# limits\nmax-clients 20\nunknown-option foo\ntimeout 30\n"
+	if string(data) != expected {
+		t.Errorf("expected %q, actually %q", expected, data)
+	}
+}
+
+func TestParse(t *testing.T) {
+	old := *Properties
+	defer func() { *Properties = old }()
+
+	src := "# comment\n  port 7000\nappend-only yes\npeers a:1, b:2\ndev no\n"
+	if err := parse(strings.NewReader(src), "test.conf"); err != nil {
+		t.Fatal(err)
+	}
+	if Properties.Port != 7000 || !Properties.AppendOnly || len(Properties.Peers) != 2 || Properties.Peers[1] != "b:2" || Properties.Dev {
+		t.Errorf("unexpected properties %v", Properties)
+	}
+	// 以前的配置文件使用 true/false
+	if err := parse(strings.NewReader("append-only false\ndev TRUE\n"), "test.conf"); err != nil {
+		t.Fatal(err)
+	}
+	if Properties.AppendOnly || !Properties.Dev {
+		t.Errorf("unexpected properties %v", Properties)
+	}
+
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"port 1\nport abc\n", "test.conf:2: 'port abc': argument couldn't be parsed into an integer"},
+		{"foo bar\n", "test.conf:1: 'foo bar': unknown config"},
+		{"bind\n", "test.conf:1: 'bind': missing value"},
+		{"append-only on\n", "test.conf:1: 'append-only on': argument must be 'yes' or 'no'"},
+		{"cycle 0\n", "test.conf:1: 'cycle 0': argument must be greater than or equal to 1"},
+		{"port 65536\n", "test.conf:1: 'port 65536': argument must be between 0 and 65535"},
+	}
+	for _, test := range tests {
+		if err := parse(strings.NewReader(test.src), "test.conf"); err == nil || err.Error() != test.err {
+			t.Errorf("expected %q, actually %v", test.err, err)
+		}
+	}
+}
+
+func TestParseArgsAndLoad(t *testing.T) {
+	old, oldPath := *Properties, configFilePath
+	defer func() { *Properties, configFilePath = old, oldPath }()
+
+	filename := filepath.Join(t.TempDir(), "node1.conf")
+	if err := os.WriteFile(filename, []byte("port 6380\nmax-clients 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := ParseArgs([]string{filename, "--port", "7000", "--peers", "a:1,b:2", "--test-config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ConfigFile != filename || !opts.TestConfig || len(opts.Overrides) != 4 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	if err = Load(opts); err != nil {
+		t.Fatal(err)
+	}
+	// 命令行参数覆盖配置文件
+	if Properties.Port != 7000 || Properties.MaxClients != 10 || len(Properties.Peers) != 2 || configFilePath != filename {
+		t.Errorf("unexpected properties %v", Properties)
+	}
+
+	for _, args := range [][]string{{"--port"}, {"--port", "--dev", "yes"}, {"a.conf", "b.conf"}, {"-port", "1"}} {
+		if _, err = ParseArgs(args); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+	err = Load(&Options{Overrides: []string{"port", "x"}})
+	if expected := "command line option '--port x': argument couldn't be parsed into an integer"; err == nil || err.Error() != expected {
+		t.Errorf("expected %q, actually %v", expected, err)
+	}
+	if err = Load(&Options{ConfigFile: filepath.Join(t.TempDir(), "missing.conf")}); err == nil {
+		t.Error("expected error for missing config file")
+	}
+}
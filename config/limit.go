@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 客户端的类别, 不同类别的客户端使用不同的输出缓冲区限制
+const (
+	ClientClassNormal  = "normal"
+	ClientClassReplica = "replica"
+	ClientClassPubSub  = "pubsub"
+)
+
+// OutputBufferLimit 客户端输出缓冲区的限制, 限制为0表示不限制
+//
+// 超过硬限制时立即断开连接, 持续超过软限制 SoftSeconds 之后断开连接
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds time.Duration
+}
+
+// ParseClientOutputBufferLimit 解析 client-output-buffer-limit 配置, 每4项配置一个类别的客户端
+//
+// 例如: normal 0 0 0 pubsub 32mb 8mb 60
+func ParseClientOutputBufferLimit(value string) (map[string]OutputBufferLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return nil, errors.New("wrong number of arguments in buffer limit configuration")
+	}
+	limits := make(map[string]OutputBufferLimit, len(fields)/4)
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" { // 旧版本的名称
+			class = ClientClassReplica
+		}
+		if class != ClientClassNormal && class != ClientClassReplica && class != ClientClassPubSub {
+			return nil, errors.New("invalid client class specified in buffer limit configuration")
+		}
+		hard, hardErr := ParseMemory(fields[i+1])
+		soft, softErr := ParseMemory(fields[i+2])
+		seconds, secondsErr := strconv.ParseInt(fields[i+3], 10, 64)
+		if hardErr != nil || softErr != nil || secondsErr != nil || seconds < 0 {
+			return nil, errors.New("error in hard, soft or soft_seconds setting in buffer limit configuration")
+		}
+		limits[class] = OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: time.Duration(seconds) * time.Second}
+	}
+	return limits, nil
+}
+
+// memoryUnits 内存的单位, 和Redis一致, k和kb分别是1000和1024
+var memoryUnits = []struct {
+	suffix string
+	mul    int64
+}{
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory 解析带单位的内存大小, 例如 1gb, 64mb, 100
+func ParseMemory(value string) (int64, error) {
+	value = strings.ToLower(value)
+	mul := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, mul = strings.TrimSuffix(value, unit.suffix), unit.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid memory value")
+	}
+	return n * mul, nil
+}
@@ -0,0 +1,325 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-redis/lib/wildcard"
+)
+
+// 运行时读取和修改配置, 包括 CONFIG GET, CONFIG SET 和 CONFIG REWRITE
+
+var (
+	mu             sync.RWMutex        // 保护可以通过 CONFIG SET 修改的配置
+	configFilePath string              // 启动时读取的配置文件, CONFIG REWRITE 写回这个文件
+	modified       = map[string]bool{} // 通过 CONFIG SET 修改过的配置, 不在配置文件中时追加到文件末尾
+	rewriteMu      sync.Mutex          // 同一时间只有一个 CONFIG REWRITE 写入文件
+)
+
+// LogLevels 合法的日志级别
+var LogLevels = []string{"debug", "info", "warning", "error"}
+
+// mutableParams 可以在运行时修改的配置, 值是修改之前的检查, 为nil时只检查类型
+var mutableParams = map[string]func(value string) error{
+	"max-clients":            nil,
+	"timeout":                minInt(0),
+	"require-pass":           nil,
+	"cycle":                  minInt(1),
+	"list-max-shard-size":    minInt(1),
+	"set-max-intset-entries": minInt(0),
+	"loglevel":               validateLogLevel,
+	// 慢查询和延迟监控
+	"slowlog-log-slower-than":   minInt(-1),
+	"slowlog-max-len":           minInt(0),
+	"latency-monitor-threshold": minInt(0),
+	"cluster-node-timeout":      minInt(1),
+	"cluster-redirect":          nil,
+	"cluster-request-timeout":   minInt(1),
+}
+
+// validators 启动时需要检查的不可修改的配置
+var validators = map[string]func(value string) error{
+	"port":     validatePort,
+	"tls-port": validatePort,
+	"unixsocketperm": func(value string) error {
+		_, err := ParseUnixSocketPerm(value)
+		return err
+	},
+	"client-output-buffer-limit": func(value string) error {
+		_, err := ParseClientOutputBufferLimit(value)
+		return err
+	},
+}
+
+// validate 检查配置的值, 类型错误由 setValue 检查
+func validate(name, value string) error {
+	check := mutableParams[name]
+	if check == nil {
+		check = validators[name]
+	}
+	if check == nil {
+		return nil
+	}
+	return check(value)
+}
+
+func validatePort(value string) error {
+	if n, err := strconv.Atoi(value); err == nil && (n < 0 || n > 65535) {
+		return errors.New("argument must be between 0 and 65535")
+	}
+	return nil
+}
+
+func minInt(lower int) func(value string) error {
+	return func(value string) error {
+		if n, err := strconv.Atoi(value); err == nil && n < lower {
+			return fmt.Errorf("argument must be greater than or equal to %d", lower)
+		}
+		return nil
+	}
+}
+
+func validateLogLevel(value string) error {
+	for _, level := range LogLevels {
+		if strings.EqualFold(level, value) {
+			return nil
+		}
+	}
+	return errors.New("argument(s) must be one of the following: " + strings.Join(LogLevels, ", "))
+}
+
+// MaxClients 返回 max-clients
+func MaxClients() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.MaxClients
+}
+
+// Timeout 返回 timeout
+func Timeout() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.Timeout
+}
+
+// RequirePass 返回 require-pass
+func RequirePass() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.RequirePass
+}
+
+// Cycle 返回 cycle
+func Cycle() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.Cycle
+}
+
+// ListMaxShardSize 返回 list-max-shard-size
+func ListMaxShardSize() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.ListMaxShardSize
+}
+
+// SetMaxIntSetEntries 返回 set-max-intset-entries
+func SetMaxIntSetEntries() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.SetMaxIntSetEntries
+}
+
+// SlowlogLogSlowerThan 返回 slowlog-log-slower-than, 单位是微秒
+func SlowlogLogSlowerThan() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.SlowlogLogSlowerThan
+}
+
+// SlowlogMaxLen 返回 slowlog-max-len
+func SlowlogMaxLen() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.SlowlogMaxLen
+}
+
+// LatencyMonitorThreshold 返回 latency-monitor-threshold, 单位是毫秒
+func LatencyMonitorThreshold() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.LatencyMonitorThreshold
+}
+
+// ClusterNodeTimeout 返回 cluster-node-timeout, 单位是毫秒
+func ClusterNodeTimeout() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.ClusterNodeTimeout
+}
+
+// ClusterRequestTimeout 返回 cluster-request-timeout, 单位是毫秒
+func ClusterRequestTimeout() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.ClusterRequestTimeout
+}
+
+// ClusterRedirect 返回 cluster-redirect
+func ClusterRedirect() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Properties.ClusterRedirect
+}
+
+// LogLevel 返回 loglevel, 没有配置时Dev模式是debug, 否则是info
+func LogLevel() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if Properties.LogLevel != "" {
+		return strings.ToLower(Properties.LogLevel)
+	}
+	if Properties.Dev {
+		return "debug"
+	}
+	return "info"
+}
+
+// Get 返回名称和任意一个模式匹配的配置, 结果是名称和值交替排列的数组, 按字段的顺序排列
+func Get(patterns ...string) []string {
+	compiled := make([]*wildcard.Pattern, len(patterns))
+	for i, pattern := range patterns {
+		compiled[i] = wildcard.CompilePattern(strings.ToLower(pattern))
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	v := reflect.ValueOf(Properties).Elem()
+	t := v.Type()
+	result := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		key := keyOf(t.Field(i))
+		for _, pattern := range compiled {
+			if pattern.IsMatch(key) {
+				result = append(result, key, formatValue(v.Field(i)))
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Set 修改配置, params 是名称和值交替排列的数组, 所有配置检查通过之后才会一起修改
+//
+// 返回的错误和Redis一致, 例如 CONFIG SET failed (possibly related to argument 'port') - can't set immutable config
+func Set(params ...string) error {
+	if len(params)%2 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	// 1. 检查所有配置
+	fields := make([]reflect.Value, len(params)/2)
+	seen := make(map[string]bool)
+	for i := 0; i < len(params); i += 2 {
+		name := strings.ToLower(params[i])
+		fieldVal, ok := lookup(name)
+		if !ok {
+			return setError(params[i], "Unsupported CONFIG parameter")
+		}
+		if _, ok = mutableParams[name]; !ok {
+			return setError(params[i], "can't set immutable config")
+		}
+		if seen[name] {
+			return setError(params[i], "duplicate parameter")
+		}
+		seen[name] = true
+		// 在副本上检查类型
+		if err := setValue(reflect.New(fieldVal.Type()).Elem(), params[i+1]); err != nil {
+			return setError(params[i], err.Error())
+		}
+		if err := validate(name, params[i+1]); err != nil {
+			return setError(params[i], err.Error())
+		}
+		fields[i/2] = fieldVal
+	}
+	// 2. 修改配置
+	for i, fieldVal := range fields {
+		_ = setValue(fieldVal, params[i*2+1])
+		modified[strings.ToLower(params[i*2])] = true
+	}
+	return nil
+}
+
+func setError(name, msg string) error {
+	return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %s", name, msg)
+}
+
+// Rewrite 把当前的配置写回启动时读取的配置文件
+//
+// 文件中的注释, 空行和未知的配置保持不变, 已有的配置替换为当前的值, 值为空的配置会被删除; 通过 CONFIG SET 修改过但是不在文件中的配置追加到文件末尾
+func Rewrite() error {
+	if configFilePath == "" {
+		return errors.New("The server is running without a config file")
+	}
+	rewriteMu.Lock()
+	defer rewriteMu.Unlock()
+	content, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	mu.RLock()
+	// 1. 替换文件中已有的配置
+	lines := make([]string, 0)
+	written := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			lines = append(lines, line)
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		fieldVal, ok := lookup(name)
+		if !ok {
+			lines = append(lines, line)
+			continue
+		}
+		if value := formatValue(fieldVal); value != "" && !written[name] {
+			lines = append(lines, name+" "+value)
+		}
+		written[name] = true
+	}
+	// 2. 追加修改过的配置
+	v := reflect.ValueOf(Properties).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := keyOf(t.Field(i))
+		if !modified[name] || written[name] {
+			continue
+		}
+		if value := formatValue(v.Field(i)); value != "" {
+			lines = append(lines, name+" "+value)
+		}
+	}
+	mu.RUnlock()
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	// 3. 先写入临时文件再重命名, 避免写入失败时破坏原来的文件
+	tmp := configFilePath + ".tmp"
+	if err = os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, configFilePath)
+}
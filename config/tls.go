@@ -0,0 +1,91 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tls-auth-clients 的取值
+const (
+	TlsAuthClientsYes      = "yes"
+	TlsAuthClientsNo       = "no"
+	TlsAuthClientsOptional = "optional"
+)
+
+// ServerTLSConfig 根据配置创建TLS监听器的配置
+//
+// tls-auth-clients 为 yes 时要求客户端提供由CA签发的证书, optional 时只验证客户端提供的证书
+func ServerTLSConfig() (*tls.Config, error) {
+	cert, err := loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	switch strings.ToLower(Properties.TlsAuthClients) {
+	case TlsAuthClientsYes, "":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case TlsAuthClientsOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case TlsAuthClientsNo:
+		cfg.ClientAuth = tls.NoClientCert
+		return cfg, nil
+	default:
+		return nil, fmt.Errorf("invalid tls-auth-clients '%s', must be yes, no or optional", Properties.TlsAuthClients)
+	}
+	if cfg.ClientCAs, err = loadCACertPool(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ClientTLSConfig 根据配置创建连接集群节点的TLS配置, 使用服务端证书作为客户端证书, 用CA证书验证对方节点
+func ClientTLSConfig() (*tls.Config, error) {
+	cert, err := loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCACertPool()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// loadCertificate 加载证书和私钥
+func loadCertificate() (tls.Certificate, error) {
+	if Properties.TlsCertFile == "" || Properties.TlsKeyFile == "" {
+		return tls.Certificate{}, errors.New("tls-cert-file and tls-key-file must be set to use TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(Properties.TlsCertFile, Properties.TlsKeyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load tls-cert-file or tls-key-file: %w", err)
+	}
+	return cert, nil
+}
+
+// loadCACertPool 加载CA证书
+func loadCACertPool() (*x509.CertPool, error) {
+	if Properties.TlsCaCertFile == "" {
+		return nil, errors.New("tls-ca-cert-file must be set to verify peer certificates")
+	}
+	pem, err := os.ReadFile(Properties.TlsCaCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls-ca-cert-file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in tls-ca-cert-file %s", Properties.TlsCaCertFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,308 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-redis/acl"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+// CheckPermission 检查连接的用户是否可以执行命令, 以及是否可以访问命令中的键和频道, 允许时返回nil
+//
+// 被拒绝的命令记录到 ACL LOG 中, 事务中被拒绝的命令会使 EXEC 失败
+func CheckPermission(conn resp.Connection, cmdName string, args db.CmdLine) resp.ErrorReply {
+	// 1. AUTH 和 HELLO 用于切换用户, 未知的命令交给后面返回错误
+	if cmdName == enum.SYS_AUTH.String() || cmdName == enum.HELLO.String() {
+		return nil
+	}
+	if _, ok := enum.LookupCommand(cmdName); !ok {
+		return nil
+	}
+	u := acl.GetUser(currentUser(conn))
+	if u == nil {
+		return &reply.NormalErrReply{Status: "NOAUTH Authentication required"}
+	}
+	// 2. 检查命令, 有子命令的命令同时检查子命令
+	var subcommand string
+	if len(args) > 1 {
+		subcommand = utils.Bytes2String(args[1])
+	}
+	if !u.CanExecute(cmdName, subcommand) {
+		object := strings.ToLower(cmdName)
+		if subcommand != "" && u.HasSubcommandRules(cmdName) {
+			object += "|" + strings.ToLower(subcommand)
+		}
+		return denyPermission(conn, acl.ReasonCommand, object,
+			"this user has no permissions to run the '"+object+"' command")
+	}
+	// 3. 检查键
+	writeKeys, readKeys := relatedKeys(cmdName, args)
+	for _, key := range writeKeys {
+		if !u.CanAccessKey(key, true) {
+			return denyPermission(conn, acl.ReasonKey, key,
+				"this user has no permissions to access one of the keys used as arguments")
+		}
+	}
+	for _, key := range readKeys {
+		if !u.CanAccessKey(key, false) {
+			return denyPermission(conn, acl.ReasonKey, key,
+				"this user has no permissions to access one of the keys used as arguments")
+		}
+	}
+	// 4. 检查频道, PSUBSCRIBE 的模式必须和用户的模式完全相同
+	var channels [][]byte
+	isPattern := false
+	switch cmdName {
+	case enum.PUBLISH.String():
+		if len(args) > 1 {
+			channels = args[1:2]
+		}
+	case enum.SUBSCRIBE.String():
+		channels = args[1:]
+	case enum.PSUBSCRIBE.String():
+		channels, isPattern = args[1:], true
+	}
+	for _, channel := range channels {
+		if !u.CanAccessChannel(utils.Bytes2String(channel), isPattern) {
+			return denyPermission(conn, acl.ReasonChannel, string(channel),
+				"this user has no permissions to access one of the channels used as arguments")
+		}
+	}
+	return nil
+}
+
+// CheckQueuedPermission EXEC 时重新检查事务中排队的命令, 排队之后用户的权限可能已经被修改, 被拒绝时放弃事务
+func CheckQueuedPermission(conn resp.Connection) resp.ErrorReply {
+	for _, cmdLine := range conn.GetQueuedCmdLine() {
+		if errReply := CheckPermission(conn, strings.ToUpper(utils.Bytes2String(cmdLine[0])), cmdLine); errReply != nil {
+			conn.SetMultiState(false)
+			return errReply
+		}
+	}
+	return nil
+}
+
+// relatedKeys 返回命令读写的键, 参数数量不正确时不检查键, 由命令返回参数错误
+func relatedKeys(cmdName string, args db.CmdLine) (writeKeys, readKeys []string) {
+	switch cmdName {
+	case enum.COPY.String():
+		if ValidateArity(enum.COPY.Arity(), args) {
+			return []string{string(args[2])}, []string{string(args[1])}
+		}
+		return nil, nil
+	case enum.MOVE.String():
+		if ValidateArity(enum.MOVE.Arity(), args) {
+			return []string{string(args[1])}, nil
+		}
+		return nil, nil
+	}
+	cmd, ok := cmdTable[strings.ToLower(cmdName)]
+	if !ok || cmd.prepare == nil || !ValidateArity(cmd.arity, args) {
+		return nil, nil
+	}
+	return cmd.prepare(args[1:])
+}
+
+// denyPermission 记录被拒绝的命令, 事务中被拒绝时标记事务失败
+func denyPermission(conn resp.Connection, reason, object, msg string) resp.ErrorReply {
+	acl.AddLog(reason, aclContext(conn), object, currentUser(conn), clientInfo(conn))
+	errReply := &reply.NormalErrReply{Status: "NOPERM " + msg}
+	if conn.InMultiState() {
+		conn.AddTxError(errReply)
+	}
+	return errReply
+}
+
+// aclContext 返回 ACL LOG 中的上下文
+func aclContext(conn resp.Connection) string {
+	if conn.InMultiState() {
+		return acl.ContextMulti
+	}
+	return acl.ContextTopLevel
+}
+
+// clientInfo 返回 ACL LOG 中的客户端信息
+func clientInfo(conn resp.Connection) string {
+	return fmt.Sprintf("id=%d addr=%s name=%s db=%d user=%s",
+		conn.GetID(), conn.RemoteAddr(), conn.GetName(), conn.GetDBIndex(), currentUser(conn))
+}
+
+// execACL 执行 ACL 命令
+//
+// # ACL SETUSER | GETUSER | DELUSER | LIST | USERS | WHOAMI | CAT | LOG | LOAD | SAVE
+func execACL(conn resp.Connection, args db.CmdLine) resp.Reply {
+	if !ValidateArity(enum.ACL.Arity(), args) {
+		return reply.NewArgNumErrReply(enum.ACL.String())
+	}
+	subCmd := strings.ToUpper(utils.Bytes2String(args[1]))
+	switch subCmd {
+	case enum.ACL_SETUSER:
+		if len(args) < 3 {
+			return reply.NewArgNumErrReply("acl|setuser")
+		}
+		rules := make([]string, len(args)-3)
+		for i, arg := range args[3:] {
+			rules[i] = string(arg)
+		}
+		if err := acl.SetUser(string(args[2]), rules); err != nil {
+			return reply.NewErrReply(err.Error())
+		}
+		return reply.NewOKReply()
+	case enum.ACL_GETUSER:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply("acl|getuser")
+		}
+		return execACLGetUser(utils.Bytes2String(args[2]))
+	case enum.ACL_DELUSER:
+		if len(args) < 3 {
+			return reply.NewArgNumErrReply("acl|deluser")
+		}
+		names := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			names[i] = string(arg)
+		}
+		deleted, err := acl.DeleteUser(names...)
+		if err != nil {
+			return reply.NewErrReply(err.Error())
+		}
+		return reply.NewIntReply(int64(deleted))
+	case enum.ACL_LIST:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("acl|list")
+		}
+		return stringsReply(acl.List())
+	case enum.ACL_USERS:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("acl|users")
+		}
+		users := acl.Users()
+		names := make([]string, len(users))
+		for i, u := range users {
+			names[i] = u.Name()
+		}
+		return stringsReply(names)
+	case enum.ACL_WHOAMI:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("acl|whoami")
+		}
+		return reply.NewBulkReply([]byte(currentUser(conn)))
+	case enum.ACL_CAT:
+		return execACLCat(args[2:])
+	case enum.ACL_LOG:
+		return execACLLog(args[2:])
+	case enum.ACL_LOAD:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("acl|load")
+		}
+		if err := acl.LoadFile(); err != nil {
+			return reply.NewErrReply(err.Error())
+		}
+		return reply.NewOKReply()
+	case enum.ACL_SAVE:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("acl|save")
+		}
+		if err := acl.SaveFile(); err != nil {
+			return reply.NewErrReply(err.Error())
+		}
+		return reply.NewOKReply()
+	default:
+		return reply.NewErrReply("unknown subcommand '" + subCmd + "'")
+	}
+}
+
+// execACLGetUser 返回用户的规则, 用户不存在时返回空值
+func execACLGetUser(name string) resp.Reply {
+	u := acl.GetUser(name)
+	if u == nil {
+		return reply.NewNullBulkReply()
+	}
+	return reply.NewMapReply([]resp.Reply{
+		reply.NewBulkReply([]byte("flags")), stringsReply(u.Flags()),
+		reply.NewBulkReply([]byte("passwords")), stringsReply(u.Passwords()),
+		reply.NewBulkReply([]byte("commands")), reply.NewBulkReply([]byte(u.CommandRules())),
+		reply.NewBulkReply([]byte("keys")), reply.NewBulkReply([]byte(u.KeyRules())),
+		reply.NewBulkReply([]byte("channels")), reply.NewBulkReply([]byte(u.ChannelRules())),
+		reply.NewBulkReply([]byte("selectors")), reply.NewEmptyMultiBulkReply(),
+	})
+}
+
+// execACLCat 没有参数时返回所有类别, 否则返回类别中的命令
+//
+// # ACL CAT [category]
+func execACLCat(args db.Params) resp.Reply {
+	switch len(args) {
+	case 0:
+		return stringsReply(enum.CategoryNames())
+	case 1:
+		category, ok := enum.LookupCategory(utils.Bytes2String(args[0]))
+		if !ok {
+			return reply.NewErrReply("Unknown category '" + string(args[0]) + "'")
+		}
+		names := make([]string, 0)
+		for _, cmd := range enum.Commands() {
+			if cmd.Categories()&category != 0 {
+				names = append(names, strings.ToLower(cmd.Name()))
+			}
+		}
+		return stringsReply(names)
+	}
+	return reply.NewArgNumErrReply("acl|cat")
+}
+
+// execACLLog 返回最近被拒绝的命令和失败的认证, RESET 清空记录
+//
+// # ACL LOG [count | RESET]
+func execACLLog(args db.Params) resp.Reply {
+	count := 10
+	switch len(args) {
+	case 0:
+	case 1:
+		if strings.ToUpper(utils.Bytes2String(args[0])) == enum.ACL_LOG_RESET {
+			acl.ResetLog()
+			return reply.NewOKReply()
+		}
+		n, err := strconv.Atoi(utils.Bytes2String(args[0]))
+		if err != nil || n < 0 {
+			return reply.NewErrReply("value is out of range, must be positive")
+		}
+		count = n
+	default:
+		return reply.NewArgNumErrReply("acl|log")
+	}
+
+	now := time.Now()
+	entries := acl.Logs(count)
+	replies := make([]resp.Reply, len(entries))
+	for i, entry := range entries {
+		replies[i] = reply.NewMapReply([]resp.Reply{
+			reply.NewBulkReply([]byte("count")), reply.NewIntReply(entry.Count),
+			reply.NewBulkReply([]byte("reason")), reply.NewBulkReply([]byte(entry.Reason)),
+			reply.NewBulkReply([]byte("context")), reply.NewBulkReply([]byte(entry.Context)),
+			reply.NewBulkReply([]byte("object")), reply.NewBulkReply([]byte(entry.Object)),
+			reply.NewBulkReply([]byte("username")), reply.NewBulkReply([]byte(entry.Username)),
+			reply.NewBulkReply([]byte("age-seconds")), reply.NewDoubleReply(now.Sub(entry.CreatedAt).Seconds()),
+			reply.NewBulkReply([]byte("client-info")), reply.NewBulkReply([]byte(entry.ClientInfo)),
+			reply.NewBulkReply([]byte("entry-id")), reply.NewIntReply(entry.EntryID),
+			reply.NewBulkReply([]byte("timestamp-created")), reply.NewIntReply(entry.CreatedAt.UnixMilli()),
+			reply.NewBulkReply([]byte("timestamp-last-updated")), reply.NewIntReply(entry.LastUpdated.UnixMilli()),
+		})
+	}
+	return reply.NewMultiRawReply(replies)
+}
+
+// stringsReply 把字符串数组转换为回复
+func stringsReply(values []string) resp.Reply {
+	args := make([][]byte, len(values))
+	for i, value := range values {
+		args[i] = []byte(value)
+	}
+	return reply.NewMultiBulkReply(args)
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"testing"
+
+	"go-redis/acl"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+func TestACLPermission(t *testing.T) {
+	defer acl.ResetLog()
+	defer acl.DeleteUser("reader")
+
+	admin := connection.NewFakeConn()
+	result := testServer.Exec(admin, utils.ToCmdLine("acl", "setuser", "reader", "on", ">pw", "%R~pub:*", "&news", "+@read", "+publish", "+subscribe", "+multi", "+exec"))
+	asserts.AssertStatusReply(t, result, "OK")
+	testServer.Exec(admin, utils.ToCmdLine("set", "pub:1", "v"))
+
+	conn := connection.NewFakeConn()
+	defer testServer.AfterClientClose(conn)
+	result = testServer.Exec(conn, utils.ToCmdLine("auth", "reader", "wrong"))
+	asserts.AssertErrReply(t, result, "WRONGPASS invalid username-password pair or user is disabled.")
+	result = testServer.Exec(conn, utils.ToCmdLine("auth", "reader", "pw"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("acl", "whoami"))
+	asserts.AssertErrReply(t, result, "NOPERM this user has no permissions to run the 'acl' command")
+
+	// 键和频道
+	result = testServer.Exec(conn, utils.ToCmdLine("get", "pub:1"))
+	asserts.AssertBulkReply(t, result, "v")
+	result = testServer.Exec(conn, utils.ToCmdLine("get", "secret"))
+	asserts.AssertErrReply(t, result, "NOPERM this user has no permissions to access one of the keys used as arguments")
+	result = testServer.Exec(conn, utils.ToCmdLine("set", "pub:1", "x"))
+	asserts.AssertErrReply(t, result, "NOPERM this user has no permissions to run the 'set' command")
+	result = testServer.Exec(conn, utils.ToCmdLine("flushall"))
+	asserts.AssertErrReply(t, result, "NOPERM this user has no permissions to run the 'flushall' command")
+	result = testServer.Exec(conn, utils.ToCmdLine("publish", "sport", "msg"))
+	asserts.AssertErrReply(t, result, "NOPERM this user has no permissions to access one of the channels used as arguments")
+	result = testServer.Exec(conn, utils.ToCmdLine("publish", "news", "msg"))
+	asserts.AssertIntReply(t, result, 0)
+
+	// 事务中被拒绝的命令使 EXEC 失败
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	testServer.Exec(conn, utils.ToCmdLine("get", "secret"))
+	result = testServer.Exec(conn, utils.ToCmdLine("exec"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected EXECABORT, actually %q", result.Bytes())
+	}
+
+	result = testServer.Exec(admin, utils.ToCmdLine("acl", "log", "1"))
+	if multi, ok := result.(*reply.MultiRawReply); !ok || len(multi.Replies) != 1 {
+		t.Errorf("unexpected acl log %q", result.Bytes())
+	}
+	// 删除用户之后连接不再通过验证
+	result = testServer.Exec(admin, utils.ToCmdLine("acl", "deluser", "reader", "default"))
+	asserts.AssertErrReply(t, result, "ERR The 'default' user cannot be removed")
+	result = testServer.Exec(admin, utils.ToCmdLine("acl", "deluser", "reader"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(conn, utils.ToCmdLine("get", "pub:1"))
+	asserts.AssertErrReply(t, result, "NOAUTH Authentication required")
+	// 重新创建同名的用户之后, 原来的连接需要使用新的密码验证
+	result = testServer.Exec(admin, utils.ToCmdLine("acl", "setuser", "reader", "on", ">newpw", "%R~pub:*", "+@read", "+multi", "+exec"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("get", "pub:1"))
+	asserts.AssertErrReply(t, result, "NOAUTH Authentication required")
+	result = testServer.Exec(conn, utils.ToCmdLine("auth", "reader", "newpw"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("get", "pub:1"))
+	asserts.AssertBulkReply(t, result, "v")
+
+	// 命令排队之后收回权限, EXEC 时重新检查
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	asserts.AssertStatusReply(t, testServer.Exec(conn, utils.ToCmdLine("get", "pub:1")), "QUEUED")
+	result = testServer.Exec(admin, utils.ToCmdLine("acl", "setuser", "reader", "-get"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("exec"))
+	asserts.AssertErrReply(t, result, "NOPERM this user has no permissions to run the 'get' command")
+	if conn.InMultiState() {
+		t.Error("transaction should be discarded")
+	}
+}
+
+func TestACLCommand(t *testing.T) {
+	conn := connection.NewFakeConn()
+	result := testServer.Exec(conn, utils.ToCmdLine("acl", "whoami"))
+	asserts.AssertBulkReply(t, result, "default")
+	result = testServer.Exec(conn, utils.ToCmdLine("acl", "list"))
+	asserts.AssertMultiBulkReply(t, result, []string{"user default on nopass ~* &* +@all"})
+	result = testServer.Exec(conn, utils.ToCmdLine("acl", "setuser", "bad", "+nosuchcommand"))
+	asserts.AssertErrReply(t, result, "ERR Error in ACL SETUSER modifier '+nosuchcommand': Unknown command or category name in ACL")
+	result = testServer.Exec(conn, utils.ToCmdLine("acl", "getuser", "bad"))
+	asserts.AssertNullBulk(t, result)
+	result = testServer.Exec(conn, utils.ToCmdLine("acl", "cat", "nosuchcategory"))
+	asserts.AssertErrReply(t, result, "ERR Unknown category 'nosuchcategory'")
+	result = testServer.Exec(conn, utils.ToCmdLine("acl", "save"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected error without aclfile")
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("auth", "pw"))
+	asserts.AssertErrReply(t, result, "ERR AUTH <password> called without any password configured for the default user. "+
+		"Are you sure your configuration is correct?")
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"go-redis/tracking"
+)
+
+// execClient 执行 CLIENT 命令
+//
+// # CLIENT ID | SETNAME | GETNAME | TRACKING | CACHING | GETREDIR
+func execClient(database *StandaloneDatabase, conn resp.Connection, args db.CmdLine) resp.Reply {
+	if !ValidateArity(enum.CLIENT.Arity(), args) {
+		return reply.NewArgNumErrReply(enum.CLIENT.String())
+	}
+	subCmd := strings.ToUpper(utils.Bytes2String(args[1]))
+	switch subCmd {
+	case enum.CLIENT_ID:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("client|id")
+		}
+		return reply.NewIntReply(conn.GetID())
+	case enum.CLIENT_SETNAME:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply("client|setname")
+		}
+		if errReply := validateClientName(args[2]); errReply != nil {
+			return errReply
+		}
+		conn.SetName(string(args[2]))
+		return reply.NewOKReply()
+	case enum.CLIENT_GETNAME:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("client|getname")
+		}
+		if conn.GetName() == "" {
+			return reply.NewNullBulkReply()
+		}
+		return reply.NewBulkReply([]byte(conn.GetName()))
+	case enum.CLIENT_TRACKING:
+		return execClientTracking(database.tracker, conn, args[2:])
+	case enum.CLIENT_CACHING:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply("client|caching")
+		}
+		var yes bool
+		switch strings.ToUpper(utils.Bytes2String(args[2])) {
+		case enum.CACHING_YES:
+			yes = true
+		case enum.CACHING_NO:
+			yes = false
+		default:
+			return reply.NewSyntaxErrReply()
+		}
+		if err := database.tracker.Caching(conn, yes); err != nil {
+			return reply.NewErrReply(err.Error())
+		}
+		return reply.NewOKReply()
+	case enum.CLIENT_GETREDIR:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("client|getredir")
+		}
+		return reply.NewIntReply(database.tracker.GetRedirect(conn))
+	default:
+		return reply.NewErrReply("unknown subcommand '" + subCmd + "'")
+	}
+}
+
+// execClientTracking 开启或关闭客户端缓存的追踪
+//
+// # CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func execClientTracking(tracker *tracking.Table, conn resp.Connection, args db.Params) resp.Reply {
+	if len(args) == 0 {
+		return reply.NewArgNumErrReply("client|tracking")
+	}
+	// 1. 解析选项
+	var opts tracking.Options
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(utils.Bytes2String(args[i])) {
+		case enum.TRACKING_REDIR:
+			if i+1 >= len(args) {
+				return reply.NewSyntaxErrReply()
+			}
+			i++
+			id, err := strconv.ParseInt(utils.Bytes2String(args[i]), 10, 64)
+			if err != nil {
+				return reply.NewIntErrReply()
+			}
+			opts.Redirect = id
+		case enum.TRACKING_PREFIX:
+			if i+1 >= len(args) {
+				return reply.NewSyntaxErrReply()
+			}
+			i++
+			opts.Prefixes = append(opts.Prefixes, string(args[i]))
+		case enum.TRACKING_BCAST:
+			opts.BCast = true
+		case enum.TRACKING_OPTIN:
+			opts.OptIn = true
+		case enum.TRACKING_OPTOUT:
+			opts.OptOut = true
+		case enum.TRACKING_NOLOOP:
+			opts.NoLoop = true
+		default:
+			return reply.NewSyntaxErrReply()
+		}
+	}
+	// 2. 开启或关闭追踪
+	switch strings.ToUpper(utils.Bytes2String(args[0])) {
+	case enum.TRACKING_ON:
+		if err := tracker.Enable(conn, opts); err != nil {
+			return reply.NewErrReply(err.Error())
+		}
+	case enum.TRACKING_OFF:
+		tracker.Disable(conn)
+	default:
+		return reply.NewSyntaxErrReply()
+	}
+	return reply.NewOKReply()
+}
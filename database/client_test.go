@@ -0,0 +1,75 @@
+package database
+
+import (
+	"testing"
+
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+)
+
+func TestClientTracking(t *testing.T) {
+	conn := connection.NewFakeConn()
+	writer := connection.NewFakeConn()
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	testServer.Exec(conn, utils.ToCmdLine("HELLO", "3")) // 失效消息只推送给RESP3的客户端
+	defer testServer.AfterClientClose(conn)
+
+	result := testServer.Exec(conn, utils.ToCmdLine("client", "getredir"))
+	asserts.AssertIntReply(t, result, -1)
+	result = testServer.Exec(conn, utils.ToCmdLine("client", "tracking", "on"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("client", "getredir"))
+	asserts.AssertIntReply(t, result, 0)
+
+	// 1. 读取之后被其他客户端修改, 收到失效消息
+	testServer.Exec(conn, utils.ToCmdLine("get", "k"))
+	testServer.Exec(writer, utils.ToCmdLine("set", "k", "v"))
+	expected := ">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nk\r\n"
+	if string(conn.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, conn.Bytes())
+	}
+	// 2. 没有再次读取, 不会再收到消息
+	conn.Clean()
+	testServer.Exec(writer, utils.ToCmdLine("set", "k", "v2"))
+	if len(conn.Bytes()) != 0 {
+		t.Errorf("unexpected message %q", conn.Bytes())
+	}
+	// 3. 清空数据库
+	testServer.Exec(writer, utils.ToCmdLine("flushdb"))
+	expected = ">2\r\n$10\r\ninvalidate\r\n_\r\n"
+	if string(conn.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, conn.Bytes())
+	}
+}
+
+func TestClientCaching(t *testing.T) {
+	conn := connection.NewFakeConn()
+	writer := connection.NewFakeConn()
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	testServer.Exec(conn, utils.ToCmdLine("HELLO", "3")) // 失效消息只推送给RESP3的客户端
+	defer testServer.AfterClientClose(conn)
+
+	result := testServer.Exec(conn, utils.ToCmdLine("client", "caching", "yes"))
+	asserts.AssertErrReply(t, result, "ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	testServer.Exec(conn, utils.ToCmdLine("client", "tracking", "on", "optin"))
+	// 没有 CACHING YES, 不记录
+	testServer.Exec(conn, utils.ToCmdLine("get", "a"))
+	// CACHING YES 只对下一条命令有效
+	result = testServer.Exec(conn, utils.ToCmdLine("client", "caching", "yes"))
+	asserts.AssertStatusReply(t, result, "OK")
+	testServer.Exec(conn, utils.ToCmdLine("get", "b"))
+	testServer.Exec(conn, utils.ToCmdLine("get", "c"))
+
+	testServer.Exec(writer, utils.ToCmdLine("mset", "a", "1", "b", "2", "c", "3"))
+	expected := ">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nb\r\n"
+	if string(conn.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, conn.Bytes())
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("client", "tracking", "on", "bcast"))
+	asserts.AssertErrReply(t, result, "ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+	result = testServer.Exec(conn, utils.ToCmdLine("client", "tracking", "off"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("client", "foo"))
+	asserts.AssertErrReply(t, result, "ERR unknown subcommand 'FOO'")
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"go-redis/acl"
+	"go-redis/config"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/timewheel"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+// execConfig 执行 CONFIG 命令
+//
+// # CONFIG GET parameter [parameter ...] | SET parameter value [parameter value ...] | REWRITE
+func execConfig(args db.CmdLine) resp.Reply {
+	if !ValidateArity(enum.CONFIG.Arity(), args) {
+		return reply.NewArgNumErrReply(enum.CONFIG.String())
+	}
+	subCmd := strings.ToUpper(utils.Bytes2String(args[1]))
+	switch subCmd {
+	case enum.CONFIG_GET:
+		if len(args) < 3 {
+			return reply.NewArgNumErrReply("config|get")
+		}
+		patterns := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			patterns[i] = string(arg)
+		}
+		params := config.Get(patterns...)
+		entries := make([][]byte, len(params))
+		for i, param := range params {
+			entries[i] = []byte(param)
+		}
+		return reply.NewBulkMapReply(entries)
+	case enum.CONFIG_SET:
+		if len(args) < 4 || len(args)%2 != 0 {
+			return reply.NewArgNumErrReply("config|set")
+		}
+		params := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			params[i] = string(arg)
+		}
+		if err := config.Set(params...); err != nil {
+			return reply.NewErrReply(err.Error())
+		}
+		for i := 0; i < len(params); i += 2 {
+			applyConfig(strings.ToLower(params[i]))
+		}
+		return reply.NewOKReply()
+	case enum.CONFIG_REWRITE:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("config|rewrite")
+		}
+		if err := config.Rewrite(); err != nil {
+			return reply.NewErrReply(err.Error())
+		}
+		return reply.NewOKReply()
+	default:
+		return reply.NewErrReply("unknown subcommand '" + subCmd + "'")
+	}
+}
+
+// applyConfig 修改配置之后更新依赖这个配置的模块, 其他配置在每次使用时读取, 修改后立即生效
+func applyConfig(name string) {
+	switch name {
+	case "require-pass":
+		acl.UpdateDefaultPassword(config.RequirePass())
+	case "cycle":
+		timewheel.SetInterval(time.Duration(config.Cycle()) * time.Second)
+	case "loglevel":
+		_ = logger.SetLevel(config.LogLevel())
+	}
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"testing"
+
+	"go-redis/config"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+func TestConfigCommand(t *testing.T) {
+	conn := connection.NewFakeConn()
+	defer testServer.AfterClientClose(conn)
+	defer config.Set("require-pass", "", "max-clients", "0")
+
+	result := testServer.Exec(conn, utils.ToCmdLine("config", "get", "max-clients"))
+	if m, ok := result.(*reply.MapReply); !ok || len(m.Entries) != 2 {
+		t.Errorf("unexpected config get result %q", result.Bytes())
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("config", "set", "port", "1"))
+	asserts.AssertErrReply(t, result, "ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config")
+	result = testServer.Exec(conn, utils.ToCmdLine("config", "set", "max-clients"))
+	asserts.AssertErrReply(t, result, "ERR wrong number of arguments for 'config|set' command")
+	result = testServer.Exec(conn, utils.ToCmdLine("config", "foo"))
+	asserts.AssertErrReply(t, result, "ERR unknown subcommand 'FOO'")
+
+	// 修改 require-pass 之后新的连接需要使用新的密码, 已经通过验证的连接不受影响
+	result = testServer.Exec(conn, utils.ToCmdLine("config", "set", "require-pass", "secret", "max-clients", "10"))
+	asserts.AssertStatusReply(t, result, "OK")
+	if config.MaxClients() != 10 {
+		t.Errorf("max-clients should be 10, actually %d", config.MaxClients())
+	}
+	other := connection.NewFakeConn()
+	defer testServer.AfterClientClose(other)
+	result = testServer.Exec(other, utils.ToCmdLine("get", "k"))
+	asserts.AssertErrReply(t, result, "NOAUTH Authentication required")
+	result = testServer.Exec(other, utils.ToCmdLine("auth", "secret"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("config", "set", "require-pass", ""))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(other, utils.ToCmdLine("auth", "secret"))
+	asserts.AssertErrReply(t, result, "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+}
@@ -0,0 +1,214 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/rdb"
+	"go-redis/resp/reply"
+)
+
+// 跨数据库的命令, 包括: COPY, MOVE, SWAPDB, DBSIZE
+//
+// 这些命令需要访问 StandaloneDatabase.dbSet 中的多个数据库, 所以不能注册到cmdTable中, 由 StandaloneDatabase.Exec 直接执行
+
+// lockCrossDB 给两个数据库中的key加写锁, 按数据库下标的顺序加锁防止死锁
+func lockCrossDB(src *DB, srcKey string, dst *DB, dstKey string) {
+	if src.index < dst.index {
+		src.RWLocks([]string{srcKey}, nil)
+		dst.RWLocks([]string{dstKey}, nil)
+	} else {
+		dst.RWLocks([]string{dstKey}, nil)
+		src.RWLocks([]string{srcKey}, nil)
+	}
+}
+
+// unlockCrossDB 释放 lockCrossDB 加的锁
+func unlockCrossDB(src *DB, srcKey string, dst *DB, dstKey string) {
+	src.RWUnLocks([]string{srcKey}, nil)
+	dst.RWUnLocks([]string{dstKey}, nil)
+}
+
+// execCopy 把源key的值复制到目标key中, 目标key可以在另一个数据库中
+//
+// # COPY source destination [DB destination-db] [REPLACE]
+//
+// 复制成功返回1, 否则返回0
+func execCopy(database *StandaloneDatabase, conn resp.Connection, args db.Params) resp.Reply {
+	// 1. 解析参数
+	srcKey, dstKey := utils.Bytes2String(args[0]), utils.Bytes2String(args[1])
+	srcIndex := conn.GetDBIndex()
+	dstIndex, replace := srcIndex, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(utils.Bytes2String(args[i])) {
+		case enum.COPY_DB:
+			if i+1 >= len(args) {
+				return reply.NewSyntaxErrReply()
+			}
+			i++
+			index, err := strconv.Atoi(utils.Bytes2String(args[i]))
+			if err != nil {
+				return reply.NewIntErrReply()
+			}
+			dstIndex = index
+		case enum.COPY_REPLACE:
+			replace = true
+		default:
+			return reply.NewSyntaxErrReply()
+		}
+	}
+	// 2. 选择源数据库和目标数据库
+	src, errReply := database.selectDB(srcIndex)
+	if errReply != nil {
+		return errReply
+	}
+	dst, errReply := database.selectDB(dstIndex)
+	if errReply != nil {
+		return errReply
+	}
+	if src == dst && srcKey == dstKey {
+		return reply.NewErrReply("source and destination objects are the same")
+	}
+	// 3. 加锁
+	if src == dst {
+		src.RWLocks([]string{dstKey}, []string{srcKey})
+		defer src.RWUnLocks([]string{dstKey}, []string{srcKey})
+	} else {
+		lockCrossDB(src, srcKey, dst, dstKey)
+		defer unlockCrossDB(src, srcKey, dst, dstKey)
+	}
+	// 4. 检查源key和目标key
+	entity, ok := src.getEntity(srcKey)
+	if !ok {
+		return reply.NewIntReply(0)
+	}
+	if _, exists := dst.getEntity(dstKey); exists && !replace {
+		return reply.NewIntReply(0)
+	}
+	// 5. 通过序列化和反序列化深拷贝值
+	payload, err := rdb.Dump(entity)
+	if err != nil {
+		return reply.NewErrReplyByError(err)
+	}
+	copied, err := rdb.Restore(payload)
+	if err != nil {
+		return reply.NewErrReplyByError(err)
+	}
+	// 6. 写入目标key, 并复制过期时间
+	dst.Remove(dstKey)
+	dst.putEntity(dstKey, copied)
+	if raw, hasTTL := src.ttl.GetWithLock(srcKey); hasTTL {
+		dst.expire(dstKey, raw.(time.Time))
+	}
+	dst.addVersion(conn, dstKey)
+	// 7. 命令中带有目标数据库, 所以在源数据库中记录aof即可
+	src.append(utils.ToCmdLine2(enum.COPY.String(), args...))
+	dst.notify(notifyGeneric, eventCopyTo, dstKey)
+
+	return reply.NewIntReply(1)
+}
+
+// execMove 把当前数据库的key移动到另一个数据库中
+//
+// # MOVE key db
+//
+// 移动成功返回1, key不存在或者目标数据库中已经有这个key返回0
+func execMove(database *StandaloneDatabase, conn resp.Connection, args db.Params) resp.Reply {
+	// 1. 解析参数
+	key := utils.Bytes2String(args[0])
+	dstIndex, err := strconv.Atoi(utils.Bytes2String(args[1]))
+	if err != nil {
+		return reply.NewIntErrReply()
+	}
+	// 2. 选择源数据库和目标数据库
+	src, errReply := database.selectDB(conn.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	dst, errReply := database.selectDB(dstIndex)
+	if errReply != nil {
+		return errReply
+	}
+	if src == dst {
+		return reply.NewErrReply("source and destination objects are the same")
+	}
+	// 3. 加锁
+	lockCrossDB(src, key, dst, key)
+	defer unlockCrossDB(src, key, dst, key)
+	// 4. 检查key
+	entity, ok := src.getEntity(key)
+	if !ok {
+		return reply.NewIntReply(0)
+	}
+	if _, exists := dst.getEntity(key); exists {
+		return reply.NewIntReply(0)
+	}
+	// 5. 移动值和过期时间
+	raw, hasTTL := src.ttl.GetWithLock(key)
+	src.Remove(key)
+	dst.putEntity(key, entity)
+	if hasTTL {
+		dst.expire(key, raw.(time.Time))
+	}
+	src.addVersion(conn, key)
+	dst.addVersion(conn, key)
+	// 6. 在源数据库中记录aof
+	src.append(utils.ToCmdLine2(enum.MOVE.String(), args...))
+	src.notify(notifyGeneric, eventMoveFrom, key)
+	dst.notify(notifyGeneric, eventMoveTo, key)
+
+	return reply.NewIntReply(1)
+}
+
+// execSwapDB 交换两个数据库的数据, 连接到其中一个数据库的客户端会立即看到另一个数据库的数据
+//
+// # SWAPDB index1 index2
+//
+// 调用者需要持有 StandaloneDatabase 的写锁
+func execSwapDB(database *StandaloneDatabase, conn resp.Connection, args db.Params) resp.Reply {
+	// 1. 解析参数
+	first, err := strconv.Atoi(utils.Bytes2String(args[0]))
+	if err != nil {
+		return reply.NewErrReply("invalid first DB index")
+	}
+	second, err := strconv.Atoi(utils.Bytes2String(args[1]))
+	if err != nil {
+		return reply.NewErrReply("invalid second DB index")
+	}
+	if _, errReply := database.selectDB(first); errReply != nil {
+		return errReply
+	}
+	if _, errReply := database.selectDB(second); errReply != nil {
+		return errReply
+	}
+	if first == second {
+		return reply.NewOKReply()
+	}
+	// 2. 交换数据库, 下标和aof函数属于位置而不是数据, 所以需要换回来
+	a, b := database.dbSet[first], database.dbSet[second]
+	a.index, b.index = b.index, a.index
+	a.append, b.append = b.append, a.append
+	database.dbSet[first], database.dbSet[second] = b, a
+	database.tracker.InvalidateAll()
+	// 3. 记录aof, 通知监听者
+	database.propagate(conn.GetDBIndex(), utils.ToCmdLine2(enum.SWAPDB.String(), args...))
+
+	return reply.NewOKReply()
+}
+
+// execDBSize 返回当前数据库中key的数量
+//
+// # DBSIZE
+func execDBSize(database *StandaloneDatabase, conn resp.Connection) resp.Reply {
+	// 调用者已经持有读锁, 直接读取数据库
+	d, errReply := database.selectDB(conn.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	return reply.NewIntReply(int64(d.data.Len()))
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go-redis/interface/db"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+)
+
+func TestCopy(t *testing.T) {
+	conn := new(connection.FakeConn)
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	testServer.Exec(conn, utils.ToCmdLine("rpush", "src", "a", "b"))
+	testServer.Exec(conn, utils.ToCmdLine("expire", "src", "100"))
+
+	result := testServer.Exec(conn, utils.ToCmdLine("copy", "src", "src"))
+	asserts.AssertErrReply(t, result, "ERR source and destination objects are the same")
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", "src", "dst"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(conn, utils.ToCmdLine("ttl", "dst"))
+	asserts.AssertIntReplyGreaterThan(t, result, 0)
+	// 深拷贝, 修改目标key不影响源key
+	testServer.Exec(conn, utils.ToCmdLine("rpush", "dst", "c"))
+	result = testServer.Exec(conn, utils.ToCmdLine("lrange", "src", "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b"})
+	// 目标key已存在
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", "src", "dst"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", "src", "dst", "replace"))
+	asserts.AssertIntReply(t, result, 1)
+	// 复制到其他数据库
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", "src", "src", "db", "1"))
+	asserts.AssertIntReply(t, result, 1)
+	testServer.Exec(conn, utils.ToCmdLine("select", "1"))
+	result = testServer.Exec(conn, utils.ToCmdLine("lrange", "src", "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b"})
+	result = testServer.Exec(conn, utils.ToCmdLine("copy", "src", "x", "db", "100"))
+	asserts.AssertErrReply(t, result, "ERR DB index is out of range")
+}
+
+func TestMove(t *testing.T) {
+	conn := new(connection.FakeConn)
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	testServer.Exec(conn, utils.ToCmdLine("set", "k", "v"))
+	testServer.Exec(conn, utils.ToCmdLine("expire", "k", "100"))
+
+	result := testServer.Exec(conn, utils.ToCmdLine("move", "k", "0"))
+	asserts.AssertErrReply(t, result, "ERR source and destination objects are the same")
+	result = testServer.Exec(conn, utils.ToCmdLine("move", "k", "2"))
+	asserts.AssertIntReply(t, result, 1)
+	result = testServer.Exec(conn, utils.ToCmdLine("exists", "k"))
+	asserts.AssertIntReply(t, result, 0)
+	result = testServer.Exec(conn, utils.ToCmdLine("move", "k", "2"))
+	asserts.AssertIntReply(t, result, 0)
+
+	testServer.Exec(conn, utils.ToCmdLine("select", "2"))
+	result = testServer.Exec(conn, utils.ToCmdLine("get", "k"))
+	asserts.AssertBulkReply(t, result, "v")
+	result = testServer.Exec(conn, utils.ToCmdLine("ttl", "k"))
+	asserts.AssertIntReplyGreaterThan(t, result, 0)
+	// 目标数据库中已存在
+	testServer.Exec(conn, utils.ToCmdLine("select", "0"))
+	testServer.Exec(conn, utils.ToCmdLine("set", "k", "v0"))
+	result = testServer.Exec(conn, utils.ToCmdLine("move", "k", "2"))
+	asserts.AssertIntReply(t, result, 0)
+}
+
+func TestSwapDBAndDBSize(t *testing.T) {
+	conn := new(connection.FakeConn)
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	testServer.Exec(conn, utils.ToCmdLine("mset", "a", "1", "b", "2"))
+	result := testServer.Exec(conn, utils.ToCmdLine("dbsize"))
+	asserts.AssertIntReply(t, result, 2)
+
+	result = testServer.Exec(conn, utils.ToCmdLine("swapdb", "0", "3"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = testServer.Exec(conn, utils.ToCmdLine("dbsize"))
+	asserts.AssertIntReply(t, result, 0)
+	// 交换后在新的数据库中写入, 下标需要随之交换
+	testServer.Exec(conn, utils.ToCmdLine("select", "3"))
+	result = testServer.Exec(conn, utils.ToCmdLine("get", "a"))
+	asserts.AssertBulkReply(t, result, "1")
+	asserts.Assert(testServer.dbSet[3].index == 3 && testServer.dbSet[0].index == 0)
+
+	result = testServer.Exec(conn, utils.ToCmdLine("swapdb", "x", "3"))
+	asserts.AssertErrReply(t, result, "ERR invalid first DB index")
+	result = testServer.Exec(conn, utils.ToCmdLine("swapdb", "0", "100"))
+	asserts.AssertErrReply(t, result, "ERR DB index is out of range")
+
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	result = testServer.Exec(conn, utils.ToCmdLine("swapdb", "0", "3"))
+	asserts.AssertErrReply(t, result, "ERR command 'SWAPDB' cannot be used in MULTI")
+	testServer.Exec(conn, utils.ToCmdLine("discard"))
+}
+
+func TestSwapDBConcurrentAccess(t *testing.T) {
+	conn := new(connection.FakeConn)
+	conn.SelectDB(5)
+	testServer.Exec(conn, utils.ToCmdLine("set", "swap:a", "1"))
+	testServer.Exec(conn, utils.ToCmdLine("pexpire", "swap:a", "100000"))
+	conn.SelectDB(6)
+	testServer.Exec(conn, utils.ToCmdLine("set", "swap:a", "2"))
+	testServer.Exec(conn, utils.ToCmdLine("pexpire", "swap:a", "100000"))
+
+	// 按下标访问数据库的同时交换数据库, 每次都能看到完整的一个数据库
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			testServer.Exec(new(connection.FakeConn), utils.ToCmdLine("swapdb", "5", "6"))
+		}
+	}()
+	for swapping := true; swapping; {
+		select {
+		case <-done:
+			swapping = false
+		default:
+		}
+		if _, ok := testServer.GetEntity(6, "swap:a"); !ok {
+			t.Fatal("key should exist in both databases")
+		}
+		if testServer.GetExpiration(5, "swap:a") == nil {
+			t.Fatal("key should have expiration")
+		}
+		testServer.ForEach(6, func(string, *db.DataEntity, *time.Time) bool { return true })
+	}
+	testServer.Exec(conn, utils.ToCmdLine("del", "swap:a"))
+	conn.SelectDB(5)
+	testServer.Exec(conn, utils.ToCmdLine("del", "swap:a"))
+}
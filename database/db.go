@@ -9,10 +9,14 @@ import (
 	"go-redis/enum"
 	"go-redis/interface/db"
 	"go-redis/interface/resp"
+	"go-redis/latency"
 	"go-redis/lib/logger"
 	"go-redis/lib/timewheel"
 	"go-redis/lib/utils"
+	"go-redis/pubsub"
 	"go-redis/resp/reply"
+	"go-redis/slowlog"
+	"go-redis/tracking"
 )
 
 // execFunc 是执行命令的函数
@@ -39,6 +43,9 @@ type DB struct {
 	ttl        dict.Dict        // key : expireTime
 	versionMap dict.Dict        // key : version
 	append     func(db.CmdLine) // 添加一行命令到aof文件
+	hub        *pubsub.Hub      // 发送键空间通知, 为nil时不发送
+	tracker    *tracking.Table  // 客户端缓存的失效消息, 为nil时不发送
+	slowLog    bool             // 是否记录慢查询, 集群中由路由记录
 }
 
 // Exec 单体数据库执行命令, 并发安全
@@ -65,7 +72,12 @@ func (d *DB) Exec(conn resp.Connection, cmd db.CmdLine) resp.Reply {
 		if !ValidateArity(enum.TX_EXEC.Arity(), cmd) {
 			return reply.NewArgNumErrReply(cmdName)
 		}
-		return execMulti(d, conn)
+		start := time.Now()
+		r := execMulti(d, conn)
+		if d.slowLog {
+			slowlog.Record(conn, cmd, start)
+		}
+		return r
 	case enum.TX_WATCH.String(): // 执行watch命令
 		if !ValidateArity(enum.TX_WATCH.Arity(), cmd) {
 			return reply.NewArgNumErrReply(cmdName)
@@ -82,7 +94,7 @@ func (d *DB) Exec(conn resp.Connection, cmd db.CmdLine) resp.Reply {
 	if conn != nil && conn.InMultiState() && cmdName != enum.PING.String() {
 		return EnqueueCmd(conn, cmd)
 	}
-	return d.execWithLock(cmd)
+	return d.execWithClient(conn, cmd)
 }
 
 func execMulti(d *DB, conn resp.Connection) resp.Reply {
@@ -115,6 +127,7 @@ func (d *DB) execMulti(conn resp.Connection, cmdLines []db.CmdLine) resp.Reply {
 	// 1. 准备事务中的命令会进行读写的键
 	writeKeys := make([]string, 0)
 	readKeys := make([]string, 0)
+	trackedKeys := make([]string, 0) // 只读命令读取的键, 用于客户端缓存
 
 	for _, cmdLine := range cmdLines {
 		cmdName := strings.ToLower(utils.Bytes2String(cmdLine[0]))
@@ -122,6 +135,9 @@ func (d *DB) execMulti(conn resp.Connection, cmdLines []db.CmdLine) resp.Reply {
 		writes, reads := cmd.prepare(cmdLine[1:])
 		writeKeys = append(writeKeys, writes...)
 		readKeys = append(readKeys, reads...)
+		if len(writes) == 0 {
+			trackedKeys = append(trackedKeys, reads...)
+		}
 	}
 	// 2. 对要观察的键设置读锁
 	watching := conn.GetWatching()
@@ -156,9 +172,10 @@ func (d *DB) execMulti(conn resp.Connection, cmdLines []db.CmdLine) resp.Reply {
 		}
 		results = append(results, result)
 	}
-	// 6. 如果事务执行成功, 把修改的key的版本号加1
+	// 6. 如果事务执行成功, 把修改的key的版本号加1, 并记录读取的key
 	if !aborted {
-		d.addVersion(writeKeys...)
+		d.addVersion(conn, writeKeys...)
+		d.trackKeys(conn, trackedKeys)
 		return reply.NewMultiRawReply(results)
 	}
 	// 7. 如果事务失败, 执行undo logs
@@ -222,6 +239,11 @@ func startMulti(conn resp.Connection) resp.Reply {
 
 // execWithLock 执行命令
 func (d *DB) execWithLock(cmd db.CmdLine) resp.Reply {
+	return d.execWithClient(nil, cmd)
+}
+
+// execWithClient 执行命令, client是发送命令的客户端, 用于客户端缓存, 可以为nil
+func (d *DB) execWithClient(client resp.Connection, cmd db.CmdLine) resp.Reply {
 	if len(cmd) == 0 {
 		return reply.NewNoReply()
 	}
@@ -240,10 +262,18 @@ func (d *DB) execWithLock(cmd db.CmdLine) resp.Reply {
 	writeKeys, readKeys := com.prepare(cmd[1:])
 	d.RWLocks(writeKeys, readKeys)
 	defer d.RWUnLocks(writeKeys, readKeys)
-	// 5. 如果命令执行成功, 要修改的键的版本号加1
+	// 5. 执行命令, 记录不包括等待锁的执行时间; 如果命令执行成功, 要修改的键的版本号加1
+	start := time.Now()
 	r := com.executor(d, cmd[1:])
+	if d.slowLog {
+		slowlog.Record(client, cmd, start)
+	}
 	if intReply, ok := r.(*reply.IntReply); !reply.IsErrReply(r) || (ok && intReply.Code() != 0) {
-		d.addVersion(writeKeys...)
+		d.addVersion(client, writeKeys...)
+	}
+	// 6. 只读命令要记录客户端读取的键, 在释放锁之前记录, 保证不会错过其他客户端的修改
+	if len(writeKeys) == 0 && !reply.IsErrReply(r) {
+		d.trackKeys(client, readKeys)
 	}
 	return r
 }
@@ -268,6 +298,16 @@ func (d *DB) exec(cmd db.CmdLine) resp.Reply {
 	return com.executor(d, cmd[1:])
 }
 
+// execWithoutLock 执行命令, 调用者已经给相关的key上锁; 执行成功时修改的key的版本号加1
+func (d *DB) execWithoutLock(client resp.Connection, cmd db.CmdLine) resp.Reply {
+	r := d.exec(cmd)
+	if intReply, ok := r.(*reply.IntReply); !reply.IsErrReply(r) || (ok && intReply.Code() != 0) {
+		writeKeys, _ := GetRelatedKeys(cmd)
+		d.addVersion(client, writeKeys...)
+	}
+	return r
+}
+
 /*
 处理DataEntity的方法
 包括: get, set, remove, putIfExists, putIfAbsent
@@ -372,7 +412,9 @@ func (d *DB) expire(key string, expireTime time.Time) {
 
 		logger.Info("expire", key)
 		// 2.2 双重检查, 防止key的ttl更新
+		start := time.Now()
 		d.expireIfNeeded(key)
+		latency.AddSampleIfNeeded(latency.EventExpireDel, time.Since(start))
 	})
 }
 
@@ -383,6 +425,12 @@ func (d *DB) persist(key string) {
 	timewheel.Cancel(taskKey)
 }
 
+// isExpired 判断key是否已经过期, 不删除过期的key, 用于没有给key上锁的只读命令
+func (d *DB) isExpired(key string) bool {
+	t, exist := d.ttl.Get(key)
+	return exist && time.Now().After(t.(time.Time))
+}
+
 // expireIfNeeded 检查key是否过期, 如果过期了就删除, 并发不安全
 //
 // 如果key过期了就删除然后返回true, key不存在、没有过期时间 或者 有过期时间但是没过期 返回false
@@ -395,6 +443,10 @@ func (d *DB) expireIfNeeded(key string) bool {
 	isExpire := time.Now().After(expireTime)
 	if isExpire {
 		d.Remove(key)
+		d.notify(notifyExpired, eventExpired, key)
+		if d.tracker != nil {
+			d.tracker.Invalidate(nil, key)
+		}
 	}
 	return isExpire
 }
@@ -432,12 +484,24 @@ func (d *DB) getVersion(key string) uint32 {
 	return entity.(uint32)
 }
 
-// addVersion 把key的版本号加1, 并发不安全
-func (d *DB) addVersion(keys ...string) {
+// addVersion 把key的版本号加1, 并给缓存了这些key的客户端发送失效消息, 并发不安全
+//
+// caller 是修改key的客户端, 用于 CLIENT TRACKING 的 NOLOOP 选项, 可以为nil
+func (d *DB) addVersion(caller resp.Connection, keys ...string) {
 	for _, key := range keys {
 		versionCode := d.getVersion(key)
 		d.versionMap.Set(key, versionCode+1)
 	}
+	if d.tracker != nil {
+		d.tracker.Invalidate(caller, keys...)
+	}
+}
+
+// trackKeys 记录开启了 CLIENT TRACKING 的客户端读取的key
+func (d *DB) trackKeys(client resp.Connection, keys []string) {
+	if d.tracker != nil && client != nil {
+		d.tracker.RememberKeys(client, keys)
+	}
 }
 
 func (d *DB) ForEach(cb func(key string, data *db.DataEntity, expiration *time.Time) bool) {
@@ -461,5 +525,8 @@ func newDB(index int) *DB {
 		dict.NewConcurrentDict(config.Properties.Buckets >> 6),
 		dict.NewConcurrentDict(config.Properties.Buckets),
 		func(db.CmdLine) {},
+		nil,
+		nil,
+		false,
 	}
 }
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/rdb"
+	"go-redis/resp/reply"
+)
+
+// execDump 把key对应的值序列化为带版本号和校验和的二进制格式
+//
+// # DUMP key
+//
+// 返回: 序列化后的值, key不存在返回nil
+func execDump(d *DB, args db.Params) resp.Reply {
+	key := utils.Bytes2String(args[0])
+	entity, ok := d.getEntity(key)
+	if !ok {
+		return reply.NewNullBulkReply()
+	}
+	payload, err := rdb.Dump(entity)
+	if err != nil {
+		return reply.NewErrReplyByError(err)
+	}
+	return reply.NewBulkReply(payload)
+}
+
+// execRestore 把DUMP得到的值反序列化后存储到key中
+//
+// # RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+//
+// ttl为0表示不设置过期时间, 否则为毫秒数; 带ABSTTL时ttl为过期时间的unix时间戳(ms).
+// 服务器不记录LRU/LFU信息, 所以IDLETIME和FREQ只做参数校验
+//
+// 返回: 成功返回OK, key已存在且没有REPLACE时返回BUSYKEY错误
+func execRestore(d *DB, args db.Params) resp.Reply {
+	// 1. 解析参数
+	key := utils.Bytes2String(args[0])
+	ttl, err := strconv.ParseInt(utils.Bytes2String(args[1]), 10, 64)
+	if err != nil {
+		return reply.NewIntErrReply()
+	}
+	if ttl < 0 {
+		return reply.NewErrReply("Invalid TTL value, must be >= 0")
+	}
+	replace, absTTL := false, false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(utils.Bytes2String(args[i])) {
+		case enum.RESTORE_REPLACE:
+			replace = true
+		case enum.RESTORE_ABSTTL:
+			absTTL = true
+		case enum.RESTORE_IDLETIME:
+			if i+1 >= len(args) {
+				return reply.NewSyntaxErrReply()
+			}
+			i++
+			idle, err := strconv.ParseInt(utils.Bytes2String(args[i]), 10, 64)
+			if err != nil {
+				return reply.NewIntErrReply()
+			}
+			if idle < 0 {
+				return reply.NewErrReply("Invalid IDLETIME value, must be >= 0")
+			}
+		case enum.RESTORE_FREQ:
+			if i+1 >= len(args) {
+				return reply.NewSyntaxErrReply()
+			}
+			i++
+			freq, err := strconv.ParseInt(utils.Bytes2String(args[i]), 10, 64)
+			if err != nil {
+				return reply.NewIntErrReply()
+			}
+			if freq < 0 || freq > 255 {
+				return reply.NewErrReply("Invalid FREQ value, must be >= 0 and <= 255")
+			}
+		default:
+			return reply.NewSyntaxErrReply()
+		}
+	}
+	// 2. 检查key是否已经存在
+	if _, exists := d.getEntity(key); exists && !replace {
+		return &reply.NormalErrReply{Status: "BUSYKEY Target key name already exists."}
+	}
+	// 3. 反序列化
+	entity, err := rdb.Restore(args[2])
+	if err != nil {
+		return reply.NewErrReplyByError(err)
+	}
+	// 4. 计算过期时间, 已经过期的值不需要存储
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = utils.If(absTTL, time.UnixMilli(ttl), time.Now().Add(time.Duration(ttl)*time.Millisecond))
+		if !expireAt.After(time.Now()) {
+			if d.removes(key) > 0 {
+				d.notify(notifyGeneric, eventDel, key)
+			}
+			return reply.NewOKReply()
+		}
+	}
+	// 5. 存储值和过期时间
+	d.Remove(key)
+	d.putEntity(key, entity)
+	ttlArg := "0"
+	if ttl > 0 {
+		d.expire(key, expireAt)
+		ttlArg = strconv.FormatInt(expireAt.UnixMilli(), 10)
+	}
+	// 6. aof中统一使用绝对过期时间, 防止重放时过期时间被推迟
+	d.append(utils.ToCmdLine2(enum.RESTORE.String(), args[0], utils.String2Bytes(ttlArg), args[2],
+		utils.String2Bytes(enum.RESTORE_REPLACE), utils.String2Bytes(enum.RESTORE_ABSTTL)))
+	d.notify(notifyGeneric, eventRestore, key)
+
+	return reply.NewOKReply()
+}
+
+func init() {
+	registerCommand(enum.DUMP, readFirstKey, execDump, nil)
+	registerCommand(enum.RESTORE, writeFirstKey, execRestore, rollbackFirstKey)
+}
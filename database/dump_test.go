@@ -0,0 +1,48 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+func TestDumpAndRestore(t *testing.T) {
+	d := newDB(0)
+	d.execWithLock(utils.ToCmdLine("rpush", "list", "a", "1", "b"))
+	dumpReply := d.execWithLock(utils.ToCmdLine("dump", "list"))
+	payload, ok := dumpReply.(*reply.BulkReply)
+	if !ok {
+		t.Fatalf("expected bulk reply, actually %s", dumpReply.Bytes())
+	}
+
+	// key已存在, 需要REPLACE
+	r := d.execWithLock(utils.ToCmdLine2("restore", []byte("list"), []byte("0"), payload.Arg))
+	asserts.AssertErrReply(t, r, "BUSYKEY Target key name already exists.")
+
+	r = d.execWithLock(utils.ToCmdLine2("restore", []byte("list2"), []byte("100000"), payload.Arg))
+	asserts.AssertStatusReply(t, r, "OK")
+	r = d.execWithLock(utils.ToCmdLine("lrange", "list2", "0", "-1"))
+	asserts.AssertMultiBulkReply(t, r, []string{"a", "1", "b"})
+	r = d.execWithLock(utils.ToCmdLine("pttl", "list2"))
+	asserts.AssertIntReplyGreaterThan(t, r, 0)
+
+	// ABSTTL 使用绝对时间, 已经过期的值不会被存储
+	expired := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	r = d.execWithLock(utils.ToCmdLine2("restore", []byte("list3"), []byte(expired), payload.Arg, []byte("ABSTTL")))
+	asserts.AssertStatusReply(t, r, "OK")
+	r = d.execWithLock(utils.ToCmdLine("exists", "list3"))
+	asserts.AssertIntReply(t, r, 0)
+
+	// 载荷被篡改
+	broken := append([]byte{}, payload.Arg...)
+	broken[len(broken)-1] ^= 0xFF
+	r = d.execWithLock(utils.ToCmdLine2("restore", []byte("list4"), []byte("0"), broken))
+	asserts.AssertErrReply(t, r, "ERR DUMP payload version or checksum are wrong")
+
+	r = d.execWithLock(utils.ToCmdLine("dump", "none"))
+	asserts.AssertNullBulk(t, r)
+}
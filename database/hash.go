@@ -56,6 +56,7 @@ func execHSet(d *DB, args db.Params) resp.Reply {
 
 	result := hashTable.Set(field, value)
 	d.append(utils.ToCmdLine2(enum.HSET.String(), args...))
+	d.notify(notifyHash, eventHSet, key)
 	return reply.NewIntReply(int64(result))
 }
 
@@ -83,7 +84,7 @@ func execHSetNX(d *DB, args db.Params) resp.Reply {
 	result := hashTable.PutIfAbsent(field, value)
 	if result > 0 {
 		d.append(utils.ToCmdLine2(enum.HSETNX.String(), args...))
-
+		d.notify(notifyHash, eventHSet, key)
 	}
 	return reply.NewIntReply(int64(result))
 }
@@ -164,11 +165,13 @@ func execHDel(d *DB, args db.Params) resp.Reply {
 		result := hashTable.Remove(field)
 		deleted += result
 	}
-	if hashTable.Len() == 0 {
-		d.Remove(key)
-	}
 	if deleted > 0 {
 		d.append(utils.ToCmdLine2(enum.HDEL.String(), args...))
+		d.notify(notifyHash, eventHDel, key)
+	}
+	if hashTable.Len() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
 
 	return reply.NewIntReply(int64(deleted))
@@ -224,6 +227,7 @@ func execHMSet(d *DB, args db.Params) resp.Reply {
 		hashTable.Set(field, value)
 	}
 	d.append(utils.ToCmdLine2(enum.HMSET.String(), args...))
+	d.notify(notifyHash, eventHSet, key)
 	return reply.NewOKReply()
 }
 
@@ -289,7 +293,7 @@ func execHGetAll(d *DB, args db.Params) resp.Reply {
 		return errReply
 	}
 	if hashTable == nil {
-		return reply.NewEmptyMultiBulkReply()
+		return reply.NewBulkMapReply(nil)
 	}
 
 	entries := make([][]byte, 0, hashTable.Len()*2)
@@ -298,7 +302,7 @@ func execHGetAll(d *DB, args db.Params) resp.Reply {
 		return true
 	})
 
-	return reply.NewMultiBulkReply(entries)
+	return reply.NewBulkMapReply(entries)
 }
 
 // execHMGet 命令用于返回哈希表中，一个或多个给定字段的值。
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-redis/config"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+)
+
+// startTime 服务器启动的时间, 用于计算运行时间
+var startTime = time.Now()
+
+// infoSections INFO命令默认返回的部分, 按顺序输出
+var infoSections = []string{"server", "memory", "persistence", "keyspace"}
+
+// execInfo 返回服务器的信息, 没有参数或者参数为all, default, everything时返回所有部分
+//
+// # INFO [section [section ...]]
+//
+// 返回: 文本格式的信息, RESP3中是verbatim类型
+func execInfo(database *StandaloneDatabase, args db.Params) resp.Reply {
+	// 1. 选择要输出的部分
+	sections := infoSections
+	if len(args) > 0 {
+		sections = make([]string, 0, len(args))
+		for _, arg := range args {
+			section := strings.ToLower(string(arg))
+			if section == "all" || section == "default" || section == "everything" {
+				sections = infoSections
+				break
+			}
+			sections = append(sections, section)
+		}
+	}
+	// 2. 生成每个部分的信息
+	var builder strings.Builder
+	for _, section := range sections {
+		var lines []string
+		switch section {
+		case "server":
+			lines = serverInfo()
+		case "memory":
+			lines = memoryInfo()
+		case "persistence":
+			lines = persistenceInfo()
+		case "keyspace":
+			lines = database.keyspaceInfo()
+		default:
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString("# " + strings.ToUpper(section[:1]) + section[1:] + "\r\n")
+		for _, line := range lines {
+			builder.WriteString(line + "\r\n")
+		}
+	}
+	return reply.NewVerbatimReply("txt", []byte(builder.String()))
+}
+
+func serverInfo() []string {
+	uptime := int64(time.Since(startTime).Seconds())
+	mode := "standalone"
+	if len(config.Properties.Peers) > 0 {
+		mode = "cluster"
+	}
+	return []string{
+		"redis_version:" + serverVersion,
+		"redis_mode:" + mode,
+		"os:" + runtime.GOOS + " " + runtime.GOARCH,
+		"arch_bits:" + strconv.Itoa(strconv.IntSize),
+		"go_version:" + runtime.Version(),
+		"process_id:" + strconv.Itoa(os.Getpid()),
+		"tcp_port:" + strconv.Itoa(config.Properties.Port),
+		"uptime_in_seconds:" + strconv.FormatInt(uptime, 10),
+		"uptime_in_days:" + strconv.FormatInt(uptime/86400, 10),
+	}
+}
+
+func memoryInfo() []string {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return []string{
+		"used_memory:" + strconv.FormatUint(stats.HeapAlloc, 10),
+		"used_memory_rss:" + strconv.FormatUint(stats.Sys, 10),
+	}
+}
+
+func persistenceInfo() []string {
+	aofEnabled := 0
+	if config.Properties.AppendOnly {
+		aofEnabled = 1
+	}
+	return []string{
+		"aof_enabled:" + strconv.Itoa(aofEnabled),
+	}
+}
+
+// keyspaceInfo 返回每个非空数据库的key数量和设置了过期时间的key数量
+func (database *StandaloneDatabase) keyspaceInfo() []string {
+	lines := make([]string, 0)
+	for i, d := range database.dbSet {
+		keys, expires := d.data.Len(), d.ttl.Len()
+		if keys == 0 {
+			continue
+		}
+		lines = append(lines, "db"+strconv.Itoa(i)+":keys="+strconv.Itoa(keys)+",expires="+strconv.Itoa(expires)+",avg_ttl=0")
+	}
+	return lines
+}
@@ -2,9 +2,9 @@ package database
 
 import (
 	"strconv"
+	"strings"
 	"time"
 
-	"go-redis/aof"
 	"go-redis/datastruct/dict"
 	"go-redis/datastruct/list"
 	"go-redis/datastruct/set"
@@ -15,21 +15,26 @@ import (
 	"go-redis/interface/resp"
 	"go-redis/lib/utils"
 	"go-redis/lib/wildcard"
-	"go-redis/resp/parser"
 	"go-redis/resp/reply"
 )
 
+// randomKeyMaxTries RANDOMKEY随机到过期的key时的最大重试次数
+const randomKeyMaxTries = 16
+
 // execDel 删除多个key
 //
 // # del key1 key2, 例如: del jack john
 //
 // 返回删除掉的key的数量
 func execDel(d *DB, args db.Params) resp.Reply {
-	keys := make([]string, 0, len(args))
+	n := 0
 	for _, arg := range args {
-		keys = append(keys, utils.Bytes2String(arg))
+		key := utils.Bytes2String(arg)
+		if d.removes(key) > 0 {
+			d.notify(notifyGeneric, eventDel, key)
+			n++
+		}
 	}
-	n := d.removes(keys...)
 
 	if n > 0 {
 		d.append(utils.ToCmdLine2(enum.DEL.String(), args...))
@@ -61,10 +66,66 @@ func execExists(d *DB, args db.Params) resp.Reply {
 	return reply.NewIntReply(n)
 }
 
+// execUnlink 与DEL相同, 但是大的数据由后台协程释放, 不阻塞当前协程
+//
+// # unlink key1 key2, 例如: unlink jack john
+//
+// 返回删除掉的key的数量
+func execUnlink(d *DB, args db.Params) resp.Reply {
+	n := 0
+	for _, arg := range args {
+		key := utils.Bytes2String(arg)
+		entity, ok := d.getEntity(key)
+		if !ok {
+			continue
+		}
+		d.Remove(key)
+		lazyFree(entity)
+		d.notify(notifyGeneric, eventDel, key)
+		n++
+	}
+
+	if n > 0 {
+		d.append(utils.ToCmdLine2(enum.DEL.String(), args...))
+	}
+
+	return reply.NewIntReply(int64(n))
+}
+
+// execTouch 返回存在的key的数量. 服务器不记录key的访问时间, 所以只检查key是否存在
+//
+// # touch key1 key2, 例如: touch jack john
+func execTouch(d *DB, args db.Params) resp.Reply {
+	return execExists(d, args)
+}
+
+// execRandomKey 随机返回一个没有过期的key
+//
+// # randomkey
+//
+// 数据库为空时返回nil
+func execRandomKey(d *DB, _ db.Params) resp.Reply {
+	// 随机到过期的key时重新随机, 最多尝试randomKeyMaxTries次
+	// 命令没有给key上锁, 只跳过过期的key, 由惰性删除和定期删除负责删除
+	for i := 0; i < randomKeyMaxTries && d.data.Len() > 0; i++ {
+		keys := d.data.RandomKeys(1)
+		if len(keys) == 0 {
+			break
+		}
+		if !d.isExpired(keys[0]) {
+			return reply.NewBulkReply(utils.String2Bytes(keys[0]))
+		}
+	}
+	return reply.NewNullBulkReply()
+}
+
 // execFlushDB deletes all the keys.
 func execFlushDB(d *DB, _ db.Params) resp.Reply {
 	d.Flush()
 	d.append(utils.ToCmdLine(enum.FLUSHDB.String()))
+	if d.tracker != nil {
+		d.tracker.InvalidateAll()
+	}
 
 	return reply.NewOKReply()
 }
@@ -121,6 +182,8 @@ func execRename(d *DB, args db.Params) resp.Reply {
 	d.removes(src)
 	// 7. 添加一个命令到aof文件
 	d.append(utils.ToCmdLine2(enum.RENAME.String(), args...))
+	d.notify(notifyGeneric, eventRenameFrom, src)
+	d.notify(notifyGeneric, eventRenameTo, dst)
 
 	return reply.NewOKReply()
 }
@@ -162,6 +225,8 @@ func execRenameNX(d *DB, args db.Params) resp.Reply {
 	}
 	// 8. 此命令加入aof
 	d.append(utils.ToCmdLine2(enum.RENAMENX.String(), args...))
+	d.notify(notifyGeneric, eventRenameFrom, src)
+	d.notify(notifyGeneric, eventRenameTo, dst)
 
 	return reply.NewIntReply(1)
 }
@@ -181,15 +246,23 @@ func execExpire(d *DB, args db.Params) resp.Reply {
 		return reply.NewIntErrReply()
 	}
 	ttl := time.Duration(ttlInt64) * time.Second
+	flags, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
+	}
 	// 3. 查询key是否存在
 	_, ok := d.getEntity(key)
 	if !ok {
 		return reply.NewIntReply(0)
 	}
-	// 4. 计算过期时间并设置给key
+	// 4. 计算过期时间, 检查NX/XX/GT/LT条件后设置给key
 	expireTime := time.Now().Add(ttl)
+	if !d.canExpire(key, expireTime, flags) {
+		return reply.NewIntReply(0)
+	}
 	d.expire(key, expireTime)
 	d.append(utils.ToCmdLine2(enum.EXPIRE.String(), args...))
+	d.notify(notifyGeneric, eventExpire, key)
 
 	return reply.NewIntReply(1)
 }
@@ -210,6 +283,10 @@ func execExpireAt(d *DB, args db.Params) resp.Reply {
 	// 1. 取出key和timestamp
 	key := utils.Bytes2String(args[0])
 	expireAtStr := utils.Bytes2String(args[1])
+	flags, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
+	}
 	// 2. 检查key是否存在
 	_, ok := d.getEntity(key)
 	if !ok {
@@ -221,9 +298,13 @@ func execExpireAt(d *DB, args db.Params) resp.Reply {
 		return reply.NewIntErrReply()
 	}
 	expireAt := time.Unix(expireAtInt64, 0)
-	// 4. 设置key的过期时间
+	// 4. 检查NX/XX/GT/LT条件后设置key的过期时间
+	if !d.canExpire(key, expireAt, flags) {
+		return reply.NewIntReply(0)
+	}
 	d.expire(key, expireAt)
 	d.append(utils.ToCmdLine2(enum.EXPIREAT.String(), args...))
+	d.notify(notifyGeneric, eventExpire, key)
 
 	return reply.NewIntReply(1)
 }
@@ -291,15 +372,23 @@ func execPExpire(d *DB, args db.Params) resp.Reply {
 		return reply.NewErrReply("value is not an integer or out of range")
 	}
 	ttl := time.Duration(ttlInt64) * time.Millisecond
+	flags, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
+	}
 	// 3. 查询key是否存在
 	_, ok := d.getEntity(key)
 	if !ok {
 		return reply.NewIntReply(0)
 	}
-	// 4. 计算过期时间并设置给key
+	// 4. 计算过期时间, 检查NX/XX/GT/LT条件后设置给key
 	expireTime := time.Now().Add(ttl)
+	if !d.canExpire(key, expireTime, flags) {
+		return reply.NewIntReply(0)
+	}
 	d.expire(key, expireTime)
 	d.append(utils.ToCmdLine2(enum.PEXPIRE.String(), args...))
+	d.notify(notifyGeneric, eventExpire, key)
 
 	return reply.NewIntReply(1)
 }
@@ -313,6 +402,10 @@ func execPExpireAt(d *DB, args db.Params) resp.Reply {
 	// 1. 取出key和timestamp
 	key := utils.Bytes2String(args[0])
 	expireAtStr := utils.Bytes2String(args[1])
+	flags, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
+	}
 	// 2. 检查key是否存在
 	_, ok := d.getEntity(key)
 	if !ok {
@@ -324,9 +417,13 @@ func execPExpireAt(d *DB, args db.Params) resp.Reply {
 		return reply.NewErrReply("value is not an integer or out of range")
 	}
 	expireAt := time.UnixMilli(expireAtInt64)
-	// 4. 设置key的过期时间
+	// 4. 检查NX/XX/GT/LT条件后设置key的过期时间
+	if !d.canExpire(key, expireAt, flags) {
+		return reply.NewIntReply(0)
+	}
 	d.expire(key, expireAt)
 	d.append(utils.ToCmdLine2(enum.PEXPIREAT.String(), args...))
+	d.notify(notifyGeneric, eventExpire, key)
 
 	return reply.NewIntReply(1)
 }
@@ -412,10 +509,64 @@ func execPersist(d *DB, args db.Params) resp.Reply {
 
 	d.persist(key)
 	d.append(utils.ToCmdLine2(enum.PERSIST.String(), args...))
+	d.notify(notifyGeneric, eventPersist, key)
 
 	return reply.NewIntReply(1)
 }
 
+// expireFlag EXPIRE系列命令的NX/XX/GT/LT选项
+type expireFlag uint8
+
+const (
+	expireNX expireFlag = 1 << iota // 只有key没有过期时间时才设置
+	expireXX                        // 只有key有过期时间时才设置
+	expireGT                        // 只有新的过期时间大于当前的过期时间时才设置
+	expireLT                        // 只有新的过期时间小于当前的过期时间时才设置
+)
+
+// parseExpireFlags 解析EXPIRE系列命令过期时间之后的选项
+func parseExpireFlags(args db.Params) (flags expireFlag, errReply resp.ErrorReply) {
+	for _, arg := range args {
+		switch strings.ToUpper(utils.Bytes2String(arg)) {
+		case enum.EXPIRE_NX:
+			flags |= expireNX
+		case enum.EXPIRE_XX:
+			flags |= expireXX
+		case enum.EXPIRE_GT:
+			flags |= expireGT
+		case enum.EXPIRE_LT:
+			flags |= expireLT
+		default:
+			return 0, reply.NewErrReply("Unsupported option " + string(arg))
+		}
+	}
+	if flags&expireNX != 0 && flags&(expireXX|expireGT|expireLT) != 0 {
+		return 0, reply.NewErrReply("NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if flags&expireGT != 0 && flags&expireLT != 0 {
+		return 0, reply.NewErrReply("GT and LT options at the same time are not compatible")
+	}
+	return flags, nil
+}
+
+// canExpire 检查是否满足选项的条件, 没有过期时间的key视为过期时间无穷大. 并发不安全
+func (d *DB) canExpire(key string, expireTime time.Time, flags expireFlag) bool {
+	raw, hasTTL := d.ttl.GetWithLock(key)
+	switch {
+	case flags&expireNX != 0:
+		return !hasTTL
+	case flags&expireXX != 0 && !hasTTL:
+		return false
+	}
+	if flags&expireGT != 0 {
+		return hasTTL && expireTime.After(raw.(time.Time))
+	}
+	if flags&expireLT != 0 {
+		return !hasTTL || expireTime.Before(raw.(time.Time))
+	}
+	return true
+}
+
 // toTTLCmd 判断数据是否有过期时间, 如果有则返回过期的命令
 func toTTLCmd(d *DB, key string) *reply.MultiBulkReply {
 	raw, exists := d.ttl.GetWithLock(key)
@@ -428,60 +579,12 @@ func toTTLCmd(d *DB, key string) *reply.MultiBulkReply {
 	return reply.NewMultiBulkReply(utils.ToCmdLine(enum.PEXPIREAT.String(), key, timestamp))
 }
 
-// execDumpKey returns redis serialization protocol data of given key (see aof.EntityToCmd)
-func execDumpKey(d *DB, args db.CmdLine) resp.Reply {
-	key := utils.Bytes2String(args[0])
-	entity, ok := d.getEntity(key)
-	if !ok {
-		return reply.NewEmptyMultiBulkReply()
-	}
-	dumpCmd := aof.Entity2Cmd(key, entity)
-	ttlCmd := toTTLCmd(d, key)
-	return reply.NewMultiBulkReply([][]byte{
-		dumpCmd.Bytes(),
-		ttlCmd.Bytes(),
-	})
-}
-
 // execRenameFrom 删除旧的key
 func execRenameFrom(d *DB, args [][]byte) resp.Reply {
 	key := utils.Bytes2String(args[0])
 	d.Remove(key)
-	return reply.NewOKReply()
-}
-
-// execRenameTo 把旧key对应的值存储到新key中, 必须使用事务保证一致性
-// # RENAMETO key dumpCmd ttlCmd
-func execRenameTo(d *DB, args db.CmdLine) resp.Reply {
-	key := args[0]
-	dumpRawCmd, err := parser.ParseOne(args[1])
-	if err != nil {
-		return reply.NewErrReply("illegal dump cmd: " + err.Error())
-	}
-	dumpCmd, ok := dumpRawCmd.(*reply.MultiBulkReply)
-	if !ok {
-		return reply.NewErrReply("dump cmd is not multi bulk reply")
-	}
-	dumpCmd.Args[1] = key // change key
-	ttlRawCmd, err := parser.ParseOne(args[2])
-	if err != nil {
-		return reply.NewErrReply("illegal ttl cmd: " + err.Error())
-	}
-	ttlCmd, ok := ttlRawCmd.(*reply.MultiBulkReply)
-	if !ok {
-		return reply.NewErrReply("ttl cmd is not multi bulk reply")
-	}
-	ttlCmd.Args[1] = key
-	d.Remove(string(key))
-	// 在cluster层已经给数据上锁了
-	dumpResult := d.exec(dumpCmd.Args)
-	if reply.IsErrReply(dumpResult) {
-		return dumpResult
-	}
-	ttlResult := d.exec(ttlCmd.Args)
-	if reply.IsErrReply(ttlResult) {
-		return ttlResult
-	}
+	d.append(utils.ToCmdLine2(enum.DEL.String(), args...))
+	d.notify(notifyGeneric, eventRenameFrom, key)
 	return reply.NewOKReply()
 }
 
@@ -501,8 +604,9 @@ func init() {
 	registerCommand(enum.PEXPIREAT, writeFirstKey, execPExpireAt, undoExpire)
 	registerCommand(enum.PEXPIRETIME, readFirstKey, execPExpireTime, nil)
 	registerCommand(enum.PTTL, readFirstKey, execPTTL, nil)
+	registerCommand(enum.UNLINK, writeAllKeys, execUnlink, undoDel)
+	registerCommand(enum.TOUCH, readAllKeys, execTouch, nil)
+	registerCommand(enum.RANDOMKEY, noPrepare, execRandomKey, nil)
 	// cluster command
-	registerCommand(enum.MULTI_DUMPKEY, writeFirstKey, execDumpKey, undoDel)
-	registerCommand(enum.MULTI_RENAMEFROM, readFirstKey, execRenameFrom, nil)
-	registerCommand(enum.MULTI_RENAMETO, writeFirstKey, execRenameTo, rollbackFirstKey)
+	registerCommand(enum.MULTI_RENAMEFROM, writeFirstKey, execRenameFrom, rollbackFirstKey)
 }
@@ -5,8 +5,10 @@ import (
 	"testing"
 	"time"
 
+	"go-redis/lib/asserts"
 	"go-redis/lib/logger"
 	"go-redis/lib/utils"
+	"go-redis/resp/reply"
 )
 
 func TestExecSetAndExecGet(t *testing.T) {
@@ -46,3 +48,57 @@ func TestDemo(t *testing.T) {
 	seconds := time.Now().Unix()
 	t.Log(seconds)
 }
+
+func TestExpireFlags(t *testing.T) {
+	d := newDB(0)
+	execSet(d, utils.ToCmdLine("name", "jack"))
+	// 没有过期时间时, XX和GT不生效
+	asserts.AssertIntReply(t, execExpire(d, utils.ToCmdLine("name", "100", "xx")), 0)
+	asserts.AssertIntReply(t, execExpire(d, utils.ToCmdLine("name", "100", "gt")), 0)
+	asserts.AssertIntReply(t, execExpire(d, utils.ToCmdLine("name", "100", "nx")), 1)
+	asserts.AssertIntReply(t, execExpire(d, utils.ToCmdLine("name", "200", "nx")), 0)
+	asserts.AssertIntReply(t, execExpire(d, utils.ToCmdLine("name", "50", "gt")), 0)
+	asserts.AssertIntReply(t, execExpire(d, utils.ToCmdLine("name", "200", "xx", "gt")), 1)
+	asserts.AssertIntReply(t, execPExpire(d, utils.ToCmdLine("name", "300000", "lt")), 0)
+	asserts.AssertIntReply(t, execPExpire(d, utils.ToCmdLine("name", "10000", "lt")), 1)
+
+	r := execExpire(d, utils.ToCmdLine("name", "10", "nx", "xx"))
+	asserts.AssertErrReply(t, r, "ERR NX and XX, GT or LT options at the same time are not compatible")
+	r = execExpire(d, utils.ToCmdLine("name", "10", "gt", "lt"))
+	asserts.AssertErrReply(t, r, "ERR GT and LT options at the same time are not compatible")
+}
+
+func TestUnlinkAndTouch(t *testing.T) {
+	d := newDB(0)
+	execSet(d, utils.ToCmdLine("a", "1"))
+	values := []string{"big"}
+	for i := 0; i < lazyFreeThreshold*2; i++ {
+		values = append(values, strconv.Itoa(i))
+	}
+	d.execWithLock(utils.ToCmdLine2("rpush", utils.ToCmdLine(values...)...))
+
+	asserts.AssertIntReply(t, execTouch(d, utils.ToCmdLine("a", "big", "none")), 2)
+	asserts.AssertIntReply(t, execUnlink(d, utils.ToCmdLine("a", "big", "none")), 2)
+	asserts.AssertIntReply(t, execTouch(d, utils.ToCmdLine("a", "big")), 0)
+}
+
+func TestRandomKey(t *testing.T) {
+	d := newDB(0)
+	asserts.AssertNullBulk(t, execRandomKey(d, nil))
+	for i := 0; i < 100; i++ {
+		execSet(d, utils.ToCmdLine("key"+strconv.Itoa(i), "v"))
+	}
+	r := execRandomKey(d, nil)
+	if _, ok := r.(*reply.BulkReply); !ok {
+		t.Errorf("expected bulk reply, actually %s", r.Bytes())
+	}
+
+	// 只有过期的key时返回nil, 不给key上锁所以不删除过期的key
+	d = newDB(0)
+	execSet(d, utils.ToCmdLine("expired", "v"))
+	d.ttl.Set("expired", time.Now().Add(-time.Second))
+	asserts.AssertNullBulk(t, execRandomKey(d, nil))
+	if _, ok := d.data.Get("expired"); !ok {
+		t.Error("randomkey should not delete expired keys")
+	}
+}
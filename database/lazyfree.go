@@ -0,0 +1,66 @@
+package database
+
+import (
+	"go-redis/datastruct/dict"
+	"go-redis/datastruct/list"
+	"go-redis/datastruct/set"
+	"go-redis/datastruct/sortedset"
+	"go-redis/interface/db"
+)
+
+const (
+	lazyFreeThreshold = 64   // 元素数量超过该值的数据交给后台协程释放
+	lazyFreeChanSize  = 1024 // 待释放数据的缓冲区大小
+)
+
+// lazyFreeChan 待后台释放的数据
+var lazyFreeChan = make(chan *db.DataEntity, lazyFreeChanSize)
+
+func init() {
+	go func() {
+		for entity := range lazyFreeChan {
+			freeEntity(entity)
+		}
+	}()
+}
+
+// lazyFree 释放已经从数据库中删除的数据, 大数据交给后台协程, 不阻塞执行命令的协程
+func lazyFree(entity *db.DataEntity) {
+	if entity == nil || entitySize(entity) <= lazyFreeThreshold {
+		return
+	}
+	select {
+	case lazyFreeChan <- entity:
+	default:
+		// 缓冲区已满, 交给gc回收
+	}
+}
+
+// entitySize 返回数据中元素的数量
+func entitySize(entity *db.DataEntity) int {
+	switch data := entity.Data.(type) {
+	case list.List:
+		return data.Len()
+	case set.Set:
+		return data.Len()
+	case dict.Dict:
+		return data.Len()
+	case *sortedset.SortedSet:
+		return int(data.Length())
+	default:
+		return 1
+	}
+}
+
+// freeEntity 拆解数据结构, 让gc可以分批回收其中的元素
+func freeEntity(entity *db.DataEntity) {
+	switch data := entity.Data.(type) {
+	case list.List:
+		for data.Len() > 0 {
+			data.RemoveLast()
+		}
+	case dict.Dict:
+		data.Clear()
+	}
+	entity.Data = nil
+}
@@ -116,10 +116,12 @@ func execLPop(d *DB, args db.Params) resp.Reply {
 
 	anyVal := l.Remove(0)
 	val := parseAny(anyVal)
+	d.append(utils.ToCmdLine2(enum.LPOP.String(), args...))
+	d.notify(notifyList, eventLPop, key)
 	if l.Len() == 0 {
 		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
-	d.append(utils.ToCmdLine2(enum.LPOP.String(), args...))
 	return reply.NewBulkReply(val)
 }
 
@@ -158,6 +160,7 @@ func execLPush(d *DB, args db.Params) resp.Reply {
 	}
 
 	d.append(utils.ToCmdLine2(enum.LPUSH.String(), args...))
+	d.notify(notifyList, eventLPush, key)
 	return reply.NewIntReply(int64(l.Len()))
 }
 
@@ -224,6 +227,7 @@ func execLPushX(d *DB, args db.Params) resp.Reply {
 		l.Insert(0, parseBytes(value))
 	}
 	d.append(utils.ToCmdLine2(enum.LPUSHX.String(), args...))
+	d.notify(notifyList, eventLPush, key)
 	return reply.NewIntReply(int64(l.Len()))
 }
 
@@ -311,11 +315,13 @@ func execLRem(d *DB, args db.Params) resp.Reply {
 		}, -count)
 	}
 
-	if l.Len() == 0 {
-		d.Remove(key)
-	}
 	if removed > 0 {
 		d.append(utils.ToCmdLine2(enum.LREM.String(), args...))
+		d.notify(notifyList, eventLRem, key)
+	}
+	if l.Len() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
 
 	return reply.NewIntReply(int64(removed))
@@ -358,6 +364,7 @@ func execLSet(d *DB, args db.Params) resp.Reply {
 
 	l.Set(index, value)
 	d.append(utils.ToCmdLine2(enum.LSET.String(), args...))
+	d.notify(notifyList, eventLSet, key)
 	return reply.NewOKReply()
 }
 
@@ -412,10 +419,12 @@ func execRPop(d *DB, args db.Params) resp.Reply {
 	anyVal := l.RemoveLast()
 	val := parseAny(anyVal)
 
+	d.append(utils.ToCmdLine2(enum.RPOP.String(), args...))
+	d.notify(notifyList, eventRPop, key)
 	if l.Len() == 0 {
 		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
-	d.append(utils.ToCmdLine2(enum.RPOP.String(), args...))
 	return reply.NewBulkReply(val)
 }
 
@@ -466,11 +475,13 @@ func execRPopLPush(d *DB, args db.Params) resp.Reply {
 	val := sourceList.RemoveLast()
 	destList.Insert(0, val)
 
+	d.append(utils.ToCmdLine2(enum.RPOPLPUSH.String(), args...))
+	d.notify(notifyList, eventRPop, sourceKey)
+	d.notify(notifyList, eventLPush, destKey)
 	if sourceList.Len() == 0 {
 		d.Remove(sourceKey)
+		d.notify(notifyGeneric, eventDel, sourceKey)
 	}
-
-	d.append(utils.ToCmdLine2(enum.RPOPLPUSH.String(), args...))
 	return reply.NewBulkReply(parseAny(val))
 }
 
@@ -519,6 +530,7 @@ func execRPush(d *DB, args db.Params) resp.Reply {
 	}
 
 	d.append(utils.ToCmdLine2(enum.RPUSH.String(), args...))
+	d.notify(notifyList, eventRPush, key)
 	return reply.NewIntReply(int64(l.Len()))
 }
 
@@ -553,6 +565,7 @@ func execRPushX(d *DB, args db.Params) resp.Reply {
 		l.PushBack(parseBytes(value))
 	}
 	d.append(utils.ToCmdLine2(enum.RPUSHX.String(), args...))
+	d.notify(notifyList, eventRPush, key)
 
 	return reply.NewIntReply(int64(l.Len()))
 }
@@ -598,6 +611,11 @@ func execLTrim(d *DB, args db.Params) resp.Reply {
 	}
 
 	d.append(utils.ToCmdLine2(enum.LTRIM.String(), args...))
+	d.notify(notifyList, eventLTrim, key)
+	if l.Len() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
+	}
 
 	return reply.NewOKReply()
 }
@@ -647,6 +665,7 @@ func execLInsert(d *DB, args db.Params) resp.Reply {
 	}
 
 	d.append(utils.ToCmdLine2(enum.LINSERT.String(), args...))
+	d.notify(notifyList, eventLInsert, key)
 
 	return reply.NewIntReply(int64(l.Len()))
 }
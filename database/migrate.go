@@ -0,0 +1,204 @@
+package database
+
+import (
+	"crypto/tls"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-redis/client"
+	"go-redis/config"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/rdb"
+	"go-redis/resp/reply"
+)
+
+// migrateOptions MIGRATE 命令的参数
+type migrateOptions struct {
+	addr     string
+	dbIndex  int
+	timeout  time.Duration // 连接目标节点和等待每个回复的最长时间
+	copy     bool
+	replace  bool
+	user     string // 为空时使用 AUTH password
+	password string
+	keys     []string
+}
+
+// defaultMigrateTimeout timeout 为0时使用的超时时间, 单位毫秒
+const defaultMigrateTimeout = 1000
+
+// parseMigrate 解析 MIGRATE 命令的参数, args 不包括命令名
+func parseMigrate(args db.Params) (*migrateOptions, resp.ErrorReply) {
+	opts := &migrateOptions{
+		addr: utils.Bytes2String(args[0]) + ":" + utils.Bytes2String(args[1]),
+	}
+	dbIndex, err := strconv.Atoi(utils.Bytes2String(args[3]))
+	if err != nil || dbIndex < 0 {
+		return nil, reply.NewIntErrReply()
+	}
+	opts.dbIndex = dbIndex
+	timeout, err := strconv.ParseInt(utils.Bytes2String(args[4]), 10, 64)
+	if err != nil || timeout < 0 {
+		return nil, reply.NewIntErrReply()
+	}
+	if timeout == 0 { // 和Redis一致, 0 使用默认的1秒
+		timeout = defaultMigrateTimeout
+	}
+	opts.timeout = time.Duration(timeout) * time.Millisecond
+	if key := utils.Bytes2String(args[2]); key != "" {
+		opts.keys = append(opts.keys, key)
+	}
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(utils.Bytes2String(args[i])) {
+		case enum.MIGRATE_COPY:
+			opts.copy = true
+		case enum.MIGRATE_REPLACE:
+			opts.replace = true
+		case enum.MIGRATE_AUTH:
+			if i+1 >= len(args) {
+				return nil, reply.NewSyntaxErrReply()
+			}
+			opts.password = utils.Bytes2String(args[i+1])
+			i++
+		case enum.MIGRATE_AUTH2:
+			if i+2 >= len(args) {
+				return nil, reply.NewSyntaxErrReply()
+			}
+			opts.user, opts.password = utils.Bytes2String(args[i+1]), utils.Bytes2String(args[i+2])
+			i += 2
+		case enum.MIGRATE_KEYS:
+			if len(opts.keys) > 0 {
+				return nil, reply.NewErrReply("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			opts.keys = utils.CopySlices(args[i+1:])
+			i = len(args)
+		default:
+			return nil, reply.NewSyntaxErrReply()
+		}
+	}
+	return opts, nil
+}
+
+// prepareMigrate 返回需要迁移的key, 迁移之后会删除这些key
+func prepareMigrate(args db.Params) (writeKeys, readKeys []string) {
+	opts, errReply := parseMigrate(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return opts.keys, nil
+}
+
+// execMigrate 把key序列化之后发送到目标节点, 目标节点使用 RESTORE 还原
+//
+// # MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key ...]
+//
+// 每个 RESTORE 之前发送 ASKING, 目标节点是集群中正在迁入槽的节点时也能写入; 没有 COPY 时迁移成功后删除本地的key.
+// 开启 tls-cluster 时使用TLS连接目标节点. 和Redis一样迁移期间一直持有key的锁, timeout 限制了连接和每个回复的等待时间
+//
+// 返回: 成功返回OK, 所有的key都不存在时返回NOKEY
+func execMigrate(d *DB, args db.Params) resp.Reply {
+	// 1. 解析参数
+	opts, errReply := parseMigrate(args)
+	if errReply != nil {
+		return errReply
+	}
+	// 2. 序列化存在的key和剩余的过期时间
+	keys := make([]string, 0, len(opts.keys))
+	restores := make([]db.CmdLine, 0, len(opts.keys))
+	for _, key := range opts.keys {
+		entity, ok := d.getEntity(key)
+		if !ok {
+			continue
+		}
+		payload, err := rdb.Dump(entity)
+		if err != nil {
+			return reply.NewErrReplyByError(err)
+		}
+		ttl := int64(0)
+		if raw, hasTTL := d.ttl.GetWithLock(key); hasTTL {
+			ttl = max(time.Until(raw.(time.Time)).Milliseconds(), 1)
+		}
+		cmdLine := utils.ToCmdLine2(enum.RESTORE.String(), utils.String2Bytes(key),
+			utils.String2Bytes(strconv.FormatInt(ttl, 10)), payload)
+		if opts.replace {
+			cmdLine = append(cmdLine, utils.String2Bytes(enum.RESTORE_REPLACE))
+		}
+		keys = append(keys, key)
+		restores = append(restores, cmdLine)
+	}
+	if len(keys) == 0 {
+		return reply.NewStatusReply("NOKEY")
+	}
+	// 3. 发送到目标节点
+	var tlsConfig *tls.Config
+	if config.Properties.TlsCluster {
+		var err error
+		if tlsConfig, err = config.ClientTLSConfig(); err != nil {
+			return reply.NewErrReplyByError(err)
+		}
+	}
+	target, err := client.NewTimeoutClient(opts.addr, tlsConfig, opts.timeout)
+	if err != nil {
+		return &reply.NormalErrReply{Status: "IOERR error or timeout connecting to the client"}
+	}
+	target.Start()
+	defer target.Close()
+	if opts.password != "" {
+		authArgs := utils.If(opts.user == "",
+			utils.ToCmdLine(enum.SYS_AUTH.String(), opts.password),
+			utils.ToCmdLine(enum.SYS_AUTH.String(), opts.user, opts.password))
+		if r := target.Send(authArgs); reply.IsErrReply(r) {
+			return targetErrReply(r)
+		}
+	}
+	if r := target.Send(utils.ToCmdLine(enum.SELECT.String(), strconv.Itoa(opts.dbIndex))); reply.IsErrReply(r) {
+		return targetErrReply(r)
+	}
+	for _, cmdLine := range restores {
+		target.Send(utils.ToCmdLine(enum.ASKING.String())) // 目标节点不是集群节点时忽略错误
+		if r := target.Send(cmdLine); reply.IsErrReply(r) {
+			return targetErrReply(r)
+		}
+	}
+	// 4. 删除本地的key
+	if opts.copy {
+		return reply.NewOKReply()
+	}
+	n := 0
+	for _, key := range keys {
+		if d.removes(key) > 0 {
+			d.notify(notifyGeneric, eventDel, key)
+			n++
+		}
+	}
+	if n > 0 {
+		d.append(utils.ToCmdLine(append([]string{enum.DEL.String()}, keys...)...))
+	}
+	return reply.NewOKReply()
+}
+
+// targetErrReply 包装目标节点返回的错误, 超时或者连接断开时返回 IOERR
+func targetErrReply(r resp.Reply) resp.Reply {
+	msg := strings.TrimSuffix(strings.TrimPrefix(utils.Bytes2String(r.Bytes()), "-"), "\r\n")
+	if msg == "ERR "+enum.SERVER_TIMEOUT.Error() || msg == "ERR "+enum.REQUEST_FAILED.Error() {
+		return &reply.NormalErrReply{Status: "IOERR error or timeout reading to target instance"}
+	}
+	return reply.NewErrReply("Target instance replied with error: " + strings.TrimPrefix(msg, "ERR "))
+}
+
+// undoMigrate 事务回滚时恢复迁移走的key, 目标节点上的key不会被删除
+func undoMigrate(d *DB, args db.Params) []db.CmdLine {
+	opts, errReply := parseMigrate(args)
+	if errReply != nil {
+		return nil
+	}
+	return rollbackKeys(d, opts.keys...)
+}
+
+func init() {
+	registerCommand(enum.MIGRATE, prepareMigrate, execMigrate, undoMigrate)
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+)
+
+// serveDatabase 在随机端口上启动一个只使用 database 的服务器, 返回服务器的地址
+func serveDatabase(t *testing.T, database *StandaloneDatabase) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				client := connection.NewRespConnection(conn)
+				reader := parser.NewReader(conn)
+				for {
+					data, _, err := reader.ReadReply()
+					if err != nil {
+						_ = conn.Close()
+						return
+					}
+					// 客户端把只有一个参数的命令发送为字符串
+					switch args := data.(type) {
+					case *reply.MultiBulkReply:
+						_, _ = conn.Write(database.Exec(client, args.Args).Bytes())
+					case *reply.BulkReply:
+						_, _ = conn.Write(database.Exec(client, [][]byte{args.Arg}).Bytes())
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestMigrate(t *testing.T) {
+	target := NewStandaloneDatabase()
+	host, port, _ := net.SplitHostPort(serveDatabase(t, target))
+	targetConn := connection.NewFakeConn()
+	targetConn.SelectDB(1)
+
+	src := newDB(0)
+	src.execWithLock(utils.ToCmdLine("set", "a", "1"))
+	src.execWithLock(utils.ToCmdLine("rpush", "b", "x", "y"))
+	src.execWithLock(utils.ToCmdLine("set", "c", "3"))
+	src.execWithLock(utils.ToCmdLine("pexpire", "c", "100000"))
+
+	// 单个key, 迁移之后删除本地的key
+	r := src.execWithLock(utils.ToCmdLine("migrate", host, port, "a", "1", "1000"))
+	asserts.AssertStatusReply(t, r, "OK")
+	asserts.AssertIntReply(t, src.execWithLock(utils.ToCmdLine("exists", "a")), 0)
+	asserts.AssertBulkReply(t, target.Exec(targetConn, utils.ToCmdLine("get", "a")), "1")
+
+	// KEYS 和 COPY, 过期时间也会迁移
+	r = src.execWithLock(utils.ToCmdLine("migrate", host, port, "", "1", "1000", "copy", "keys", "b", "c", "none"))
+	asserts.AssertStatusReply(t, r, "OK")
+	asserts.AssertIntReply(t, src.execWithLock(utils.ToCmdLine("exists", "b", "c")), 2)
+	asserts.AssertMultiBulkReply(t, target.Exec(targetConn, utils.ToCmdLine("lrange", "b", "0", "-1")), []string{"x", "y"})
+	asserts.AssertIntReplyGreaterThan(t, target.Exec(targetConn, utils.ToCmdLine("pttl", "c")), 0)
+
+	// 目标节点上已经存在的key需要 REPLACE
+	r = src.execWithLock(utils.ToCmdLine("migrate", host, port, "b", "1", "1000"))
+	asserts.AssertErrReply(t, r, "ERR Target instance replied with error: BUSYKEY Target key name already exists.")
+	asserts.AssertIntReply(t, src.execWithLock(utils.ToCmdLine("exists", "b")), 1)
+	r = src.execWithLock(utils.ToCmdLine("migrate", host, port, "b", "1", "1000", "replace"))
+	asserts.AssertStatusReply(t, r, "OK")
+
+	r = src.execWithLock(utils.ToCmdLine("migrate", host, port, "none", "1", "1000"))
+	asserts.AssertStatusReply(t, r, "NOKEY")
+	r = src.execWithLock(utils.ToCmdLine("migrate", host, port, "a", "1", "1000", "keys", "c"))
+	asserts.AssertErrReply(t, r, "ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+}
+
+func TestMigrateTimeout(t *testing.T) {
+	// 目标节点接受连接但是不回复
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	src := newDB(0)
+	src.execWithLock(utils.ToCmdLine("set", "a", "1"))
+	start := time.Now()
+	r := src.execWithLock(utils.ToCmdLine("migrate", host, port, "a", "0", "100"))
+	asserts.AssertErrReply(t, r, "IOERR error or timeout reading to target instance")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("migrate should time out after 100ms, took %v", elapsed)
+	}
+	// 迁移失败时不删除本地的key
+	asserts.AssertBulkReply(t, src.execWithLock(utils.ToCmdLine("get", "a")), "1")
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	"go-redis/config"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+)
+
+// notifyFlag 键空间通知的事件类型, 对应 notify-keyspace-events 配置中的字符
+type notifyFlag uint16
+
+const (
+	notifyKeyspace notifyFlag = 1 << iota // K: 发布到 __keyspace@<db>__:<key>
+	notifyKeyevent                        // E: 发布到 __keyevent@<db>__:<event>
+	notifyGeneric                         // g: DEL, EXPIRE, RENAME等通用命令
+	notifyString                          // $: 字符串命令
+	notifyList                            // l: 列表命令
+	notifySet                             // s: 集合命令
+	notifyHash                            // h: 哈希命令
+	notifyZSet                            // z: 有序集合命令
+	notifyExpired                         // x: key过期
+	notifyEvicted                         // e: key因内存淘汰被删除
+
+	// A: g$lshzxe 的别名
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet | notifyExpired | notifyEvicted
+)
+
+// 键空间通知的事件名, 与redis保持一致
+const (
+	// generic
+	eventDel        = "del"
+	eventRenameFrom = "rename_from"
+	eventRenameTo   = "rename_to"
+	eventExpire     = "expire"
+	eventPersist    = "persist"
+	eventRestore    = "restore"
+	eventCopyTo     = "copy_to"
+	eventMoveFrom   = "move_from"
+	eventMoveTo     = "move_to"
+	eventExpired    = "expired"
+	eventEvicted    = "evicted"
+	// string
+	eventSet    = "set"
+	eventIncrBy = "incrby"
+	eventDecrBy = "decrby"
+	// list
+	eventLPush   = "lpush"
+	eventRPush   = "rpush"
+	eventLPop    = "lpop"
+	eventRPop    = "rpop"
+	eventLRem    = "lrem"
+	eventLSet    = "lset"
+	eventLTrim   = "ltrim"
+	eventLInsert = "linsert"
+	// set
+	eventSAdd        = "sadd"
+	eventSRem        = "srem"
+	eventSPop        = "spop"
+	eventSInterStore = "sinterstore"
+	eventSUnionStore = "sunionstore"
+	eventSDiffStore  = "sdiffstore"
+	// hash
+	eventHSet = "hset"
+	eventHDel = "hdel"
+	// zset
+	eventZAdd             = "zadd"
+	eventZIncrBy          = "zincr"
+	eventZRem             = "zrem"
+	eventZRemRangeByScore = "zremrangebyscore"
+	eventZRemRangeByRank  = "zremrangebyrank"
+	eventZPopMin          = "zpopmin"
+	eventZPopMax          = "zpopmax"
+)
+
+var errInvalidNotifyFlags = errors.New("invalid event class character, use 'Ag$lshzxeKE'")
+
+// parseNotifyFlags 解析 notify-keyspace-events 配置
+func parseNotifyFlags(s string) (flags notifyFlag, err error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZSet
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'A':
+			flags |= notifyAll
+		default:
+			return 0, errInvalidNotifyFlags
+		}
+	}
+	return flags, nil
+}
+
+// notifySetting 缓存解析后的配置, 配置没有变化时不需要重新解析
+type notifySetting struct {
+	raw   string
+	flags notifyFlag
+}
+
+var notifyCache atomic.Pointer[notifySetting]
+
+// notifyFlags 返回当前配置的事件类型
+func notifyFlags() notifyFlag {
+	raw := config.Properties.NotifyKeyspaceEvents
+	if setting := notifyCache.Load(); setting != nil && setting.raw == raw {
+		return setting.flags
+	}
+	flags, err := parseNotifyFlags(raw)
+	if err != nil {
+		logger.Error("notify-keyspace-events:", err)
+	}
+	notifyCache.Store(&notifySetting{raw: raw, flags: flags})
+	return flags
+}
+
+// notify 发送键空间通知, 没有开启对应的事件类型时不做任何操作
+//
+// 键空间: PUBLISH __keyspace@<db>__:<key> <event>
+//
+// 键事件: PUBLISH __keyevent@<db>__:<event> <key>
+func (d *DB) notify(class notifyFlag, event string, key string) {
+	if d.hub == nil {
+		return
+	}
+	flags := notifyFlags()
+	if flags&class == 0 {
+		return
+	}
+	index := strconv.Itoa(d.index)
+	if flags&notifyKeyspace != 0 {
+		channel := "__keyspace@" + index + "__:" + key
+		d.hub.PublishMessage(utils.String2Bytes(channel), utils.String2Bytes(event))
+	}
+	if flags&notifyKeyevent != 0 {
+		channel := "__keyevent@" + index + "__:" + event
+		d.hub.PublishMessage(utils.String2Bytes(channel), utils.String2Bytes(key))
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-redis/config"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+)
+
+func TestParseNotifyFlags(t *testing.T) {
+	flags, err := parseNotifyFlags("KEA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != notifyKeyspace|notifyKeyevent|notifyAll {
+		t.Errorf("unexpected flags: %b", flags)
+	}
+	flags, err = parseNotifyFlags("Elg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != notifyKeyevent|notifyList|notifyGeneric {
+		t.Errorf("unexpected flags: %b", flags)
+	}
+	if _, err = parseNotifyFlags("Kq"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestKeyspaceNotify(t *testing.T) {
+	config.Properties.NotifyKeyspaceEvents = "KEA"
+	defer func() {
+		config.Properties.NotifyKeyspaceEvents = ""
+	}()
+	conn := new(connection.FakeConn)
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	sub := connection.NewFakeConn()
+	testServer.Exec(sub, utils.ToCmdLine("psubscribe", "__key*__:*"))
+	sub.Clean()
+
+	// 1. 修改key的命令
+	testServer.Exec(conn, utils.ToCmdLine("set", "k", "v"))
+	expected := "*4\r\n$11\r\n__key*__:*\r\n$16\r\n__keyspace@0__:k\r\n$3\r\nset\r\n" +
+		"*4\r\n$11\r\n__key*__:*\r\n$18\r\n__keyevent@0__:set\r\n$1\r\nk\r\n"
+	expected = strings.ReplaceAll(expected, "*4\r\n$11", "*4\r\n$8\r\npmessage\r\n$10")
+	if string(sub.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, sub.Bytes())
+	}
+	// 2. 只读命令不发送通知
+	sub.Clean()
+	testServer.Exec(conn, utils.ToCmdLine("get", "k"))
+	if len(sub.Bytes()) != 0 {
+		t.Errorf("unexpected notification %q", sub.Bytes())
+	}
+	// 3. 删除命令
+	testServer.Exec(conn, utils.ToCmdLine("del", "k"))
+	if !strings.Contains(string(sub.Bytes()), "__keyevent@0__:del") {
+		t.Errorf("missing del notification, actually %q", sub.Bytes())
+	}
+	// 4. 过期
+	sub.Clean()
+	testServer.Exec(conn, utils.ToCmdLine("set", "k", "v"))
+	testServer.Exec(conn, utils.ToCmdLine("pexpire", "k", "10"))
+	time.Sleep(50 * time.Millisecond)
+	result := testServer.Exec(conn, utils.ToCmdLine("exists", "k"))
+	asserts.AssertIntReply(t, result, 0)
+	for _, event := range []string{"set", "expire", "expired"} {
+		if !strings.Contains(string(sub.Bytes()), "__keyevent@0__:"+event+"\r\n") {
+			t.Errorf("missing %s notification, actually %q", event, sub.Bytes())
+		}
+	}
+
+	// 5. 订阅状态下只能执行订阅相关的命令
+	result = testServer.Exec(sub, utils.ToCmdLine("get", "k"))
+	asserts.AssertErrReply(t, result, "ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+	testServer.Exec(sub, utils.ToCmdLine("punsubscribe"))
+	result = testServer.Exec(sub, utils.ToCmdLine("get", "k"))
+	asserts.AssertNullBulk(t, result)
+}
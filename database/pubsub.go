@@ -0,0 +1,52 @@
+package database
+
+import (
+	"strings"
+
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/pubsub"
+	"go-redis/resp/reply"
+)
+
+// execPubSub 执行发布订阅命令, 第二个返回值表示cmdName是否已经处理
+//
+// RESP2客户端订阅了频道或模式之后, 只能执行订阅相关的命令和PING, RESP3客户端没有这个限制
+func execPubSub(hub *pubsub.Hub, client resp.Connection, cmdName string, args db.CmdLine) (resp.Reply, bool) {
+	switch cmdName {
+	case enum.SUBSCRIBE.String():
+		if !ValidateArity(enum.SUBSCRIBE.Arity(), args) {
+			return reply.NewArgNumErrReply(cmdName), true
+		}
+		return hub.Subscribe(client, args[1:]), true
+	case enum.UNSUBSCRIBE.String():
+		return hub.UnSubscribe(client, args[1:]), true
+	case enum.PSUBSCRIBE.String():
+		if !ValidateArity(enum.PSUBSCRIBE.Arity(), args) {
+			return reply.NewArgNumErrReply(cmdName), true
+		}
+		return hub.PSubscribe(client, args[1:]), true
+	case enum.PUNSUBSCRIBE.String():
+		return hub.PUnSubscribe(client, args[1:]), true
+	case enum.PING.String():
+		return nil, false
+	}
+	if client.SubsCount() > 0 && client.GetProtocol() < enum.RESP3 {
+		return reply.NewErrReply("Can't execute '" + strings.ToLower(cmdName) +
+			"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context"), true
+	}
+	switch cmdName {
+	case enum.PUBLISH.String():
+		if !ValidateArity(enum.PUBLISH.Arity(), args) {
+			return reply.NewArgNumErrReply(cmdName), true
+		}
+		return hub.Publish(args[1:]), true
+	case enum.PUBSUB.String():
+		if !ValidateArity(enum.PUBSUB.Arity(), args) {
+			return reply.NewArgNumErrReply(cmdName), true
+		}
+		return hub.PubSub(args[1:]), true
+	}
+	return nil, false
+}
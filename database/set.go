@@ -82,7 +82,7 @@ func execSAdd(d *DB, args db.Params) resp.Reply {
 			counter += st.Add(num)
 			i++
 			// 3.1 intset中的整数数量 等于 配置中的限制, 要转化为hashSet再做插入
-			if st.Len() == config.Properties.SetMaxIntSetEntries {
+			if st.Len() == config.SetMaxIntSetEntries() {
 				changeSet = true
 				break
 			}
@@ -105,6 +105,9 @@ func execSAdd(d *DB, args db.Params) resp.Reply {
 	}
 
 	d.append(utils.ToCmdLine2(enum.SADD.String(), args...))
+	if counter > 0 {
+		d.notify(notifySet, eventSAdd, key)
+	}
 	return reply.NewIntReply(int64(counter))
 }
 
@@ -141,16 +144,21 @@ func execSIsMember(d *DB, args db.Params) resp.Reply {
 		return reply.NewIntReply(0)
 	}
 
+	return utils.If(setContains(st, member), reply.NewIntReply(1), reply.NewIntReply(0))
+}
+
+// setContains 判断集合中是否包含成员, intset中的成员需要转化为整数
+func setContains(st set.Set, member string) bool {
 	_, ok := st.(*set.IntSet)
 	num, err := strconv.ParseInt(member, 10, 64)
 	if err != nil && ok { // 参数不是数字 且 set是intset
-		return reply.NewIntReply(0)
+		return false
 	}
 	if err == nil && ok { // 参数是数字 且 set是intset
-		return utils.If(st.Contains(num), reply.NewIntReply(1), reply.NewIntReply(0))
+		return st.Contains(num)
 	}
 	// (参数是数字 且 set是hashSet) 或者 (参数不是数字 且 set是hashSet)
-	return utils.If(st.Contains(member), reply.NewIntReply(1), reply.NewIntReply(0))
+	return st.Contains(member)
 }
 
 // execSRem 命令用于移除集合中的一个或多个成员元素，不存在的成员元素会被忽略。
@@ -187,14 +195,56 @@ func execSRem(d *DB, args db.Params) resp.Reply {
 		}
 	}
 
+	if counter > 0 {
+		d.append(utils.ToCmdLine2(enum.SREM.String(), args...))
+		d.notify(notifySet, eventSRem, key)
+	}
 	if st.Len() == 0 {
 		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
+	return reply.NewIntReply(int64(counter))
+}
 
-	if counter > 0 {
-		d.append(utils.ToCmdLine2(enum.SREM.String(), args...))
+// execSMove 命令将指定成员 member 元素从 source 集合移动到 destination 集合。
+//
+// 如果 source 集合不存在或不包含指定的 member 元素，则不执行任何操作。当 destination 集合已经包含 member 元素时，只从 source 集合中删除 member 元素。
+//
+// 当 source 或 destination 不是集合类型时，返回一个错误。
+//
+// # SMOVE SOURCE DESTINATION MEMBER
+//
+// 返回: 成员元素被成功移除返回 1，否则返回 0
+func execSMove(d *DB, args db.Params) resp.Reply {
+	src := utils.Bytes2String(args[0])
+	dest := utils.Bytes2String(args[1])
+	member := utils.Bytes2String(args[2])
+
+	srcSet, errReply := d.getSet(src)
+	if errReply != nil {
+		return errReply
 	}
-	return reply.NewIntReply(int64(counter))
+	if _, errReply = d.getSet(dest); errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !setContains(srcSet, member) {
+		return reply.NewIntReply(0)
+	}
+	if src == dest {
+		return reply.NewIntReply(1)
+	}
+	// 先从 source 删除再加入 destination, aof中分别记录 SREM 和 SADD
+	execSRem(d, [][]byte{args[0], args[2]})
+	execSAdd(d, [][]byte{args[1], args[2]})
+	return reply.NewIntReply(1)
+}
+
+func prepareSMove(args db.Params) (writeKeys []string, readKeys []string) {
+	return utils.CopySlices(args[:2]), nil
+}
+
+func undoSMove(d *DB, args db.Params) []db.CmdLine {
+	return rollbackKeys(d, utils.Bytes2String(args[0]), utils.Bytes2String(args[1]))
 }
 
 // execSPop 命令用于移除集合中的指定 key 的一个或多个随机元素，移除后会返回移除的元素。
@@ -248,6 +298,11 @@ func execSPop(d *DB, args db.Params) resp.Reply {
 
 	if count > 0 {
 		d.append(utils.ToCmdLine2(enum.SPOP.String(), args...))
+		d.notify(notifySet, eventSPop, key)
+	}
+	if st.Len() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
 	return reply.NewMultiBulkReply(result)
 }
@@ -315,6 +370,7 @@ func execSInterStore(d *DB, args db.Params) resp.Reply {
 	d.Remove(dest) // 刷新ttl
 	d.putEntity(dest, db.NewDataEntity(result))
 	d.append(utils.ToCmdLine2(enum.SINTERSTORE.String(), args...))
+	d.notify(notifySet, eventSInterStore, dest)
 	return reply.NewIntReply(int64(result.Len()))
 }
 
@@ -352,6 +408,7 @@ func execSUnionStore(d *DB, args db.Params) resp.Reply {
 	d.Remove(dest) // 刷新ttl
 	d.putEntity(dest, db.NewDataEntity(result))
 	d.append(utils.ToCmdLine2(enum.SUNIONSTORE.String(), args...))
+	d.notify(notifySet, eventSUnionStore, dest)
 	return reply.NewIntReply(int64(result.Len()))
 }
 
@@ -389,6 +446,7 @@ func execSDiffStore(d *DB, args db.Params) resp.Reply {
 	}
 	d.putEntity(dest, db.NewDataEntity(result))
 	d.append(utils.ToCmdLine2(enum.SDIFFSTORE.String(), args...))
+	d.notify(notifySet, eventSDiffStore, dest)
 	return reply.NewIntReply(int64(result.Len()))
 }
 
@@ -448,13 +506,13 @@ func execSMembers(d *DB, args db.Params) resp.Reply {
 		return errReply
 	}
 	if st == nil {
-		return reply.NewEmptyMultiBulkReply()
+		return reply.NewBulkSetReply(nil)
 	}
 
 	members := st.ToSlice()
 	result := members2Bytes(st, members)
 
-	return reply.NewMultiBulkReply(result)
+	return reply.NewBulkSetReply(result)
 }
 
 // getSets 获取参数中的所有key对应的set, 返回一个set切片
@@ -510,6 +568,7 @@ func init() {
 	registerCommand(enum.SINTER, prepareSetCalculate, execSInter, nil)                             //
 	registerCommand(enum.SINTERSTORE, prepareSetCalculateStore, execSInterStore, rollbackFirstKey) //
 	registerCommand(enum.SISMEMBER, readFirstKey, execSIsMember, nil)                              //
+	registerCommand(enum.SMOVE, prepareSMove, execSMove, undoSMove)                                //
 	registerCommand(enum.SMEMBERS, readFirstKey, execSMembers, nil)                                //
 	registerCommand(enum.SPOP, writeFirstKey, execSPop, undoSet)                                   //
 	registerCommand(enum.SRANDMEMBER, readFirstKey, execSRandMember, nil)                          //
@@ -8,6 +8,7 @@ import (
 	"go-redis/datastruct/set"
 	"go-redis/enum"
 	"go-redis/interface/resp"
+	"go-redis/lib/asserts"
 	"go-redis/lib/utils"
 )
 
@@ -199,3 +200,26 @@ func TestUndoSet(t *testing.T) {
 		}
 	}
 }
+
+func TestSMove(t *testing.T) {
+	testDB.Flush()
+	testDB.execWithLock(utils.ToCmdLine(enum.SADD.String(), "smove:src", "1", "a"))
+	testDB.execWithLock(utils.ToCmdLine(enum.SET.String(), "smove:str", "a"))
+
+	asserts.AssertIntReply(t, testDB.execWithLock(utils.ToCmdLine(enum.SMOVE.String(), "smove:src", "smove:dest", "1")), 1)
+	asserts.AssertIntReply(t, testDB.execWithLock(utils.ToCmdLine(enum.SMOVE.String(), "smove:src", "smove:dest", "1")), 0)
+	asserts.AssertIntReply(t, testDB.execWithLock(utils.ToCmdLine(enum.SISMEMBER.String(), "smove:dest", "1")), 1)
+	r := testDB.execWithLock(utils.ToCmdLine(enum.SMOVE.String(), "smove:src", "smove:str", "a"))
+	asserts.AssertErrReply(t, r, "ERR Operation against a key holding the wrong kind of value")
+
+	// 移动最后一个成员之后 source 被删除, 回滚之后恢复
+	cmdLine := utils.ToCmdLine(enum.SMOVE.String(), "smove:src", "smove:dest", "a")
+	undoLogs := testDB.GetUndoLogs(cmdLine)
+	asserts.AssertIntReply(t, testDB.execWithLock(cmdLine), 1)
+	asserts.AssertIntReply(t, testDB.execWithLock(utils.ToCmdLine(enum.EXISTS.String(), "smove:src")), 0)
+	for _, undo := range undoLogs {
+		testDB.execWithLock(undo)
+	}
+	asserts.AssertIntReply(t, testDB.execWithLock(utils.ToCmdLine(enum.SISMEMBER.String(), "smove:src", "a")), 1)
+	asserts.AssertIntReply(t, testDB.execWithLock(utils.ToCmdLine(enum.SISMEMBER.String(), "smove:dest", "a")), 0)
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/latency"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"go-redis/slowlog"
+)
+
+// execSlowLog 执行 SLOWLOG 命令
+//
+// # SLOWLOG GET [count] | LEN | RESET
+func execSlowLog(args db.CmdLine) resp.Reply {
+	if !ValidateArity(enum.SLOWLOG.Arity(), args) {
+		return reply.NewArgNumErrReply(enum.SLOWLOG.String())
+	}
+	subCmd := strings.ToUpper(utils.Bytes2String(args[1]))
+	switch subCmd {
+	case enum.SLOWLOG_GET:
+		if len(args) > 3 {
+			return reply.NewArgNumErrReply("slowlog|get")
+		}
+		count := 10
+		if len(args) == 3 {
+			n, err := strconv.Atoi(utils.Bytes2String(args[2]))
+			if err != nil || n < -1 {
+				return reply.NewErrReply("count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		entries := slowlog.Get(count)
+		replies := make([]resp.Reply, len(entries))
+		for i, entry := range entries {
+			cmdArgs := make([][]byte, len(entry.Args))
+			for j, arg := range entry.Args {
+				cmdArgs[j] = []byte(arg)
+			}
+			replies[i] = reply.NewMultiRawReply([]resp.Reply{
+				reply.NewIntReply(entry.ID),
+				reply.NewIntReply(entry.Time.Unix()),
+				reply.NewIntReply(entry.Duration.Microseconds()),
+				reply.NewMultiBulkReply(cmdArgs),
+				reply.NewBulkReply([]byte(entry.ClientAddr)),
+				reply.NewBulkReply([]byte(entry.ClientName)),
+			})
+		}
+		return reply.NewMultiRawReply(replies)
+	case enum.SLOWLOG_LEN:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("slowlog|len")
+		}
+		return reply.NewIntReply(int64(slowlog.Len()))
+	case enum.SLOWLOG_RESET:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("slowlog|reset")
+		}
+		slowlog.Reset()
+		return reply.NewOKReply()
+	default:
+		return reply.NewErrReply("unknown subcommand '" + subCmd + "'")
+	}
+}
+
+// execLatency 执行 LATENCY 命令, 延迟的单位是毫秒
+//
+// # LATENCY LATEST | HISTORY event | RESET [event [event ...]]
+func execLatency(args db.CmdLine) resp.Reply {
+	if !ValidateArity(enum.LATENCY.Arity(), args) {
+		return reply.NewArgNumErrReply(enum.LATENCY.String())
+	}
+	subCmd := strings.ToUpper(utils.Bytes2String(args[1]))
+	switch subCmd {
+	case enum.LATENCY_LATEST:
+		if len(args) != 2 {
+			return reply.NewArgNumErrReply("latency|latest")
+		}
+		events := latency.Latest()
+		replies := make([]resp.Reply, len(events))
+		for i, stats := range events {
+			replies[i] = reply.NewMultiRawReply([]resp.Reply{
+				reply.NewBulkReply([]byte(stats.Event)),
+				reply.NewIntReply(stats.Latest.Time.Unix()),
+				reply.NewIntReply(stats.Latest.Latency.Milliseconds()),
+				reply.NewIntReply(stats.Max.Milliseconds()),
+			})
+		}
+		return reply.NewMultiRawReply(replies)
+	case enum.LATENCY_HISTORY:
+		if len(args) != 3 {
+			return reply.NewArgNumErrReply("latency|history")
+		}
+		samples := latency.History(string(args[2]))
+		replies := make([]resp.Reply, len(samples))
+		for i, sample := range samples {
+			replies[i] = reply.NewMultiRawReply([]resp.Reply{
+				reply.NewIntReply(sample.Time.Unix()),
+				reply.NewIntReply(sample.Latency.Milliseconds()),
+			})
+		}
+		return reply.NewMultiRawReply(replies)
+	case enum.LATENCY_RESET:
+		events := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			events[i] = string(arg)
+		}
+		return reply.NewIntReply(int64(latency.Reset(events...)))
+	default:
+		return reply.NewErrReply("unknown subcommand '" + subCmd + "'")
+	}
+}
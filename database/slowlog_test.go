@@ -0,0 +1,70 @@
+package database
+
+import (
+	"testing"
+
+	"go-redis/config"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"go-redis/slowlog"
+)
+
+func TestSlowLog(t *testing.T) {
+	defer slowlog.Reset()
+	defer config.Set("slowlog-log-slower-than", "10000")
+
+	conn := connection.NewFakeConn()
+	defer testServer.AfterClientClose(conn)
+	conn.SetName("slow-client")
+	result := testServer.Exec(conn, utils.ToCmdLine("slowlog", "reset"))
+	asserts.AssertStatusReply(t, result, "OK")
+	if err := config.Set("slowlog-log-slower-than", "0"); err != nil {
+		t.Fatal(err)
+	}
+	testServer.Exec(conn, utils.ToCmdLine("set", "slow", "v"))
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	testServer.Exec(conn, utils.ToCmdLine("get", "slow"))
+	testServer.Exec(conn, utils.ToCmdLine("exec"))
+	if err := config.Set("slowlog-log-slower-than", "-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// SET 和 EXEC, 事务中的命令不单独记录
+	result = testServer.Exec(conn, utils.ToCmdLine("slowlog", "len"))
+	asserts.AssertIntReply(t, result, 2)
+	result = testServer.Exec(conn, utils.ToCmdLine("slowlog", "get", "1"))
+	entries, ok := result.(*reply.MultiRawReply)
+	if !ok || len(entries.Replies) != 1 {
+		t.Fatalf("unexpected slowlog get result %q", result.Bytes())
+	}
+	entry := entries.Replies[0].(*reply.MultiRawReply)
+	asserts.AssertMultiBulkReply(t, entry.Replies[3], []string{"exec"})
+	asserts.AssertBulkReply(t, entry.Replies[5], "slow-client")
+
+	result = testServer.Exec(conn, utils.ToCmdLine("slowlog", "get", "-2"))
+	asserts.AssertErrReply(t, result, "ERR count should be greater than or equal to -1")
+	result = testServer.Exec(conn, utils.ToCmdLine("slowlog", "foo"))
+	asserts.AssertErrReply(t, result, "ERR unknown subcommand 'FOO'")
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	result = testServer.Exec(conn, utils.ToCmdLine("slowlog", "len"))
+	asserts.AssertErrReply(t, result, "ERR command 'SLOWLOG' cannot be used in MULTI")
+	testServer.Exec(conn, utils.ToCmdLine("discard"))
+}
+
+func TestLatency(t *testing.T) {
+	conn := connection.NewFakeConn()
+	defer testServer.AfterClientClose(conn)
+
+	result := testServer.Exec(conn, utils.ToCmdLine("latency", "reset"))
+	if _, ok := result.(*reply.IntReply); !ok {
+		t.Errorf("unexpected latency reset result %q", result.Bytes())
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("latency", "latest"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	result = testServer.Exec(conn, utils.ToCmdLine("latency", "history", "aof-write"))
+	asserts.AssertMultiBulkReplySize(t, result, 0)
+	result = testServer.Exec(conn, utils.ToCmdLine("latency", "history"))
+	asserts.AssertErrReply(t, result, "ERR wrong number of arguments for 'latency|history' command")
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go-redis/config"
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/rdb"
+	"go-redis/resp/reply"
+)
+
+var (
+	errNoDbFilename = errors.New("dbfilename is not configured")
+	errSaveRunning  = errors.New("Background save already in progress")
+)
+
+// execSave 把所有数据库保存到快照文件, 值的编码和 DUMP 相同
+//
+// # SAVE
+//
+// # BGSAVE
+//
+// SAVE 保存完成之后返回, BGSAVE 在后台保存, 同时只能有一个保存在进行
+func execSave(database *StandaloneDatabase, cmdName string) resp.Reply {
+	filename := config.Properties.DbFilename
+	if filename == "" {
+		return reply.NewErrReplyByError(errNoDbFilename)
+	}
+	if !database.saving.CompareAndSwap(false, true) {
+		return reply.NewErrReplyByError(errSaveRunning)
+	}
+	if cmdName == enum.BGSAVE.String() {
+		go func() {
+			defer database.saving.Store(false)
+			if err := database.saveSnapshot(filename); err != nil {
+				logger.Error("background saving: " + err.Error())
+				return
+			}
+			logger.Info("background saving terminated with success")
+		}()
+		return reply.NewStatusReply("Background saving started")
+	}
+	defer database.saving.Store(false)
+	if err := database.saveSnapshot(filename); err != nil {
+		return reply.NewErrReplyByError(err)
+	}
+	return reply.NewOKReply()
+}
+
+// saveSnapshot 先写入临时文件再重命名, 避免保存失败时破坏原来的快照
+//
+// 保存期间不能执行 SWAPDB; 每个key在读锁下编码, 快照中的每个值都是完整的, 但不是同一时刻的数据
+func (database *StandaloneDatabase) saveSnapshot(filename string) error {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	writer, err := rdb.NewSnapshotWriter(tmp)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	for _, d := range database.dbSet {
+		if err = d.writeSnapshot(writer); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// writeSnapshot 把数据库中没有过期的key写入快照
+func (d *DB) writeSnapshot(writer *rdb.SnapshotWriter) error {
+	for _, key := range d.data.Keys() {
+		if err := d.writeSnapshotKey(writer, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSnapshotKey 给key上读锁之后写入快照, 遍历之后被删除或者已经过期的key不写入
+func (d *DB) writeSnapshotKey(writer *rdb.SnapshotWriter, key string) error {
+	keys := []string{key}
+	d.RWLocks(nil, keys)
+	defer d.RWUnLocks(nil, keys)
+
+	raw, ok := d.data.Get(key)
+	if !ok || d.isExpired(key) {
+		return nil
+	}
+	var expiration *time.Time
+	if rawExpireTime, ok := d.ttl.Get(key); ok {
+		expireTime, _ := rawExpireTime.(time.Time)
+		expiration = &expireTime
+	}
+	return writer.WriteEntry(d.index, key, raw.(*db.DataEntity), expiration)
+}
+
+// loadSnapshot 启动时从快照文件加载数据, 文件不存在时不加载, 已经过期的key和超出数据库数量的key被丢弃
+func (database *StandaloneDatabase) loadSnapshot(filename string) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error(err)
+		}
+		return
+	}
+	defer file.Close()
+
+	now := time.Now()
+	err = rdb.ReadSnapshot(file, func(dbIndex int, key string, entity *db.DataEntity, expiration *time.Time) bool {
+		d, errReply := database.selectDB(dbIndex)
+		if errReply != nil {
+			logger.Warn("snapshot: skip key " + key + ": " + errReply.Error())
+			return true
+		}
+		if expiration != nil && !expiration.After(now) {
+			return true
+		}
+		d.putEntity(key, entity)
+		if expiration != nil {
+			d.expire(key, *expiration)
+		}
+		return true
+	})
+	if err != nil {
+		logger.Error("load snapshot " + filename + ": " + err.Error())
+	}
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go-redis/config"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+)
+
+func TestSaveAndLoadSnapshot(t *testing.T) {
+	filename := config.Properties.DbFilename
+	defer func() { config.Properties.DbFilename = filename }()
+	config.Properties.DbFilename = ""
+
+	conn := connection.NewFakeConn()
+	result := testServer.Exec(conn, utils.ToCmdLine("save"))
+	asserts.AssertErrReply(t, result, "ERR dbfilename is not configured")
+
+	config.Properties.DbFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	testServer.Exec(conn, utils.ToCmdLine("flushall"))
+	testServer.Exec(conn, utils.ToCmdLine("set", "str", "v"))
+	testServer.Exec(conn, utils.ToCmdLine("rpush", "list", "a", "b", "c"))
+	testServer.Exec(conn, utils.ToCmdLine("set", "ttl", "v"))
+	testServer.Exec(conn, utils.ToCmdLine("expire", "ttl", "100"))
+	testServer.Exec(conn, utils.ToCmdLine("select", "1"))
+	testServer.Exec(conn, utils.ToCmdLine("hset", "hash", "f", "v"))
+	testServer.Exec(conn, utils.ToCmdLine("select", "0"))
+
+	// 事务中不能保存快照
+	testServer.Exec(conn, utils.ToCmdLine("multi"))
+	result = testServer.Exec(conn, utils.ToCmdLine("save"))
+	asserts.AssertErrReply(t, result, "ERR command 'SAVE' cannot be used in MULTI")
+	testServer.Exec(conn, utils.ToCmdLine("discard"))
+
+	result = testServer.Exec(conn, utils.ToCmdLine("save"))
+	asserts.AssertStatusReply(t, result, "OK")
+	testServer.saving.Store(true)
+	result = testServer.Exec(conn, utils.ToCmdLine("bgsave"))
+	asserts.AssertErrReply(t, result, "ERR Background save already in progress")
+	testServer.saving.Store(false)
+
+	// 重新创建数据库时从快照加载
+	loaded := NewStandaloneDatabase()
+	loadedConn := connection.NewFakeConn()
+	result = loaded.Exec(loadedConn, utils.ToCmdLine("get", "str"))
+	asserts.AssertBulkReply(t, result, "v")
+	result = loaded.Exec(loadedConn, utils.ToCmdLine("lrange", "list", "0", "-1"))
+	asserts.AssertMultiBulkReply(t, result, []string{"a", "b", "c"})
+	result = loaded.Exec(loadedConn, utils.ToCmdLine("ttl", "ttl"))
+	asserts.AssertIntReplyGreaterThan(t, result, 90)
+	result = loaded.Exec(loadedConn, utils.ToCmdLine("select", "1"))
+	asserts.AssertStatusReply(t, result, "OK")
+	result = loaded.Exec(loadedConn, utils.ToCmdLine("hget", "hash", "f"))
+	asserts.AssertBulkReply(t, result, "v")
+	result = loaded.Exec(loadedConn, utils.ToCmdLine("dbsize"))
+	asserts.AssertIntReply(t, result, 1)
+}
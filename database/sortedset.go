@@ -63,6 +63,7 @@ func execZAdd(d *DB, args db.Params) resp.Reply {
 	}
 
 	d.append(utils.ToCmdLine2(enum.ZADD.Name(), args...))
+	d.notify(notifyZSet, eventZAdd, key)
 
 	return reply.NewIntReply(i)
 }
@@ -98,8 +99,7 @@ func execZScore(d *DB, args db.Params) resp.Reply {
 	if !exists {
 		return reply.NewNullBulkReply()
 	}
-	value := strconv.FormatFloat(element.Score, 'f', -1, 64)
-	return reply.NewBulkReply(utils.String2Bytes(value))
+	return reply.NewDoubleReply(element.Score)
 }
 
 // execZRank 返回有序集中指定成员的排名。其中有序集成员按分数值递增(从小到大)顺序排列。
@@ -318,6 +318,11 @@ func execZRem(d *DB, args db.Params) resp.Reply {
 	}
 	if deleted > 0 {
 		d.append(utils.ToCmdLine2(enum.ZREM.String(), args...))
+		d.notify(notifyZSet, eventZRem, key)
+	}
+	if sortedSet.Length() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
 	return reply.NewIntReply(deleted)
 }
@@ -356,6 +361,11 @@ func execZRemRangeByScore(d *DB, args db.Params) resp.Reply {
 	removed := sortedSet.RemoveRange(minBorder, maxBorder)
 	if removed > 0 {
 		d.append(utils.ToCmdLine2(enum.ZREMRANGEBYSCORE.String(), args...))
+		d.notify(notifyZSet, eventZRemRangeByScore, key)
+	}
+	if sortedSet.Length() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
 	return reply.NewIntReply(removed)
 }
@@ -390,6 +400,11 @@ func execZRemRangeByRank(d *DB, args db.Params) resp.Reply {
 	removed := sortedSet.RemoveByRank(start, stop-1)
 	if removed > 0 {
 		d.append(utils.ToCmdLine2(enum.ZREMRANGEBYRANK.String(), args...))
+		d.notify(notifyZSet, eventZRemRangeByRank, key)
+	}
+	if sortedSet.Length() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
 	return reply.NewIntReply(removed)
 }
@@ -440,6 +455,11 @@ func execGenericZPopCommand(d *DB, args db.Params, cmd *enum.Command) resp.Reply
 
 	if len(removed) > 0 {
 		d.append(utils.ToCmdLine2(cmd.String(), args...))
+		d.notify(notifyZSet, utils.If(cmd == enum.ZPOPMIN, eventZPopMin, eventZPopMax), key)
+	}
+	if sortedSet.Length() == 0 {
+		d.Remove(key)
+		d.notify(notifyGeneric, eventDel, key)
 	}
 	result := make([][]byte, 0, len(removed)*2)
 	for _, element := range removed {
@@ -481,12 +501,14 @@ func execZIncrBy(d *DB, args db.Params) resp.Reply {
 	if !exists {
 		sortedSet.Add(field, delta)
 		d.append(utils.ToCmdLine2(enum.ZINCRBY.String(), args...))
+		d.notify(notifyZSet, eventZIncrBy, key)
 		return reply.NewBulkReply(args[1])
 	}
 	score := element.Score + delta
 	sortedSet.Add(field, score)
 	result := utils.String2Bytes(strconv.FormatFloat(score, 'f', -1, 64))
 	d.append(utils.ToCmdLine2(enum.ZINCRBY.String(), args...))
+	d.notify(notifyZSet, eventZIncrBy, key)
 	return reply.NewBulkReply(result)
 }
 
@@ -3,8 +3,11 @@ package database
 import (
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go-redis/acl"
 	"go-redis/aof"
 	"go-redis/config"
 	"go-redis/enum"
@@ -12,25 +15,37 @@ import (
 	"go-redis/interface/resp"
 	"go-redis/lib/logger"
 	"go-redis/lib/utils"
+	"go-redis/pubsub"
 	"go-redis/resp/reply"
+	"go-redis/tracking"
 )
 
 // StandaloneDatabase 单机版数据库
 type StandaloneDatabase struct {
-	dbSet      []*DB        // 数据库集合
-	aofHandler *aof.Handler // aof处理器
+	dbSet      []*DB           // 数据库集合
+	aofHandler *aof.Handler    // aof处理器
+	hub        *pubsub.Hub     // 发布订阅
+	tracker    *tracking.Table // 客户端缓存
+	mu         sync.RWMutex    // 执行命令和按下标访问数据库时持有读锁, SWAPDB和FLUSHALL替换dbSet中的数据库时持有写锁
+	saving     atomic.Bool     // 是否正在保存快照, 同时只能执行一个 SAVE 或 BGSAVE
+
+	listener atomic.Pointer[func(dbIndex int, cmdLine db.CmdLine)] // 写命令的监听者, 集群中用于复制到副本
 }
 
-// ExecWithoutLock 不加锁就执行命令, 并发不安全
+// ExecWithoutLock 不给key加锁就执行命令, 调用者需要持有相关key的锁
 func (database *StandaloneDatabase) ExecWithoutLock(conn resp.Connection, cmdLine db.CmdLine) resp.Reply {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	d, errReply := database.selectDB(conn.GetDBIndex())
 	if errReply != nil {
 		return errReply
 	}
-	return d.exec(cmdLine)
+	return d.execWithoutLock(conn, cmdLine)
 }
 
 func (database *StandaloneDatabase) ExecMulti(conn resp.Connection, cmdLines []db.CmdLine) resp.Reply {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	selectedDB, errReply := database.selectDB(conn.GetDBIndex())
 	if errReply != nil {
 		return errReply
@@ -39,31 +54,46 @@ func (database *StandaloneDatabase) ExecMulti(conn resp.Connection, cmdLines []d
 }
 
 func (database *StandaloneDatabase) GetUndoLogs(dbIndex int, cmdLine db.CmdLine) []db.CmdLine {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	return database.mustSelectDB(dbIndex).GetUndoLogs(cmdLine)
 }
 
 func (database *StandaloneDatabase) ForEach(dbIndex int, cb func(key string, data *db.DataEntity, expiration *time.Time) bool) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	database.mustSelectDB(dbIndex).ForEach(cb)
 }
 
+// RWLocks 给数据库中的key上锁, 锁属于数据库而不是下标, 加锁和解锁之间不能执行 SWAPDB, 集群中不支持 SWAPDB
 func (database *StandaloneDatabase) RWLocks(dbIndex int, writeKeys, readKeys []string) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	database.mustSelectDB(dbIndex).RWLocks(writeKeys, readKeys)
 }
 
 func (database *StandaloneDatabase) RWUnLocks(dbIndex int, writeKeys []string, readKeys []string) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	database.mustSelectDB(dbIndex).RWUnLocks(writeKeys, readKeys)
 }
 
 func (database *StandaloneDatabase) GetDBSize(dbIndex int) (dataSize int, ttlSize int) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	d := database.mustSelectDB(dbIndex)
 	return d.data.Len(), d.ttl.Len()
 }
 
 func (database *StandaloneDatabase) GetEntity(dbIndex int, key string) (*db.DataEntity, bool) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	return database.mustSelectDB(dbIndex).getEntity(key)
 }
 
 func (database *StandaloneDatabase) GetExpiration(dbIndex int, key string) *time.Time {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
 	raw, ok := database.mustSelectDB(dbIndex).ttl.Get(key)
 	if !ok {
 		return nil
@@ -72,16 +102,41 @@ func (database *StandaloneDatabase) GetExpiration(dbIndex int, key string) *time
 	return &expireTime
 }
 
+func (database *StandaloneDatabase) GetVersion(dbIndex int, key string) uint32 {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
+	return database.mustSelectDB(dbIndex).getVersion(key)
+}
+
+// DisableSlowLog 不再记录慢查询, 集群中由路由记录包括转发时间在内的执行时间, 避免重复记录
+func (database *StandaloneDatabase) DisableSlowLog() {
+	database.mu.Lock()
+	defer database.mu.Unlock()
+	for _, d := range database.dbSet {
+		d.slowLog = false
+	}
+}
+
 func NewStandaloneDatabase() *StandaloneDatabase {
 	if config.Properties.Databases == 0 {
 		config.Properties.Databases = 16
 	}
 
+	hub := pubsub.NewHub()
+	tracker := tracking.NewTable(hub)
 	dbSet := make([]*DB, config.Properties.Databases)
 	for i := range dbSet {
 		dbSet[i] = newDB(i)
+		dbSet[i].hub = hub
+		dbSet[i].tracker = tracker
+		dbSet[i].slowLog = true
+	}
+	d := &StandaloneDatabase{dbSet: dbSet, hub: hub, tracker: tracker}
+
+	// acl
+	if err := acl.Setup(); err != nil {
+		panic(err)
 	}
-	d := &StandaloneDatabase{dbSet: dbSet}
 
 	// aof
 	if config.Properties.AppendOnly {
@@ -90,18 +145,34 @@ func NewStandaloneDatabase() *StandaloneDatabase {
 			panic(err)
 		}
 		d.aofHandler = aofHandler
-		// 将aofHandler.Append方法赋值给每个db
-		for i := range dbSet {
-			j := i                                    // 闭包, 防止循环变量被修改
-			dbSet[j].append = func(line db.CmdLine) { // 给每个数据库添加aof落盘函数
-				aofHandler.Append(dbSet[j].index, line)
-			}
+	} else if config.Properties.DbFilename != "" {
+		// 开启aof时数据从aof恢复, 否则从快照加载
+		d.loadSnapshot(config.Properties.DbFilename)
+	}
+	// 写命令记录到aof并通知监听者
+	for i := range dbSet {
+		j := i                                    // 闭包, 防止循环变量被修改
+		dbSet[j].append = func(line db.CmdLine) { // 给每个数据库添加aof落盘函数
+			d.propagate(dbSet[j].index, line)
 		}
 	}
 
 	return d
 }
 
+// SetWriteListener 设置写命令的监听者, 监听者在执行命令的协程中调用, 不能阻塞
+func (database *StandaloneDatabase) SetWriteListener(listener func(dbIndex int, cmdLine db.CmdLine)) {
+	database.listener.Store(&listener)
+}
+
+// propagate 把修改了数据的命令记录到aof并通知监听者
+func (database *StandaloneDatabase) propagate(dbIndex int, cmdLine db.CmdLine) {
+	database.aofHandler.Append(dbIndex, cmdLine)
+	if listener := database.listener.Load(); listener != nil {
+		(*listener)(dbIndex, cmdLine)
+	}
+}
+
 func (database *StandaloneDatabase) Exec(client resp.Connection, args db.CmdLine) resp.Reply {
 	defer func() {
 		if err := recover(); err != nil {
@@ -110,6 +181,26 @@ func (database *StandaloneDatabase) Exec(client resp.Connection, args db.CmdLine
 	}()
 	cmdName := strings.ToUpper(utils.Bytes2String(args[0]))
 
+	// Auth
+	if cmdName == enum.SYS_AUTH.String() {
+		return Auth(client, args[1:])
+	}
+	// Hello 可以同时验证密码, 所以在验证之前执行
+	if cmdName == enum.HELLO.String() {
+		return Hello(client, args[1:], "standalone")
+	}
+	if !IsAuthenticated(client) {
+		return &reply.NormalErrReply{Status: "NOAUTH Authentication required"}
+	}
+	// ACL 检查用户的权限, 包括 FLUSHALL 和 SELECT 在内的所有命令都需要检查
+	if errReply := CheckPermission(client, cmdName, args); errReply != nil {
+		return errReply
+	}
+	if cmdName == enum.TX_EXEC.String() && client.InMultiState() {
+		if errReply := CheckQueuedPermission(client); errReply != nil {
+			return errReply
+		}
+	}
 	// FlushAll
 	if cmdName == enum.FLUSHALL.String() {
 		return database.flushAll()
@@ -121,20 +212,84 @@ func (database *StandaloneDatabase) Exec(client resp.Connection, args db.CmdLine
 		}
 		return reply.NewArgNumErrReply(cmdName)
 	}
-	// FlushDB
-	if cmdName == enum.FLUSHDB.String() && client.InMultiState() {
-		return reply.NewErrReply("command 'FlushDB' cannot be used in MULTI")
+	// FlushDB, 跨数据库的命令和发布订阅命令不能在事务中执行
+	switch cmdName {
+	case enum.FLUSHDB.String(), enum.COPY.String(), enum.MOVE.String(), enum.SWAPDB.String(),
+		enum.ACL.String(), enum.CONFIG.String(), enum.SLOWLOG.String(), enum.LATENCY.String(),
+		enum.SAVE.String(), enum.BGSAVE.String(),
+		enum.SUBSCRIBE.String(), enum.UNSUBSCRIBE.String(), enum.PSUBSCRIBE.String(), enum.PUNSUBSCRIBE.String():
+		if client.InMultiState() {
+			return reply.NewErrReply("command '" + cmdName + "' cannot be used in MULTI")
+		}
 	}
-	// Auth
-	if cmdName == enum.SYS_AUTH.String() {
-		return Auth(client, args[1:])
+	// ACL 用户, 配置, 慢查询日志, 延迟监控和快照是所有数据库共享的
+	switch cmdName {
+	case enum.ACL.String():
+		return execACL(client, args)
+	case enum.CONFIG.String():
+		return execConfig(args)
+	case enum.SLOWLOG.String():
+		return execSlowLog(args)
+	case enum.LATENCY.String():
+		return execLatency(args)
+	case enum.SAVE.String(), enum.BGSAVE.String():
+		if !ValidateArity(enum.SAVE.Arity(), args) {
+			return reply.NewArgNumErrReply(cmdName)
+		}
+		return execSave(database, cmdName)
+	case enum.CLUSTER.String():
+		return reply.NewErrReply("This instance has cluster support disabled")
 	}
-	if !IsAuthenticated(client) {
-		return &reply.NormalErrReply{Status: "NOAUTH Authentication required"}
+	// 发布订阅
+	if r, ok := execPubSub(database.hub, client, cmdName, args); ok {
+		return r
+	}
+	// 客户端缓存, CLIENT CACHING 只对下一条命令有效, 事务中对整个事务有效
+	if cmdName == enum.CLIENT.String() {
+		return execClient(database, client, args)
+	}
+	defer func() {
+		if !client.InMultiState() {
+			database.tracker.AfterCommand(client)
+		}
+	}()
+	// SwapDB 需要独占dbSet
+	if cmdName == enum.SWAPDB.String() {
+		if !ValidateArity(enum.SWAPDB.Arity(), args) {
+			return reply.NewArgNumErrReply(cmdName)
+		}
+		database.mu.Lock()
+		defer database.mu.Unlock()
+		return execSwapDB(database, client, args[1:])
+	}
+
+	database.mu.RLock()
+	defer database.mu.RUnlock()
+	// 其他跨数据库的命令
+	switch cmdName {
+	case enum.COPY.String():
+		if !ValidateArity(enum.COPY.Arity(), args) {
+			return reply.NewArgNumErrReply(cmdName)
+		}
+		return execCopy(database, client, args[1:])
+	case enum.MOVE.String():
+		if !ValidateArity(enum.MOVE.Arity(), args) {
+			return reply.NewArgNumErrReply(cmdName)
+		}
+		return execMove(database, client, args[1:])
+	case enum.DBSIZE.String():
+		if !ValidateArity(enum.DBSIZE.Arity(), args) {
+			return reply.NewArgNumErrReply(cmdName)
+		}
+		return execDBSize(database, client)
+	case enum.INFO.String():
+		return execInfo(database, args[1:])
 	}
 
-	dbIndex := client.GetDBIndex()
-	d := database.dbSet[dbIndex]
+	d, errReply := database.selectDB(client.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
 	return d.Exec(client, args)
 }
 
@@ -142,7 +297,9 @@ func (database *StandaloneDatabase) Close() error {
 	return nil
 }
 
-func (database *StandaloneDatabase) AfterClientClose(_ resp.Connection) {
+func (database *StandaloneDatabase) AfterClientClose(c resp.Connection) {
+	database.hub.UnsubscribeAll(c)
+	database.tracker.Disable(c)
 }
 
 func (database *StandaloneDatabase) selectDB(dbIndex int) (*DB, resp.ErrorReply) {
@@ -176,9 +333,13 @@ func execSelect(conn resp.Connection, database *StandaloneDatabase, args db.CmdL
 
 // flushAll flushes all databases.
 func (database *StandaloneDatabase) flushAll() resp.Reply {
+	database.mu.Lock()
+	defer database.mu.Unlock()
 	for i := range database.dbSet {
 		database.flushDB(i)
 	}
+	database.tracker.InvalidateAll()
+	database.propagate(0, utils.ToCmdLine(enum.FLUSHALL.String()))
 	return reply.NewOKReply()
 }
 
@@ -198,6 +359,9 @@ func (database *StandaloneDatabase) setDB(dbIndex int, newDB *DB) resp.Reply {
 	oldDB := database.mustSelectDB(dbIndex)
 	newDB.index = dbIndex
 	newDB.append = oldDB.append // inherit oldDB
+	newDB.hub = oldDB.hub
+	newDB.tracker = oldDB.tracker
+	newDB.slowLog = oldDB.slowLog
 	database.dbSet[dbIndex] = newDB
 	return reply.NewOKReply()
 }
@@ -57,6 +57,7 @@ func execSet(d *DB, args db.Params) resp.Reply {
 	value := str.NewString(args[1])
 	d.putEntity(key, db.NewDataEntity(value))
 	d.append(utils.ToCmdLine2(enum.SET.String(), args...))
+	d.notify(notifyString, eventSet, key)
 
 	return reply.NewOKReply()
 }
@@ -67,6 +68,9 @@ func execSetNX(d *DB, args db.Params) resp.Reply {
 	value := str.NewString(args[1])
 	n := d.putIfAbsent(key, db.NewDataEntity(value))
 	d.append(utils.ToCmdLine2(enum.SETNX.String(), args...))
+	if n > 0 {
+		d.notify(notifyString, eventSet, key)
+	}
 
 	return reply.NewIntReply(int64(n))
 }
@@ -85,6 +89,7 @@ func execGetSet(d *DB, args db.Params) resp.Reply {
 	entity, ok := d.getEntity(key)
 	d.putEntity(key, db.NewDataEntity(value))
 	d.append(utils.ToCmdLine2(enum.GETSET.String(), args...))
+	d.notify(notifyString, eventSet, key)
 
 	if !ok {
 		return reply.NewNullBulkReply()
@@ -131,6 +136,7 @@ func execIncr(d *DB, args db.Params) resp.Reply {
 	if !ok {
 		d.putEntity(key, db.NewDataEntity(str.NewString(1)))
 		d.append(utils.ToCmdLine2(enum.INCR.String(), args...))
+		d.notify(notifyString, eventIncrBy, key)
 		return reply.NewIntReply(1)
 	}
 	value, ok := entity.Data.(*str.String)
@@ -150,6 +156,7 @@ func execIncr(d *DB, args db.Params) resp.Reply {
 	d.putEntity(key, entity)
 
 	d.append(utils.ToCmdLine2(enum.INCR.String(), args...))
+	d.notify(notifyString, eventIncrBy, key)
 
 	return reply.NewIntReply(value.Int())
 }
@@ -171,6 +178,7 @@ func execDecr(d *DB, args db.Params) resp.Reply {
 	if !ok {
 		d.putEntity(key, db.NewDataEntity(str.NewString(-1)))
 		d.append(utils.ToCmdLine2(enum.DECR.String(), args...))
+		d.notify(notifyString, eventDecrBy, key)
 		return reply.NewIntReply(-1)
 	}
 	value, ok := entity.Data.(*str.String)
@@ -189,6 +197,7 @@ func execDecr(d *DB, args db.Params) resp.Reply {
 	d.putEntity(key, entity)
 
 	d.append(utils.ToCmdLine2(enum.DECR.String(), args...))
+	d.notify(notifyString, eventDecrBy, key)
 
 	return reply.NewIntReply(value.Int())
 }
@@ -211,21 +220,19 @@ func execMSet(d *DB, args db.Params) resp.Reply {
 		d.putEntity(key, db.NewDataEntity(str.NewString(value)))
 	}
 	d.append(utils.ToCmdLine2(enum.MSET.String(), args...))
+	for _, key := range keys {
+		d.notify(notifyString, eventSet, key)
+	}
 	return reply.NewOKReply()
 }
 
+// execMGet 按照参数的顺序返回多个key的值, 不存在或者不是字符串的key返回 nil
+//
+// # MGET key [key ...]
 func execMGet(d *DB, args db.Params) resp.Reply {
-	// 1. 校验参数
-	if len(args)%2 != 0 {
-		return reply.NewSyntaxErrReply()
-	}
-	// 2.取出键
 	results := make([][]byte, 0, len(args))
 	for _, keyBytes := range args {
-		s, err := d.getString(utils.Bytes2String(keyBytes))
-		if err != nil {
-			return err
-		}
+		s, _ := d.getString(utils.Bytes2String(keyBytes))
 		results = append(results, s.Bytes())
 	}
 	return reply.NewMultiBulkReply(results)
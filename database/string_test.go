@@ -4,6 +4,7 @@ import (
 	"slices"
 	"testing"
 
+	"go-redis/lib/asserts"
 	"go-redis/lib/logger"
 	"go-redis/lib/utils"
 )
@@ -41,4 +42,8 @@ func TestMGET(t *testing.T) {
 	args = utils.ToCmdLine("name", "nil")
 	r := execMGet(d, args)
 	t.Log(string(r.Bytes()))
+
+	// 奇数个key, 不存在的key返回nil
+	r = execMGet(d, utils.ToCmdLine("age", "nil", "name"))
+	asserts.AssertMultiBulkReply(t, r, []string{"18", "", "jack"})
 }
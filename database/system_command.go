@@ -1,7 +1,10 @@
 package database
 
 import (
-	"go-redis/config"
+	"strconv"
+	"strings"
+
+	"go-redis/acl"
 	"go-redis/enum"
 	"go-redis/interface/db"
 	"go-redis/interface/resp"
@@ -9,26 +12,140 @@ import (
 	"go-redis/resp/reply"
 )
 
-// Auth 验证密码
+// Auth 验证用户名和密码, 只有密码时验证默认用户
+//
+// # AUTH [username] password
 func Auth(conn resp.Connection, args db.Params) resp.Reply {
-	if len(args) != enum.SYS_AUTH.ParamCount() {
+	if len(args) != 1 && len(args) != 2 {
 		return reply.NewArgNumErrReply(enum.SYS_AUTH.String())
 	}
-	if config.Properties.RequirePass == "" {
-		return reply.NewErrReply("Client sent AUTH, but no password is set")
+	if len(args) == 1 {
+		if u := acl.GetUser(acl.DefaultUser); u != nil && u.NoPass() {
+			return reply.NewErrReply("AUTH <password> called without any password configured for the default user. " +
+				"Are you sure your configuration is correct?")
+		}
+		return authenticate(conn, acl.DefaultUser, utils.Bytes2String(args[0]))
 	}
-	pwd := utils.Bytes2String(args[0])
-	conn.SetPassword(pwd)
-	if config.Properties.RequirePass != pwd {
-		return reply.NewErrReply("invalid password")
+	return authenticate(conn, utils.Bytes2String(args[0]), utils.Bytes2String(args[1]))
+}
+
+// authenticate 验证成功后连接切换到新的用户, 失败时保持原来的用户并记录到 ACL LOG
+func authenticate(conn resp.Connection, username, password string) resp.Reply {
+	u, err := acl.Authenticate(username, password)
+	if err != nil {
+		acl.AddLog(acl.ReasonAuth, aclContext(conn), enum.SYS_AUTH.String(), username, clientInfo(conn))
+		return &reply.NormalErrReply{Status: err.Error()}
 	}
+	conn.SetUser(username, u.ID())
 	return reply.NewOKReply()
 }
 
-// IsAuthenticated 判断是否验证密码成功, 如果没有配置密码或者密码匹配返回true, 否则返回false
+// IsAuthenticated 判断连接是否已经通过验证
+//
+// 没有执行过 AUTH 的连接使用默认用户, 只有默认用户不需要密码时才通过验证;
+// 用户被删除, 禁用, 或者删除之后重新创建时连接不再通过验证, 需要重新执行 AUTH
+//
+// 以默认用户通过验证的连接会记录用户名, 之后通过 CONFIG SET require-pass 设置密码时已有的连接不需要重新验证
 func IsAuthenticated(conn resp.Connection) bool {
-	if config.Properties.RequirePass == "" {
+	name, id := conn.GetUser()
+	if name == "" {
+		u := acl.GetUser(acl.DefaultUser)
+		if u == nil || !u.Enabled() || !u.NoPass() {
+			return false
+		}
+		conn.SetUser(acl.DefaultUser, u.ID())
 		return true
 	}
-	return conn.GetPassword() == config.Properties.RequirePass
+	u := acl.GetUser(name)
+	return u != nil && u.Enabled() && u.ID() == id
+}
+
+// currentUser 返回连接的用户名, 没有执行过 AUTH 时是默认用户
+func currentUser(conn resp.Connection) string {
+	if name, _ := conn.GetUser(); name != "" {
+		return name
+	}
+	return acl.DefaultUser
+}
+
+// serverVersion 兼容的redis版本, 客户端根据这个版本判断支持的功能
+const serverVersion = "7.0.0"
+
+// Hello 协商协议版本, 同时可以验证密码和设置客户端名称, mode是服务器的运行模式: standalone 或者 cluster
+//
+// # HELLO [protover [AUTH username password] [SETNAME clientname]]
+//
+// 返回服务器和连接的信息
+func Hello(conn resp.Connection, args db.Params, mode string) resp.Reply {
+	// 1. 解析协议版本
+	protocol := conn.GetProtocol()
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(utils.Bytes2String(args[0]))
+		if err != nil {
+			return reply.NewErrReply("Protocol version is not an integer or out of range")
+		}
+		if ver != enum.RESP2 && ver != enum.RESP3 {
+			return &reply.NormalErrReply{Status: "NOPROTO unsupported protocol version"}
+		}
+		protocol = ver
+	}
+	// 2. 解析选项
+	var user, password, name []byte
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(utils.Bytes2String(args[i])) {
+		case enum.HELLO_AUTH:
+			if i+2 >= len(args) {
+				return reply.NewSyntaxErrReply()
+			}
+			user, password = args[i+1], args[i+2]
+			i += 2
+		case enum.HELLO_SETNAME:
+			if i+1 >= len(args) {
+				return reply.NewSyntaxErrReply()
+			}
+			i++
+			name = args[i]
+		default:
+			return reply.NewSyntaxErrReply()
+		}
+	}
+	// 3. 验证密码
+	if user != nil {
+		if r := authenticate(conn, utils.Bytes2String(user), utils.Bytes2String(password)); reply.IsErrReply(r) {
+			return r
+		}
+	}
+	if !IsAuthenticated(conn) {
+		return &reply.NormalErrReply{Status: "NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time"}
+	}
+	// 4. 设置客户端名称和协议版本
+	if name != nil {
+		if errReply := validateClientName(name); errReply != nil {
+			return errReply
+		}
+		conn.SetName(string(name))
+	}
+	conn.SetProtocol(protocol)
+
+	return reply.NewMapReply([]resp.Reply{
+		reply.NewBulkReply([]byte("server")), reply.NewBulkReply([]byte("redis")),
+		reply.NewBulkReply([]byte("version")), reply.NewBulkReply([]byte(serverVersion)),
+		reply.NewBulkReply([]byte("proto")), reply.NewIntReply(int64(protocol)),
+		reply.NewBulkReply([]byte("id")), reply.NewIntReply(conn.GetID()),
+		reply.NewBulkReply([]byte("mode")), reply.NewBulkReply([]byte(mode)),
+		reply.NewBulkReply([]byte("role")), reply.NewBulkReply([]byte("master")),
+		reply.NewBulkReply([]byte("modules")), reply.NewEmptyMultiBulkReply(),
+	})
+}
+
+// validateClientName 客户端名称不能包含空格和特殊字符
+func validateClientName(name []byte) resp.ErrorReply {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return reply.NewErrReply("Client names cannot contain spaces, newlines or special characters.")
+		}
+	}
+	return nil
 }
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"go-redis/enum"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+func TestHello(t *testing.T) {
+	conn := connection.NewFakeConn()
+	defer testServer.AfterClientClose(conn)
+
+	result := testServer.Exec(conn, utils.ToCmdLine("hello", "4"))
+	asserts.AssertErrReply(t, result, "NOPROTO unsupported protocol version")
+	result = testServer.Exec(conn, utils.ToCmdLine("hello", "foo"))
+	asserts.AssertErrReply(t, result, "ERR Protocol version is not an integer or out of range")
+	result = testServer.Exec(conn, utils.ToCmdLine("hello", "3", "setname", "a b"))
+	asserts.AssertErrReply(t, result, "ERR Client names cannot contain spaces, newlines or special characters.")
+	if conn.GetProtocol() != enum.RESP2 {
+		t.Errorf("protocol should not change on error")
+	}
+
+	result = testServer.Exec(conn, utils.ToCmdLine("hello", "3", "setname", "myclient"))
+	if _, ok := result.(*reply.MapReply); !ok {
+		t.Fatalf("expected map reply, actually %q", result.Bytes())
+	}
+	if !strings.HasPrefix(string(reply.Marshal(result, enum.RESP3)), "%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n") {
+		t.Errorf("unexpected hello reply %q", reply.Marshal(result, enum.RESP3))
+	}
+	if conn.GetProtocol() != enum.RESP3 {
+		t.Errorf("expected protocol 3, actually %d", conn.GetProtocol())
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("client", "getname"))
+	asserts.AssertBulkReply(t, result, "myclient")
+	// 不带参数时只返回服务器信息, 不改变协议
+	testServer.Exec(conn, utils.ToCmdLine("hello"))
+	if conn.GetProtocol() != enum.RESP3 {
+		t.Errorf("expected protocol 3, actually %d", conn.GetProtocol())
+	}
+}
+
+func TestResp3Replies(t *testing.T) {
+	conn := connection.NewFakeConn()
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	testServer.Exec(conn, utils.ToCmdLine("hset", "h", "f", "v"))
+	testServer.Exec(conn, utils.ToCmdLine("sadd", "s", "m"))
+	testServer.Exec(conn, utils.ToCmdLine("zadd", "z", "1.5", "m", "2", "n"))
+
+	tests := []struct {
+		cmdLine      []string
+		resp2, resp3 string
+	}{
+		{[]string{"hgetall", "h"}, "*2\r\n$1\r\nf\r\n$1\r\nv\r\n", "%1\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{[]string{"hgetall", "none"}, "*0\r\n", "%0\r\n"},
+		{[]string{"smembers", "s"}, "*1\r\n$1\r\nm\r\n", "~1\r\n$1\r\nm\r\n"},
+		{[]string{"zscore", "z", "m"}, "$3\r\n1.5\r\n", ",1.5\r\n"},
+	}
+	for _, tt := range tests {
+		result := testServer.Exec(conn, utils.ToCmdLine(tt.cmdLine...))
+		if actual := string(reply.Marshal(result, enum.RESP2)); actual != tt.resp2 {
+			t.Errorf("%v resp2: expected %q, actually %q", tt.cmdLine, tt.resp2, actual)
+		}
+		if actual := string(reply.Marshal(result, enum.RESP3)); actual != tt.resp3 {
+			t.Errorf("%v resp3: expected %q, actually %q", tt.cmdLine, tt.resp3, actual)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	conn := connection.NewFakeConn()
+	testServer.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+	testServer.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	testServer.Exec(conn, utils.ToCmdLine("set", "b", "2"))
+	testServer.Exec(conn, utils.ToCmdLine("expire", "b", "100"))
+
+	result := testServer.Exec(conn, utils.ToCmdLine("info", "keyspace"))
+	expected := "# Keyspace\r\ndb0:keys=2,expires=1,avg_ttl=0\r\n"
+	if string(result.Bytes()) != string(reply.NewBulkReply([]byte(expected)).Bytes()) {
+		t.Errorf("expected %q, actually %q", expected, result.Bytes())
+	}
+	if !strings.HasPrefix(string(reply.Marshal(result, enum.RESP3)), "=") {
+		t.Errorf("expected verbatim reply, actually %q", reply.Marshal(result, enum.RESP3))
+	}
+	result = testServer.Exec(conn, utils.ToCmdLine("info"))
+	if !strings.Contains(string(result.Bytes()), "redis_version:"+serverVersion) {
+		t.Errorf("server section missing: %q", result.Bytes())
+	}
+}
@@ -245,7 +245,7 @@ func (dict *ConcurrentDict) RandomKeys(n int) (keys []string) {
 	// 3. 以现在的系统时间创建随机数
 	nR := rand.New(rand.NewSource(time.Now().UnixNano()))
 	// 4. 遍历
-	for i := 0; i < n; {
+	for len(keys) < n {
 		sd := dict.buckets[nR.Intn(dict.shardCount)]
 		if sd == nil {
 			continue
@@ -259,7 +259,7 @@ func (ql *QuickList) Range(start, stop int) []any {
 func NewQuickList() *QuickList {
 	l := &QuickList{
 		data:      list.New(),
-		shardSize: config.Properties.ListMaxShardSize,
+		shardSize: config.ListMaxShardSize(),
 	}
 	return l
 }
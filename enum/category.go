@@ -0,0 +1,94 @@
+package enum
+
+import (
+	"sort"
+	"strings"
+)
+
+// ACL中命令的类别, 和Redis的命令类别保持一致
+const (
+	CAT_KEYSPACE uint64 = 1 << iota
+	CAT_READ
+	CAT_WRITE
+	CAT_SET
+	CAT_SORTEDSET
+	CAT_LIST
+	CAT_HASH
+	CAT_STRING
+	CAT_PUBSUB
+	CAT_ADMIN
+	CAT_FAST
+	CAT_SLOW
+	CAT_DANGEROUS
+	CAT_CONNECTION
+	CAT_TRANSACTION
+)
+
+// categoryNames 类别的名称, 按照 ACL CAT 输出的顺序排列
+var categoryNames = []struct {
+	name string
+	flag uint64
+}{
+	{"keyspace", CAT_KEYSPACE},
+	{"read", CAT_READ},
+	{"write", CAT_WRITE},
+	{"set", CAT_SET},
+	{"sortedset", CAT_SORTEDSET},
+	{"list", CAT_LIST},
+	{"hash", CAT_HASH},
+	{"string", CAT_STRING},
+	{"pubsub", CAT_PUBSUB},
+	{"admin", CAT_ADMIN},
+	{"fast", CAT_FAST},
+	{"slow", CAT_SLOW},
+	{"dangerous", CAT_DANGEROUS},
+	{"connection", CAT_CONNECTION},
+	{"transaction", CAT_TRANSACTION},
+}
+
+// commandTable 所有注册的命令, 键是小写的命令名称
+var commandTable = make(map[string]*Command)
+
+// register 注册命令, 使ACL可以根据名称和类别找到命令
+func register(cmd *Command) *Command {
+	commandTable[strings.ToLower(cmd.name)] = cmd
+	return cmd
+}
+
+// LookupCommand 根据命令名称查找命令, 不区分大小写
+func LookupCommand(name string) (*Command, bool) {
+	cmd, ok := commandTable[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// Commands 返回所有注册的命令, 按名称排序
+func Commands() []*Command {
+	commands := make([]*Command, 0, len(commandTable))
+	for _, cmd := range commandTable {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].name < commands[j].name
+	})
+	return commands
+}
+
+// LookupCategory 根据类别名称查找类别, 不区分大小写
+func LookupCategory(name string) (uint64, bool) {
+	name = strings.ToLower(name)
+	for _, category := range categoryNames {
+		if category.name == name {
+			return category.flag, true
+		}
+	}
+	return 0, false
+}
+
+// CategoryNames 返回所有类别的名称
+func CategoryNames() []string {
+	names := make([]string, len(categoryNames))
+	for i, category := range categoryNames {
+		names[i] = category.name
+	}
+	return names
+}
@@ -8,6 +8,7 @@ import (
 type Command struct {
 	name       string // 命令名称
 	paramCount int    // 除去命令本身后的参数数量
+	categories uint64 // 命令所属的ACL类别
 }
 
 func (cmd *Command) Bytes() []byte {
@@ -29,6 +30,11 @@ func (cmd *Command) ParamCount() int {
 	return cmd.paramCount
 }
 
+// Categories 返回命令所属的ACL类别
+func (cmd *Command) Categories() uint64 {
+	return cmd.categories
+}
+
 // Arity 返回命令带命令本身的参数数量, 即 ParamCount() + 1
 func (cmd *Command) Arity() int {
 	return utils.If(cmd.paramCount >= 0, cmd.paramCount+1, cmd.paramCount-1)
@@ -36,146 +42,257 @@ func (cmd *Command) Arity() int {
 
 // db command
 var (
-	FLUSHALL = &Command{"FLUSHALL", 0}
-	PING     = &Command{name: "PING", paramCount: 0}
-	SELECT   = &Command{name: "SELECT", paramCount: 1}
+	FLUSHALL = register(&Command{name: "FLUSHALL", paramCount: 0, categories: CAT_KEYSPACE | CAT_WRITE | CAT_SLOW | CAT_DANGEROUS})
+	PING     = register(&Command{name: "PING", paramCount: 0, categories: CAT_FAST | CAT_CONNECTION})
+	SELECT   = register(&Command{name: "SELECT", paramCount: 1, categories: CAT_FAST | CAT_CONNECTION})
+	SWAPDB   = register(&Command{name: "SWAPDB", paramCount: 2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_FAST | CAT_DANGEROUS})
+	DBSIZE   = register(&Command{name: "DBSIZE", paramCount: 0, categories: CAT_KEYSPACE | CAT_READ | CAT_FAST})
 )
 
 // keys command
 var (
-	DEL         = &Command{name: "DEL", paramCount: -1}
-	EXISTS      = &Command{name: "EXISTS", paramCount: -1}
-	FLUSHDB     = &Command{name: "FLUSHDB", paramCount: 0}
-	TYPE        = &Command{name: "TYPE", paramCount: 1}
-	RENAME      = &Command{name: "RENAME", paramCount: 2}
-	RENAMENX    = &Command{name: "RENAMENX", paramCount: 2}
-	KEYS        = &Command{name: "KEYS", paramCount: 1}
-	EXPIRE      = &Command{name: "EXPIRE", paramCount: 2}
-	EXPIREAT    = &Command{name: "EXPIREAT", paramCount: 2}
-	EXPIRETIME  = &Command{name: "EXPIRETIME", paramCount: 1}
-	TTL         = &Command{name: "TTL", paramCount: 1}
-	PEXPIRE     = &Command{name: "PEXPIRE", paramCount: 2}
-	PEXPIREAT   = &Command{name: "PEXPIREAT", paramCount: 2}
-	PEXPIRETIME = &Command{name: "PEXPIRETIME", paramCount: 1}
-	PTTL        = &Command{name: "PTTL", paramCount: 1}
-	PERSIST     = &Command{name: "PERSIST", paramCount: 2}
+	DEL         = register(&Command{name: "DEL", paramCount: -1, categories: CAT_KEYSPACE | CAT_WRITE | CAT_SLOW})
+	EXISTS      = register(&Command{name: "EXISTS", paramCount: -1, categories: CAT_KEYSPACE | CAT_READ | CAT_FAST})
+	FLUSHDB     = register(&Command{name: "FLUSHDB", paramCount: 0, categories: CAT_KEYSPACE | CAT_WRITE | CAT_SLOW | CAT_DANGEROUS})
+	TYPE        = register(&Command{name: "TYPE", paramCount: 1, categories: CAT_KEYSPACE | CAT_READ | CAT_FAST})
+	RENAME      = register(&Command{name: "RENAME", paramCount: 2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_SLOW})
+	RENAMENX    = register(&Command{name: "RENAMENX", paramCount: 2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_FAST})
+	KEYS        = register(&Command{name: "KEYS", paramCount: 1, categories: CAT_KEYSPACE | CAT_READ | CAT_SLOW | CAT_DANGEROUS})
+	EXPIRE      = register(&Command{name: "EXPIRE", paramCount: -2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_FAST})
+	EXPIREAT    = register(&Command{name: "EXPIREAT", paramCount: -2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_FAST})
+	EXPIRETIME  = register(&Command{name: "EXPIRETIME", paramCount: 1, categories: CAT_KEYSPACE | CAT_READ | CAT_FAST})
+	TTL         = register(&Command{name: "TTL", paramCount: 1, categories: CAT_KEYSPACE | CAT_READ | CAT_FAST})
+	PEXPIRE     = register(&Command{name: "PEXPIRE", paramCount: -2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_FAST})
+	PEXPIREAT   = register(&Command{name: "PEXPIREAT", paramCount: -2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_FAST})
+	PEXPIRETIME = register(&Command{name: "PEXPIRETIME", paramCount: 1, categories: CAT_KEYSPACE | CAT_READ | CAT_FAST})
+	PTTL        = register(&Command{name: "PTTL", paramCount: 1, categories: CAT_KEYSPACE | CAT_READ | CAT_FAST})
+	PERSIST     = register(&Command{name: "PERSIST", paramCount: 2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_FAST})
+	DUMP        = register(&Command{name: "DUMP", paramCount: 1, categories: CAT_KEYSPACE | CAT_READ | CAT_SLOW})
+	RESTORE     = register(&Command{name: "RESTORE", paramCount: -3, categories: CAT_KEYSPACE | CAT_WRITE | CAT_SLOW | CAT_DANGEROUS})
+	MIGRATE     = register(&Command{name: "MIGRATE", paramCount: -5, categories: CAT_KEYSPACE | CAT_WRITE | CAT_SLOW | CAT_DANGEROUS})
+	COPY        = register(&Command{name: "COPY", paramCount: -2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_SLOW})
+	MOVE        = register(&Command{name: "MOVE", paramCount: 2, categories: CAT_KEYSPACE | CAT_WRITE | CAT_FAST})
+	RANDOMKEY   = register(&Command{name: "RANDOMKEY", paramCount: 0, categories: CAT_KEYSPACE | CAT_READ | CAT_SLOW})
+	TOUCH       = register(&Command{name: "TOUCH", paramCount: -1, categories: CAT_KEYSPACE | CAT_READ | CAT_FAST})
+	UNLINK      = register(&Command{name: "UNLINK", paramCount: -1, categories: CAT_KEYSPACE | CAT_WRITE | CAT_FAST})
 )
 
 // string command
 var (
-	GET    = &Command{name: "GET", paramCount: 1}
-	SET    = &Command{name: "SET", paramCount: 2}
-	MSET   = &Command{name: "MSET", paramCount: -2}
-	MGET   = &Command{name: "MGET", paramCount: -1}
-	SETNX  = &Command{name: "SETNX", paramCount: 2}
-	STRLEN = &Command{name: "STRLEN", paramCount: 1}
-	GETSET = &Command{name: "GETSET", paramCount: 2}
-	INCR   = &Command{name: "INCR", paramCount: 1}
-	DECR   = &Command{name: "DECR", paramCount: 1}
+	GET    = register(&Command{name: "GET", paramCount: 1, categories: CAT_READ | CAT_STRING | CAT_FAST})
+	SET    = register(&Command{name: "SET", paramCount: 2, categories: CAT_WRITE | CAT_STRING | CAT_SLOW})
+	MSET   = register(&Command{name: "MSET", paramCount: -2, categories: CAT_WRITE | CAT_STRING | CAT_SLOW})
+	MGET   = register(&Command{name: "MGET", paramCount: -1, categories: CAT_READ | CAT_STRING | CAT_FAST})
+	SETNX  = register(&Command{name: "SETNX", paramCount: 2, categories: CAT_WRITE | CAT_STRING | CAT_FAST})
+	STRLEN = register(&Command{name: "STRLEN", paramCount: 1, categories: CAT_READ | CAT_STRING | CAT_FAST})
+	GETSET = register(&Command{name: "GETSET", paramCount: 2, categories: CAT_WRITE | CAT_STRING | CAT_FAST})
+	INCR   = register(&Command{name: "INCR", paramCount: 1, categories: CAT_WRITE | CAT_STRING | CAT_FAST})
+	DECR   = register(&Command{name: "DECR", paramCount: 1, categories: CAT_WRITE | CAT_STRING | CAT_FAST})
 )
 
 // zset command
 var (
-	ZADD             = &Command{name: "ZADD", paramCount: -4}
-	ZSCORE           = &Command{name: "ZSCORE", paramCount: 2}
-	ZINCRBY          = &Command{name: "ZINCRBY", paramCount: 3}
-	ZRANK            = &Command{name: "ZRANK", paramCount: 2}
-	ZCOUNT           = &Command{name: "ZCOUNT", paramCount: 3}
-	ZREVRANK         = &Command{name: "ZREVRANK", paramCount: 2}
-	ZCARD            = &Command{name: "ZCARD", paramCount: 1}
-	ZRANGE           = &Command{name: "ZRANGE", paramCount: -3}
-	ZRANGEBYSCORE    = &Command{name: "ZRANGEBYSCORE", paramCount: -3}
-	ZREVRANGE        = &Command{name: "ZREVRANGE", paramCount: -3}
-	ZREVRANGEBYSCORE = &Command{name: "ZREVRANGEBYSCORE", paramCount: -3}
-	ZPOPMIN          = &Command{name: "ZPOPMIN", paramCount: -1}
-	ZPOPMAX          = &Command{name: "ZPOPMAX", paramCount: -1}
-	ZREM             = &Command{name: "ZREM", paramCount: -2}
-	ZREMRANGEBYSCORE = &Command{name: "ZREMRANGEBYSCORE", paramCount: 3}
-	ZREMRANGEBYRANK  = &Command{name: "ZREMRANGEBYRANK", paramCount: 3}
+	ZADD             = register(&Command{name: "ZADD", paramCount: -4, categories: CAT_WRITE | CAT_SORTEDSET | CAT_FAST})
+	ZSCORE           = register(&Command{name: "ZSCORE", paramCount: 2, categories: CAT_READ | CAT_SORTEDSET | CAT_FAST})
+	ZINCRBY          = register(&Command{name: "ZINCRBY", paramCount: 3, categories: CAT_WRITE | CAT_SORTEDSET | CAT_FAST})
+	ZRANK            = register(&Command{name: "ZRANK", paramCount: 2, categories: CAT_READ | CAT_SORTEDSET | CAT_FAST})
+	ZCOUNT           = register(&Command{name: "ZCOUNT", paramCount: 3, categories: CAT_READ | CAT_SORTEDSET | CAT_FAST})
+	ZREVRANK         = register(&Command{name: "ZREVRANK", paramCount: 2, categories: CAT_READ | CAT_SORTEDSET | CAT_FAST})
+	ZCARD            = register(&Command{name: "ZCARD", paramCount: 1, categories: CAT_READ | CAT_SORTEDSET | CAT_FAST})
+	ZRANGE           = register(&Command{name: "ZRANGE", paramCount: -3, categories: CAT_READ | CAT_SORTEDSET | CAT_SLOW})
+	ZRANGEBYSCORE    = register(&Command{name: "ZRANGEBYSCORE", paramCount: -3, categories: CAT_READ | CAT_SORTEDSET | CAT_SLOW})
+	ZREVRANGE        = register(&Command{name: "ZREVRANGE", paramCount: -3, categories: CAT_READ | CAT_SORTEDSET | CAT_SLOW})
+	ZREVRANGEBYSCORE = register(&Command{name: "ZREVRANGEBYSCORE", paramCount: -3, categories: CAT_READ | CAT_SORTEDSET | CAT_SLOW})
+	ZPOPMIN          = register(&Command{name: "ZPOPMIN", paramCount: -1, categories: CAT_WRITE | CAT_SORTEDSET | CAT_FAST})
+	ZPOPMAX          = register(&Command{name: "ZPOPMAX", paramCount: -1, categories: CAT_WRITE | CAT_SORTEDSET | CAT_FAST})
+	ZREM             = register(&Command{name: "ZREM", paramCount: -2, categories: CAT_WRITE | CAT_SORTEDSET | CAT_FAST})
+	ZREMRANGEBYSCORE = register(&Command{name: "ZREMRANGEBYSCORE", paramCount: 3, categories: CAT_WRITE | CAT_SORTEDSET | CAT_SLOW})
+	ZREMRANGEBYRANK  = register(&Command{name: "ZREMRANGEBYRANK", paramCount: 3, categories: CAT_WRITE | CAT_SORTEDSET | CAT_SLOW})
 )
 
 // list command
 var (
-	LPUSH     = &Command{name: "LPUSH", paramCount: -2}
-	LPUSHX    = &Command{name: "LPUSHX", paramCount: -2}
-	RPUSH     = &Command{name: "RPUSH", paramCount: -2}
-	RPUSHX    = &Command{name: "RPUSHX", paramCount: -2}
-	LPOP      = &Command{name: "LPOP", paramCount: 1}
-	RPOP      = &Command{name: "RPOP", paramCount: 1}
-	RPOPLPUSH = &Command{name: "RPOPLPUSH", paramCount: 2}
-	LREM      = &Command{name: "LREM", paramCount: 3}
-	LLEN      = &Command{name: "LLEN", paramCount: 1}
-	LINDEX    = &Command{name: "LINDEX", paramCount: 2}
-	LSET      = &Command{name: "LSET", paramCount: 3}
-	LRANGE    = &Command{name: "LRANGE", paramCount: 3}
-	LTRIM     = &Command{name: "LTRIM", paramCount: 3}
-	LINSERT   = &Command{name: "LINSERT", paramCount: 4}
+	LPUSH     = register(&Command{name: "LPUSH", paramCount: -2, categories: CAT_WRITE | CAT_LIST | CAT_FAST})
+	LPUSHX    = register(&Command{name: "LPUSHX", paramCount: -2, categories: CAT_WRITE | CAT_LIST | CAT_FAST})
+	RPUSH     = register(&Command{name: "RPUSH", paramCount: -2, categories: CAT_WRITE | CAT_LIST | CAT_FAST})
+	RPUSHX    = register(&Command{name: "RPUSHX", paramCount: -2, categories: CAT_WRITE | CAT_LIST | CAT_FAST})
+	LPOP      = register(&Command{name: "LPOP", paramCount: 1, categories: CAT_WRITE | CAT_LIST | CAT_FAST})
+	RPOP      = register(&Command{name: "RPOP", paramCount: 1, categories: CAT_WRITE | CAT_LIST | CAT_FAST})
+	RPOPLPUSH = register(&Command{name: "RPOPLPUSH", paramCount: 2, categories: CAT_WRITE | CAT_LIST | CAT_SLOW})
+	LREM      = register(&Command{name: "LREM", paramCount: 3, categories: CAT_WRITE | CAT_LIST | CAT_SLOW})
+	LLEN      = register(&Command{name: "LLEN", paramCount: 1, categories: CAT_READ | CAT_LIST | CAT_FAST})
+	LINDEX    = register(&Command{name: "LINDEX", paramCount: 2, categories: CAT_READ | CAT_LIST | CAT_SLOW})
+	LSET      = register(&Command{name: "LSET", paramCount: 3, categories: CAT_WRITE | CAT_LIST | CAT_SLOW})
+	LRANGE    = register(&Command{name: "LRANGE", paramCount: 3, categories: CAT_READ | CAT_LIST | CAT_SLOW})
+	LTRIM     = register(&Command{name: "LTRIM", paramCount: 3, categories: CAT_WRITE | CAT_LIST | CAT_SLOW})
+	LINSERT   = register(&Command{name: "LINSERT", paramCount: 4, categories: CAT_WRITE | CAT_LIST | CAT_SLOW})
 )
 
 // hash command
 var (
-	HDEL    = &Command{name: "HDEL", paramCount: -2}
-	HEXISTS = &Command{name: "HEXISTS", paramCount: 2}
-	HGET    = &Command{name: "HGET", paramCount: 2}
-	HGETALL = &Command{name: "HGETALL", paramCount: 1}
-	HKEYS   = &Command{name: "HKEYS", paramCount: 1}
-	HLEN    = &Command{name: "HLEN", paramCount: 1}
-	HMGET   = &Command{name: "HMGET", paramCount: -2}
-	HSET    = &Command{name: "HSET", paramCount: 3}
-	HMSET   = &Command{name: "HMSET", paramCount: -3}
-	HSETNX  = &Command{name: "HSETNX", paramCount: 3}
-	HVALS   = &Command{name: "HVALS", paramCount: 1}
+	HDEL    = register(&Command{name: "HDEL", paramCount: -2, categories: CAT_WRITE | CAT_HASH | CAT_FAST})
+	HEXISTS = register(&Command{name: "HEXISTS", paramCount: 2, categories: CAT_READ | CAT_HASH | CAT_FAST})
+	HGET    = register(&Command{name: "HGET", paramCount: 2, categories: CAT_READ | CAT_HASH | CAT_FAST})
+	HGETALL = register(&Command{name: "HGETALL", paramCount: 1, categories: CAT_READ | CAT_HASH | CAT_SLOW})
+	HKEYS   = register(&Command{name: "HKEYS", paramCount: 1, categories: CAT_READ | CAT_HASH | CAT_SLOW})
+	HLEN    = register(&Command{name: "HLEN", paramCount: 1, categories: CAT_READ | CAT_HASH | CAT_FAST})
+	HMGET   = register(&Command{name: "HMGET", paramCount: -2, categories: CAT_READ | CAT_HASH | CAT_FAST})
+	HSET    = register(&Command{name: "HSET", paramCount: 3, categories: CAT_WRITE | CAT_HASH | CAT_FAST})
+	HMSET   = register(&Command{name: "HMSET", paramCount: -3, categories: CAT_WRITE | CAT_HASH | CAT_FAST})
+	HSETNX  = register(&Command{name: "HSETNX", paramCount: 3, categories: CAT_WRITE | CAT_HASH | CAT_FAST})
+	HVALS   = register(&Command{name: "HVALS", paramCount: 1, categories: CAT_READ | CAT_HASH | CAT_SLOW})
 )
 
 // set command
 var (
-	SADD        = &Command{name: "SADD", paramCount: -2}
-	SCARD       = &Command{name: "SCARD", paramCount: 1}
-	SDIFF       = &Command{name: "SDIFF", paramCount: -1}
-	SDIFFSTORE  = &Command{name: "SDIFFSTORE", paramCount: -2}
-	SINTER      = &Command{name: "SINTER", paramCount: -1}
-	SINTERSTORE = &Command{name: "SINTERSTORE", paramCount: -2}
-	SISMEMBER   = &Command{name: "SISMEMBER", paramCount: 2}
-	SMEMBERS    = &Command{name: "SMEMBERS", paramCount: 1}
-	SMOVE       = &Command{name: "SMOVE", paramCount: 3}
-	SPOP        = &Command{name: "SPOP", paramCount: -1}
-	SRANDMEMBER = &Command{name: "SRANDMEMBER", paramCount: -1}
-	SREM        = &Command{name: "SREM", paramCount: -2}
-	SUNION      = &Command{name: "SUNION", paramCount: -1}
-	SUNIONSTORE = &Command{name: "SUNIONSTORE", paramCount: -2}
+	SADD        = register(&Command{name: "SADD", paramCount: -2, categories: CAT_WRITE | CAT_SET | CAT_FAST})
+	SCARD       = register(&Command{name: "SCARD", paramCount: 1, categories: CAT_READ | CAT_SET | CAT_FAST})
+	SDIFF       = register(&Command{name: "SDIFF", paramCount: -1, categories: CAT_READ | CAT_SET | CAT_SLOW})
+	SDIFFSTORE  = register(&Command{name: "SDIFFSTORE", paramCount: -2, categories: CAT_WRITE | CAT_SET | CAT_SLOW})
+	SINTER      = register(&Command{name: "SINTER", paramCount: -1, categories: CAT_READ | CAT_SET | CAT_SLOW})
+	SINTERSTORE = register(&Command{name: "SINTERSTORE", paramCount: -2, categories: CAT_WRITE | CAT_SET | CAT_SLOW})
+	SISMEMBER   = register(&Command{name: "SISMEMBER", paramCount: 2, categories: CAT_READ | CAT_SET | CAT_FAST})
+	SMEMBERS    = register(&Command{name: "SMEMBERS", paramCount: 1, categories: CAT_READ | CAT_SET | CAT_SLOW})
+	SMOVE       = register(&Command{name: "SMOVE", paramCount: 3, categories: CAT_WRITE | CAT_SET | CAT_FAST})
+	SPOP        = register(&Command{name: "SPOP", paramCount: -1, categories: CAT_WRITE | CAT_SET | CAT_FAST})
+	SRANDMEMBER = register(&Command{name: "SRANDMEMBER", paramCount: -1, categories: CAT_READ | CAT_SET | CAT_SLOW})
+	SREM        = register(&Command{name: "SREM", paramCount: -2, categories: CAT_WRITE | CAT_SET | CAT_FAST})
+	SUNION      = register(&Command{name: "SUNION", paramCount: -1, categories: CAT_READ | CAT_SET | CAT_SLOW})
+	SUNIONSTORE = register(&Command{name: "SUNIONSTORE", paramCount: -2, categories: CAT_WRITE | CAT_SET | CAT_SLOW})
 )
 
 // transactions command
 var (
 	// local
-	TX_MULTI   = &Command{name: "MULTI", paramCount: 0}
-	TX_EXEC    = &Command{name: "EXEC", paramCount: 0}
-	TX_DISCARD = &Command{name: "DISCARD", paramCount: 0}
-	TX_WATCH   = &Command{name: "WATCH", paramCount: -1}
-	TX_UNWATCH = &Command{name: "UNWATCH", paramCount: 0}
+	TX_MULTI   = register(&Command{name: "MULTI", paramCount: 0, categories: CAT_FAST | CAT_TRANSACTION})
+	TX_EXEC    = register(&Command{name: "EXEC", paramCount: 0, categories: CAT_SLOW | CAT_TRANSACTION})
+	TX_DISCARD = register(&Command{name: "DISCARD", paramCount: 0, categories: CAT_FAST | CAT_TRANSACTION})
+	TX_WATCH   = register(&Command{name: "WATCH", paramCount: -1, categories: CAT_FAST | CAT_TRANSACTION})
+	TX_UNWATCH = register(&Command{name: "UNWATCH", paramCount: 0, categories: CAT_FAST | CAT_TRANSACTION})
 	// cluster
-	TCC_PREPARE  = &Command{name: "PREPARE", paramCount: -2}
-	TCC_COMMIT   = &Command{name: "COMMIT", paramCount: 1}
-	TCC_ROLLBACK = &Command{name: "ROLLBACK", paramCount: 1}
+	TCC_PREPARE  = register(&Command{name: "PREPARE", paramCount: -2, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	TCC_COMMIT   = register(&Command{name: "COMMIT", paramCount: 1, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	TCC_ROLLBACK = register(&Command{name: "ROLLBACK", paramCount: 1, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	// 集群中的 MULTI/EXEC: 多个节点的事务先在每个节点 PREPAREMULTI, 只涉及一个节点时直接 EXEC_
+	TCC_PREPARE_MULTI = register(&Command{name: "PREPAREMULTI", paramCount: -3, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	MULTI_EXEC        = register(&Command{name: "EXEC_", paramCount: -2, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	MULTI_WATCH       = register(&Command{name: "WATCH_", paramCount: -1, categories: CAT_ADMIN | CAT_FAST | CAT_DANGEROUS})
 )
 
 // cluster command
 var (
-	MULTI_RENAMEFROM = &Command{name: "RENAMEFROM", paramCount: 1}
-	MULTI_DUMPKEY    = &Command{name: "DUMPKEY", paramCount: 1}
-	MULTI_RENAMETO   = &Command{name: "RENAMETO", paramCount: 3}
-	MULTI_KEYS       = &Command{name: "KEYS_", paramCount: KEYS.paramCount}
+	MULTI_RENAMEFROM = register(&Command{name: "RENAMEFROM", paramCount: 1, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	MULTI_KEYS       = register(&Command{name: "KEYS_", paramCount: KEYS.paramCount, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	MULTI_PUBLISH    = register(&Command{name: "PUBLISH_", paramCount: 2, categories: CAT_ADMIN | CAT_FAST | CAT_DANGEROUS})
+	REPL_APPLY       = register(&Command{name: "REPLAPPLY", paramCount: -3, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	TCC              = register(&Command{name: "TCC", paramCount: -1, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+)
+
+// pub/sub command
+var (
+	SUBSCRIBE    = register(&Command{name: "SUBSCRIBE", paramCount: -1, categories: CAT_PUBSUB | CAT_SLOW})
+	UNSUBSCRIBE  = register(&Command{name: "UNSUBSCRIBE", paramCount: 0, categories: CAT_PUBSUB | CAT_SLOW}) // 参数可以为空, 不检查参数数量
+	PSUBSCRIBE   = register(&Command{name: "PSUBSCRIBE", paramCount: -1, categories: CAT_PUBSUB | CAT_SLOW})
+	PUNSUBSCRIBE = register(&Command{name: "PUNSUBSCRIBE", paramCount: 0, categories: CAT_PUBSUB | CAT_SLOW}) // 参数可以为空, 不检查参数数量
+	PUBLISH      = register(&Command{name: "PUBLISH", paramCount: 2, categories: CAT_PUBSUB | CAT_FAST})
+	PUBSUB       = register(&Command{name: "PUBSUB", paramCount: -1, categories: CAT_PUBSUB | CAT_SLOW})
 )
 
 // system command
 var (
-	SYS_AUTH = &Command{name: "AUTH", paramCount: 1}
+	SYS_AUTH = register(&Command{name: "AUTH", paramCount: -1, categories: CAT_FAST | CAT_CONNECTION})
+	CLIENT   = register(&Command{name: "CLIENT", paramCount: -1, categories: CAT_SLOW | CAT_CONNECTION})
+	HELLO    = register(&Command{name: "HELLO", paramCount: 0, categories: CAT_FAST | CAT_CONNECTION}) // 参数可以为空, 不检查参数数量
+	ACL      = register(&Command{name: "ACL", paramCount: -1, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	INFO     = register(&Command{name: "INFO", paramCount: 0, categories: CAT_SLOW | CAT_DANGEROUS}) // 参数可以为空, 不检查参数数量
+	CONFIG   = register(&Command{name: "CONFIG", paramCount: -1, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	SLOWLOG  = register(&Command{name: "SLOWLOG", paramCount: -1, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	LATENCY  = register(&Command{name: "LATENCY", paramCount: -1, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	SAVE     = register(&Command{name: "SAVE", paramCount: 0, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	BGSAVE   = register(&Command{name: "BGSAVE", paramCount: 0, categories: CAT_ADMIN | CAT_SLOW | CAT_DANGEROUS})
+	CLUSTER  = register(&Command{name: "CLUSTER", paramCount: -1, categories: CAT_SLOW})
+	ASKING   = register(&Command{name: "ASKING", paramCount: 0, categories: CAT_FAST | CAT_CONNECTION})
 )
 
 // Command flags
 const (
-	ZSET_WITH_SCORES = "WITHSCORES"
-	ZSET_LIMIT       = "LIMIT"
-	LIST_BEFORE      = "BEFORE"
-	LIST_AFTER       = "AFTER"
+	ZSET_WITH_SCORES        = "WITHSCORES"
+	ZSET_LIMIT              = "LIMIT"
+	LIST_BEFORE             = "BEFORE"
+	LIST_AFTER              = "AFTER"
+	RESTORE_REPLACE         = "REPLACE"
+	RESTORE_ABSTTL          = "ABSTTL"
+	RESTORE_IDLETIME        = "IDLETIME"
+	RESTORE_FREQ            = "FREQ"
+	COPY_DB                 = "DB"
+	COPY_REPLACE            = "REPLACE"
+	EXPIRE_NX               = "NX"
+	EXPIRE_XX               = "XX"
+	EXPIRE_GT               = "GT"
+	EXPIRE_LT               = "LT"
+	PUBSUB_CHANNELS         = "CHANNELS"
+	PUBSUB_NUMSUB           = "NUMSUB"
+	PUBSUB_NUMPAT           = "NUMPAT"
+	CLIENT_ID               = "ID"
+	CLIENT_TRACKING         = "TRACKING"
+	CLIENT_CACHING          = "CACHING"
+	CLIENT_GETREDIR         = "GETREDIR"
+	CLIENT_SETNAME          = "SETNAME"
+	CLIENT_GETNAME          = "GETNAME"
+	HELLO_AUTH              = "AUTH"
+	HELLO_SETNAME           = "SETNAME"
+	TRACKING_ON             = "ON"
+	TRACKING_OFF            = "OFF"
+	TRACKING_REDIR          = "REDIRECT"
+	TRACKING_PREFIX         = "PREFIX"
+	TRACKING_BCAST          = "BCAST"
+	TRACKING_OPTIN          = "OPTIN"
+	TRACKING_OPTOUT         = "OPTOUT"
+	TRACKING_NOLOOP         = "NOLOOP"
+	CACHING_YES             = "YES"
+	CACHING_NO              = "NO"
+	ACL_SETUSER             = "SETUSER"
+	ACL_GETUSER             = "GETUSER"
+	ACL_DELUSER             = "DELUSER"
+	ACL_LIST                = "LIST"
+	ACL_USERS               = "USERS"
+	ACL_WHOAMI              = "WHOAMI"
+	ACL_CAT                 = "CAT"
+	ACL_LOG                 = "LOG"
+	ACL_LOAD                = "LOAD"
+	ACL_SAVE                = "SAVE"
+	ACL_LOG_RESET           = "RESET"
+	CONFIG_GET              = "GET"
+	CONFIG_SET              = "SET"
+	CONFIG_REWRITE          = "REWRITE"
+	SLOWLOG_GET             = "GET"
+	SLOWLOG_LEN             = "LEN"
+	SLOWLOG_RESET           = "RESET"
+	LATENCY_LATEST          = "LATEST"
+	LATENCY_HISTORY         = "HISTORY"
+	LATENCY_RESET           = "RESET"
+	CLUSTER_SLOTS           = "SLOTS"
+	CLUSTER_SHARDS          = "SHARDS"
+	CLUSTER_KEYSLOT         = "KEYSLOT"
+	CLUSTER_COUNTKEYSINSLOT = "COUNTKEYSINSLOT"
+	CLUSTER_GETKEYSINSLOT   = "GETKEYSINSLOT"
+	CLUSTER_MEET            = "MEET"
+	CLUSTER_ADDSLOTS        = "ADDSLOTS"
+	CLUSTER_SETSLOT         = "SETSLOT"
+	CLUSTER_REDIRECT        = "REDIRECT"
+	CLUSTER_REBALANCE       = "REBALANCE"
+	CLUSTER_NODES           = "NODES"
+	CLUSTER_FORGET          = "FORGET"
+	CLUSTER_REPLICATE       = "REPLICATE"
+	TCC_LIST                = "LIST"
+	TCC_STATUS              = "STATUS"
+	SETSLOT_MIGRATING       = "MIGRATING"
+	SETSLOT_IMPORTING       = "IMPORTING"
+	SETSLOT_STABLE          = "STABLE"
+	SETSLOT_NODE            = "NODE"
+	MIGRATE_COPY            = "COPY"
+	MIGRATE_REPLACE         = "REPLACE"
+	MIGRATE_AUTH            = "AUTH"
+	MIGRATE_AUTH2           = "AUTH2"
+	MIGRATE_KEYS            = "KEYS"
 )
@@ -2,6 +2,12 @@ package enum
 
 const CRLF = "\r\n"
 
+// 协议版本, 通过 HELLO 命令协商
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
 // 固定回复
 const (
 	PONG             = "+PONG" + CRLF
@@ -9,6 +15,7 @@ const (
 	NIL              = "$-1" + CRLF
 	EMPTY_BULK_REPLY = "*0" + CRLF
 	NO_REPLY         = "" + CRLF
+	NULL             = "_" + CRLF // RESP3
 )
 
 // 错误回复
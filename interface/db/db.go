@@ -42,4 +42,8 @@ type DBEngine interface {
 	GetDBSize(dbIndex int) (int, int)
 	GetEntity(dbIndex int, key string) (*DataEntity, bool)
 	GetExpiration(dbIndex int, key string) *time.Time
+	// GetVersion 返回key的版本号, 每次修改key时加1, 用于 WATCH
+	GetVersion(dbIndex int, key string) uint32
+	// SetWriteListener 设置写命令的监听者, 每条修改了数据的命令执行之后调用
+	SetWriteListener(listener func(dbIndex int, cmdLine CmdLine))
 }
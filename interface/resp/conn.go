@@ -14,10 +14,17 @@ type Connection interface {
 	GetDBIndex() int
 	SelectDB(int)
 	RemoteAddr() string
+	GetID() int64
 
-	// password
-	SetPassword(string)
-	GetPassword() string
+	// protocol, 通过 HELLO 命令协商
+	GetProtocol() int
+	SetProtocol(int)
+	GetName() string
+	SetName(string)
+
+	// ACL user, 空字符串表示默认用户; id 是验证时用户的id, 用户被删除之后重新创建时已经验证的连接失效
+	SetUser(name string, id uint64)
+	GetUser() (name string, id uint64)
 
 	// transaction
 	InMultiState() bool
@@ -29,4 +36,19 @@ type Connection interface {
 	ClearWatching()
 	AddTxError(err error)
 	GetTxErrors() []error
+
+	// cluster, ASKING 只对下一条命令有效; 重定向模式下不属于本节点的key返回 MOVED 或者 ASK, 不转发
+	SetAsking(bool)
+	IsAsking() bool
+	SetRedirect(bool)
+	IsRedirect() bool
+
+	// pub/sub
+	Subscribe(channel string)
+	UnSubscribe(channel string)
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	GetChannels() []string
+	GetPatterns() []string
+	SubsCount() int
 }
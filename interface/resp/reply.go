@@ -12,3 +12,10 @@ type ErrorReply interface {
 	// Error 只返回错误信息, 不使用resp格式
 	Error() string
 }
+
+// Resp3Reply 是可以按RESP3协议编码的回复, Bytes 返回降级后的RESP2编码, 供没有协商RESP3的客户端使用
+type Resp3Reply interface {
+	Reply
+	// Resp3Bytes 返回RESP3格式的回复
+	Resp3Bytes() []byte
+}
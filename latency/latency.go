@@ -0,0 +1,117 @@
+package latency
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go-redis/config"
+)
+
+// 监控的事件
+const (
+	EventCommand   = "command"    // 执行普通命令
+	EventAofWrite  = "aof-write"  // 写入aof文件
+	EventExpireDel = "expire-del" // 删除过期的键
+)
+
+// historyLen 每个事件最多保存的样本数量, 和Redis的LATENCY_TS_LEN一致
+const historyLen = 160
+
+// Sample 一个事件在某一秒内的最大延迟
+type Sample struct {
+	Time    time.Time     // 样本所在的秒
+	Latency time.Duration // 这一秒内的最大延迟
+}
+
+// Stats 一个事件的最新延迟和历史最大延迟
+type Stats struct {
+	Event   string
+	Latest  Sample
+	Max     time.Duration // 所有样本中的最大延迟
+	history []Sample      // 最旧的样本在最前面
+}
+
+var (
+	mu     sync.Mutex
+	events = make(map[string]*Stats)
+)
+
+// Enabled 判断延迟为duration的事件是否需要记录, latency-monitor-threshold 为0时不记录
+func Enabled(duration time.Duration) bool {
+	threshold := config.LatencyMonitorThreshold()
+	return threshold > 0 && duration >= time.Duration(threshold)*time.Millisecond
+}
+
+// AddSampleIfNeeded 延迟超过 latency-monitor-threshold 时记录一个样本, 同一秒内只保留最大的延迟
+func AddSampleIfNeeded(event string, duration time.Duration) {
+	if !Enabled(duration) {
+		return
+	}
+	now := time.Now().Truncate(time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	stats, ok := events[event]
+	if !ok {
+		stats = &Stats{Event: event}
+		events[event] = stats
+	}
+	stats.Max = max(stats.Max, duration)
+	// 1. 同一秒内的样本合并
+	if n := len(stats.history); n > 0 && stats.history[n-1].Time.Equal(now) {
+		stats.history[n-1].Latency = max(stats.history[n-1].Latency, duration)
+		stats.Latest = stats.history[n-1]
+		return
+	}
+	// 2. 添加新的样本, 超过长度时删除最旧的样本
+	stats.Latest = Sample{Time: now, Latency: duration}
+	stats.history = append(stats.history, stats.Latest)
+	if len(stats.history) > historyLen {
+		stats.history = stats.history[len(stats.history)-historyLen:]
+	}
+}
+
+// Latest 返回所有事件的最新延迟, 按照事件的名称排序
+func Latest() []Stats {
+	mu.Lock()
+	defer mu.Unlock()
+	result := make([]Stats, 0, len(events))
+	for _, stats := range events {
+		result = append(result, Stats{Event: stats.Event, Latest: stats.Latest, Max: stats.Max})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Event < result[j].Event
+	})
+	return result
+}
+
+// History 返回事件的所有样本, 最旧的样本在最前面
+func History(event string) []Sample {
+	mu.Lock()
+	defer mu.Unlock()
+	stats, ok := events[event]
+	if !ok {
+		return nil
+	}
+	return append([]Sample(nil), stats.history...)
+}
+
+// Reset 清空指定事件的样本, 没有指定事件时清空所有事件, 返回清空的事件数量
+func Reset(eventNames ...string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(eventNames) == 0 {
+		n := len(events)
+		events = make(map[string]*Stats)
+		return n
+	}
+	n := 0
+	for _, event := range eventNames {
+		if _, ok := events[event]; ok {
+			delete(events, event)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,42 @@
+package latency
+
+import (
+	"testing"
+	"time"
+
+	"go-redis/config"
+)
+
+func TestAddSample(t *testing.T) {
+	defer Reset()
+	defer config.Set("latency-monitor-threshold", "0")
+
+	AddSampleIfNeeded(EventAofWrite, time.Second)
+	if len(Latest()) != 0 {
+		t.Errorf("latency monitor should be disabled")
+	}
+	if err := config.Set("latency-monitor-threshold", "10"); err != nil {
+		t.Fatal(err)
+	}
+	AddSampleIfNeeded(EventAofWrite, time.Millisecond)
+	AddSampleIfNeeded(EventAofWrite, 20*time.Millisecond)
+	AddSampleIfNeeded(EventAofWrite, 50*time.Millisecond)
+	AddSampleIfNeeded(EventExpireDel, 15*time.Millisecond)
+
+	// 同一秒内的样本合并为最大的延迟, 没有超过阈值的样本不记录
+	history := History(EventAofWrite)
+	if len(history) == 0 || len(history) > 2 || history[len(history)-1].Latency != 50*time.Millisecond {
+		t.Errorf("unexpected history %+v", history)
+	}
+	latest := Latest()
+	if len(latest) != 2 || latest[0].Event != EventAofWrite || latest[0].Max != 50*time.Millisecond || latest[1].Event != EventExpireDel {
+		t.Errorf("unexpected latest %+v", latest)
+	}
+
+	if n := Reset(EventExpireDel, "unknown"); n != 1 {
+		t.Errorf("expected 1 event reset, actually %d", n)
+	}
+	if History(EventExpireDel) != nil || len(Latest()) != 1 {
+		t.Errorf("event should be reset")
+	}
+}
@@ -1,27 +1,54 @@
 package consistenthash
 
 import (
-	"hash/crc32"
+	"hash/fnv"
+	"strconv"
+	"sync"
 
 	"go-redis/lib/utils"
 )
 
+// DefaultReplicas is the default number of virtual nodes of a node with weight 1
+const DefaultReplicas = 160
+
 // HashFunc is the type of hash function to use to map keys to
 type HashFunc func(data []byte) uint32
 
 // NodeMap is the interface that wraps the basic NodeMap methods
+//
+// every node is placed on the ring as replicas * weight virtual nodes, so the keys are spread evenly
+// and a node with a larger weight gets proportionally more keys. NodeMap is safe for concurrent use.
 type NodeMap struct {
+	mu       sync.RWMutex
 	hashFunc HashFunc          // hash function
-	hashes   []uint32          // sorted hashes
+	replicas int               // virtual nodes of a node with weight 1
+	hashes   []uint32          // sorted hashes of virtual nodes
 	mp       map[uint32]string // hash -> node
+	weights  map[string]int    // node -> weight
+}
+
+// defaultHash is FNV-1a followed by the murmur3 finalizer.
+// crc32 is linear, the virtual nodes of a node only differ in a few bytes and would cluster on the ring.
+func defaultHash(data []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(data)
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
 }
 
-// NewNodeMap creates a new NodeMap instance
-func NewNodeMap(hashFunc HashFunc) *NodeMap {
+// NewNodeMap creates a new NodeMap instance, replicas <= 0 means DefaultReplicas and nil hashFunc means defaultHash
+func NewNodeMap(replicas int, hashFunc HashFunc) *NodeMap {
 	return &NodeMap{
-		hashFunc: utils.If(hashFunc == nil, crc32.ChecksumIEEE, hashFunc),
+		hashFunc: utils.If(hashFunc == nil, defaultHash, hashFunc),
+		replicas: utils.If(replicas <= 0, DefaultReplicas, replicas),
 		hashes:   make([]uint32, 0),
 		mp:       make(map[uint32]string),
+		weights:  make(map[string]int),
 	}
 }
 
@@ -29,30 +56,90 @@ func NewNodeMap(hashFunc HashFunc) *NodeMap {
 //
 // return true if the NodeMap is empty, else false
 func (nodeMap *NodeMap) IsEmpty() bool {
+	nodeMap.mu.RLock()
+	defer nodeMap.mu.RUnlock()
 	return len(nodeMap.hashes) == 0
 }
 
-// Add adds some nodes to the NodeMap
+// Add adds some nodes with weight 1 to the NodeMap
 //
 // return the NodeMap itself
 func (nodeMap *NodeMap) Add(nodes ...string) *NodeMap {
 	for _, node := range nodes {
-		if node == "" {
+		nodeMap.AddWeighted(node, 1)
+	}
+	return nodeMap
+}
+
+// AddWeighted adds a node with replicas * weight virtual nodes, an existing node is re-added with the new weight
+//
+// return the NodeMap itself
+func (nodeMap *NodeMap) AddWeighted(node string, weight int) *NodeMap {
+	if node == "" || weight <= 0 {
+		return nodeMap
+	}
+	nodeMap.mu.Lock()
+	defer nodeMap.mu.Unlock()
+
+	nodeMap.remove(node)
+	for i := 0; i < nodeMap.replicas*weight; i++ {
+		hash := nodeMap.hashFunc(utils.String2Bytes(virtualNode(node, i)))
+		// the first virtual node wins when two virtual nodes collide
+		if _, ok := nodeMap.mp[hash]; ok {
 			continue
 		}
-
-		hash := nodeMap.hashFunc(utils.String2Bytes(node))
 		nodeMap.hashes = append(nodeMap.hashes, hash)
 		nodeMap.mp[hash] = node
 	}
+	nodeMap.weights[node] = weight
 	utils.Sort(nodeMap.hashes)
 
 	return nodeMap
 }
 
+// Remove removes the node from the NodeMap, the keys of the node are taken over by the next nodes on the ring
+//
+// return the NodeMap itself
+func (nodeMap *NodeMap) Remove(node string) *NodeMap {
+	nodeMap.mu.Lock()
+	defer nodeMap.mu.Unlock()
+	nodeMap.remove(node)
+	return nodeMap
+}
+
+func (nodeMap *NodeMap) remove(node string) {
+	if _, ok := nodeMap.weights[node]; !ok {
+		return
+	}
+	hashes := nodeMap.hashes[:0]
+	for _, hash := range nodeMap.hashes {
+		if nodeMap.mp[hash] == node {
+			delete(nodeMap.mp, hash)
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	nodeMap.hashes = hashes
+	delete(nodeMap.weights, node)
+}
+
+// Nodes returns all the nodes in the NodeMap
+func (nodeMap *NodeMap) Nodes() []string {
+	nodeMap.mu.RLock()
+	defer nodeMap.mu.RUnlock()
+	nodes := make([]string, 0, len(nodeMap.weights))
+	for node := range nodeMap.weights {
+		nodes = append(nodes, node)
+	}
+	utils.Sort(nodes)
+	return nodes
+}
+
 // Pick picks a node according to the key
 func (nodeMap *NodeMap) Pick(key string) string {
-	if nodeMap.IsEmpty() {
+	nodeMap.mu.RLock()
+	defer nodeMap.mu.RUnlock()
+	if len(nodeMap.hashes) == 0 {
 		return ""
 	}
 
@@ -63,3 +150,8 @@ func (nodeMap *NodeMap) Pick(key string) string {
 
 	return nodeMap.mp[nodeMap.hashes[idx%len(nodeMap.hashes)]]
 }
+
+// virtualNode returns the name of the i-th virtual node of node
+func virtualNode(node string, i int) string {
+	return strconv.Itoa(i) + "#" + node
+}
@@ -0,0 +1,76 @@
+package consistenthash
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+const keyCount = 1000000
+
+// distribution 返回每个节点分配到的key的数量
+func distribution(nodeMap *NodeMap) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < keyCount; i++ {
+		counts[nodeMap.Pick("key:"+strconv.Itoa(i))]++
+	}
+	return counts
+}
+
+// stddevRatio 返回每个节点的key数量的标准差和平均值的比值
+func stddevRatio(counts map[string]int, nodes int) float64 {
+	mean := float64(keyCount) / float64(nodes)
+	variance := 0.0
+	for _, count := range counts {
+		variance += (float64(count) - mean) * (float64(count) - mean)
+	}
+	return math.Sqrt(variance/float64(nodes)) / mean
+}
+
+func TestDistribution(t *testing.T) {
+	for n := 3; n <= 10; n++ {
+		nodes := make([]string, n)
+		for i := range nodes {
+			nodes[i] = "127.0.0.1:" + strconv.Itoa(7000+i)
+		}
+		single := stddevRatio(distribution(NewNodeMap(1, nil).Add(nodes...)), n)
+		virtual := stddevRatio(distribution(NewNodeMap(0, nil).Add(nodes...)), n)
+		t.Logf("%2d nodes: stddev/mean without virtual nodes %.3f, with %d virtual nodes %.3f", n, single, DefaultReplicas, virtual)
+		// 每个节点160个虚拟节点时理论上约为 1/sqrt(160) ≈ 0.08
+		if virtual > 0.15 {
+			t.Errorf("%d nodes: distribution is too uneven with virtual nodes, stddev/mean %.3f", n, virtual)
+		}
+	}
+}
+
+func TestWeight(t *testing.T) {
+	nodeMap := NewNodeMap(0, nil).AddWeighted("a", 1).AddWeighted("b", 3)
+	counts := distribution(nodeMap)
+	ratio := float64(counts["b"]) / float64(counts["a"])
+	if ratio < 2.5 || ratio > 3.5 {
+		t.Errorf("expected b to get about 3 times keys of a, actually %d and %d", counts["b"], counts["a"])
+	}
+}
+
+func TestRemove(t *testing.T) {
+	nodeMap := NewNodeMap(0, nil).Add("a", "b", "c")
+	before := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		before[key] = nodeMap.Pick(key)
+	}
+	nodeMap.Remove("b")
+	if nodes := nodeMap.Nodes(); len(nodes) != 2 || nodes[0] != "a" || nodes[1] != "c" {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+	// 只有被删除的节点上的key会重新分配
+	for key, node := range before {
+		after := nodeMap.Pick(key)
+		if after == "b" || (node != "b" && after != node) {
+			t.Fatalf("key %s moved from %s to %s", key, node, after)
+		}
+	}
+	if nodeMap.Remove("a").Remove("c").Pick("key") != "" || !nodeMap.IsEmpty() {
+		t.Errorf("empty node map should pick nothing")
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go-redis/config"
@@ -45,8 +47,35 @@ const (
 
 const flags = log.LstdFlags
 
+// minLevel 低于这个级别的日志不会输出, 通过 loglevel 配置
+var minLevel atomic.Int32
+
+// levelNames 配置中的日志级别名称
+var levelNames = map[string]logLevel{
+	"debug":   DEBUG,
+	"info":    INFO,
+	"warning": WARNING,
+	"error":   ERROR,
+}
+
 func init() {
 	logger = log.New(os.Stdout, defaultPrefix, flags)
+	_ = SetLevel(config.LogLevel())
+}
+
+// SetLevel 设置输出日志的最低级别: debug, info, warning, error
+func SetLevel(name string) error {
+	level, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown log level '%s'", name)
+	}
+	minLevel.Store(int32(level))
+	return nil
+}
+
+// enabled 判断是否输出这个级别的日志
+func enabled(level logLevel) bool {
+	return int32(level) >= minLevel.Load()
 }
 
 // Setup initializes logger
@@ -80,7 +109,7 @@ func setPrefix(level logLevel) {
 
 // Debug prints debug log
 func Debug(v ...interface{}) {
-	if !config.Properties.Dev { // dev
+	if !enabled(DEBUG) { // Dev 模式默认输出Debug日志
 		return
 	}
 
@@ -92,6 +121,9 @@ func Debug(v ...interface{}) {
 
 // Info prints normal log
 func Info(v ...interface{}) {
+	if !enabled(INFO) {
+		return
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	setPrefix(INFO)
@@ -100,6 +132,9 @@ func Info(v ...interface{}) {
 
 // Warn prints warning log
 func Warn(v ...interface{}) {
+	if !enabled(WARNING) {
+		return
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	setPrefix(WARNING)
@@ -108,6 +143,9 @@ func Warn(v ...interface{}) {
 
 // Error prints error log
 func Error(v ...interface{}) {
+	if !enabled(ERROR) {
+		return
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	setPrefix(ERROR)
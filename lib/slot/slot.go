@@ -0,0 +1,52 @@
+package slot
+
+import "strings"
+
+// Count 集群中槽的数量, 和Redis Cluster一致
+const Count = 16384
+
+// crc16Table CRC16-XMODEM的查找表, 多项式是0x1021
+var crc16Table [256]uint16
+
+func init() {
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+// CRC16 计算CRC16-XMODEM校验和, 和Redis Cluster计算槽使用的算法一致
+func CRC16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// HashTag 返回key中用于计算槽的部分
+//
+// key中第一个 { 和之后第一个 } 之间的内容不为空时只使用这部分计算槽, 相同hash tag的key分配到同一个槽, 例如 {user1000}.following 和 {user1000}.followers
+func HashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 { // 没有 } 或者 {} 之间为空
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+// KeySlot 返回key所在的槽
+func KeySlot(key string) int {
+	return int(CRC16(HashTag(key)) % Count)
+}
@@ -0,0 +1,29 @@
+package slot
+
+import "testing"
+
+func TestCRC16(t *testing.T) {
+	if crc := CRC16("123456789"); crc != 0x31C3 {
+		t.Errorf("expected 0x31C3, actually %#x", crc)
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	// 和Redis的 CLUSTER KEYSLOT 结果一致
+	tests := map[string]int{
+		"foo":                  12182,
+		"bar":                  5061,
+		"hello":                866,
+		"{user1000}.following": 3443,
+		"{user1000}.followers": 3443,
+		"user1000":             3443,
+		"foo{}{bar}":           8363,
+		"foo{{bar}}zap":        4015,
+		"foo{bar}{zap}":        5061,
+	}
+	for key, expected := range tests {
+		if slot := KeySlot(key); slot != expected {
+			t.Errorf("key %s: expected slot %d, actually %d", key, expected, slot)
+		}
+	}
+}
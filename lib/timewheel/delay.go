@@ -11,7 +11,7 @@ var timeWheel *TimeWheel
 const SLOW_NUM = 360
 
 func init() {
-	duration := time.Duration(config.Properties.Cycle) * time.Second
+	duration := time.Duration(config.Cycle()) * time.Second
 	timeWheel = NewTimeWheel(duration, SLOW_NUM)
 	timeWheel.Start()
 }
@@ -30,3 +30,8 @@ func At(at time.Time, key string, job func()) {
 func Cancel(key string) {
 	timeWheel.RemoveJob(key)
 }
+
+// SetInterval changes the tick interval of the time wheel, used when cycle is changed by CONFIG SET
+func SetInterval(interval time.Duration) {
+	timeWheel.SetInterval(interval)
+}
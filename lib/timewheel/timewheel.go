@@ -23,6 +23,7 @@ type TimeWheel struct {
 	slotNum           int // 时间轮槽slot数量
 	addTaskChannel    chan task
 	removeTaskChannel chan string
+	intervalChannel   chan time.Duration
 	stopChannel       chan struct{}
 }
 
@@ -46,6 +47,7 @@ func NewTimeWheel(interval time.Duration, slotNum int) *TimeWheel {
 		slotNum:           slotNum,
 		addTaskChannel:    make(chan task),
 		removeTaskChannel: make(chan string),
+		intervalChannel:   make(chan time.Duration),
 		stopChannel:       make(chan struct{}),
 	}
 	tw.initSlots()
@@ -78,6 +80,14 @@ func (tw *TimeWheel) AddJob(delay time.Duration, key string, job func()) {
 	tw.addTaskChannel <- task{delay: delay, key: key, job: job}
 }
 
+// SetInterval changes the tick interval, pending jobs are rescheduled with their remaining delay
+func (tw *TimeWheel) SetInterval(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	tw.intervalChannel <- interval
+}
+
 // RemoveJob add remove job from pending queue
 // if job is done or not found, then nothing happened
 func (tw *TimeWheel) RemoveJob(key string) {
@@ -96,6 +106,8 @@ func (tw *TimeWheel) start() {
 			tw.addTask(&task)
 		case key := <-tw.removeTaskChannel:
 			tw.removeTask(key)
+		case interval := <-tw.intervalChannel:
+			tw.resetInterval(interval)
 		case <-tw.stopChannel:
 			tw.ticker.Stop()
 			return
@@ -123,7 +135,7 @@ func (tw *TimeWheel) scanAndRunTask(l *list.List) {
 			continue
 		}
 		// 3. 任务的圈数为0, 说明任务到时间了
-        	// 3.1 异步执行任务
+		// 3.1 异步执行任务
 		go func() {
 			defer func() {
 				if err := recover(); err != nil {
@@ -161,6 +173,28 @@ func (tw *TimeWheel) addTask(task *task) {
 	tw.timer[task.key] = loc
 }
 
+// resetInterval 修改刻度之后, 按照任务剩余的时间重新放入时间轮
+func (tw *TimeWheel) resetInterval(interval time.Duration) {
+	// 1. 计算每个任务剩余的时间
+	tasks := make([]*task, 0, len(tw.timer))
+	for i := 0; i < tw.slotNum; i++ {
+		slots := (i - tw.currentPos + tw.slotNum) % tw.slotNum
+		for e := tw.slots[i].Front(); e != nil; e = e.Next() {
+			t := e.Value.(*task)
+			t.delay = time.Duration(slots+t.circle*tw.slotNum) * tw.interval
+			tasks = append(tasks, t)
+		}
+	}
+	// 2. 清空时间轮, 使用新的刻度重新添加任务
+	tw.initSlots()
+	tw.timer = make(map[string]*location)
+	tw.interval = interval
+	tw.ticker.Reset(interval)
+	for _, t := range tasks {
+		tw.addTask(t)
+	}
+}
+
 func (tw *TimeWheel) getPositionAndCircle(d time.Duration) (pos int, circle int) {
 	delaySeconds := int(d.Seconds())
 	intervalSeconds := int(tw.interval.Seconds())
@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	"go-redis/config"
 	"go-redis/lib/logger"
+	"go-redis/lib/timewheel"
 	"go-redis/resp/handler"
 	"go-redis/tcp"
 )
@@ -20,17 +22,69 @@ func init() {
 }
 
 func main() {
+	// 1. 加载配置文件和命令行参数
+	opts, err := config.ParseArgs(os.Args[1:])
+	if err != nil {
+		fatal(err)
+	}
+	if opts.Help {
+		fmt.Print(config.Usage)
+		return
+	}
+	if err = config.Load(opts); err != nil {
+		fatal(err)
+	}
+	cfg, err := serverConfig()
+	if err != nil {
+		fatal(err)
+	}
+	if opts.TestConfig {
+		fmt.Println("Configuration test passed")
+		return
+	}
 
+	// 2. 在 init 中使用默认配置初始化的模块需要使用加载后的配置
+	_ = logger.SetLevel(config.LogLevel())
+	timewheel.SetInterval(time.Duration(config.Cycle()) * time.Second)
 	logger.Debug(config.Properties)
 
-	err := tcp.ListenAndServeWithSignal(
-		&tcp.Config{
-			Address: fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port),
-		},
-		handler.NewRespHandler(),
-	)
+	err = tcp.ListenAndServeWithSignal(cfg, handler.NewRespHandler())
 
 	if err != nil {
 		logger.Error("tcp server error:", err)
 	}
 }
+
+// fatal 配置错误时打印错误信息并退出
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "*** FATAL CONFIG ERROR ***")
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// serverConfig 根据配置创建监听的地址, 同时检查TLS证书和 unixsocketperm
+func serverConfig() (*tcp.Config, error) {
+	cfg := &tcp.Config{}
+	// port 为0时不监听明文连接
+	if config.Properties.Port != 0 {
+		cfg.Address = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)
+	}
+	if config.Properties.TlsPort != 0 {
+		tlsConfig, err := config.ServerTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("tls config error: %w", err)
+		}
+		cfg.TLSAddress = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.TlsPort)
+		cfg.TLSConfig = tlsConfig
+	}
+
+	if config.Properties.UnixSocket != "" {
+		perm, err := config.ParseUnixSocketPerm(config.Properties.UnixSocketPerm)
+		if err != nil {
+			return nil, err
+		}
+		cfg.UnixSocket = config.Properties.UnixSocket
+		cfg.UnixSocketPerm = perm
+	}
+	return cfg, nil
+}
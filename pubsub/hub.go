@@ -0,0 +1,117 @@
+package pubsub
+
+import (
+	"sync"
+
+	"go-redis/interface/resp"
+	"go-redis/lib/wildcard"
+)
+
+// Hub 保存所有频道和模式的订阅者, 并发安全
+type Hub struct {
+	mu       sync.RWMutex
+	channels map[string]map[resp.Connection]struct{} // channel -> 订阅者
+	patterns map[string]*patternSubscribers          // pattern -> 订阅者
+}
+
+// patternSubscribers 订阅同一个模式的客户端
+type patternSubscribers struct {
+	pattern *wildcard.Pattern
+	conns   map[resp.Connection]struct{}
+}
+
+// NewHub 创建一个空的 Hub
+func NewHub() *Hub {
+	return &Hub{
+		channels: make(map[string]map[resp.Connection]struct{}),
+		patterns: make(map[string]*patternSubscribers),
+	}
+}
+
+// subscribe 把客户端加入频道的订阅者中, 返回是否是新的订阅
+func (hub *Hub) subscribe(c resp.Connection, channel string) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	subs, ok := hub.channels[channel]
+	if !ok {
+		subs = make(map[resp.Connection]struct{})
+		hub.channels[channel] = subs
+	}
+	if _, ok = subs[c]; ok {
+		return false
+	}
+	subs[c] = struct{}{}
+	return true
+}
+
+// unsubscribe 把客户端从频道的订阅者中删除, 没有订阅者的频道会被删除
+func (hub *Hub) unsubscribe(c resp.Connection, channel string) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	subs, ok := hub.channels[channel]
+	if !ok {
+		return false
+	}
+	if _, ok = subs[c]; !ok {
+		return false
+	}
+	delete(subs, c)
+	if len(subs) == 0 {
+		delete(hub.channels, channel)
+	}
+	return true
+}
+
+// psubscribe 把客户端加入模式的订阅者中, 返回是否是新的订阅
+func (hub *Hub) psubscribe(c resp.Connection, pattern string) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	subs, ok := hub.patterns[pattern]
+	if !ok {
+		subs = &patternSubscribers{
+			pattern: wildcard.CompilePattern(pattern),
+			conns:   make(map[resp.Connection]struct{}),
+		}
+		hub.patterns[pattern] = subs
+	}
+	if _, ok = subs.conns[c]; ok {
+		return false
+	}
+	subs.conns[c] = struct{}{}
+	return true
+}
+
+// punsubscribe 把客户端从模式的订阅者中删除, 没有订阅者的模式会被删除
+func (hub *Hub) punsubscribe(c resp.Connection, pattern string) bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	subs, ok := hub.patterns[pattern]
+	if !ok {
+		return false
+	}
+	if _, ok = subs.conns[c]; !ok {
+		return false
+	}
+	delete(subs.conns, c)
+	if len(subs.conns) == 0 {
+		delete(hub.patterns, pattern)
+	}
+	return true
+}
+
+// Subscriber 返回订阅了频道且id为给定值的客户端, 没有找到时返回nil
+func (hub *Hub) Subscriber(channel string, id int64) resp.Connection {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+
+	for c := range hub.channels[channel] {
+		if c.GetID() == id {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package pubsub
+
+import (
+	"bytes"
+	"strings"
+
+	"go-redis/enum"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/lib/wildcard"
+	"go-redis/resp/reply"
+)
+
+var (
+	messageBytes      = []byte("message")
+	pmessageBytes     = []byte("pmessage")
+	subscribeBytes    = []byte("subscribe")
+	unsubscribeBytes  = []byte("unsubscribe")
+	psubscribeBytes   = []byte("psubscribe")
+	punsubscribeBytes = []byte("punsubscribe")
+)
+
+// multiReply 一条命令产生多个回复, 例如订阅多个频道时每个频道都要回复一次
+type multiReply []resp.Reply
+
+func (r multiReply) Bytes() []byte {
+	return r.marshal(enum.RESP2)
+}
+
+func (r multiReply) Resp3Bytes() []byte {
+	return r.marshal(enum.RESP3)
+}
+
+func (r multiReply) marshal(protocol int) []byte {
+	var buf bytes.Buffer
+	for _, re := range r {
+		buf.Write(reply.Marshal(re, protocol))
+	}
+	return buf.Bytes()
+}
+
+// makeAck 生成订阅和取消订阅的回复: kind, channel, 客户端当前订阅的数量, RESP3中是推送消息
+func makeAck(kind []byte, channel []byte, count int) resp.Reply {
+	return reply.NewPushReply([]resp.Reply{
+		reply.NewBulkReply(kind),
+		utils.If[resp.Reply](channel == nil, reply.NewNullBulkReply(), reply.NewBulkReply(channel)),
+		reply.NewIntReply(int64(count)),
+	})
+}
+
+// Subscribe 订阅一个或多个频道
+//
+// # SUBSCRIBE channel [channel ...]
+func (hub *Hub) Subscribe(c resp.Connection, args db.Params) resp.Reply {
+	result := make(multiReply, 0, len(args))
+	for _, arg := range args {
+		channel := string(arg)
+		if hub.subscribe(c, channel) {
+			c.Subscribe(channel)
+		}
+		result = append(result, makeAck(subscribeBytes, arg, c.SubsCount()))
+	}
+	return result
+}
+
+// UnSubscribe 取消订阅频道, 没有参数时取消订阅所有频道
+//
+// # UNSUBSCRIBE [channel [channel ...]]
+func (hub *Hub) UnSubscribe(c resp.Connection, args db.Params) resp.Reply {
+	channels := utils.CmdLine2Strings(args)
+	if len(channels) == 0 {
+		channels = c.GetChannels()
+	}
+	if len(channels) == 0 {
+		return makeAck(unsubscribeBytes, nil, c.SubsCount())
+	}
+	result := make(multiReply, 0, len(channels))
+	for _, channel := range channels {
+		if hub.unsubscribe(c, channel) {
+			c.UnSubscribe(channel)
+		}
+		result = append(result, makeAck(unsubscribeBytes, utils.String2Bytes(channel), c.SubsCount()))
+	}
+	return result
+}
+
+// PSubscribe 订阅一个或多个模式, 模式的语法与KEYS命令相同
+//
+// # PSUBSCRIBE pattern [pattern ...]
+func (hub *Hub) PSubscribe(c resp.Connection, args db.Params) resp.Reply {
+	result := make(multiReply, 0, len(args))
+	for _, arg := range args {
+		pattern := string(arg)
+		if hub.psubscribe(c, pattern) {
+			c.PSubscribe(pattern)
+		}
+		result = append(result, makeAck(psubscribeBytes, arg, c.SubsCount()))
+	}
+	return result
+}
+
+// PUnSubscribe 取消订阅模式, 没有参数时取消订阅所有模式
+//
+// # PUNSUBSCRIBE [pattern [pattern ...]]
+func (hub *Hub) PUnSubscribe(c resp.Connection, args db.Params) resp.Reply {
+	patterns := utils.CmdLine2Strings(args)
+	if len(patterns) == 0 {
+		patterns = c.GetPatterns()
+	}
+	if len(patterns) == 0 {
+		return makeAck(punsubscribeBytes, nil, c.SubsCount())
+	}
+	result := make(multiReply, 0, len(patterns))
+	for _, pattern := range patterns {
+		if hub.punsubscribe(c, pattern) {
+			c.PUnSubscribe(pattern)
+		}
+		result = append(result, makeAck(punsubscribeBytes, utils.String2Bytes(pattern), c.SubsCount()))
+	}
+	return result
+}
+
+// UnsubscribeAll 取消客户端的所有订阅, 在客户端关闭时调用
+func (hub *Hub) UnsubscribeAll(c resp.Connection) {
+	for _, channel := range c.GetChannels() {
+		hub.unsubscribe(c, channel)
+		c.UnSubscribe(channel)
+	}
+	for _, pattern := range c.GetPatterns() {
+		hub.punsubscribe(c, pattern)
+		c.PUnSubscribe(pattern)
+	}
+}
+
+// Publish 向频道发送消息
+//
+// # PUBLISH channel message
+//
+// 返回: 收到消息的客户端数量
+func (hub *Hub) Publish(args db.Params) resp.Reply {
+	return reply.NewIntReply(int64(hub.PublishMessage(args[0], args[1])))
+}
+
+// PublishMessage 向频道及匹配频道的模式的订阅者发送消息, 返回收到消息的客户端数量
+func (hub *Hub) PublishMessage(channel, message []byte) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+
+	count := 0
+	// 1. 频道的订阅者
+	if subs, ok := hub.channels[string(channel)]; ok {
+		msg := newEncodedMessage(messageBytes, channel, message)
+		for c := range subs {
+			_, _ = c.Write(msg.bytes(c.GetProtocol()))
+			count++
+		}
+	}
+	// 2. 模式的订阅者
+	for pattern, subs := range hub.patterns {
+		if !subs.pattern.IsMatch(utils.Bytes2String(channel)) {
+			continue
+		}
+		msg := newEncodedMessage(pmessageBytes, utils.String2Bytes(pattern), channel, message)
+		for c := range subs.conns {
+			_, _ = c.Write(msg.bytes(c.GetProtocol()))
+			count++
+		}
+	}
+	return count
+}
+
+// encodedMessage 缓存一条消息的RESP2和RESP3编码, 每种编码只生成一次
+type encodedMessage struct {
+	msg          *reply.PushReply
+	resp2, resp3 []byte
+}
+
+func newEncodedMessage(args ...[]byte) *encodedMessage {
+	replies := make([]resp.Reply, len(args))
+	for i, arg := range args {
+		replies[i] = reply.NewBulkReply(arg)
+	}
+	return &encodedMessage{msg: reply.NewPushReply(replies)}
+}
+
+// bytes 返回对应协议版本的编码, RESP3中消息是推送类型
+func (m *encodedMessage) bytes(protocol int) []byte {
+	if protocol >= enum.RESP3 {
+		if m.resp3 == nil {
+			m.resp3 = m.msg.Resp3Bytes()
+		}
+		return m.resp3
+	}
+	if m.resp2 == nil {
+		m.resp2 = m.msg.Bytes()
+	}
+	return m.resp2
+}
+
+// PubSub 查询订阅状态
+//
+// # PUBSUB CHANNELS [pattern] | NUMSUB [channel [channel ...]] | NUMPAT
+func (hub *Hub) PubSub(args db.Params) resp.Reply {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+
+	subCmd := strings.ToUpper(utils.Bytes2String(args[0]))
+	switch subCmd {
+	case enum.PUBSUB_CHANNELS:
+		if len(args) > 2 {
+			return reply.NewArgNumErrReply("pubsub|channels")
+		}
+		var pattern *wildcard.Pattern
+		if len(args) == 2 {
+			pattern = wildcard.CompilePattern(string(args[1]))
+		}
+		result := make([][]byte, 0, len(hub.channels))
+		for channel := range hub.channels {
+			if pattern == nil || pattern.IsMatch(channel) {
+				result = append(result, []byte(channel))
+			}
+		}
+		return reply.NewMultiBulkReply(result)
+	case enum.PUBSUB_NUMSUB:
+		result := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			result = append(result, reply.NewBulkReply(arg), reply.NewIntReply(int64(len(hub.channels[string(arg)]))))
+		}
+		return reply.NewMultiRawReply(result)
+	case enum.PUBSUB_NUMPAT:
+		if len(args) != 1 {
+			return reply.NewArgNumErrReply("pubsub|numpat")
+		}
+		return reply.NewIntReply(int64(len(hub.patterns)))
+	default:
+		return reply.NewErrReply("unknown subcommand '" + subCmd + "'")
+	}
+}
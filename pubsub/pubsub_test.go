@@ -0,0 +1,91 @@
+package pubsub
+
+import (
+	"testing"
+
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+)
+
+func TestPublish(t *testing.T) {
+	hub := NewHub()
+	sub := connection.NewFakeConn()
+	psub := connection.NewFakeConn()
+
+	hub.Subscribe(sub, utils.ToCmdLine("news", "sport"))
+	hub.PSubscribe(psub, utils.ToCmdLine("n*"))
+	if sub.SubsCount() != 2 || psub.SubsCount() != 1 {
+		t.Fatalf("unexpected subscription count: %d, %d", sub.SubsCount(), psub.SubsCount())
+	}
+
+	result := hub.Publish(utils.ToCmdLine("news", "hello"))
+	asserts.AssertIntReply(t, result, 2)
+	expected := "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"
+	if string(sub.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, sub.Bytes())
+	}
+	expected = "*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n"
+	if string(psub.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, psub.Bytes())
+	}
+
+	result = hub.Publish(utils.ToCmdLine("sport", "goal"))
+	asserts.AssertIntReply(t, result, 1)
+	result = hub.Publish(utils.ToCmdLine("weather", "sunny"))
+	asserts.AssertIntReply(t, result, 0)
+
+	// 取消所有订阅之后不再收到消息
+	hub.UnSubscribe(sub, nil)
+	hub.UnsubscribeAll(psub)
+	if sub.SubsCount() != 0 || psub.SubsCount() != 0 {
+		t.Fatalf("unexpected subscription count: %d, %d", sub.SubsCount(), psub.SubsCount())
+	}
+	result = hub.Publish(utils.ToCmdLine("news", "bye"))
+	asserts.AssertIntReply(t, result, 0)
+}
+
+func TestSubscribeAck(t *testing.T) {
+	hub := NewHub()
+	conn := connection.NewFakeConn()
+
+	result := hub.Subscribe(conn, utils.ToCmdLine("a", "b"))
+	expected := "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n" +
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n"
+	if string(result.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, result.Bytes())
+	}
+	// 重复订阅不增加计数
+	result = hub.Subscribe(conn, utils.ToCmdLine("a"))
+	expected = "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:2\r\n"
+	if string(result.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, result.Bytes())
+	}
+	result = hub.PUnSubscribe(conn, nil)
+	expected = "*3\r\n$12\r\npunsubscribe\r\n$-1\r\n:2\r\n"
+	if string(result.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, result.Bytes())
+	}
+}
+
+func TestPubSubCmd(t *testing.T) {
+	hub := NewHub()
+	c1, c2 := connection.NewFakeConn(), connection.NewFakeConn()
+	hub.Subscribe(c1, utils.ToCmdLine("news", "sport"))
+	hub.Subscribe(c2, utils.ToCmdLine("news"))
+	hub.PSubscribe(c2, utils.ToCmdLine("n*", "s*"))
+
+	result := hub.PubSub(utils.ToCmdLine("channels", "n*"))
+	asserts.AssertMultiBulkReply(t, result, []string{"news"})
+	result = hub.PubSub(utils.ToCmdLine("channels"))
+	asserts.AssertMultiBulkReplySize(t, result, 2)
+	result = hub.PubSub(utils.ToCmdLine("numsub", "news", "none"))
+	expected := "*4\r\n$4\r\nnews\r\n:2\r\n$4\r\nnone\r\n:0\r\n"
+	if string(result.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, result.Bytes())
+	}
+	result = hub.PubSub(utils.ToCmdLine("numpat"))
+	asserts.AssertIntReply(t, result, 2)
+	result = hub.PubSub(utils.ToCmdLine("foo"))
+	asserts.AssertErrReply(t, result, "ERR unknown subcommand 'FOO'")
+}
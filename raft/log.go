@@ -0,0 +1,124 @@
+package raft
+
+// EntryType 日志的类型
+type EntryType uint8
+
+const (
+	EntryNormal  EntryType = iota // 状态机的命令, Data 为空的是leader当选时的空日志
+	EntryMembers                  // 成员变更, Members 是变更之后的全部成员
+)
+
+// Entry 一条日志
+type Entry struct {
+	Index   uint64    `json:"index"`
+	Term    uint64    `json:"term"`
+	Type    EntryType `json:"type"`
+	Data    []byte    `json:"data,omitempty"`
+	Members []string  `json:"members,omitempty"`
+}
+
+// Snapshot 状态机在 Index 处的快照, 包括当时的成员
+type Snapshot struct {
+	Index   uint64   `json:"index"`
+	Term    uint64   `json:"term"`
+	Members []string `json:"members"`
+	Data    []byte   `json:"data"`
+}
+
+// raftLog 快照之后的日志
+//
+// entries[0] 不是真正的日志, 它的 Index 和 Term 等于快照的, 用于检查快照之后第一条日志的前一条日志
+type raftLog struct {
+	snapshot Snapshot
+	entries  []Entry
+}
+
+func newRaftLog(members []string) *raftLog {
+	return &raftLog{
+		snapshot: Snapshot{Members: members},
+		entries:  []Entry{{}},
+	}
+}
+
+// firstIndex 返回快照的位置, 之后的日志都保存在 entries 中
+func (l *raftLog) firstIndex() uint64 {
+	return l.entries[0].Index
+}
+
+func (l *raftLog) lastIndex() uint64 {
+	return l.entries[len(l.entries)-1].Index
+}
+
+func (l *raftLog) lastTerm() uint64 {
+	return l.entries[len(l.entries)-1].Term
+}
+
+// term 返回日志的任期, 日志已经被快照删除或者还不存在时返回false
+func (l *raftLog) term(index uint64) (uint64, bool) {
+	if index < l.firstIndex() || index > l.lastIndex() {
+		return 0, false
+	}
+	return l.entries[index-l.firstIndex()].Term, true
+}
+
+// slice 返回 [lo, hi) 之间的日志的副本, lo 必须大于 firstIndex
+func (l *raftLog) slice(lo, hi uint64) []Entry {
+	first := l.firstIndex()
+	result := make([]Entry, hi-lo)
+	copy(result, l.entries[lo-first:hi-first])
+	return result
+}
+
+// append 在最后添加日志
+func (l *raftLog) append(entries ...Entry) {
+	l.entries = append(l.entries, entries...)
+}
+
+// merge 追加leader发送的在 prev 之后的日志: 已经存在并且任期相同的日志跳过, 第一条冲突的日志以及之后的日志被删除
+//
+// 返回日志是否有修改
+func (l *raftLog) merge(prev uint64, entries []Entry) bool {
+	for i, entry := range entries {
+		index := prev + uint64(i) + 1
+		if index <= l.firstIndex() {
+			continue
+		}
+		if term, ok := l.term(index); ok && term == entry.Term {
+			continue
+		}
+		l.entries = append(l.entries[:index-l.firstIndex()], entries[i:]...)
+		return true
+	}
+	return false
+}
+
+// firstIndexOfTerm 返回 index 所在的任期在日志中的第一条日志
+func (l *raftLog) firstIndexOfTerm(index uint64) uint64 {
+	term, _ := l.term(index)
+	for index > l.firstIndex()+1 {
+		if prev, _ := l.term(index - 1); prev != term {
+			break
+		}
+		index--
+	}
+	return index
+}
+
+// compact 生成 index 处的快照并删除之前的日志
+func (l *raftLog) compact(index uint64, members []string, data []byte) {
+	term, _ := l.term(index)
+	l.snapshot = Snapshot{Index: index, Term: term, Members: members, Data: data}
+	remain := make([]Entry, 0, l.lastIndex()-index+1)
+	remain = append(remain, Entry{Index: index, Term: term})
+	l.entries = append(remain, l.entries[index-l.firstIndex()+1:]...)
+}
+
+// restore 使用leader发送的快照, 本地有快照位置上任期相同的日志时保留之后的日志, 否则删除所有日志
+func (l *raftLog) restore(snapshot Snapshot) {
+	remain := []Entry{{Index: snapshot.Index, Term: snapshot.Term}}
+	if term, ok := l.term(snapshot.Index); ok && term == snapshot.Term {
+		remain = append(remain, l.entries[snapshot.Index-l.firstIndex()+1:]...)
+	}
+	l.snapshot = snapshot
+	l.entries = remain
+}
@@ -0,0 +1,73 @@
+package raft
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Persister 保存节点的任期, 投票, 快照和日志, 没有保存过时 Load 返回空
+type Persister interface {
+	Save(data []byte) error
+	Load() ([]byte, error)
+}
+
+// MemoryPersister 保存在内存中, 用于测试节点重启
+type MemoryPersister struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func NewMemoryPersister() *MemoryPersister {
+	return &MemoryPersister{}
+}
+
+func (p *MemoryPersister) Save(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.data = append([]byte(nil), data...)
+	return nil
+}
+
+func (p *MemoryPersister) Load() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]byte(nil), p.data...), nil
+}
+
+// FilePersister 保存在文件中, 先写入临时文件再重命名, 避免写入过程中崩溃损坏文件
+type FilePersister struct {
+	path string
+}
+
+func NewFilePersister(path string) *FilePersister {
+	return &FilePersister{path: path}
+}
+
+func (p *FilePersister) Save(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), filepath.Base(p.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.path)
+}
+
+func (p *FilePersister) Load() ([]byte, error) {
+	data, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
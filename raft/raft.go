@@ -0,0 +1,851 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"go-redis/lib/logger"
+)
+
+// State 节点的角色
+type State uint8
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Leader:
+		return "leader"
+	case Candidate:
+		return "candidate"
+	default:
+		return "follower"
+	}
+}
+
+var (
+	ErrNotLeader          = errors.New("raft: not the leader")
+	ErrTimeout            = errors.New("raft: timeout")
+	ErrStopped            = errors.New("raft: node stopped")
+	ErrMembershipChanging = errors.New("raft: membership change in progress")
+	ErrInvalidMembers     = errors.New("raft: only one member can be added or removed at a time")
+)
+
+// maxEntriesPerAppend 一次 AppendEntries 最多发送的日志数量
+const maxEntriesPerAppend = 512
+
+// StateMachine 日志提交之后应用到的状态机, 所有方法都只在应用日志的协程中调用
+type StateMachine interface {
+	Apply(data []byte)
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// Config 节点的配置
+type Config struct {
+	ID string
+	// Members 初始的成员, 包括本节点. 为空时节点等待leader把它加入集群, 在此之前不会发起选举
+	Members      []string
+	Transport    Transport
+	StateMachine StateMachine
+	Persister    Persister // 为nil时不持久化
+	// TickInterval 时钟周期, 选举超时在 [ElectionTicks, 2*ElectionTicks) 个周期之间随机选择
+	TickInterval   time.Duration
+	ElectionTicks  int
+	HeartbeatTicks int
+	// SnapshotThreshold 快照之后应用的日志超过这个数量时生成新的快照, 为0时不生成快照
+	SnapshotThreshold uint64
+}
+
+// Status 节点的状态
+type Status struct {
+	ID           string
+	State        State
+	Term         uint64
+	Leader       string
+	Members      []string
+	CommitIndex  uint64
+	AppliedIndex uint64
+	LastIndex    uint64
+}
+
+// persistentState 需要在回复RPC之前持久化的状态
+type persistentState struct {
+	Term     uint64   `json:"term"`
+	VotedFor string   `json:"votedFor"`
+	Snapshot Snapshot `json:"snapshot"`
+	Entries  []Entry  `json:"entries"`
+}
+
+// Node Raft节点
+//
+// 成员变更每次只增加或删除一个节点, 成员变更日志提交并应用之后生效
+type Node struct {
+	id  string
+	cfg Config
+
+	mu          sync.Mutex
+	applyCond   *sync.Cond // commitIndex 增加或者收到快照时唤醒应用日志的协程
+	appliedCond *sync.Cond // lastApplied 增加时唤醒等待日志应用的协程
+
+	state    State
+	term     uint64
+	votedFor string
+	leader   string
+	log      *raftLog
+	members  []string // 最近应用的成员, 按照ID排序
+
+	commitIndex     uint64
+	lastApplied     uint64
+	pendingSnapshot *Snapshot // 等待应用到状态机的快照
+	pendingMembers  uint64    // 还没有应用的成员变更日志的位置
+
+	electionElapsed  int
+	electionTimeout  int
+	heartbeatElapsed int
+	votes            map[string]bool
+
+	// leader 的状态
+	nextIndex    map[string]uint64
+	matchIndex   map[string]uint64
+	replicators  map[string]chan struct{} // 唤醒向成员发送日志的协程
+	recentActive map[string]bool          // 最近一个选举超时内回复过的成员
+
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewNode 创建节点并恢复持久化的状态, 调用 Start 之后开始运行
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.TickInterval <= 0 {
+		cfg.TickInterval = 50 * time.Millisecond
+	}
+	if cfg.ElectionTicks <= 0 {
+		cfg.ElectionTicks = 10
+	}
+	if cfg.HeartbeatTicks <= 0 {
+		cfg.HeartbeatTicks = 2
+	}
+	n := &Node{
+		id:      cfg.ID,
+		cfg:     cfg,
+		log:     newRaftLog(sortedCopy(cfg.Members)),
+		stopped: make(chan struct{}),
+	}
+	n.applyCond = sync.NewCond(&n.mu)
+	n.appliedCond = sync.NewCond(&n.mu)
+	if cfg.Persister != nil {
+		data, err := cfg.Persister.Load()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			state := &persistentState{}
+			if err := json.Unmarshal(data, state); err != nil {
+				return nil, err
+			}
+			n.term, n.votedFor = state.Term, state.VotedFor
+			n.log.restore(state.Snapshot)
+			n.log.append(state.Entries...)
+		}
+	}
+	// 重启之后还没有提交的成员变更日志也立即生效, 否则所有节点都等待成员变更提交而无法选举
+	n.members = n.log.snapshot.Members
+	for _, entry := range n.log.entries[1:] {
+		if entry.Type == EntryMembers {
+			n.members = entry.Members
+		}
+	}
+	n.commitIndex = n.log.snapshot.Index
+	n.resetElectionTimeout()
+	return n, nil
+}
+
+// ID 返回节点的ID
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Start 恢复快照中的状态机, 然后开始计时和应用日志
+func (n *Node) Start() error {
+	n.mu.Lock()
+	snapshot := n.log.snapshot
+	n.mu.Unlock()
+	if len(snapshot.Data) > 0 {
+		if err := n.cfg.StateMachine.Restore(snapshot.Data); err != nil {
+			return err
+		}
+	}
+	n.mu.Lock()
+	n.lastApplied = snapshot.Index
+	// 唯一的成员不需要其他节点投票
+	if len(n.members) == 1 && n.members[0] == n.id {
+		n.campaign()
+	}
+	n.mu.Unlock()
+
+	go n.runTicker()
+	go n.runApplier()
+	return nil
+}
+
+// Stop 停止节点, 正在等待的 Propose 返回 ErrStopped
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		n.mu.Lock()
+		close(n.stopped)
+		n.applyCond.Broadcast()
+		n.appliedCond.Broadcast()
+		n.mu.Unlock()
+	})
+}
+
+func (n *Node) isStopped() bool {
+	select {
+	case <-n.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status 返回节点当前的状态
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:           n.id,
+		State:        n.state,
+		Term:         n.term,
+		Leader:       n.leader,
+		Members:      sortedCopy(n.members),
+		CommitIndex:  n.commitIndex,
+		AppliedIndex: n.lastApplied,
+		LastIndex:    n.log.lastIndex(),
+	}
+}
+
+// Propose 提交状态机的命令, 等待命令应用到本节点的状态机之后返回它在日志中的位置
+//
+// 只有leader可以提交命令, 其他节点返回 ErrNotLeader
+func (n *Node) Propose(data []byte, timeout time.Duration) (uint64, error) {
+	if len(data) == 0 {
+		return 0, errors.New("raft: empty proposal")
+	}
+	return n.propose(Entry{Type: EntryNormal, Data: data}, timeout)
+}
+
+// ProposeMembers 把成员修改为 members, 和当前的成员相比只能增加或删除一个节点
+func (n *Node) ProposeMembers(members []string, timeout time.Duration) (uint64, error) {
+	members = sortedCopy(members)
+	n.mu.Lock()
+	if n.state == Leader && n.pendingMembers > n.lastApplied {
+		n.mu.Unlock()
+		return 0, ErrMembershipChanging
+	}
+	if diff := memberDiff(n.members, members); diff != 1 {
+		n.mu.Unlock()
+		if diff == 0 {
+			return n.lastAppliedIndex(), nil
+		}
+		return 0, ErrInvalidMembers
+	}
+	n.mu.Unlock()
+	return n.propose(Entry{Type: EntryMembers, Members: members}, timeout)
+}
+
+func (n *Node) lastAppliedIndex() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lastApplied
+}
+
+func (n *Node) propose(entry Entry, timeout time.Duration) (uint64, error) {
+	n.mu.Lock()
+	if n.isStopped() {
+		n.mu.Unlock()
+		return 0, ErrStopped
+	}
+	if n.state != Leader {
+		n.mu.Unlock()
+		return 0, ErrNotLeader
+	}
+	entry.Index, entry.Term = n.log.lastIndex()+1, n.term
+	n.log.append(entry)
+	if entry.Type == EntryMembers {
+		n.pendingMembers = entry.Index
+	}
+	n.persist()
+	n.advanceCommit()
+	n.triggerReplicators()
+	n.mu.Unlock()
+	return entry.Index, n.waitApplied(entry.Index, entry.Term, timeout)
+}
+
+// WaitApplied 等待 index 位置的日志应用到本节点的状态机
+func (n *Node) WaitApplied(index uint64, timeout time.Duration) error {
+	return n.waitApplied(index, 0, timeout)
+}
+
+// waitApplied 等待日志应用, term 不为0时检查应用的日志是否是 term 任期提交的, 被其他leader覆盖时返回 ErrNotLeader
+func (n *Node) waitApplied(index, term uint64, timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		n.mu.Lock()
+		n.appliedCond.Broadcast()
+		n.mu.Unlock()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for n.lastApplied < index {
+		if n.isStopped() {
+			return ErrStopped
+		}
+		if !time.Now().Before(deadline) {
+			return ErrTimeout
+		}
+		n.appliedCond.Wait()
+	}
+	if term > 0 {
+		if t, ok := n.log.term(index); ok && t != term {
+			return ErrNotLeader
+		}
+	}
+	return nil
+}
+
+/* ---- 选举 ---- */
+
+func (n *Node) runTicker() {
+	ticker := time.NewTicker(n.cfg.TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopped:
+			return
+		case <-ticker.C:
+			n.mu.Lock()
+			n.tick()
+			n.mu.Unlock()
+		}
+	}
+}
+
+func (n *Node) tick() {
+	if n.state == Leader {
+		n.heartbeatElapsed++
+		if n.heartbeatElapsed >= n.cfg.HeartbeatTicks {
+			n.heartbeatElapsed = 0
+			n.triggerReplicators()
+		}
+		// 一个选举超时内没有收到超过半数成员的回复时退位, 避免少数派分区中的leader一直接收请求
+		n.electionElapsed++
+		if n.electionElapsed >= n.cfg.ElectionTicks {
+			n.electionElapsed = 0
+			active := n.recentActive
+			n.recentActive = make(map[string]bool)
+			active[n.id] = true
+			if !n.isQuorum(active) {
+				logger.Info("raft: " + n.id + " lost quorum, stepping down")
+				n.becomeFollower(n.term, "")
+			}
+		}
+		return
+	}
+	n.electionElapsed++
+	if n.electionElapsed >= n.electionTimeout && n.isMember(n.id) {
+		n.campaign()
+	}
+}
+
+func (n *Node) resetElectionTimeout() {
+	n.electionElapsed = 0
+	n.electionTimeout = n.cfg.ElectionTicks + rand.Intn(n.cfg.ElectionTicks)
+}
+
+// campaign 增加任期并向所有成员请求投票
+func (n *Node) campaign() {
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.id
+	n.leader = ""
+	n.persist()
+	n.resetElectionTimeout()
+	n.votes = map[string]bool{n.id: true}
+	if n.isQuorum(n.votes) {
+		n.becomeLeader()
+		return
+	}
+	args := &RequestVoteArgs{
+		Term:         n.term,
+		Candidate:    n.id,
+		LastLogIndex: n.log.lastIndex(),
+		LastLogTerm:  n.log.lastTerm(),
+	}
+	for _, peer := range n.members {
+		if peer != n.id {
+			go n.requestVote(peer, args)
+		}
+	}
+}
+
+func (n *Node) requestVote(peer string, args *RequestVoteArgs) {
+	reply, err := n.cfg.Transport.RequestVote(peer, args)
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term, "")
+		return
+	}
+	if n.state != Candidate || n.term != args.Term || !reply.VoteGranted {
+		return
+	}
+	n.votes[peer] = true
+	if n.isQuorum(n.votes) {
+		n.becomeLeader()
+	}
+}
+
+// HandleRequestVote 处理候选人的投票请求
+func (n *Node) HandleRequestVote(args *RequestVoteArgs) *RequestVoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// 1. 最近收到过leader消息时忽略请求, 避免被移除的成员或者刚从分区恢复的节点打断正常的leader
+	if n.leader != "" && n.leader != args.Candidate &&
+		(n.state == Leader || n.electionElapsed < n.cfg.ElectionTicks) {
+		return &RequestVoteReply{Term: n.term}
+	}
+	// 2. 更大的任期
+	if args.Term > n.term {
+		n.becomeFollower(args.Term, "")
+	}
+	// 3. 每个任期只投一票, 候选人的日志至少和本节点一样新
+	reply := &RequestVoteReply{Term: n.term}
+	if args.Term < n.term || (n.votedFor != "" && n.votedFor != args.Candidate) {
+		return reply
+	}
+	lastTerm := n.log.lastTerm()
+	if args.LastLogTerm < lastTerm || (args.LastLogTerm == lastTerm && args.LastLogIndex < n.log.lastIndex()) {
+		return reply
+	}
+	n.votedFor = args.Candidate
+	n.persist()
+	n.resetElectionTimeout()
+	reply.VoteGranted = true
+	return reply
+}
+
+func (n *Node) becomeFollower(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.persist()
+	}
+	n.state = Follower
+	n.leader = leader
+	n.replicators = nil
+	n.recentActive = nil
+}
+
+func (n *Node) becomeLeader() {
+	logger.Info("raft: " + n.id + " became leader")
+	n.state = Leader
+	n.leader = n.id
+	n.heartbeatElapsed = 0
+	n.electionElapsed = 0
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.replicators = make(map[string]chan struct{})
+	n.recentActive = make(map[string]bool)
+	// 当前任期的空日志提交之后, 之前任期的日志随之提交
+	n.log.append(Entry{Index: n.log.lastIndex() + 1, Term: n.term, Type: EntryNormal})
+	n.persist()
+	n.startReplicators()
+	n.advanceCommit()
+}
+
+/* ---- 日志复制 ---- */
+
+// startReplicators 为还没有复制协程的成员启动协程
+func (n *Node) startReplicators() {
+	for _, peer := range n.members {
+		if _, ok := n.replicators[peer]; ok || peer == n.id {
+			continue
+		}
+		trigger := make(chan struct{}, 1)
+		trigger <- struct{}{}
+		n.replicators[peer] = trigger
+		n.nextIndex[peer] = n.log.lastIndex() + 1
+		n.matchIndex[peer] = 0
+		go n.replicate(peer, n.term, trigger)
+	}
+}
+
+// triggerReplicators 唤醒所有复制协程, 发送新的日志或者心跳
+func (n *Node) triggerReplicators() {
+	for _, trigger := range n.replicators {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// replicate 在任期 term 内向成员 peer 发送日志, 节点不再是这个任期的leader或者 peer 被移除时退出
+func (n *Node) replicate(peer string, term uint64, trigger chan struct{}) {
+	for {
+		select {
+		case <-n.stopped:
+			return
+		case <-trigger:
+		}
+		if !n.sendAppend(peer, term, trigger) {
+			return
+		}
+	}
+}
+
+// sendAppend 发送一次日志或者快照, 返回false时复制协程退出
+func (n *Node) sendAppend(peer string, term uint64, trigger chan struct{}) bool {
+	n.mu.Lock()
+	if n.state != Leader || n.term != term || n.replicators[peer] != trigger {
+		n.mu.Unlock()
+		return false
+	}
+	next := n.nextIndex[peer]
+	if next <= n.log.firstIndex() {
+		n.mu.Unlock()
+		return n.sendSnapshot(peer, term, trigger)
+	}
+	prevTerm, _ := n.log.term(next - 1)
+	last := min(n.log.lastIndex(), next-1+maxEntriesPerAppend)
+	args := &AppendEntriesArgs{
+		Term:         term,
+		Leader:       n.id,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  prevTerm,
+		Entries:      n.log.slice(next, last+1),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	reply, err := n.cfg.Transport.AppendEntries(peer, args)
+	if err != nil {
+		return true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term, "")
+		return false
+	}
+	if n.state != Leader || n.term != term || n.replicators[peer] != trigger {
+		return false
+	}
+	n.recentActive[peer] = true
+	if reply.Success {
+		if match := args.PrevLogIndex + uint64(len(args.Entries)); match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+			n.nextIndex[peer] = match + 1
+			n.advanceCommit()
+		}
+	} else {
+		n.nextIndex[peer] = max(1, min(reply.ConflictIndex, next-1))
+	}
+	// 还有没有发送的日志时继续发送
+	if n.nextIndex[peer] <= n.log.lastIndex() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+func (n *Node) sendSnapshot(peer string, term uint64, trigger chan struct{}) bool {
+	n.mu.Lock()
+	args := &InstallSnapshotArgs{Term: term, Leader: n.id, Snapshot: n.log.snapshot}
+	n.mu.Unlock()
+
+	reply, err := n.cfg.Transport.InstallSnapshot(peer, args)
+	if err != nil {
+		return true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term, "")
+		return false
+	}
+	if n.state != Leader || n.term != term || n.replicators[peer] != trigger {
+		return false
+	}
+	n.recentActive[peer] = true
+	if index := args.Snapshot.Index; index > n.matchIndex[peer] {
+		n.matchIndex[peer] = index
+		n.nextIndex[peer] = index + 1
+	}
+	select {
+	case trigger <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// advanceCommit 提交超过半数成员已经复制的当前任期的日志
+func (n *Node) advanceCommit() {
+	for index := n.log.lastIndex(); index > n.commitIndex; index-- {
+		if term, _ := n.log.term(index); term != n.term {
+			break
+		}
+		replicated := map[string]bool{n.id: true}
+		for peer, match := range n.matchIndex {
+			if match >= index {
+				replicated[peer] = true
+			}
+		}
+		if n.isQuorum(replicated) {
+			n.commitIndex = index
+			n.applyCond.Broadcast()
+			n.triggerReplicators()
+			return
+		}
+	}
+}
+
+// HandleAppendEntries 处理leader发送的日志和心跳
+func (n *Node) HandleAppendEntries(args *AppendEntriesArgs) *AppendEntriesReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	reply := &AppendEntriesReply{Term: n.term}
+	if args.Term < n.term {
+		return reply
+	}
+	n.becomeFollower(args.Term, args.Leader)
+	n.resetElectionTimeout()
+	reply.Term = n.term
+
+	// 1. 检查前一条日志是否一致, 不一致时返回leader下一次尝试的位置
+	switch {
+	case args.PrevLogIndex < n.log.firstIndex():
+		reply.ConflictIndex = n.log.firstIndex() + 1
+		return reply
+	case args.PrevLogIndex > n.log.lastIndex():
+		reply.ConflictIndex = n.log.lastIndex() + 1
+		return reply
+	}
+	if term, _ := n.log.term(args.PrevLogIndex); term != args.PrevLogTerm {
+		reply.ConflictIndex = n.log.firstIndexOfTerm(args.PrevLogIndex)
+		return reply
+	}
+	// 2. 追加日志, 删除冲突的日志
+	if n.log.merge(args.PrevLogIndex, args.Entries) {
+		n.persist()
+	}
+	// 3. 提交leader已经提交的日志
+	if last := args.PrevLogIndex + uint64(len(args.Entries)); args.LeaderCommit > n.commitIndex && last > n.commitIndex {
+		n.commitIndex = min(args.LeaderCommit, last)
+		n.applyCond.Broadcast()
+	}
+	reply.Success = true
+	return reply
+}
+
+// HandleInstallSnapshot 处理leader发送的快照
+func (n *Node) HandleInstallSnapshot(args *InstallSnapshotArgs) *InstallSnapshotReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if args.Term < n.term {
+		return &InstallSnapshotReply{Term: n.term}
+	}
+	n.becomeFollower(args.Term, args.Leader)
+	n.resetElectionTimeout()
+	snapshot := args.Snapshot
+	if snapshot.Index <= n.commitIndex {
+		return &InstallSnapshotReply{Term: n.term}
+	}
+	n.log.restore(snapshot)
+	n.commitIndex = snapshot.Index
+	n.pendingSnapshot = &snapshot
+	n.persist()
+	n.applyCond.Broadcast()
+	return &InstallSnapshotReply{Term: n.term}
+}
+
+/* ---- 应用日志 ---- */
+
+func (n *Node) runApplier() {
+	for {
+		n.mu.Lock()
+		for !n.isStopped() && n.pendingSnapshot == nil && n.lastApplied >= n.commitIndex {
+			n.applyCond.Wait()
+		}
+		if n.isStopped() {
+			n.mu.Unlock()
+			return
+		}
+		// 1. 快照替换整个状态机
+		if snapshot := n.pendingSnapshot; snapshot != nil {
+			n.pendingSnapshot = nil
+			n.mu.Unlock()
+			if err := n.cfg.StateMachine.Restore(snapshot.Data); err != nil {
+				logger.Error("raft: restore snapshot: " + err.Error())
+			}
+			n.mu.Lock()
+			if snapshot.Index > n.lastApplied {
+				n.lastApplied = snapshot.Index
+				n.setMembers(snapshot.Members)
+			}
+			n.appliedCond.Broadcast()
+			n.mu.Unlock()
+			continue
+		}
+		// 2. 依次应用已经提交的日志
+		entries := n.log.slice(n.lastApplied+1, n.commitIndex+1)
+		n.mu.Unlock()
+		for _, entry := range entries {
+			if entry.Type == EntryNormal && len(entry.Data) > 0 {
+				n.cfg.StateMachine.Apply(entry.Data)
+			}
+			n.mu.Lock()
+			if n.pendingSnapshot != nil {
+				// 应用过程中收到的快照包含了之后的日志
+				n.mu.Unlock()
+				break
+			}
+			n.lastApplied = entry.Index
+			if entry.Type == EntryMembers {
+				n.setMembers(entry.Members)
+			}
+			n.appliedCond.Broadcast()
+			n.mu.Unlock()
+		}
+		n.maybeSnapshot()
+	}
+}
+
+// setMembers 应用成员变更, leader 为新成员启动复制协程, 不再是成员时退位
+func (n *Node) setMembers(members []string) {
+	n.members = members
+	if n.state != Leader {
+		return
+	}
+	if !n.isMember(n.id) {
+		logger.Info("raft: " + n.id + " removed from the cluster, stepping down")
+		n.becomeFollower(n.term, "")
+		return
+	}
+	for peer := range n.replicators {
+		if !n.isMember(peer) {
+			delete(n.replicators, peer)
+			delete(n.nextIndex, peer)
+			delete(n.matchIndex, peer)
+		}
+	}
+	n.startReplicators()
+	n.advanceCommit()
+}
+
+// maybeSnapshot 快照之后应用的日志超过阈值时生成新的快照并删除之前的日志
+func (n *Node) maybeSnapshot() {
+	n.mu.Lock()
+	threshold := n.cfg.SnapshotThreshold
+	index, members := n.lastApplied, n.members
+	if threshold == 0 || index-n.log.firstIndex() < threshold || n.pendingSnapshot != nil {
+		n.mu.Unlock()
+		return
+	}
+	n.mu.Unlock()
+
+	data, err := n.cfg.StateMachine.Snapshot()
+	if err != nil {
+		logger.Error("raft: snapshot: " + err.Error())
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if index > n.log.firstIndex() && index <= n.log.lastIndex() {
+		n.log.compact(index, members, data)
+		n.persist()
+	}
+}
+
+/* ---- 工具函数 ---- */
+
+// persist 保存任期, 投票和日志, 需要持有锁
+func (n *Node) persist() {
+	if n.cfg.Persister == nil {
+		return
+	}
+	state := &persistentState{
+		Term:     n.term,
+		VotedFor: n.votedFor,
+		Snapshot: n.log.snapshot,
+		Entries:  n.log.entries[1:],
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = n.cfg.Persister.Save(data)
+	}
+	if err != nil {
+		logger.Error("raft: persist state: " + err.Error())
+	}
+}
+
+func (n *Node) isMember(id string) bool {
+	i := sort.SearchStrings(n.members, id)
+	return i < len(n.members) && n.members[i] == id
+}
+
+// isQuorum 判断 nodes 中是否包含超过半数的成员
+func (n *Node) isQuorum(nodes map[string]bool) bool {
+	count := 0
+	for _, member := range n.members {
+		if nodes[member] {
+			count++
+		}
+	}
+	return count > len(n.members)/2
+}
+
+func sortedCopy(members []string) []string {
+	result := make([]string, len(members))
+	copy(result, members)
+	sort.Strings(result)
+	return result
+}
+
+// memberDiff 返回两个有序成员列表之间增加和删除的成员数量
+func memberDiff(a, b []string) int {
+	diff := 0
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j >= len(b) || (i < len(a) && a[i] < b[j]):
+			diff++
+			i++
+		case i >= len(a) || b[j] < a[i]:
+			diff++
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return diff
+}
@@ -0,0 +1,343 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// kvMachine 测试用的状态机, 命令的格式是 key=value
+type kvMachine struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func newKVMachine() *kvMachine {
+	return &kvMachine{data: make(map[string]string)}
+}
+
+func (m *kvMachine) Apply(data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, value, _ := strings.Cut(string(data), "=")
+	m.data[key] = value
+}
+
+func (m *kvMachine) Snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Marshal(m.data)
+}
+
+func (m *kvMachine) Restore(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = make(map[string]string)
+	return json.Unmarshal(data, &m.data)
+}
+
+func (m *kvMachine) get(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.data[key]
+}
+
+func (m *kvMachine) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.data)
+}
+
+type testCluster struct {
+	t          *testing.T
+	network    *Network
+	nodes      map[string]*Node
+	machines   map[string]*kvMachine
+	persisters map[string]*MemoryPersister
+	threshold  uint64
+}
+
+func newTestCluster(t *testing.T, threshold uint64, ids ...string) *testCluster {
+	c := &testCluster{
+		t:          t,
+		network:    NewNetwork(),
+		nodes:      make(map[string]*Node),
+		machines:   make(map[string]*kvMachine),
+		persisters: make(map[string]*MemoryPersister),
+		threshold:  threshold,
+	}
+	for _, id := range ids {
+		c.start(id, ids)
+	}
+	t.Cleanup(func() {
+		for _, node := range c.nodes {
+			node.Stop()
+		}
+	})
+	return c
+}
+
+// start 启动节点, 节点已经存在时使用之前持久化的状态重启
+func (c *testCluster) start(id string, members []string) *Node {
+	if persister := c.persisters[id]; persister == nil {
+		c.persisters[id] = NewMemoryPersister()
+	}
+	machine := newKVMachine()
+	node, err := NewNode(Config{
+		ID:                id,
+		Members:           members,
+		Transport:         c.network.Transport(id),
+		StateMachine:      machine,
+		Persister:         c.persisters[id],
+		TickInterval:      10 * time.Millisecond,
+		SnapshotThreshold: c.threshold,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.network.Register(node)
+	if err := node.Start(); err != nil {
+		c.t.Fatal(err)
+	}
+	c.nodes[id] = node
+	c.machines[id] = machine
+	return node
+}
+
+// leader 等待 ids 中的节点选出唯一的leader
+func (c *testCluster) leader(ids ...string) *Node {
+	c.t.Helper()
+	var leader *Node
+	c.waitFor("leader election", func() bool {
+		leader = nil
+		term := uint64(0)
+		for _, id := range ids {
+			status := c.nodes[id].Status()
+			if status.State != Leader {
+				continue
+			}
+			if leader != nil && status.Term == term {
+				c.t.Fatalf("two leaders in term %d", term)
+			}
+			if status.Term > term {
+				leader, term = c.nodes[id], status.Term
+			}
+		}
+		if leader == nil {
+			return false
+		}
+		// 其他节点都承认这个leader
+		for _, id := range ids {
+			if c.nodes[id].Status().Leader != leader.id {
+				return false
+			}
+		}
+		return true
+	})
+	return leader
+}
+
+func (c *testCluster) waitFor(what string, cond func() bool) {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitValue 等待 ids 中的节点都应用了 key=value
+func (c *testCluster) waitValue(key, value string, ids ...string) {
+	c.t.Helper()
+	for _, id := range ids {
+		c.waitFor(fmt.Sprintf("%s=%s on %s", key, value, id), func() bool {
+			return c.machines[id].get(key) == value
+		})
+	}
+}
+
+func TestElection(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	c := newTestCluster(t, 0, ids...)
+	leader := c.leader(ids...)
+	term := leader.Status().Term
+
+	// leader 和其他节点断开之后, 剩下的节点选出新的leader, 旧leader失去多数派之后退位
+	c.network.Isolate(leader.id)
+	var rest []string
+	for _, id := range ids {
+		if id != leader.id {
+			rest = append(rest, id)
+		}
+	}
+	next := c.leader(rest...)
+	if next.Status().Term <= term {
+		t.Errorf("expected new term greater than %d, actually %d", term, next.Status().Term)
+	}
+	c.waitFor("old leader to step down", func() bool { return leader.Status().State != Leader })
+
+	// 网络恢复之后所有节点承认同一个leader
+	c.network.Heal()
+	c.leader(ids...)
+}
+
+func TestReplication(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+	c := newTestCluster(t, 0, ids...)
+	leader := c.leader(ids...)
+	if _, err := leader.Propose([]byte("x=1"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.waitValue("x", "1", ids...)
+
+	follower := c.nodes[ids[0]]
+	if follower == leader {
+		follower = c.nodes[ids[1]]
+	}
+	if _, err := follower.Propose([]byte("x=2"), time.Second); err != ErrNotLeader {
+		t.Errorf("expected ErrNotLeader, actually %v", err)
+	}
+
+	// 少数派中的leader无法提交, 它的日志在网络恢复之后被新leader的日志覆盖
+	var minority, majority []string
+	minority = append(minority, leader.id)
+	for _, id := range ids {
+		if id != leader.id {
+			if len(minority) < 2 {
+				minority = append(minority, id)
+			} else {
+				majority = append(majority, id)
+			}
+		}
+	}
+	c.network.Partition(minority, majority)
+	if _, err := leader.Propose([]byte("x=lost"), 200*time.Millisecond); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout in minority, actually %v", err)
+	}
+	next := c.leader(majority...)
+	if _, err := next.Propose([]byte("x=3"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.waitValue("x", "3", majority...)
+
+	c.network.Heal()
+	if _, err := c.leader(ids...).Propose([]byte("y=1"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.waitValue("y", "1", ids...)
+	for _, id := range ids {
+		if value := c.machines[id].get("x"); value != "3" {
+			t.Errorf("expected x=3 on %s, actually %s", id, value)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	c := newTestCluster(t, 5, ids...)
+	leader := c.leader(ids...)
+	lagging := ids[0]
+	if lagging == leader.id {
+		lagging = ids[1]
+	}
+
+	// 落后的节点需要的日志已经被快照删除, leader发送快照
+	c.network.Isolate(lagging)
+	for i := 0; i < 20; i++ {
+		if _, err := leader.Propose([]byte(fmt.Sprintf("k%d=%d", i, i)), time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.waitFor("leader to compact log", func() bool {
+		leader.mu.Lock()
+		defer leader.mu.Unlock()
+		return leader.log.firstIndex() > 5
+	})
+	c.network.Heal()
+	c.waitValue("k19", "19", ids...)
+	if n := c.machines[lagging].len(); n != 20 {
+		t.Errorf("expected 20 keys on lagging node, actually %d", n)
+	}
+	node := c.nodes[lagging]
+	node.mu.Lock()
+	first := node.log.firstIndex()
+	node.mu.Unlock()
+	if first == 0 {
+		t.Errorf("expected lagging node to install snapshot")
+	}
+}
+
+func TestMembership(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	c := newTestCluster(t, 0, ids...)
+	leader := c.leader(ids...)
+	if _, err := leader.Propose([]byte("x=1"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有成员配置的节点被leader加入之后复制所有的日志
+	c.start("d", nil)
+	if _, err := leader.ProposeMembers([]string{"a", "b", "c", "d", "e"}, time.Second); err != ErrInvalidMembers {
+		t.Errorf("expected ErrInvalidMembers, actually %v", err)
+	}
+	if _, err := leader.ProposeMembers([]string{"a", "b", "c", "d"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.waitValue("x", "1", "d")
+	if members := c.nodes["d"].Status().Members; len(members) != 4 {
+		t.Errorf("expected 4 members on d, actually %v", members)
+	}
+
+	// 被移除的leader退位, 剩下的节点选出新的leader
+	var rest []string
+	for _, id := range append(ids, "d") {
+		if id != leader.id {
+			rest = append(rest, id)
+		}
+	}
+	if _, err := leader.ProposeMembers(rest, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("removed leader to step down", func() bool { return leader.Status().State != Leader })
+	next := c.leader(rest...)
+	if _, err := next.Propose([]byte("x=2"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.waitValue("x", "2", rest...)
+}
+
+func TestRestart(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	c := newTestCluster(t, 3, ids...)
+	leader := c.leader(ids...)
+	for i := 0; i < 10; i++ {
+		if _, err := leader.Propose([]byte(fmt.Sprintf("k%d=%d", i, i)), time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.waitValue("k9", "9", ids...)
+
+	// 所有节点重启之后从持久化的快照和日志恢复
+	term := leader.Status().Term
+	for _, id := range ids {
+		c.nodes[id].Stop()
+	}
+	for _, id := range ids {
+		c.start(id, ids)
+	}
+	for _, id := range ids {
+		if n := c.machines[id].len(); n == 0 {
+			t.Errorf("expected snapshot restored on %s, actually %d keys", id, n)
+		}
+	}
+	next := c.leader(ids...)
+	if next.Status().Term <= term {
+		t.Errorf("expected term to be persisted, actually %d <= %d", next.Status().Term, term)
+	}
+	c.waitValue("k9", "9", ids...)
+}
@@ -0,0 +1,164 @@
+package raft
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnreachable 节点之间的网络不通
+var ErrUnreachable = errors.New("raft: node unreachable")
+
+// Transport 发送RPC到其他节点, to 是目标节点的ID
+//
+// 实现需要是并发安全的, 节点会同时向多个节点发送RPC
+type Transport interface {
+	RequestVote(to string, args *RequestVoteArgs) (*RequestVoteReply, error)
+	AppendEntries(to string, args *AppendEntriesArgs) (*AppendEntriesReply, error)
+	InstallSnapshot(to string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error)
+}
+
+// RequestVoteArgs 候选人请求投票
+type RequestVoteArgs struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type RequestVoteReply struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"voteGranted"`
+}
+
+// AppendEntriesArgs leader复制日志, Entries 为空时是心跳
+type AppendEntriesArgs struct {
+	Term         uint64  `json:"term"`
+	Leader       string  `json:"leader"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+// AppendEntriesReply 日志不匹配时 ConflictIndex 是leader下一次尝试的位置, 避免逐条回退
+type AppendEntriesReply struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflictIndex"`
+}
+
+// InstallSnapshotArgs leader发送快照给落后太多的节点, 快照之前的日志已经被删除
+type InstallSnapshotArgs struct {
+	Term     uint64   `json:"term"`
+	Leader   string   `json:"leader"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type InstallSnapshotReply struct {
+	Term uint64 `json:"term"`
+}
+
+// Network 进程内的网络, 用于测试: 节点之间直接调用, 可以模拟网络分区
+type Network struct {
+	mu      sync.RWMutex
+	nodes   map[string]*Node
+	blocked map[[2]string]bool // from -> to 不通
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		nodes:   make(map[string]*Node),
+		blocked: make(map[[2]string]bool),
+	}
+}
+
+// Register 把节点加入网络, 之后其他节点可以访问它
+func (network *Network) Register(node *Node) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.nodes[node.id] = node
+}
+
+// Transport 返回节点 id 发送RPC使用的 Transport
+func (network *Network) Transport(id string) Transport {
+	return &networkTransport{network: network, from: id}
+}
+
+// Partition 把节点分为互相不通的几组, 没有出现在任何一组中的节点和所有节点都不通
+func (network *Network) Partition(groups ...[]string) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	group := make(map[string]int)
+	for i, ids := range groups {
+		for _, id := range ids {
+			group[id] = i + 1
+		}
+	}
+	network.blocked = make(map[[2]string]bool)
+	for a := range network.nodes {
+		for b := range network.nodes {
+			if a != b && (group[a] == 0 || group[a] != group[b]) {
+				network.blocked[[2]string{a, b}] = true
+			}
+		}
+	}
+}
+
+// Isolate 断开节点和其他所有节点的连接
+func (network *Network) Isolate(id string) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	for other := range network.nodes {
+		if other != id {
+			network.blocked[[2]string{id, other}] = true
+			network.blocked[[2]string{other, id}] = true
+		}
+	}
+}
+
+// Heal 恢复所有的连接
+func (network *Network) Heal() {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.blocked = make(map[[2]string]bool)
+}
+
+// route 返回可以访问的目标节点, 请求和回复都需要网络是通的
+func (network *Network) route(from, to string) (*Node, error) {
+	network.mu.RLock()
+	defer network.mu.RUnlock()
+	node, ok := network.nodes[to]
+	if !ok || network.blocked[[2]string{from, to}] || network.blocked[[2]string{to, from}] {
+		return nil, ErrUnreachable
+	}
+	return node, nil
+}
+
+type networkTransport struct {
+	network *Network
+	from    string
+}
+
+func (t *networkTransport) RequestVote(to string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	node, err := t.network.route(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleRequestVote(args), nil
+}
+
+func (t *networkTransport) AppendEntries(to string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	node, err := t.network.route(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleAppendEntries(args), nil
+}
+
+func (t *networkTransport) InstallSnapshot(to string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	node, err := t.network.route(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+	return node.HandleInstallSnapshot(args), nil
+}
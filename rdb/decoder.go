@@ -0,0 +1,250 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+
+	"go-redis/datastruct/dict"
+	"go-redis/datastruct/list"
+	"go-redis/datastruct/set"
+	"go-redis/datastruct/sortedset"
+	str "go-redis/datastruct/string"
+	"go-redis/interface/db"
+	"go-redis/lib/utils"
+)
+
+// Restore 校验并解码Dump生成的二进制载荷
+func Restore(payload []byte) (*db.DataEntity, error) {
+	// 1. 载荷至少包含类型标记, 版本号和校验和
+	if len(payload) < 1+2+8 {
+		return nil, ErrBadPayload
+	}
+	// 2. 校验crc64
+	body := payload[:len(payload)-8]
+	if checksum(body) != binary.LittleEndian.Uint64(payload[len(payload)-8:]) {
+		return nil, ErrBadPayload
+	}
+	// 3. 校验版本号
+	if binary.LittleEndian.Uint16(body[len(body)-2:]) > Version {
+		return nil, ErrBadPayload
+	}
+	// 4. 解码值, 值之后不允许有多余的数据
+	r := &reader{buf: body[:len(body)-2]}
+	entity, err := r.readEntity()
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.buf) {
+		return nil, ErrBadPayload
+	}
+	return entity, nil
+}
+
+// reader 从字节数组中顺序读取编码后的数据
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) readByte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, ErrTruncated
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) readUvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, ErrTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *reader) readVarint() (int64, error) {
+	v, n := binary.Varint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, ErrTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *reader) readUint64() (uint64, error) {
+	if r.pos+8 > len(r.buf) {
+		return 0, ErrTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.buf[r.pos:])
+	r.pos += 8
+	return v, nil
+}
+
+// readBytes 读取 长度 + 内容, 返回的切片是拷贝, 不引用原始数据
+func (r *reader) readBytes() ([]byte, error) {
+	size, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(len(r.buf)-r.pos) {
+		return nil, ErrTruncated
+	}
+	b := make([]byte, size)
+	copy(b, r.buf[r.pos:])
+	r.pos += int(size)
+	return b, nil
+}
+
+// readLen 读取集合长度, 长度不可能超过剩余的字节数, 防止恶意数据导致分配过大的内存
+func (r *reader) readLen() (int, error) {
+	size, err := r.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if size > uint64(len(r.buf)-r.pos) {
+		return 0, ErrTruncated
+	}
+	return int(size), nil
+}
+
+// readEntity 读取 类型标记 + 值
+func (r *reader) readEntity() (*db.DataEntity, error) {
+	valueType, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	return r.readValue(valueType)
+}
+
+// readValue 根据类型标记读取值
+func (r *reader) readValue(valueType byte) (*db.DataEntity, error) {
+	switch valueType {
+	case typeString:
+		b, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		return db.NewDataEntity(str.NewString(b)), nil
+	case typeList:
+		return r.readList()
+	case typeHashSet:
+		return r.readHashSet()
+	case typeIntSet:
+		return r.readIntSet()
+	case typeHash:
+		return r.readHash()
+	case typeZSet:
+		return r.readZSet()
+	default:
+		return nil, ErrUnknownType
+	}
+}
+
+func (r *reader) readList() (*db.DataEntity, error) {
+	size, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	l := list.NewQuickList()
+	for i := 0; i < size; i++ {
+		b, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		l.PushBack(parseElement(b))
+	}
+	return db.NewDataEntity(l), nil
+}
+
+func (r *reader) readHashSet() (*db.DataEntity, error) {
+	size, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	st := set.NewHashSet()
+	for i := 0; i < size; i++ {
+		b, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		st.Add(string(b))
+	}
+	return db.NewDataEntity(st), nil
+}
+
+func (r *reader) readIntSet() (*db.DataEntity, error) {
+	size, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	st := set.NewIntSet()
+	for i := 0; i < size; i++ {
+		v, err := r.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		st.Add(v)
+	}
+	return db.NewDataEntity(st), nil
+}
+
+func (r *reader) readHash() (*db.DataEntity, error) {
+	size, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	hashTable := dict.NewNormalDict()
+	for i := 0; i < size; i++ {
+		field, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		hashTable.Set(utils.Bytes2String(field), parseElement(value))
+	}
+	return db.NewDataEntity(hashTable), nil
+}
+
+func (r *reader) readZSet() (*db.DataEntity, error) {
+	size, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	zset := sortedset.NewSortedSet()
+	for i := 0; i < size; i++ {
+		member, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		bits, err := r.readUint64()
+		if err != nil {
+			return nil, err
+		}
+		zset.Add(utils.Bytes2String(member), math.Float64frombits(bits))
+	}
+	return db.NewDataEntity(zset), nil
+}
+
+// parseElement 与命令写入list/hash时的编码保持一致: 能转为整数的存储为最小的整数类型, 否则存储字节数组
+func parseElement(b []byte) any {
+	v, err := strconv.ParseInt(utils.Bytes2String(b), 10, 64)
+	if err != nil {
+		return b
+	}
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return int8(v)
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return int16(v)
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return int32(v)
+	default:
+		return v
+	}
+}
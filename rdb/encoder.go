@@ -0,0 +1,129 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strconv"
+
+	"go-redis/datastruct/dict"
+	"go-redis/datastruct/list"
+	"go-redis/datastruct/set"
+	"go-redis/datastruct/sortedset"
+	str "go-redis/datastruct/string"
+	"go-redis/interface/db"
+	"go-redis/lib/utils"
+)
+
+// Dump 把数据实体序列化为DUMP命令使用的二进制载荷
+//
+// 格式: 类型标记(1B) | 值 | 版本号(2B, 小端) | crc64(8B, 小端)
+func Dump(entity *db.DataEntity) ([]byte, error) {
+	buf, err := appendEntity(make([]byte, 0, 64), entity)
+	if err != nil {
+		return nil, err
+	}
+	buf = binary.LittleEndian.AppendUint16(buf, Version)
+	return binary.LittleEndian.AppendUint64(buf, checksum(buf)), nil
+}
+
+// appendEntity 把 类型标记 + 值 追加到buf末尾
+func appendEntity(buf []byte, entity *db.DataEntity) ([]byte, error) {
+	valueType, err := typeOf(entity)
+	if err != nil {
+		return nil, err
+	}
+	return appendValue(append(buf, valueType), valueType, entity), nil
+}
+
+// typeOf 返回数据实体的类型标记
+func typeOf(entity *db.DataEntity) (byte, error) {
+	if entity == nil {
+		return 0, ErrUnknownType
+	}
+	switch entity.Data.(type) {
+	case *str.String:
+		return typeString, nil
+	case list.List:
+		return typeList, nil
+	case *set.IntSet:
+		return typeIntSet, nil
+	case set.Set:
+		return typeHashSet, nil
+	case dict.Dict:
+		return typeHash, nil
+	case *sortedset.SortedSet:
+		return typeZSet, nil
+	default:
+		return 0, ErrUnknownType
+	}
+}
+
+// appendValue 把值追加到buf末尾, valueType必须是typeOf(entity)的结果
+func appendValue(buf []byte, valueType byte, entity *db.DataEntity) []byte {
+	switch valueType {
+	case typeString:
+		buf = appendBytes(buf, entity.Data.(*str.String).Bytes())
+	case typeList:
+		l := entity.Data.(list.List)
+		buf = binary.AppendUvarint(buf, uint64(l.Len()))
+		l.ForEach(func(_ int, v any) bool {
+			buf = appendBytes(buf, elementBytes(v))
+			return true
+		})
+	case typeIntSet:
+		st := entity.Data.(*set.IntSet)
+		buf = binary.AppendUvarint(buf, uint64(st.Len()))
+		st.ForEach(func(member any) bool {
+			buf = binary.AppendVarint(buf, member.(int64))
+			return true
+		})
+	case typeHashSet:
+		st := entity.Data.(set.Set)
+		buf = binary.AppendUvarint(buf, uint64(st.Len()))
+		st.ForEach(func(member any) bool {
+			buf = appendBytes(buf, set.ToBytes(member))
+			return true
+		})
+	case typeHash:
+		hashTable := entity.Data.(dict.Dict)
+		buf = binary.AppendUvarint(buf, uint64(hashTable.Len()))
+		hashTable.ForEach(func(field string, v any) bool {
+			buf = appendBytes(buf, utils.String2Bytes(field))
+			buf = appendBytes(buf, elementBytes(v))
+			return true
+		})
+	case typeZSet:
+		zset := entity.Data.(*sortedset.SortedSet)
+		buf = binary.AppendUvarint(buf, uint64(zset.Length()))
+		if zset.Length() > 0 {
+			zset.ForEachByRank(0, zset.Length(), false, func(e *sortedset.Element) bool {
+				buf = appendBytes(buf, utils.String2Bytes(e.Ele))
+				buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(e.Score))
+				return true
+			})
+		}
+	}
+	return buf
+}
+
+// appendBytes 以 长度(uvarint) + 内容 的格式追加字节数组
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// elementBytes 把list和hash中存储的元素([]byte或者整数)转化为字节数组
+func elementBytes(v any) []byte {
+	switch val := v.(type) {
+	case []byte:
+		return val
+	case string:
+		return utils.String2Bytes(val)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.CanInt() {
+		return utils.String2Bytes(strconv.FormatInt(rv.Int(), 10))
+	}
+	return nil
+}
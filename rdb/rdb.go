@@ -0,0 +1,44 @@
+package rdb
+
+import (
+	"errors"
+	"hash/crc64"
+)
+
+// Version 是序列化格式的版本号, 解码时拒绝高于此版本的数据
+const Version uint16 = 1
+
+// 值类型标记, 写在每个值的第一个字节
+const (
+	typeString byte = iota
+	typeList
+	typeHashSet
+	typeIntSet
+	typeZSet
+	typeHash
+)
+
+// 快照文件中的操作码, 与值类型标记共用一个字节, 所以从高位开始取值
+const (
+	opExpireMs byte = 0xFC // 后跟8字节的过期时间戳(ms)
+	opSelectDB byte = 0xFE // 后跟uvarint编码的数据库号
+	opEOF      byte = 0xFF // 快照结束, 后跟8字节的crc64
+)
+
+// snapshotMagic 是快照文件的头部标识
+const snapshotMagic = "GOREDIS"
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+var (
+	ErrBadPayload     = errors.New("DUMP payload version or checksum are wrong")
+	ErrUnknownType    = errors.New("unknown value type")
+	ErrTruncated      = errors.New("unexpected end of data")
+	ErrBadSnapshot    = errors.New("bad snapshot file")
+	ErrVersionTooHigh = errors.New("version is higher than supported")
+)
+
+// checksum 计算crc64(ECMA)校验和
+func checksum(b []byte) uint64 {
+	return crc64.Checksum(b, crcTable)
+}
@@ -0,0 +1,116 @@
+package rdb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"go-redis/datastruct/dict"
+	"go-redis/datastruct/list"
+	"go-redis/datastruct/set"
+	"go-redis/datastruct/sortedset"
+	str "go-redis/datastruct/string"
+	"go-redis/interface/db"
+)
+
+func newTestEntities() map[string]*db.DataEntity {
+	l := list.NewQuickList()
+	l.PushBack([]byte("a"))
+	l.PushBack(int8(12))
+	l.PushBack(int64(1 << 40))
+
+	hashTable := dict.NewNormalDict()
+	hashTable.Set("name", []byte("jack"))
+	hashTable.Set("age", int16(300))
+
+	intSet := set.NewIntSet()
+	intSet.Add(int64(-1))
+	intSet.Add(int64(1 << 33))
+
+	zset := sortedset.NewSortedSet()
+	zset.Add("a", 1.5)
+	zset.Add("b", -3)
+
+	return map[string]*db.DataEntity{
+		"string":  db.NewDataEntity(str.NewString("hello")),
+		"int":     db.NewDataEntity(str.NewString("-123456")),
+		"list":    db.NewDataEntity(l),
+		"hash":    db.NewDataEntity(hashTable),
+		"hashset": db.NewDataEntity(set.NewHashSet("x", "y", "z")),
+		"intset":  db.NewDataEntity(intSet),
+		"zset":    db.NewDataEntity(zset),
+		"empty":   db.NewDataEntity(sortedset.NewSortedSet()),
+	}
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	for name, entity := range newTestEntities() {
+		payload, err := Dump(entity)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		restored, err := Restore(payload)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// 重新编码的结果应与原始载荷一致
+		again, err := Dump(restored)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if name != "hash" && name != "hashset" && !bytes.Equal(payload, again) {
+			t.Errorf("%s: payload changed after restore", name)
+		}
+	}
+}
+
+func TestRestoreCorrupted(t *testing.T) {
+	payload, _ := Dump(db.NewDataEntity(str.NewString("hello")))
+
+	broken := bytes.Clone(payload)
+	broken[1] ^= 0xFF
+	if _, err := Restore(broken); err != ErrBadPayload {
+		t.Errorf("expected bad payload, actually %v", err)
+	}
+	if _, err := Restore(payload[:5]); err != ErrBadPayload {
+		t.Errorf("expected bad payload, actually %v", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewSnapshotWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expireAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	entities := newTestEntities()
+	dbIndex := 0
+	for key, entity := range entities {
+		if err = w.WriteEntry(dbIndex%3, key, entity, &expireAt); err != nil {
+			t.Fatal(err)
+		}
+		dbIndex++
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	err = ReadSnapshot(bytes.NewReader(buf.Bytes()), func(_ int, key string, entity *db.DataEntity, expiration *time.Time) bool {
+		count++
+		if _, ok := entities[key]; !ok {
+			t.Errorf("unexpected key %s", key)
+		}
+		if expiration == nil || !expiration.Equal(expireAt) {
+			t.Errorf("%s: wrong expiration %v", key, expiration)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(entities) {
+		t.Errorf("expected %d entries, actually %d", len(entities), count)
+	}
+}
@@ -0,0 +1,144 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash"
+	"hash/crc64"
+	"io"
+	"time"
+
+	"go-redis/interface/db"
+	"go-redis/lib/utils"
+)
+
+// SnapshotWriter 把多个数据库的数据写入一个快照文件, 值的编码与Dump相同
+//
+// 格式: "GOREDIS" | 版本号(2B) | { [SELECTDB n] [EXPIREMS t] 类型标记 key 值 }* | EOF | crc64(8B)
+type SnapshotWriter struct {
+	w       *bufio.Writer
+	hash    hash.Hash64
+	dbIndex int
+	buf     []byte // 复用的编码缓冲区
+}
+
+// NewSnapshotWriter 创建快照写入器并写入文件头
+func NewSnapshotWriter(w io.Writer) (*SnapshotWriter, error) {
+	sw := &SnapshotWriter{
+		w:       bufio.NewWriter(w),
+		hash:    crc64.New(crcTable),
+		dbIndex: -1,
+	}
+	header := binary.LittleEndian.AppendUint16([]byte(snapshotMagic), Version)
+	if err := sw.write(header); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// WriteEntry 写入一个键值对, 参数与 db.DBEngine.ForEach 的回调保持一致
+func (sw *SnapshotWriter) WriteEntry(dbIndex int, key string, entity *db.DataEntity, expiration *time.Time) error {
+	buf := sw.buf[:0]
+	// 1. 切换数据库
+	if dbIndex != sw.dbIndex {
+		buf = append(buf, opSelectDB)
+		buf = binary.AppendUvarint(buf, uint64(dbIndex))
+		sw.dbIndex = dbIndex
+	}
+	// 2. 过期时间
+	if expiration != nil {
+		buf = append(buf, opExpireMs)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(expiration.UnixMilli()))
+	}
+	// 3. 类型标记 + key + 值
+	valueType, err := typeOf(entity)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, valueType)
+	buf = appendBytes(buf, utils.String2Bytes(key))
+	buf = appendValue(buf, valueType, entity)
+	sw.buf = buf
+	return sw.write(buf)
+}
+
+// Close 写入结束标记和校验和, 并刷新缓冲区. 不会关闭底层的 io.Writer
+func (sw *SnapshotWriter) Close() error {
+	if err := sw.write([]byte{opEOF}); err != nil {
+		return err
+	}
+	if _, err := sw.w.Write(binary.LittleEndian.AppendUint64(nil, sw.hash.Sum64())); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+func (sw *SnapshotWriter) write(b []byte) error {
+	sw.hash.Write(b)
+	_, err := sw.w.Write(b)
+	return err
+}
+
+// ReadSnapshot 读取快照文件, 对每个键值对调用cb, cb返回false时停止读取
+func ReadSnapshot(rd io.Reader, cb func(dbIndex int, key string, entity *db.DataEntity, expiration *time.Time) bool) error {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	// 1. 校验文件头和校验和
+	headerLen := len(snapshotMagic) + 2
+	if len(data) < headerLen+1+8 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrBadSnapshot
+	}
+	body := data[:len(data)-8]
+	if checksum(body) != binary.LittleEndian.Uint64(data[len(data)-8:]) {
+		return ErrBadSnapshot
+	}
+	if binary.LittleEndian.Uint16(data[len(snapshotMagic):]) > Version {
+		return ErrVersionTooHigh
+	}
+	// 2. 逐条读取
+	r := &reader{buf: body, pos: headerLen}
+	dbIndex := 0
+	var expiration *time.Time
+	for {
+		op, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opEOF:
+			if r.pos != len(r.buf) {
+				return ErrBadSnapshot
+			}
+			return nil
+		case opSelectDB:
+			index, err := r.readUvarint()
+			if err != nil {
+				return err
+			}
+			dbIndex = int(index)
+		case opExpireMs:
+			ms, err := r.readUint64()
+			if err != nil {
+				return err
+			}
+			expireTime := time.UnixMilli(int64(ms))
+			expiration = &expireTime
+		default:
+			// 其余的操作码都是类型标记, 之后是key和值
+			key, err := r.readBytes()
+			if err != nil {
+				return err
+			}
+			entity, err := r.readValue(op)
+			if err != nil {
+				return err
+			}
+			if !cb(dbIndex, string(key), entity, expiration) {
+				return nil
+			}
+			expiration = nil
+		}
+	}
+}
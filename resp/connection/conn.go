@@ -3,10 +3,19 @@ package connection
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go-redis/config"
+	"go-redis/enum"
 	"go-redis/interface/db"
-	"go-redis/lib/sync/wait"
+	"go-redis/lib/logger"
+)
+
+const (
+	replyChunkBytes = 16 << 10         // 缓冲区达到这个大小时立即写入连接, 和Redis的PROTO_REPLY_CHUNK_BYTES一致
+	maxSpareBytes   = 64 << 10         // 写入完成之后复用的缓冲区的最大容量
+	closeTimeout    = 10 * time.Second // 关闭连接时等待写完回复的最长时间
 )
 
 const (
@@ -16,27 +25,53 @@ const (
 	flagMaster
 	// flagMulti means this connection is within a transaction, 100
 	flagMulti
+	// flagAsking means the next command may access a slot being imported, set by ASKING
+	flagAsking
+	// flagRedirect means keys of other nodes are replied with MOVED or ASK instead of being relayed
+	flagRedirect
 )
 
 // RespConnection is the connection to the client.
+//
+// Replies are appended to an output buffer and written by a dedicated goroutine,
+// so it must be created by NewRespConnection.
 type RespConnection struct {
-	conn         net.Conn   // the connection to the client
-	waitingReply wait.Wait  // the waiting reply
-	mu           sync.Mutex // the mutex to protect the connection
-	flags        uint64
-	selectedDB   int // the selected db index
+	conn       net.Conn // the connection to the client
+	flags      uint64
+	selectedDB int          // the selected db index
+	id         atomic.Int64 // the unique id of the connection, assigned lazily
+	protocol   atomic.Int32 // the protocol version negotiated by HELLO, 0 means RESP2, read by publishers and invalidation
+	name       string       // the name set by CLIENT SETNAME or HELLO SETNAME
+
+	// output buffer
+	mu          sync.Mutex    // protects the fields of output buffer
+	cond        *sync.Cond    // wakes up the writer goroutine
+	out         []byte        // 等待写入连接的回复
+	spare       []byte        // 写入完成之后复用的缓冲区
+	writing     int           // 正在写入连接的字节数
+	flushNeeded bool          // 需要把缓冲区写入连接
+	closed      bool          // 连接已经关闭, 不再接收回复
+	softSince   time.Time     // 第一次超过软限制的时间
+	done        chan struct{} // 写入的goroutine退出时关闭
+	subs        atomic.Int32  // 订阅的频道和模式的数量, 其他goroutine写入时用于判断客户端的类别
 
-	// password is user's password
-	password string
+	// user is the name of the ACL user authenticated by AUTH, empty means the default user
+	user   string
+	userID uint64 // the id of the user when authenticated, changes if the user is deleted and created again
 
 	// implement transaction
 	queue             []db.CmdLine      // 事务命令的执行队列
 	watching          map[string]uint32 // 一个事务执行过程中的有关的键与对应的版本号
 	transactionErrors []error           // 事务执行中的抛出的错误
+
+	// implement pub/sub
+	channels map[string]struct{} // 订阅的频道
+	patterns map[string]struct{} // 订阅的模式
 }
 
-func (rc *RespConnection) GetPassword() string {
-	return rc.password
+// GetUser returns the name and id of the authenticated user, empty means the default user
+func (rc *RespConnection) GetUser() (string, uint64) {
+	return rc.user, rc.userID
 }
 
 func (rc *RespConnection) InMultiState() bool {
@@ -47,8 +82,8 @@ func (rc *RespConnection) GetQueuedCmdLine() []db.CmdLine {
 	return rc.queue
 }
 
-func (rc *RespConnection) SetPassword(password string) {
-	rc.password = password
+func (rc *RespConnection) SetUser(user string, id uint64) {
+	rc.user, rc.userID = user, id
 }
 
 // SetMultiState 设置此链接正在执行事务的标志
@@ -64,6 +99,32 @@ func (rc *RespConnection) SetMultiState(state bool) {
 	rc.flags |= flagMulti
 }
 
+// SetAsking 设置 ASKING 标志, 只对下一条命令有效
+func (rc *RespConnection) SetAsking(asking bool) {
+	if asking {
+		rc.flags |= flagAsking
+	} else {
+		rc.flags &= ^flagAsking
+	}
+}
+
+func (rc *RespConnection) IsAsking() bool {
+	return rc.flags&flagAsking > 0
+}
+
+// SetRedirect 设置集群的重定向模式, 集群节点之间转发命令的连接使用重定向模式, 避免命令在节点之间反复转发
+func (rc *RespConnection) SetRedirect(redirect bool) {
+	if redirect {
+		rc.flags |= flagRedirect
+	} else {
+		rc.flags &= ^flagRedirect
+	}
+}
+
+func (rc *RespConnection) IsRedirect() bool {
+	return rc.flags&flagRedirect > 0
+}
+
 func (rc *RespConnection) ClearWatching() {
 	rc.watching = nil
 }
@@ -88,12 +149,130 @@ func (rc *RespConnection) ClearQueuedCmds() {
 	rc.queue = nil
 }
 
+// Subscribe 记录客户端订阅的频道
+func (rc *RespConnection) Subscribe(channel string) {
+	if rc.channels == nil {
+		rc.channels = make(map[string]struct{})
+	}
+	rc.channels[channel] = struct{}{}
+	rc.subs.Store(int32(rc.SubsCount()))
+}
+
+// UnSubscribe 删除客户端订阅的频道
+func (rc *RespConnection) UnSubscribe(channel string) {
+	delete(rc.channels, channel)
+	rc.subs.Store(int32(rc.SubsCount()))
+}
+
+// PSubscribe 记录客户端订阅的模式
+func (rc *RespConnection) PSubscribe(pattern string) {
+	if rc.patterns == nil {
+		rc.patterns = make(map[string]struct{})
+	}
+	rc.patterns[pattern] = struct{}{}
+	rc.subs.Store(int32(rc.SubsCount()))
+}
+
+// PUnSubscribe 删除客户端订阅的模式
+func (rc *RespConnection) PUnSubscribe(pattern string) {
+	delete(rc.patterns, pattern)
+	rc.subs.Store(int32(rc.SubsCount()))
+}
+
+// GetChannels 返回客户端订阅的所有频道
+func (rc *RespConnection) GetChannels() []string {
+	channels := make([]string, 0, len(rc.channels))
+	for channel := range rc.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// GetPatterns 返回客户端订阅的所有模式
+func (rc *RespConnection) GetPatterns() []string {
+	patterns := make([]string, 0, len(rc.patterns))
+	for pattern := range rc.patterns {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// SubsCount 返回客户端订阅的频道和模式的总数, 大于0时客户端处于订阅状态
+func (rc *RespConnection) SubsCount() int {
+	return len(rc.channels) + len(rc.patterns)
+}
+
+// nextID is the id of the last created connection
+var nextID atomic.Int64
+
+// connections 记录已经分配了id并且没有关闭的连接, 用于判断客户端id是否存在
+var connections sync.Map
+
+// GetID returns the unique id of the connection, the first call assigns a new id.
+func (rc *RespConnection) GetID() int64 {
+	if id := rc.id.Load(); id != 0 {
+		return id
+	}
+	if rc.id.CompareAndSwap(0, nextID.Add(1)) {
+		rc.mu.Lock()
+		if !rc.closed {
+			connections.Store(rc.id.Load(), struct{}{})
+		}
+		rc.mu.Unlock()
+	}
+	return rc.id.Load()
+}
+
+// Exists reports whether a connection with the id is still open.
+func Exists(id int64) bool {
+	_, ok := connections.Load(id)
+	return ok
+}
+
+// markClosed 标记连接已经关闭, 不再接收回复, 之后不能再通过id找到这个连接
+// invoker should hold rc.mu
+func (rc *RespConnection) markClosed() {
+	rc.closed = true
+	connections.Delete(rc.id.Load())
+}
+
+// GetProtocol returns the protocol version of the connection, RESP2 by default.
+func (rc *RespConnection) GetProtocol() int {
+	if protocol := rc.protocol.Load(); protocol != 0 {
+		return int(protocol)
+	}
+	return enum.RESP2
+}
+
+// SetProtocol sets the protocol version negotiated by HELLO.
+func (rc *RespConnection) SetProtocol(protocol int) {
+	rc.protocol.Store(int32(protocol))
+}
+
+// GetName returns the name of the connection.
+func (rc *RespConnection) GetName() string {
+	return rc.name
+}
+
+// SetName sets the name of the connection.
+func (rc *RespConnection) SetName(name string) {
+	rc.name = name
+}
+
 func NewRespConnection(conn net.Conn) *RespConnection {
-	return &RespConnection{conn: conn}
+	rc := &RespConnection{conn: conn, done: make(chan struct{})}
+	rc.cond = sync.NewCond(&rc.mu)
+	go rc.writeLoop()
+	return rc
 }
 
 // RemoteAddr returns the remote network address.
+//
+// Unix socket peers have no address, so the socket path is returned in the form path:0 like Redis.
 func (rc *RespConnection) RemoteAddr() string {
+	if local, ok := rc.conn.LocalAddr().(*net.UnixAddr); ok && local != nil {
+		return local.Name + ":0"
+	}
 	return rc.conn.RemoteAddr().String()
 }
 
@@ -105,31 +284,152 @@ func (rc *RespConnection) GetWatching() map[string]uint32 {
 	return rc.watching
 }
 
-// Close closes the connection.
+// Close closes the connection after the buffered replies are written.
 func (rc *RespConnection) Close() error {
-	rc.waitingReply.WaitWithTimeout(10 * time.Second)
+	rc.mu.Lock()
+	rc.markClosed()
+	rc.cond.Signal()
+	rc.mu.Unlock()
+
+	select {
+	case <-rc.done:
+	case <-time.After(closeTimeout):
+	}
 	_ = rc.conn.Close()
 	return nil
 }
 
-// Write writes data to the connection and returns the number of bytes written and an error if any.
-//
-// If len(p) == 0, Write returns 0, nil without writing anything.
+// Write appends data to the output buffer and wakes up the writer goroutine at once.
 //
-// Mutex is used to protect the connection.
+// It is safe to be called by other goroutines, e.g. publishing messages.
 func (rc *RespConnection) Write(p []byte) (n int, err error) {
+	if err = rc.Buffer(p); err != nil {
+		return 0, err
+	}
+	rc.Flush()
+	return len(p), nil
+}
+
+// Buffer appends data to the output buffer without writing it to the connection,
+// the buffer is written when Flush is called or its size reaches replyChunkBytes.
+//
+// The client is disconnected if the output buffer exceeds client-output-buffer-limit.
+func (rc *RespConnection) Buffer(p []byte) error {
 	if len(p) == 0 {
-		return 0, nil
+		return nil
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return enum.CONNECTION_CLOSED
+	}
+	rc.out = append(rc.out, p...)
+	if len(rc.out) >= replyChunkBytes {
+		rc.flushNeeded = true
+		rc.cond.Signal()
+	}
+	if rc.overOutputLimit() {
+		logger.Warn("client", rc.RemoteAddr(), "scheduled to be closed ASAP for overcoming of output buffer limits")
+		rc.markClosed()
+		rc.out = nil
+		rc.cond.Signal()
+		_ = rc.conn.Close() // 关闭连接使阻塞的读写立即返回
+		return enum.CONNECTION_CLOSED
 	}
+	return nil
+}
 
+// Flush wakes up the writer goroutine to write the output buffer.
+func (rc *RespConnection) Flush() {
 	rc.mu.Lock()
-	rc.waitingReply.Add(1)
-	defer func() {
-		rc.waitingReply.Done()
+	if len(rc.out) > 0 {
+		rc.flushNeeded = true
+		rc.cond.Signal()
+	}
+	rc.mu.Unlock()
+}
+
+// overOutputLimit 判断输出缓冲区是否超过限制, 需要持有锁
+//
+// 超过硬限制, 或者持续超过软限制一段时间都会断开连接
+func (rc *RespConnection) overOutputLimit() bool {
+	class := config.ClientClassNormal
+	if rc.subs.Load() > 0 {
+		class = config.ClientClassPubSub
+	}
+	limit := getOutputLimit(class)
+	size := int64(len(rc.out) + rc.writing)
+	if limit.Hard > 0 && size >= limit.Hard {
+		return true
+	}
+	if limit.Soft == 0 || size < limit.Soft {
+		rc.softSince = time.Time{}
+		return false
+	}
+	if rc.softSince.IsZero() {
+		rc.softSince = time.Now()
+		return false
+	}
+	return time.Since(rc.softSince) >= limit.SoftSeconds
+}
+
+// writeLoop 把输出缓冲区写入连接, 写入时不持有锁, 其他goroutine可以继续追加回复
+//
+// 连接关闭后写完剩余的回复再退出
+func (rc *RespConnection) writeLoop() {
+	defer close(rc.done)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for {
+		for !rc.closed && !(rc.flushNeeded && len(rc.out) > 0) {
+			rc.cond.Wait()
+		}
+		if len(rc.out) == 0 { // 已经关闭并且写完
+			return
+		}
+		data := rc.out
+		rc.out, rc.spare = rc.spare[:0], nil
+		rc.writing, rc.flushNeeded = len(data), false
+
 		rc.mu.Unlock()
-	}()
+		_, err := rc.conn.Write(data)
+		rc.mu.Lock()
 
-	return rc.conn.Write(p)
+		rc.writing = 0
+		if cap(data) <= maxSpareBytes { // 过大的缓冲区不复用, 释放内存
+			rc.spare = data
+		}
+		if err != nil {
+			rc.markClosed()
+			rc.out = nil
+			return
+		}
+	}
+}
+
+// outputLimits 缓存解析后的 client-output-buffer-limit, 配置修改之后重新解析
+var outputLimits atomic.Pointer[parsedOutputLimits]
+
+type parsedOutputLimits struct {
+	source string
+	limits map[string]config.OutputBufferLimit
+}
+
+// getOutputLimit 返回指定类别的客户端的输出缓冲区限制
+func getOutputLimit(class string) config.OutputBufferLimit {
+	source := config.Properties.ClientOutputBufferLimit
+	cached := outputLimits.Load()
+	if cached == nil || cached.source != source {
+		limits, err := config.ParseClientOutputBufferLimit(source)
+		if err != nil {
+			logger.Error("client-output-buffer-limit:", err)
+		}
+		cached = &parsedOutputLimits{source: source, limits: limits}
+		outputLimits.Store(cached)
+	}
+	return cached.limits[class]
 }
 
 // GetDBIndex returns the selected db index.
@@ -0,0 +1,136 @@
+package connection
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-redis/config"
+	"go-redis/enum"
+)
+
+// countingConn 统计写入连接的次数
+type countingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+func TestBufferAndFlush(t *testing.T) {
+	server, client := net.Pipe()
+	conn := &countingConn{Conn: server}
+	rc := NewRespConnection(conn)
+
+	for i := 0; i < 100; i++ {
+		if err := rc.Buffer([]byte("+OK\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rc.Flush()
+	buf := make([]byte, 500)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.writes.Load(); n != 1 {
+		t.Errorf("expected 1 write, actually %d", n)
+	}
+
+	// 关闭时写完剩余的回复
+	_ = rc.Buffer([]byte("+OK\r\n"))
+	go func() { _ = rc.Close() }()
+	if _, err := io.ReadFull(client, buf[:5]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.Write([]byte("+OK\r\n")); err == nil {
+		t.Error("expected error after close")
+	}
+}
+
+func TestOutputBufferLimit(t *testing.T) {
+	defer func(limit string) { config.Properties.ClientOutputBufferLimit = limit }(config.Properties.ClientOutputBufferLimit)
+
+	tests := []struct {
+		limit     string
+		subscribe bool
+		closed    bool
+	}{
+		{"normal 0 0 0 pubsub 100 0 0", false, false},
+		{"normal 0 0 0 pubsub 100 0 0", true, true},
+		{"normal 0 50 0", false, true}, // 持续超过软限制
+	}
+	for _, tt := range tests {
+		config.Properties.ClientOutputBufferLimit = tt.limit
+		server, client := net.Pipe() // 客户端不读取, 模拟缓慢的消费者
+		rc := NewRespConnection(server)
+		if tt.subscribe {
+			rc.Subscribe("ch")
+		}
+		var err error
+		for i := 0; i < 50 && err == nil; i++ {
+			_, err = rc.Write([]byte("$5\r\nhello\r\n"))
+			time.Sleep(time.Millisecond)
+		}
+		if closed := err == enum.CONNECTION_CLOSED; closed != tt.closed {
+			t.Errorf("%q: expected closed %v, actually %v", tt.limit, tt.closed, err)
+		}
+		_ = client.Close()
+		_ = rc.Close()
+	}
+}
+
+func TestRemoteAddrUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := NewRespConnection(server)
+	defer func() { _ = rc.Close() }()
+	if addr := rc.RemoteAddr(); addr != path+":0" {
+		t.Errorf("expected %s:0, actually %s", path, addr)
+	}
+}
+
+func TestProtocolConcurrentAccess(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	rc := NewRespConnection(server)
+	defer func() { _ = rc.Close() }()
+	if rc.GetProtocol() != enum.RESP2 {
+		t.Fatalf("expected RESP2 by default, got %d", rc.GetProtocol())
+	}
+
+	// 发布消息和失效通知在其他goroutine中读取协议版本
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if protocol := rc.GetProtocol(); protocol != enum.RESP2 && protocol != enum.RESP3 {
+				t.Errorf("unexpected protocol %d", protocol)
+				return
+			}
+		}
+	}()
+	rc.SetProtocol(enum.RESP3)
+	<-done
+	if rc.GetProtocol() != enum.RESP3 {
+		t.Fatalf("expected RESP3, got %d", rc.GetProtocol())
+	}
+}
@@ -102,6 +102,9 @@ func (c *FakeConn) Bytes() []byte {
 
 func (c *FakeConn) Close() error {
 	c.closed = true
+	c.RespConnection.mu.Lock()
+	c.RespConnection.markClosed()
+	c.RespConnection.mu.Unlock()
 	c.notify()
 	return nil
 }
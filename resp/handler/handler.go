@@ -5,10 +5,12 @@ import (
 	"errors"
 	"io"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	cluster_database "go-redis/cluster"
 	"go-redis/config"
@@ -33,7 +35,7 @@ type RespHandler struct {
 func NewRespHandler() (handler *RespHandler) {
 	handler = new(RespHandler)
 
-	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
+	if config.Properties.ClusterEnabled || (config.Properties.Self != "" && len(config.Properties.Peers) > 0) {
 		logger.Info("start a cluster, self address:", config.Properties.Self,
 			", peers addresses:", config.Properties.Peers)
 
@@ -66,25 +68,41 @@ func (rh *RespHandler) Handle(_ context.Context, conn net.Conn) {
 	}
 	// create a new client
 	client := connection.NewRespConnection(conn)
+	// 集群的重定向模式下, 客户端根据 MOVED 和 ASK 直接访问负责key的节点
+	client.SetRedirect(config.ClusterRedirect())
 	rh.activeConn.Store(client, struct{}{})
 	rh.connCount.Add(1)
-	ch := parser.ParseStream(conn)
-	// receive payload
-	for payload := range ch {
-		if payload.Err != nil {
+	reader := parser.NewRequestReader(conn)
+	idle := false // 是否设置了空闲超时
+	// receive reply
+	for {
+		idle = setIdleDeadline(conn, client, idle)
+		data, fatal, err := reader.ReadReply()
+		if err != nil {
+			// 空闲超时, 直接关闭连接
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				rh.closeClient(client)
+				go logger.Info("closing idle client:", client.RemoteAddr())
+				return
+			}
 			// if client closed, close the connection
-			if payload.Err == io.EOF ||
-				errors.Is(payload.Err, io.ErrUnexpectedEOF) ||
-				strings.Contains(payload.Err.Error(), enum.CONNECTION_CLOSED.Error()) {
+			if err == io.EOF ||
+				errors.Is(err, io.ErrUnexpectedEOF) ||
+				strings.Contains(err.Error(), enum.CONNECTION_CLOSED.Error()) {
 
 				rh.closeClient(client)
 				go logger.Info("client closed:", client.RemoteAddr())
 				return
 			}
 			// protocol error
-			_, err := client.Write([]byte(payload.Err.Error()))
+			var errReply resp.ErrorReply
+			if !errors.As(err, &errReply) {
+				errReply = reply.NewErrReply(err.Error())
+			}
+			_, err = client.Write(errReply.Bytes())
 
-			if err != nil {
+			// 无法恢复的错误之后不能继续读取, 关闭连接
+			if err != nil || fatal {
 				rh.closeClient(client)
 				go logger.Info("client closed:", client.RemoteAddr())
 				return
@@ -93,30 +111,44 @@ func (rh *RespHandler) Handle(_ context.Context, conn net.Conn) {
 			continue
 		}
 
-		if payload.Data == nil {
-			go logger.Info(enum.EMPTY_PAYLOAD)
-			continue
-		}
-
-		result := rh.exec(payload, client)
+		result := rh.exec(data, client)
 
+		// 回复先写入缓冲区, 流水线中的命令都处理完之后一起写入连接
 		if result != nil {
-			_, _ = client.Write(result.Bytes())
+			_ = client.Buffer(reply.Marshal(result, client.GetProtocol()))
 		} else {
-			_, _ = client.Write(reply.NewUnknownErrReply().Bytes())
+			_ = client.Buffer(reply.NewUnknownErrReply().Bytes())
 		}
+		if reader.Buffered() == 0 {
+			client.Flush()
+		}
+	}
+}
+
+// setIdleDeadline 根据 timeout 配置设置读取下一条命令的超时时间, 返回是否设置了超时
+//
+// 订阅状态的客户端只接收消息, 不会因为空闲被断开; 每次读取之前都会重新读取配置, 配置修改之后立即生效
+func setIdleDeadline(conn net.Conn, client *connection.RespConnection, idle bool) bool {
+	timeout := time.Duration(config.Timeout()) * time.Second
+	if timeout > 0 && client.SubsCount() == 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		return true
+	}
+	if idle { // 取消之前设置的超时
+		_ = conn.SetReadDeadline(time.Time{})
 	}
+	return false
 }
 
 // exec 使用数据库根据解析后的客户端的回复执行命令, 然后返回结果
-func (rh *RespHandler) exec(payload *parser.Payload, client *connection.RespConnection) resp.Reply {
-	switch payload.Data.(type) {
+func (rh *RespHandler) exec(data resp.Reply, client *connection.RespConnection) resp.Reply {
+	switch data := data.(type) {
 	case *reply.MultiBulkReply:
-		return rh.db.Exec(client, payload.Data.(*reply.MultiBulkReply).Args)
+		return rh.db.Exec(client, data.Args)
 	case *reply.BulkReply:
-		return rh.db.Exec(client, [][]byte{payload.Data.(*reply.BulkReply).Arg})
+		return rh.db.Exec(client, [][]byte{data.Arg})
 	default: // 错误回复
-		return payload.Data
+		return data
 	}
 }
 
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go-redis/config"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	old := config.Properties.Timeout
+	defer func() { config.Properties.Timeout = old }()
+	config.Properties.Timeout = 1
+
+	handler := NewRespHandler()
+	defer handler.Close()
+
+	start := func() (net.Conn, *bufio.Reader) {
+		server, client := net.Pipe()
+		go handler.Handle(context.Background(), server)
+		return client, bufio.NewReader(client)
+	}
+	request := func(conn net.Conn, reader *bufio.Reader, cmd string) string {
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte(cmd)); err != nil {
+			return ""
+		}
+		line, _ := reader.ReadString('\n')
+		return line
+	}
+
+	// 1. 空闲超时之后断开连接
+	idle, idleReader := start()
+	defer idle.Close()
+	if line := request(idle, idleReader, "PING\r\n"); line != "+PONG\r\n" {
+		t.Fatalf("unexpected reply %q", line)
+	}
+	// 2. 订阅状态的客户端不会因为空闲断开
+	subscriber, subReader := start()
+	defer subscriber.Close()
+	if line := request(subscriber, subReader, "SUBSCRIBE ch\r\n"); line != "*3\r\n" {
+		t.Fatalf("unexpected reply %q", line)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	_ = idle.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idleReader.ReadByte(); err != io.EOF {
+		t.Errorf("idle client should be closed, actually %v", err)
+	}
+	// 跳过 SUBSCRIBE 剩余的回复
+	line := request(subscriber, subReader, "PING\r\n")
+	for line != "" && line != "+PONG\r\n" {
+		line, _ = subReader.ReadString('\n')
+	}
+	if line == "" {
+		t.Errorf("subscriber should not be closed")
+	}
+}
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+)
+
+// parseInline 解析内联命令, 参数之间用空白分隔, 支持和redis-cli一样的引号和转义
+//
+// 例如: SET foo "hello world"
+//
+// 空行返回nil
+func parseInline(msg []byte) (resp.Reply, error) {
+	args, err := splitArgs(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return reply.NewMultiBulkReply(args), nil
+}
+
+// splitArgs 按照Redis的sdssplitargs规则拆分参数
+//
+//  1. 双引号中支持 \n \r \t \b \a \\ \" 和 \xHH 转义
+//  2. 单引号中只支持 \' 转义
+//  3. 引号结束后必须紧跟空白或者行尾
+func splitArgs(line []byte) ([][]byte, error) {
+	args := make([][]byte, 0)
+	i := 0
+	for {
+		// 1. 跳过参数之间的空白
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+		// 2. 读取一个参数
+		var (
+			arg          = make([]byte, 0)
+			inDoubleQuot bool
+			inSingleQuot bool
+			done         bool
+		)
+		for !done {
+			if i >= len(line) {
+				if inDoubleQuot || inSingleQuot { // 引号没有闭合
+					return nil, reply.NewProtocolErrReply("unbalanced quotes in request")
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDoubleQuot:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					arg = append(arg, hexDigitToInt(line[i+2])<<4|hexDigitToInt(line[i+3]))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					arg = append(arg, unescape(line[i]))
+				} else if c == '"' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, reply.NewProtocolErrReply("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			case inSingleQuot:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, reply.NewProtocolErrReply("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			default:
+				switch c {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDoubleQuot = true
+				case '\'':
+					inSingleQuot = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			i++
+		}
+		args = append(args, arg)
+	}
+}
+
+// unescape 返回双引号中转义字符对应的字符
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexDigitToInt(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
@@ -0,0 +1,239 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"go-redis/enum"
+	"go-redis/interface/resp"
+	"go-redis/lib/asserts"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+func TestParseStream(t *testing.T) {
+	replies := []resp.Reply{
+		reply.NewIntReply(1),
+		reply.NewStatusReply("OK"),
+		reply.NewErrReply("ERR unknown"),
+		reply.NewBulkReply([]byte("a\r\nb")),
+		reply.NewMultiBulkReply([][]byte{[]byte("a"), nil, []byte("c")}),
+		reply.NewMultiRawReply([]resp.Reply{reply.NewIntReply(1), reply.NewMultiBulkReply([][]byte{[]byte("x")})}),
+		reply.NewBulkMapReply([][]byte{[]byte("k"), []byte("v")}),
+		reply.NewBulkSetReply([][]byte{[]byte("m")}),
+		reply.NewPushReply([]resp.Reply{reply.NewBulkReply([]byte("invalidate")), reply.NewNullReply()}),
+		reply.NewAttributeReply([]resp.Reply{reply.NewStatusReply("ttl"), reply.NewIntReply(100)}, reply.NewBulkReply([]byte("v"))),
+		reply.NewDoubleReply(-1.5),
+		reply.NewBoolReply(true),
+		reply.NewVerbatimReply("txt", []byte("hello")),
+		reply.NewNullReply(),
+	}
+	var buf bytes.Buffer
+	for _, re := range replies {
+		buf.Write(reply.Marshal(re, enum.RESP3))
+	}
+	ch := ParseStream(&buf)
+	for _, expected := range replies {
+		payload := <-ch
+		if payload.Err != nil {
+			t.Fatal(payload.Err)
+		}
+		if !bytes.Equal(reply.Marshal(payload.Data, enum.RESP3), reply.Marshal(expected, enum.RESP3)) {
+			t.Errorf("expected %q, actually %q", reply.Marshal(expected, enum.RESP3), reply.Marshal(payload.Data, enum.RESP3))
+		}
+	}
+}
+
+func TestParseProtocolErr(t *testing.T) {
+	ch := ParseStream(bytes.NewReader([]byte("#x\r\n:y\r\n:2\r\n")))
+	for i := 0; i < 2; i++ {
+		payload := <-ch
+		if payload.Err == nil {
+			t.Errorf("expected protocol error, actually %q", payload.Data.Bytes())
+		}
+	}
+	payload := <-ch
+	if payload.Err != nil || string(payload.Data.Bytes()) != ":2\r\n" {
+		t.Errorf("expected :2, actually %v", payload)
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"SET foo bar\r\n", []string{"SET", "foo", "bar"}},
+		{"  get   foo\n", []string{"get", "foo"}},
+		{"set k \"hello world\"\r\n", []string{"set", "k", "hello world"}},
+		{"set k \"a\\n\\x41\\\"\"\r\n", []string{"set", "k", "a\nA\""}},
+		{"set k 'it\\'s' \"\"\r\n", []string{"set", "k", "it's", ""}},
+	}
+	for _, tt := range tests {
+		payload := <-ParseStream(bytes.NewReader([]byte(tt.line)))
+		if payload.Err != nil {
+			t.Fatal(payload.Err)
+		}
+		expected := reply.NewMultiBulkReply(utils.ToCmdLine(tt.expected...))
+		if !bytes.Equal(payload.Data.Bytes(), expected.Bytes()) {
+			t.Errorf("%q: expected %q, actually %q", tt.line, expected.Bytes(), payload.Data.Bytes())
+		}
+	}
+
+	// 引号不匹配时返回错误, 然后继续解析下一行
+	ch := ParseStream(bytes.NewReader([]byte("set k \"v\r\nset k \"v\"x\r\n\r\nping\r\n")))
+	for i := 0; i < 2; i++ {
+		payload := <-ch
+		asserts.AssertErrReply(t, payload.Err.(resp.ErrorReply), "ERR Protocol error: 'unbalanced quotes in request'")
+	}
+	payload := <-ch
+	asserts.AssertMultiBulkReply(t, payload.Data, []string{"ping"})
+}
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"$536870913\r\n", "ERR Protocol error: 'invalid bulk length'"},
+		{"*1\r\n$-2\r\n", "ERR Protocol error: 'invalid bulk length'"},
+		{"*2147483648\r\n", "ERR Protocol error: 'invalid multibulk length'"},
+		{strings.Repeat("a", maxInlineSize+1), "ERR Protocol error: 'too big inline request'"},
+	}
+	for _, tt := range tests {
+		ch := ParseStream(bytes.NewReader([]byte(tt.input + "\r\nping\r\n")))
+		payload := <-ch
+		if payload.Err == nil {
+			t.Fatalf("%q: expected error", tt.input)
+		}
+		asserts.AssertErrReply(t, payload.Err.(resp.ErrorReply), tt.expected)
+		// 无法恢复的错误之后关闭通道
+		if _, ok := <-ch; ok {
+			t.Errorf("%q: channel should be closed", tt.input)
+		}
+	}
+
+	// 较长的字符串按块读取
+	value := strings.Repeat("v", bulkChunkSize*3+1)
+	payload := <-ParseStream(bytes.NewReader(reply.NewMultiBulkReply(utils.ToCmdLine("set", "k", value)).Bytes()))
+	asserts.AssertMultiBulkReply(t, payload.Data, []string{"set", "k", value})
+	// 声明的长度大于实际数据
+	payload = <-ParseStream(bytes.NewReader([]byte("$100000\r\nabc")))
+	if payload.Err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF, actually %v", payload.Err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	// 超过缓冲区的行复制后解析
+	long := strings.Repeat("x", 5000)
+	data := "+" + long + "\r\n*2\r\n$1\r\na\r\n:1\r\n\r\n*2\r\n$1\r\na\r\n$-1\r\n"
+	reader := NewReader(strings.NewReader(data))
+	res, _, err := reader.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertStatusReply(t, res, long)
+	res, _, err = reader.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.(*reply.MultiRawReply); !ok || string(res.Bytes()) != "*2\r\n$1\r\na\r\n:1\r\n" {
+		t.Errorf("unexpected reply %q", res.Bytes())
+	}
+	res, _, err = reader.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := res.(*reply.MultiBulkReply).Args; string(args[0]) != "a" || args[1] != nil {
+		t.Errorf("unexpected args %q", args)
+	}
+	if _, fatal, err := reader.ReadReply(); err != io.EOF || !fatal {
+		t.Errorf("expected fatal EOF, actually %v", err)
+	}
+
+	res, err = ParseOne([]byte("$3\r\nfoo\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertBulkReply(t, res, "foo")
+	if _, err = ParseOne(nil); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestRequestReader(t *testing.T) {
+	// 命令只能是字符串数组, 嵌套的数组直接返回错误, 不会递归解析
+	nested := strings.Repeat("*1\r\n", 1<<20)
+	reader := NewRequestReader(strings.NewReader(nested))
+	_, fatal, err := reader.ReadReply()
+	if err == nil || !fatal {
+		t.Fatalf("expected fatal error, actually %v", err)
+	}
+	asserts.AssertErrReply(t, err.(resp.ErrorReply), "ERR Protocol error: 'expected '$', got '*''")
+
+	// 字符串数组, 单个字符串和内联命令, 空数组被跳过
+	reader = NewRequestReader(strings.NewReader("*0\r\n*2\r\n$3\r\nget\r\n$1\r\na\r\n$4\r\nping\r\n+ok\r\n*1\r\n$-1\r\n"))
+	res, _, err := reader.ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asserts.AssertMultiBulkReply(t, res, []string{"get", "a"})
+	res, _, _ = reader.ReadReply()
+	asserts.AssertBulkReply(t, res, "ping")
+	res, _, _ = reader.ReadReply()
+	asserts.AssertMultiBulkReply(t, res, []string{"+ok"})
+	if _, fatal, err = reader.ReadReply(); err == nil || !fatal {
+		t.Errorf("null bulk string is not a valid argument, actually %v", err)
+	}
+
+	// 解析回复时限制嵌套层数
+	_, fatal, err = NewReader(strings.NewReader(nested)).ReadReply()
+	if err == nil || !fatal {
+		t.Fatalf("expected fatal error, actually %v", err)
+	}
+	asserts.AssertErrReply(t, err.(resp.ErrorReply), "ERR Protocol error: 'too deep nesting'")
+	if _, err = ParseOne([]byte(strings.Repeat("*1\r\n", maxNestingDepth) + ":1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loopReader 循环读取同一段数据, 用于模拟持续发送命令的客户端
+type loopReader struct {
+	data []byte
+	off  int
+}
+
+func (r *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+var benchCmd = reply.NewMultiBulkReply(utils.ToCmdLine("SET", "key:000001", strings.Repeat("v", 64))).Bytes()
+
+func BenchmarkParseStream(b *testing.B) {
+	ch := ParseStream(&loopReader{data: benchCmd})
+	b.SetBytes(int64(len(benchCmd)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if payload := <-ch; payload.Err != nil {
+			b.Fatal(payload.Err)
+		}
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	reader := NewReader(&loopReader{data: benchCmd})
+	b.SetBytes(int64(len(benchCmd)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := reader.ReadReply(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
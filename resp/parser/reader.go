@@ -0,0 +1,372 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+// Reader 同步地从 bufio.Reader 中解析RESP协议, 调用方在自己的goroutine中逐个读取回复
+//
+// 读取一行时直接使用 bufio.Reader 的缓冲区, 只有一行超过缓冲区时才复制到 line 中, line 在多次读取之间复用
+type Reader struct {
+	br      *bufio.Reader
+	line    []byte // 超过缓冲区的行, 复用以减少内存分配
+	request bool   // 是否只读取客户端发送的命令
+	depth   int    // 正在解析的聚合类型的嵌套层数
+}
+
+// NewReader creates Reader, 如果rd已经是 bufio.Reader 则直接使用
+func NewReader(rd io.Reader) *Reader {
+	br, ok := rd.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(rd)
+	}
+	return &Reader{br: br}
+}
+
+// NewRequestReader 创建读取客户端命令的 Reader
+//
+// 和Redis一样只接受字符串数组和内联命令, 不解析嵌套的聚合类型, 客户端无法通过深层嵌套耗尽服务端的栈.
+// 兼容只有一个参数时发送单个字符串的客户端
+func NewRequestReader(rd io.Reader) *Reader {
+	reader := NewReader(rd)
+	reader.request = true
+	return reader
+}
+
+// Buffered 返回已经读取到缓冲区但还没有解析的字节数, 为0时说明客户端发送的命令都已经处理完
+func (r *Reader) Buffered() int {
+	return r.br.Buffered()
+}
+
+// ReadReply 读取下一个回复, 空行会被跳过
+//
+// 以RESP类型符号开头的行按照RESP协议解析, 其他的行作为内联命令解析, 例如通过telnet输入的 SET foo bar
+//
+// 出错时 fatal 表示是否还能继续读取, IO错误和无法定位下一个回复的协议错误都无法恢复
+func (r *Reader) ReadReply() (res resp.Reply, fatal bool, err error) {
+	for res == nil {
+		var line []byte
+		if line, fatal, err = r.readLine(); err != nil {
+			return nil, fatal, err
+		}
+		if r.request && line[0] == '*' {
+			res, fatal, err = r.parseRequest(line)
+		} else if r.request && line[0] == '$' { // 客户端把只有一个参数的命令发送为字符串
+			res, fatal, err = r.parseBlob(line)
+		} else if !r.request && isRespType(line[0]) {
+			res, fatal, err = r.parseReply(line)
+		} else {
+			res, err = parseInline(line)
+		}
+		if err != nil {
+			return nil, fatal, err
+		}
+	}
+	return res, false, nil
+}
+
+// readLine 用于读取以\n结尾的一行数据, 返回的数据包含\n, 只在下一次读取之前有效
+//
+// 一行的长度超过 maxInlineSize 时返回协议错误, 避免一直读取不含换行符的数据
+func (r *Reader) readLine() ([]byte, bool, error) {
+	// 1. 大部分行都在缓冲区中, 直接返回缓冲区的切片
+	line, err := r.br.ReadSlice('\n')
+	if err == nil && len(line) <= maxInlineSize {
+		return line, false, nil
+	}
+	// 2. 超过缓冲区的行复制到 r.line 中
+	r.line = append(r.line[:0], line...)
+	for err == bufio.ErrBufferFull && len(r.line) <= maxInlineSize {
+		line, err = r.br.ReadSlice('\n')
+		r.line = append(r.line, line...)
+	}
+	if len(r.line) > maxInlineSize {
+		return nil, true, reply.NewProtocolErrReply("too big inline request")
+	}
+	if err != nil {
+		if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) { // 空闲超时由调用方处理
+			logger.Error("readLine error:", err)
+		}
+		return nil, true, err
+	}
+	return r.line, false, nil
+}
+
+// readReply 读取并解析聚合类型中的一个元素, 元素解析失败后无法定位下一个回复, 所有的错误都无法恢复
+func (r *Reader) readReply() (resp.Reply, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := r.parseReply(line)
+	return res, err
+}
+
+// isRespType 判断是否是RESP协议的类型符号
+func isRespType(b byte) bool {
+	return strings.IndexByte("+-:_,#($!=*%~>|", b) >= 0
+}
+
+// parseReply 根据第一行的类型符号解析回复, msg是已经读取的第一行
+//
+// RESP2: + - : $ *
+//
+// RESP3: _ , # ( ! = % ~ > |
+//
+// msg 引用的是缓冲区, 回复中需要保存的内容都要复制
+func (r *Reader) parseReply(msg []byte) (resp.Reply, bool, error) {
+	// 1. 必须以\r\n结尾
+	if len(msg) <= 2 || msg[len(msg)-2] != '\r' {
+		return nil, false, reply.NewProtocolErrReply(string(msg))
+	}
+	// 2. 去除开头的标示和末尾的\r\n, 只用于解析数字
+	content := utils.Bytes2String(msg[1 : len(msg)-2])
+
+	switch msg[0] {
+	case '+': // 状态回复
+		return reply.NewStatusReply(string(msg[1 : len(msg)-2])), false, nil
+	case '-': // 错误回复
+		return reply.NewErrReply(strings.TrimPrefix(string(msg[1:len(msg)-2]), "ERR ")), false, nil
+	case ':': // 整数回复
+		code, err := strconv.ParseInt(content, 10, 64)
+		if err != nil {
+			return nil, false, reply.NewProtocolErrReply(string(msg[1 : len(msg)-2]))
+		}
+		return reply.NewIntReply(code), false, nil
+	case '_': // 空值
+		return reply.NewNullReply(), false, nil
+	case ',': // 浮点数, 支持 inf, -inf, nan
+		value, err := strconv.ParseFloat(content, 64)
+		if err != nil {
+			return nil, false, reply.NewProtocolErrReply(string(msg[1 : len(msg)-2]))
+		}
+		return reply.NewDoubleReply(value), false, nil
+	case '#': // 布尔值
+		if content != "t" && content != "f" {
+			return nil, false, reply.NewProtocolErrReply(string(msg[1 : len(msg)-2]))
+		}
+		return reply.NewBoolReply(content == "t"), false, nil
+	case '(': // 大整数
+		value, ok := new(big.Int).SetString(content, 10)
+		if !ok {
+			return nil, false, reply.NewProtocolErrReply(string(msg[1 : len(msg)-2]))
+		}
+		return reply.NewBigNumberReply(value), false, nil
+	case '$', '!', '=': // 字符串, 错误字符串, 带格式的字符串
+		return r.parseBlob(msg)
+	case '*', '%', '~', '>', '|': // 数组, 键值对, 集合, 推送, 属性
+		return r.parseAggregate(msg[0], content)
+	}
+	return nil, false, reply.NewProtocolErrReply(string(msg))
+}
+
+// parseBlob 解析带长度的字符串类型
+//
+// 例如: $4\r\nPING\r\n
+func (r *Reader) parseBlob(msg []byte) (resp.Reply, bool, error) {
+	msgType := msg[0]
+	body, err := r.readBulk(msg)
+	if err != nil {
+		return nil, true, err
+	}
+	switch {
+	case body == nil && msgType != '$': // 只有字符串可以是空值
+		return nil, true, reply.NewProtocolErrReply("invalid bulk length")
+	case body == nil:
+		return reply.NewNullBulkReply(), false, nil
+	case msgType == '!':
+		return reply.NewErrReply(strings.TrimPrefix(string(body), "ERR ")), false, nil
+	case msgType == '=':
+		if len(body) < 4 || body[3] != ':' {
+			return nil, false, reply.NewProtocolErrReply(string(body))
+		}
+		return reply.NewVerbatimReply(string(body[:3]), body[4:]), false, nil
+	}
+	return reply.NewBulkReply(body), false, nil
+}
+
+// readBulk 根据第一行声明的长度读取字符串, 长度为-1时返回nil
+//
+// 长度不合法或者数据不完整时无法定位下一个回复, 返回的错误都无法恢复
+func (r *Reader) readBulk(msg []byte) ([]byte, error) {
+	// 1. 解析长度, 不能超过 proto-max-bulk-len
+	if len(msg) <= 2 || msg[len(msg)-2] != '\r' {
+		return nil, reply.NewProtocolErrReply("invalid bulk length")
+	}
+	bulkLen, err := strconv.ParseInt(utils.Bytes2String(msg[1:len(msg)-2]), 10, 64)
+	if err != nil || bulkLen < -1 || bulkLen > int64(config.Properties.ProtoMaxBulkLen) {
+		return nil, reply.NewProtocolErrReply("invalid bulk length")
+	}
+	if bulkLen == -1 {
+		return nil, nil
+	}
+	// 2. 严格按照bulkLen+2字节数读取数据, 较长的数据按块读取, 不会根据声明的长度一次分配内存
+	var body []byte
+	if bulkLen+2 <= bulkChunkSize {
+		body = make([]byte, bulkLen+2)
+		if _, err = io.ReadFull(r.br, body); err != nil {
+			return nil, err
+		}
+	} else {
+		var buf bytes.Buffer
+		if _, err = io.CopyN(&buf, r.br, bulkLen+2); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		body = buf.Bytes()
+	}
+	if body[bulkLen] != '\r' || body[bulkLen+1] != '\n' { // 如果数据不以回车换行符结尾, 则返回错误
+		return nil, reply.NewProtocolErrReply(string(body))
+	}
+	return body[:bulkLen], nil
+}
+
+// parseRequest 解析客户端发送的命令, 命令只能是字符串数组, 元素数量为0时跳过
+//
+// 例如: *3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n
+func (r *Reader) parseRequest(msg []byte) (resp.Reply, bool, error) {
+	if len(msg) <= 2 || msg[len(msg)-2] != '\r' {
+		return nil, true, reply.NewProtocolErrReply("invalid multibulk length")
+	}
+	count, err := strconv.Atoi(utils.Bytes2String(msg[1 : len(msg)-2]))
+	if err != nil || count > maxMultiBulkLen {
+		return nil, true, reply.NewProtocolErrReply("invalid multibulk length")
+	}
+	if count <= 0 {
+		return nil, false, nil
+	}
+	args := make([][]byte, 0, min(count, maxPrealloc))
+	for len(args) < count {
+		line, _, err := r.readLine()
+		if err != nil {
+			return nil, true, err
+		}
+		if line[0] != '$' {
+			return nil, true, reply.NewProtocolErrReply("expected '$', got '" + string(line[0]) + "'")
+		}
+		arg, err := r.readBulk(line)
+		if err != nil {
+			return nil, true, err
+		}
+		if arg == nil {
+			return nil, true, reply.NewProtocolErrReply("invalid bulk length")
+		}
+		args = append(args, arg)
+	}
+	return reply.NewMultiBulkReply(args), false, nil
+}
+
+// parseAggregate 解析聚合类型, 递归读取其中的元素, 嵌套层数不能超过 maxNestingDepth
+//
+// 例如: *3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n
+func (r *Reader) parseAggregate(msgType byte, header string) (resp.Reply, bool, error) {
+	r.depth++
+	defer func() { r.depth-- }()
+	if r.depth > maxNestingDepth {
+		return nil, true, reply.NewProtocolErrReply("too deep nesting")
+	}
+	// 1. 解析元素个数, 键值对和属性的元素个数是声明的两倍
+	count, err := strconv.Atoi(header)
+	if err != nil || count < -1 || count > maxMultiBulkLen {
+		return nil, true, reply.NewProtocolErrReply("invalid multibulk length")
+	}
+	if count == -1 { // RESP2中的空数组
+		if msgType != '*' {
+			return nil, true, reply.NewProtocolErrReply("invalid multibulk length")
+		}
+		return reply.NewNullReply(), false, nil
+	}
+	if msgType == '*' {
+		return r.parseArray(count)
+	}
+	if msgType == '%' || msgType == '|' {
+		count *= 2
+	}
+	// 2. 递归读取元素
+	elements, err := r.readElements(make([]resp.Reply, 0, min(count, maxPrealloc)), count)
+	if err != nil {
+		return nil, true, err
+	}
+	// 3. 根据类型生成回复
+	switch msgType {
+	case '%':
+		return reply.NewMapReply(elements), false, nil
+	case '~':
+		return reply.NewSetReply(elements), false, nil
+	case '>':
+		return reply.NewPushReply(elements), false, nil
+	}
+	// 属性后面紧跟着真正的回复
+	res, err := r.readReply()
+	if err != nil {
+		return nil, true, err
+	}
+	return reply.NewAttributeReply(elements, res), false, nil
+}
+
+// parseArray 解析数组, 只包含字符串的数组解析为 MultiBulkReply, 否则解析为 MultiRawReply
+//
+// 命令都是字符串数组, 所以字符串直接读取为参数, 不创建中间的回复
+func (r *Reader) parseArray(count int) (resp.Reply, bool, error) {
+	if count == 0 {
+		return reply.NewEmptyMultiBulkReply(), false, nil
+	}
+	args := make([][]byte, 0, min(count, maxPrealloc))
+	for len(args) < count {
+		line, _, err := r.readLine()
+		if err != nil {
+			return nil, true, err
+		}
+		if line[0] != '$' { // 包含其他类型的元素, 已经读取的参数转换为回复, 空值转换为 NullBulkReply
+			elements := make([]resp.Reply, len(args), min(count, maxPrealloc))
+			for i, arg := range args {
+				if arg == nil {
+					elements[i] = reply.NewNullBulkReply()
+				} else {
+					elements[i] = reply.NewBulkReply(arg)
+				}
+			}
+			element, _, err := r.parseReply(line)
+			if err != nil {
+				return nil, true, err
+			}
+			if elements, err = r.readElements(append(elements, element), count); err != nil {
+				return nil, true, err
+			}
+			return reply.NewMultiRawReply(elements), false, nil
+		}
+		arg, err := r.readBulk(line)
+		if err != nil {
+			return nil, true, err
+		}
+		args = append(args, arg)
+	}
+	return reply.NewMultiBulkReply(args), false, nil
+}
+
+// readElements 读取元素追加到elements中, 直到一共有count个元素
+func (r *Reader) readElements(elements []resp.Reply, count int) ([]resp.Reply, error) {
+	for len(elements) < count {
+		element, err := r.readReply()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
@@ -17,6 +17,7 @@ var (
 	theEmptyMultiBulkReply *EmptyMultiBulkReply
 	theNoReply             *noReply
 	theQueuedReply         *queuedReply
+	theNullReply           *NullReply
 )
 
 func init() {
@@ -26,6 +27,7 @@ func init() {
 	theOKReply = new(okReply)
 	theNullBulkReply = new(NullBulkReply)
 	theQueuedReply = new(queuedReply)
+	theNullReply = new(NullReply)
 
 	replies = map[resp.Reply][]byte{
 		theNoReply:             utils.String2Bytes(enum.NO_REPLY),
@@ -34,6 +36,7 @@ func init() {
 		theOKReply:             utils.String2Bytes(enum.OK),
 		theNullBulkReply:       utils.String2Bytes(enum.NIL),
 		theQueuedReply:         queuedBytes,
+		theNullReply:           utils.String2Bytes(enum.NIL),
 	}
 }
 
@@ -75,6 +78,30 @@ func (reply *NullBulkReply) Bytes() []byte {
 	return replies[reply]
 }
 
+// Resp3Bytes RESP3中所有的空值都使用 _
+func (reply *NullBulkReply) Resp3Bytes() []byte {
+	return nullBytes
+}
+
+// NullReply 用于表示RESP3的空值, RESP2中降级为空的回复字符串
+type NullReply struct {
+}
+
+// NewNullReply 用于创建RESP3的空值
+func NewNullReply() resp.Reply {
+	return theNullReply
+}
+
+func (reply *NullReply) Bytes() []byte {
+	return replies[reply]
+}
+
+func (reply *NullReply) Resp3Bytes() []byte {
+	return nullBytes
+}
+
+var nullBytes = []byte(enum.NULL)
+
 // EmptyMultiBulkReply 用于表示空的多条批量回复数组
 type EmptyMultiBulkReply struct {
 }
@@ -135,11 +135,10 @@ func NewMultiRawReply(replies []resp.Reply) *MultiRawReply {
 
 // Bytes marshal redis.Reply
 func (r *MultiRawReply) Bytes() []byte {
-	argLen := len(r.Replies)
-	var buf bytes.Buffer
-	buf.WriteString("*" + strconv.Itoa(argLen) + enum.CRLF)
-	for _, re := range r.Replies {
-		buf.Write(re.Bytes())
-	}
-	return buf.Bytes()
+	return marshalAggregate('*', len(r.Replies), r.Replies, enum.RESP2)
+}
+
+// Resp3Bytes 数组的类型不变, 数组中的元素按RESP3编码
+func (r *MultiRawReply) Resp3Bytes() []byte {
+	return marshalAggregate('*', len(r.Replies), r.Replies, enum.RESP3)
 }
@@ -0,0 +1,259 @@
+package reply
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strconv"
+
+	"go-redis/enum"
+	"go-redis/interface/resp"
+)
+
+// RESP3新增的回复类型, 每种类型的 Bytes 返回降级后的RESP2编码, Resp3Bytes 返回RESP3编码
+//
+// 命令总是返回RESP3的类型, 写回客户端时由 Marshal 根据客户端协商的协议版本选择编码
+
+// Marshal 按协议版本编码回复, 只有协商了RESP3的客户端才使用RESP3编码
+func Marshal(r resp.Reply, protocol int) []byte {
+	if protocol >= enum.RESP3 {
+		if r3, ok := r.(resp.Resp3Reply); ok {
+			return r3.Resp3Bytes()
+		}
+	}
+	return r.Bytes()
+}
+
+// marshalAggregate 编码聚合类型: 类型符号, 长度, 元素
+func marshalAggregate(prefix byte, length int, replies []resp.Reply, protocol int) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(prefix)
+	buf.WriteString(strconv.Itoa(length))
+	buf.WriteString(enum.CRLF)
+	for _, re := range replies {
+		buf.Write(Marshal(re, protocol))
+	}
+	return buf.Bytes()
+}
+
+/**********************************************************************************************************************/
+
+// MapReply 用于表示键值对, Entries 中依次保存键和值, RESP2中降级为数组
+//
+// 例如: %2\r\n+first\r\n:1\r\n+second\r\n:2\r\n
+type MapReply struct {
+	Entries []resp.Reply
+}
+
+// NewMapReply creates MapReply, entries的长度必须是偶数
+func NewMapReply(entries []resp.Reply) *MapReply {
+	return &MapReply{Entries: entries}
+}
+
+// NewBulkMapReply 用字符串的键值对创建 MapReply
+func NewBulkMapReply(entries [][]byte) *MapReply {
+	replies := make([]resp.Reply, len(entries))
+	for i, entry := range entries {
+		replies[i] = NewBulkReply(entry)
+	}
+	return NewMapReply(replies)
+}
+
+func (r *MapReply) Bytes() []byte {
+	return marshalAggregate('*', len(r.Entries), r.Entries, enum.RESP2)
+}
+
+func (r *MapReply) Resp3Bytes() []byte {
+	return marshalAggregate('%', len(r.Entries)/2, r.Entries, enum.RESP3)
+}
+
+/**********************************************************************************************************************/
+
+// SetReply 用于表示无序且不重复的集合, RESP2中降级为数组
+//
+// 例如: ~2\r\n+a\r\n+b\r\n
+type SetReply struct {
+	Members []resp.Reply
+}
+
+// NewSetReply creates SetReply
+func NewSetReply(members []resp.Reply) *SetReply {
+	return &SetReply{Members: members}
+}
+
+// NewBulkSetReply 用字符串创建 SetReply
+func NewBulkSetReply(members [][]byte) *SetReply {
+	replies := make([]resp.Reply, len(members))
+	for i, member := range members {
+		replies[i] = NewBulkReply(member)
+	}
+	return NewSetReply(replies)
+}
+
+func (r *SetReply) Bytes() []byte {
+	return marshalAggregate('*', len(r.Members), r.Members, enum.RESP2)
+}
+
+func (r *SetReply) Resp3Bytes() []byte {
+	return marshalAggregate('~', len(r.Members), r.Members, enum.RESP3)
+}
+
+/**********************************************************************************************************************/
+
+// PushReply 是服务端主动发送给客户端的推送消息, 例如发布订阅的消息和客户端缓存的失效消息, RESP2中降级为数组
+//
+// 例如: >2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nkey\r\n
+type PushReply struct {
+	Replies []resp.Reply
+}
+
+// NewPushReply creates PushReply
+func NewPushReply(replies []resp.Reply) *PushReply {
+	return &PushReply{Replies: replies}
+}
+
+func (r *PushReply) Bytes() []byte {
+	return marshalAggregate('*', len(r.Replies), r.Replies, enum.RESP2)
+}
+
+func (r *PushReply) Resp3Bytes() []byte {
+	return marshalAggregate('>', len(r.Replies), r.Replies, enum.RESP3)
+}
+
+/**********************************************************************************************************************/
+
+// AttributeReply 是附加在回复前面的辅助信息, 客户端可以忽略, RESP2中只返回回复本身
+//
+// 例如: |1\r\n+ttl\r\n:100\r\n$5\r\nvalue\r\n
+type AttributeReply struct {
+	Attributes []resp.Reply // 依次保存键和值
+	Reply      resp.Reply
+}
+
+// NewAttributeReply creates AttributeReply
+func NewAttributeReply(attributes []resp.Reply, reply resp.Reply) *AttributeReply {
+	return &AttributeReply{Attributes: attributes, Reply: reply}
+}
+
+func (r *AttributeReply) Bytes() []byte {
+	return r.Reply.Bytes()
+}
+
+func (r *AttributeReply) Resp3Bytes() []byte {
+	buf := bytes.NewBuffer(marshalAggregate('|', len(r.Attributes)/2, r.Attributes, enum.RESP3))
+	buf.Write(Marshal(r.Reply, enum.RESP3))
+	return buf.Bytes()
+}
+
+/**********************************************************************************************************************/
+
+// DoubleReply 用于表示浮点数, RESP2中降级为字符串
+//
+// 例如: ,3.14\r\n, ,inf\r\n
+type DoubleReply struct {
+	Value float64
+}
+
+// NewDoubleReply creates DoubleReply
+func NewDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{Value: value}
+}
+
+// formatDouble 和RESP3保持一致, 无穷大和非数字使用 inf, -inf, nan
+func formatDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	case math.IsNaN(value):
+		return "nan"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (r *DoubleReply) Bytes() []byte {
+	return NewBulkReply([]byte(formatDouble(r.Value))).Bytes()
+}
+
+func (r *DoubleReply) Resp3Bytes() []byte {
+	return []byte("," + formatDouble(r.Value) + enum.CRLF)
+}
+
+/**********************************************************************************************************************/
+
+// BoolReply 用于表示布尔值, RESP2中降级为整数1和0
+//
+// 例如: #t\r\n, #f\r\n
+type BoolReply struct {
+	Value bool
+}
+
+// NewBoolReply creates BoolReply
+func NewBoolReply(value bool) *BoolReply {
+	return &BoolReply{Value: value}
+}
+
+func (r *BoolReply) Bytes() []byte {
+	if r.Value {
+		return []byte(":1" + enum.CRLF)
+	}
+	return []byte(":0" + enum.CRLF)
+}
+
+func (r *BoolReply) Resp3Bytes() []byte {
+	if r.Value {
+		return []byte("#t" + enum.CRLF)
+	}
+	return []byte("#f" + enum.CRLF)
+}
+
+/**********************************************************************************************************************/
+
+// BigNumberReply 用于表示超出64位整数范围的整数, RESP2中降级为字符串
+//
+// 例如: (3492890328409238509324850943850943825024385\r\n
+type BigNumberReply struct {
+	Value *big.Int
+}
+
+// NewBigNumberReply creates BigNumberReply
+func NewBigNumberReply(value *big.Int) *BigNumberReply {
+	return &BigNumberReply{Value: value}
+}
+
+func (r *BigNumberReply) Bytes() []byte {
+	return NewBulkReply([]byte(r.Value.String())).Bytes()
+}
+
+func (r *BigNumberReply) Resp3Bytes() []byte {
+	return []byte("(" + r.Value.String() + enum.CRLF)
+}
+
+/**********************************************************************************************************************/
+
+// VerbatimReply 用于表示带格式的文本, Format是3个字符的格式, 例如txt和mkd, RESP2中降级为字符串
+//
+// 例如: =15\r\ntxt:Some string\r\n
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+// NewVerbatimReply creates VerbatimReply
+func NewVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{Format: format, Text: text}
+}
+
+func (r *VerbatimReply) Bytes() []byte {
+	return NewBulkReply(r.Text).Bytes()
+}
+
+func (r *VerbatimReply) Resp3Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("=" + strconv.Itoa(len(r.Format)+1+len(r.Text)) + enum.CRLF)
+	buf.WriteString(r.Format + ":")
+	buf.Write(r.Text)
+	buf.WriteString(enum.CRLF)
+	return buf.Bytes()
+}
@@ -0,0 +1,40 @@
+package reply
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"go-redis/enum"
+	"go-redis/interface/resp"
+)
+
+func TestResp3Marshal(t *testing.T) {
+	tests := []struct {
+		reply resp.Reply
+		resp2 string
+		resp3 string
+	}{
+		{NewBulkMapReply([][]byte{[]byte("a"), []byte("1")}), "*2\r\n$1\r\na\r\n$1\r\n1\r\n", "%1\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{NewBulkSetReply([][]byte{[]byte("a")}), "*1\r\n$1\r\na\r\n", "~1\r\n$1\r\na\r\n"},
+		{NewPushReply([]resp.Reply{NewBulkReply([]byte("invalidate")), NewNullReply()}), "*2\r\n$10\r\ninvalidate\r\n$-1\r\n", ">2\r\n$10\r\ninvalidate\r\n_\r\n"},
+		{NewAttributeReply([]resp.Reply{NewStatusReply("ttl"), NewIntReply(100)}, NewIntReply(1)), ":1\r\n", "|1\r\n+ttl\r\n:100\r\n:1\r\n"},
+		{NewDoubleReply(3.14), "$4\r\n3.14\r\n", ",3.14\r\n"},
+		{NewDoubleReply(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{NewBoolReply(true), ":1\r\n", "#t\r\n"},
+		{NewBigNumberReply(new(big.Int).Lsh(big.NewInt(1), 64)), "$20\r\n18446744073709551616\r\n", "(18446744073709551616\r\n"},
+		{NewVerbatimReply("txt", []byte("Some string")), "$11\r\nSome string\r\n", "=15\r\ntxt:Some string\r\n"},
+		{NewNullBulkReply(), "$-1\r\n", "_\r\n"},
+		{NewIntReply(1), ":1\r\n", ":1\r\n"},
+		// 聚合类型中的元素按同一个协议编码
+		{NewMultiRawReply([]resp.Reply{NewDoubleReply(1), NewBoolReply(false)}), "*2\r\n$1\r\n1\r\n:0\r\n", "*2\r\n,1\r\n#f\r\n"},
+	}
+	for _, tt := range tests {
+		if actual := string(Marshal(tt.reply, enum.RESP2)); actual != tt.resp2 {
+			t.Errorf("resp2: expected %q, actually %q", tt.resp2, actual)
+		}
+		if actual := string(Marshal(tt.reply, enum.RESP3)); actual != tt.resp3 {
+			t.Errorf("resp3: expected %q, actually %q", tt.resp3, actual)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package slowlog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go-redis/config"
+	"go-redis/interface/db"
+	"go-redis/interface/resp"
+	"go-redis/latency"
+)
+
+// 和Redis一致, 每条记录最多保存32个参数, 每个参数最多保存128字节
+const (
+	maxArgc   = 32
+	maxArgLen = 128
+)
+
+// Entry SLOWLOG 中的一条记录
+type Entry struct {
+	ID         int64         // 递增的记录ID
+	Time       time.Time     // 命令开始执行的时间
+	Duration   time.Duration // 命令执行的时间
+	Args       []string      // 命令和参数, 超出长度的部分会被截断
+	ClientAddr string        // 客户端的地址
+	ClientName string        // 客户端的名称
+}
+
+var (
+	mu      sync.Mutex
+	entries []*Entry // 最新的记录在最前面
+	nextID  int64
+)
+
+// Enabled 判断执行时间为duration的命令是否需要记录, slowlog-log-slower-than 小于0时不记录, 为0时记录所有命令
+func Enabled(duration time.Duration) bool {
+	threshold := config.SlowlogLogSlowerThan()
+	return threshold >= 0 && duration >= time.Duration(threshold)*time.Microsecond
+}
+
+// Record 在命令执行结束之后调用, start是命令开始执行的时间, client可以为nil
+//
+// 执行时间超过 slowlog-log-slower-than 时添加一条记录, 记录的数量超过 slowlog-max-len 时删除最旧的记录;
+// 同时作为 command 事件交给延迟监控
+func Record(client resp.Connection, args db.CmdLine, start time.Time) {
+	duration := time.Since(start)
+	latency.AddSampleIfNeeded(latency.EventCommand, duration)
+	if !Enabled(duration) {
+		return
+	}
+	entry := &Entry{
+		Time:     start,
+		Duration: duration,
+		Args:     truncateArgs(args),
+	}
+	if client != nil {
+		entry.ClientAddr = client.RemoteAddr()
+		entry.ClientName = client.GetName()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	entry.ID = nextID
+	nextID++
+	entries = append([]*Entry{entry}, entries...)
+	if maxLen := config.SlowlogMaxLen(); len(entries) > maxLen {
+		entries = entries[:maxLen]
+	}
+}
+
+// truncateArgs 复制命令的参数, 参数过多或者过长时截断, 并说明截断的数量
+func truncateArgs(args db.CmdLine) []string {
+	argc := min(len(args), maxArgc)
+	result := make([]string, argc)
+	for i := 0; i < argc; i++ {
+		// 最后一个位置说明剩余的参数数量
+		if i == maxArgc-1 && len(args) > maxArgc {
+			result[i] = fmt.Sprintf("... (%d more arguments)", len(args)-maxArgc+1)
+			break
+		}
+		if arg := args[i]; len(arg) > maxArgLen {
+			result[i] = fmt.Sprintf("%s... (%d more bytes)", arg[:maxArgLen], len(arg)-maxArgLen)
+		} else {
+			result[i] = string(arg)
+		}
+	}
+	return result
+}
+
+// Get 返回最新的count条记录, count小于0时返回所有记录
+func Get(count int) []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if count < 0 || count > len(entries) {
+		count = len(entries)
+	}
+	result := make([]Entry, count)
+	for i := range result {
+		result[i] = *entries[i]
+	}
+	return result
+}
+
+// Len 返回记录的数量
+func Len() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(entries)
+}
+
+// Reset 清空所有记录
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	entries = nil
+}
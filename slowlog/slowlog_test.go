@@ -0,0 +1,62 @@
+package slowlog
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-redis/config"
+	"go-redis/lib/utils"
+)
+
+func TestRecord(t *testing.T) {
+	defer Reset()
+	defer config.Set("slowlog-log-slower-than", "10000", "slowlog-max-len", "128")
+
+	// 没有超过阈值的命令不记录
+	Record(nil, utils.ToCmdLine("get", "a"), time.Now())
+	if Len() != 0 {
+		t.Errorf("fast command should not be logged")
+	}
+	if err := config.Set("slowlog-log-slower-than", "0", "slowlog-max-len", "2"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		Record(nil, utils.ToCmdLine("set", "k", strconv.Itoa(i)), time.Now())
+	}
+	entries := Get(-1)
+	if len(entries) != 2 || entries[0].ID != 2 || entries[1].ID != 1 || entries[0].Args[2] != "2" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+	if entries = Get(1); len(entries) != 1 || entries[0].ID != 2 {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	// 不记录
+	if err := config.Set("slowlog-log-slower-than", "-1"); err != nil {
+		t.Fatal(err)
+	}
+	Record(nil, utils.ToCmdLine("get", "a"), time.Now().Add(-time.Second))
+	if Len() != 2 {
+		t.Errorf("slowlog should be disabled")
+	}
+}
+
+func TestTruncateArgs(t *testing.T) {
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = strconv.Itoa(i)
+	}
+	args[1] = strings.Repeat("x", 130)
+	result := truncateArgs(utils.ToCmdLine(args...))
+	if len(result) != maxArgc {
+		t.Fatalf("expected %d args, actually %d", maxArgc, len(result))
+	}
+	if expected := strings.Repeat("x", 128) + "... (2 more bytes)"; result[1] != expected {
+		t.Errorf("expected %q, actually %q", expected, result[1])
+	}
+	if expected := "... (9 more arguments)"; result[maxArgc-1] != expected {
+		t.Errorf("expected %q, actually %q", expected, result[maxArgc-1])
+	}
+}
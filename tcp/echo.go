@@ -90,3 +90,13 @@ func (handler *EchoHandler) Close() error {
 
 	return err
 }
+
+// GetConnCount returns the number of active connections.
+func (handler *EchoHandler) GetConnCount() int {
+	count := 0
+	handler.activeConn.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count
+}
@@ -0,0 +1,89 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"go-redis/config"
+	"go-redis/lib/logger"
+)
+
+const (
+	errMaxClients      = "-ERR max number of clients reached\r\n"
+	errMaxClientsPerIP = "-ERR max number of clients per IP reached\r\n"
+	rejectTimeout      = time.Second // 拒绝连接时写入错误的最长时间
+)
+
+// connLimiter 统计所有监听器接受的连接数量和每个IP的连接数量
+//
+// 连接在接受时计数, 处理结束时释放, 不依赖 handler 异步更新的计数, 同时到达的连接也不会超过限制
+type connLimiter struct {
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{perIP: make(map[string]int)}
+}
+
+// acquire 为新连接计数, 超过 max-clients 或者 max-clients-per-ip 时返回需要发送给客户端的错误
+//
+// 返回的ip用于 release, unix socket 的连接没有IP, 不受 max-clients-per-ip 限制
+func (l *connLimiter) acquire(conn net.Conn) (ip string, errMsg string) {
+	ip = remoteIP(conn)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if maxClients := config.MaxClients(); maxClients > 0 && l.total >= maxClients {
+		return "", errMaxClients
+	}
+	if maxPerIP := config.Properties.MaxClientsPerIP; maxPerIP > 0 && ip != "" && l.perIP[ip] >= maxPerIP {
+		return "", errMaxClientsPerIP
+	}
+	l.total++
+	if ip != "" {
+		l.perIP[ip]++
+	}
+	return ip, ""
+}
+
+// release 连接处理结束之后释放计数
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if ip == "" {
+		return
+	}
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// remoteIP 返回TCP连接的对端IP, 其他连接返回空字符串
+func remoteIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// reject 向客户端发送错误之后关闭连接, 写入超时之后直接关闭, 不会阻塞接受新的连接
+func reject(conn net.Conn, errMsg string) {
+	defer conn.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(rejectTimeout))
+	if _, err := conn.Write([]byte(errMsg)); err != nil {
+		logger.Warn("reject connection error:", err)
+	}
+}
+
+// listenConfig 根据 tcp-keepalive 配置TCP连接的keepalive, 为0时关闭keepalive
+func listenConfig() *net.ListenConfig {
+	keepAlive := time.Duration(config.Properties.TcpKeepAlive) * time.Second
+	if keepAlive <= 0 {
+		keepAlive = -1
+	}
+	return &net.ListenConfig{KeepAlive: keepAlive}
+}
@@ -2,21 +2,29 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 
-	"go-redis/config"
 	"go-redis/interface/tcp"
 	"go-redis/lib/logger"
 )
 
 // Config defines the configuration for the tcp server.
-// Address is the TCP address to listen on, ":6379" if empty.
+// Address is the TCP address to listen on, plaintext connections are disabled if empty.
+// TLSAddress is the TCP address to accept TLS connections with TLSConfig, TLS is disabled if empty.
+// UnixSocket is the path of the unix socket, UnixSocketPerm is the permission of the socket file, 0 means default.
 type Config struct {
-	Address string
+	Address        string
+	TLSAddress     string
+	TLSConfig      *tls.Config
+	UnixSocket     string
+	UnixSocketPerm os.FileMode
 }
 
 // ListenAndServeWithSignal listens on the TCP network address addr and then
@@ -39,15 +47,13 @@ func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 		}
 	}()
 
-	// listen for an incoming connection.
-	listen, err := net.Listen("tcp", cfg.Address)
+	listeners, err := listen(cfg)
 	if err != nil {
 		return err
 	}
 
-	go logger.Info("tcp server start listening on", cfg.Address)
 	// handle the connection in a new goroutine.
-	err = listenAndServe(listen, handler, closeChan)
+	err = listenAndServe(listeners, handler, closeChan)
 
 	if err != nil {
 		return err
@@ -56,34 +62,122 @@ func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	return nil
 }
 
-// listenAndServe listens on the TCP network address addr and then
+// listen creates the plaintext listener, the TLS listener and the unix socket listener according to cfg.
+func listen(cfg *Config) (listeners []net.Listener, err error) {
+	defer func() {
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+		}
+	}()
+	if cfg.Address != "" {
+		listener, err := listenConfig().Listen(context.Background(), "tcp", cfg.Address)
+		if err != nil {
+			return listeners, err
+		}
+		go logger.Info("tcp server start listening on", cfg.Address)
+		listeners = append(listeners, listener)
+	}
+	if cfg.TLSAddress != "" {
+		listener, err := listenConfig().Listen(context.Background(), "tcp", cfg.TLSAddress)
+		if err != nil {
+			return listeners, err
+		}
+		go logger.Info("tls server start listening on", cfg.TLSAddress)
+		listeners = append(listeners, tls.NewListener(listener, cfg.TLSConfig))
+	}
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			return listeners, err
+		}
+		go logger.Info("unix server start listening on", cfg.UnixSocket)
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("no address to listen on, port, tls-port and unixsocket are all disabled")
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on the unix socket path, the stale socket file left by the last run is removed first.
+// The socket file is removed when the listener is closed.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("unixsocket %s exists and is not a socket", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err = os.Chmod(path, perm); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// listenAndServe accepts connections from all listeners and then
 // calls Serve to handle requests on incoming connections.
-func listenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) error {
+func listenAndServe(listeners []net.Listener, handler tcp.Handler, closeChan <-chan struct{}) error {
 	go func() {
 		// wait for the close signal.
 		<-closeChan
-		// close the listener when the application closes.
-		go logger.Info("tcp server stop listening on", listener.Addr())
+		// close the listeners when the application closes.
+		for _, listener := range listeners {
+			go logger.Info("tcp server stop listening on", listener.Addr())
+		}
 		// close the listener and handler.
-		release(listener, handler)
+		release(listeners, handler)
 	}()
 
-	defer release(listener, handler)
+	defer release(listeners, handler)
 
 	ctx := context.Background()
 	wg := sync.WaitGroup{}
+	acceptWg := sync.WaitGroup{}
+	limiter := newConnLimiter()
+
+	for _, listener := range listeners {
+		acceptWg.Add(1)
+		go func(listener net.Listener) {
+			defer acceptWg.Done()
+			// the first listener that fails stops the whole server.
+			defer release(listeners, handler)
+			accept(ctx, listener, handler, limiter, &wg)
+		}(listener)
+	}
+	acceptWg.Wait()
+
+	// wait for all goroutines to complete.
+	wg.Wait()
 
+	return nil
+}
+
+// accept accepts connections from listener until it is closed.
+func accept(ctx context.Context, listener net.Listener, handler tcp.Handler, limiter *connLimiter, wg *sync.WaitGroup) {
 	for {
 		// listen for an incoming connection.
 		conn, err := listener.Accept()
 		if err != nil {
 			logger.Error(err)
-			break
+			return
 		}
 
-		// check max clients limit
-		if config.Properties.MaxClients != -1 && handler.GetConnCount() >= config.Properties.MaxClients {
-			logger.Error("exceed max clients limit", config.Properties.MaxClients)
+		// check max clients limit, reply an error and close the connection if exceeded.
+		ip, errMsg := limiter.acquire(conn)
+		if errMsg != "" {
+			go logger.Warn("reject connection", conn.RemoteAddr(), "for exceeding the limit of clients")
+			go reject(conn, errMsg)
 			continue
 		}
 
@@ -94,18 +188,16 @@ func listenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 
 		go func() {
 			defer wg.Done()
+			defer limiter.release(ip)
 			handler.Handle(ctx, conn)
 		}()
 	}
-
-	// wait for all goroutines to complete.
-	wg.Wait()
-
-	return nil
 }
 
-// release the listener and handler.
-func release(listener net.Listener, handler tcp.Handler) {
-	listener.Close()
+// release the listeners and handler.
+func release(listeners []net.Listener, handler tcp.Handler) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
 	handler.Close()
 }
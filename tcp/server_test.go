@@ -0,0 +1,298 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-redis/config"
+)
+
+// writeCert 生成由parent签发的证书, parent为nil时生成自签名的CA证书, 返回证书和私钥
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err = os.WriteFile(filepath.Join(dir, name+".crt"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, name+".key"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestTLSListener(t *testing.T) {
+	// 1. 生成CA和节点证书, 另一个CA签发的证书不能通过验证
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "node", ca, caKey)
+	otherCA, otherKey := writeCert(t, dir, "other-ca", nil, nil)
+	writeCert(t, dir, "other", otherCA, otherKey)
+
+	old := *config.Properties
+	defer func() { *config.Properties = old }()
+	config.Properties.TlsCertFile = filepath.Join(dir, "node.crt")
+	config.Properties.TlsKeyFile = filepath.Join(dir, "node.key")
+	config.Properties.TlsCaCertFile = filepath.Join(dir, "ca.crt")
+	config.Properties.TlsAuthClients = config.TlsAuthClientsYes
+
+	// 2. 同时监听明文和TLS连接
+	serverConfig, err := config.ServerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners, err := listen(&Config{Address: "127.0.0.1:0", TLSAddress: "127.0.0.1:0", TLSConfig: serverConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		_ = listenAndServe(listeners, NewEchoHandler(), closeChan)
+		close(done)
+	}()
+	plainAddr, tlsAddr := listeners[0].Addr().String(), listeners[1].Addr().String()
+
+	echo := func(conn net.Conn) string {
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte("ping\n")); err != nil {
+			return ""
+		}
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		return line
+	}
+
+	conn, err := net.Dial("tcp", plainAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := echo(conn); line != "response : ping\n" {
+		t.Errorf("plaintext echo: %q", line)
+	}
+
+	// 3. 双向认证
+	clientConfig, err := config.ClientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConn, err := tls.Dial("tcp", tlsAddr, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := echo(tlsConn); line != "response : ping\n" {
+		t.Errorf("tls echo: %q", line)
+	}
+
+	// 没有客户端证书或者证书不是CA签发的都会被拒绝
+	noCert := clientConfig.Clone()
+	noCert.Certificates = nil
+	otherCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "other.crt"), filepath.Join(dir, "other.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongCert := clientConfig.Clone()
+	wrongCert.Certificates = []tls.Certificate{otherCert}
+	for _, cfg := range []*tls.Config{noCert, wrongCert} {
+		conn, err := tls.Dial("tcp", tlsAddr, cfg)
+		if err != nil {
+			continue
+		}
+		// TLS 1.3 中客户端证书在握手之后才被验证, 读取时才能发现连接被拒绝
+		if line := echo(conn); line != "" {
+			t.Errorf("connection without a valid client certificate should be rejected")
+		}
+	}
+
+	close(closeChan)
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Errorf("server did not stop")
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	old := *config.Properties
+	defer func() { *config.Properties = old }()
+
+	config.Properties.TlsCertFile = ""
+	if _, err := config.ServerTLSConfig(); err == nil {
+		t.Errorf("expected error without certificate")
+	}
+
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "node", ca, caKey)
+	config.Properties.TlsCertFile = filepath.Join(dir, "node.crt")
+	config.Properties.TlsKeyFile = filepath.Join(dir, "node.key")
+	config.Properties.TlsCaCertFile = ""
+	config.Properties.TlsAuthClients = config.TlsAuthClientsNo
+	cfg, err := config.ServerTLSConfig()
+	if err != nil || cfg.ClientAuth != tls.NoClientCert {
+		t.Errorf("tls-auth-clients no should not require a CA: %v", err)
+	}
+	config.Properties.TlsAuthClients = config.TlsAuthClientsOptional
+	if _, err = config.ServerTLSConfig(); err == nil {
+		t.Errorf("expected error without tls-ca-cert-file")
+	}
+	config.Properties.TlsAuthClients = "maybe"
+	if _, err = config.ServerTLSConfig(); err == nil {
+		t.Errorf("expected error for invalid tls-auth-clients")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	// 上次运行遗留的socket文件会被删除
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listeners, err := listen(&Config{UnixSocket: path, UnixSocketPerm: 0700})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("expected perm 0700, actually %o", info.Mode().Perm())
+	}
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		_ = listenAndServe(listeners, NewEchoHandler(), closeChan)
+		close(done)
+	}()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); line != "response : ping\n" {
+		t.Errorf("unix echo: %q", line)
+	}
+	_ = conn.Close()
+
+	close(closeChan)
+	<-done
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file should be removed after close")
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	old := *config.Properties
+	defer func() { *config.Properties = old }()
+
+	listeners, err := listen(&Config{Address: "127.0.0.1:0", UnixSocket: filepath.Join(t.TempDir(), "redis.sock")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		_ = listenAndServe(listeners, NewEchoHandler(), closeChan)
+		close(done)
+	}()
+	defer func() {
+		close(closeChan)
+		<-done
+	}()
+	tcpAddr, unixAddr := listeners[0].Addr().String(), listeners[1].Addr().String()
+
+	// readLine 返回服务器发送的第一行, 连接被接受时服务器不会主动发送数据, 超时之后返回空字符串
+	readLine := func(conn net.Conn) string {
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		return line
+	}
+	dial := func(network, addr string) net.Conn {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	// 1. 超过 max-clients 时返回错误并关闭连接
+	config.Properties.MaxClients, config.Properties.MaxClientsPerIP = 1, 0
+	first := dial("tcp", tcpAddr)
+	if line := readLine(first); line != "" {
+		t.Errorf("first connection should be accepted, actually %q", line)
+	}
+	second := dial("tcp", tcpAddr)
+	if line := readLine(second); line != errMaxClients {
+		t.Errorf("expected %q, actually %q", errMaxClients, line)
+	}
+	_ = second.Close()
+	_ = first.Close()
+
+	// 2. 连接关闭之后释放计数, 每个IP的限制不影响unix socket
+	config.Properties.MaxClients, config.Properties.MaxClientsPerIP = 0, 1
+	time.Sleep(50 * time.Millisecond)
+	first = dial("tcp", tcpAddr)
+	defer first.Close()
+	if line := readLine(first); line != "" {
+		t.Errorf("connection should be accepted after the previous one closed, actually %q", line)
+	}
+	second = dial("tcp", tcpAddr)
+	if line := readLine(second); line != errMaxClientsPerIP {
+		t.Errorf("expected %q, actually %q", errMaxClientsPerIP, line)
+	}
+	_ = second.Close()
+	unixConn := dial("unix", unixAddr)
+	defer unixConn.Close()
+	if line := readLine(unixConn); line != "" {
+		t.Errorf("unix socket should not be limited per IP, actually %q", line)
+	}
+}
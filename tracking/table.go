@@ -0,0 +1,354 @@
+package tracking
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go-redis/enum"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/pubsub"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+// InvalidateChannel RESP2客户端订阅这个频道, 接收重定向过来的失效消息
+const InvalidateChannel = "__redis__:invalidate"
+
+var (
+	invalidateBytes        = []byte("invalidate")
+	messageBytes           = []byte("message")
+	invalidateChannelBytes = []byte(InvalidateChannel)
+)
+
+var (
+	errOptInAndOptOut = errors.New("You can't use both OPTIN and OPTOUT")
+	errBCastOptIn     = errors.New("OPTIN and OPTOUT are not compatible with BCAST")
+	errPrefixNoBCast  = errors.New("PREFIX option requires BCAST mode to be enabled")
+	errSwitchBCast    = errors.New("You can't switch BCAST mode on/off before disabling tracking " +
+		"for this client, and then re-enabling it with a different mode.")
+	errSwitchOptIn = errors.New("You can't switch OPTIN/OPTOUT mode before disabling tracking " +
+		"for this client, and then re-enabling it with a different mode.")
+	errRedirectNotExist = errors.New("The client ID you want redirect to does not exist")
+	errCachingMode      = errors.New("CLIENT CACHING can be called only when the client is in tracking mode " +
+		"with OPTIN or OPTOUT mode enabled")
+	errCachingYes = errors.New("CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+	errCachingNo  = errors.New("CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+)
+
+// Options 是 CLIENT TRACKING ON 的选项
+type Options struct {
+	Redirect int64    // 把失效消息发送给这个id的客户端, 为0时发送给自己
+	BCast    bool     // 广播模式, 不记录读过的key, 修改了匹配前缀的key就发送失效消息
+	Prefixes []string // 广播模式下关注的前缀, 为空时关注所有key
+	OptIn    bool     // 只记录 CLIENT CACHING YES 之后的下一条命令读过的key
+	OptOut   bool     // 不记录 CLIENT CACHING NO 之后的下一条命令读过的key
+	NoLoop   bool     // 不接收自己修改key产生的失效消息
+}
+
+// client 一个开启了追踪的客户端
+type client struct {
+	conn resp.Connection
+	Options
+	caching bool                // OPTIN模式下表示 CACHING YES, OPTOUT模式下表示 CACHING NO, 只对下一条命令有效
+	keys    map[string]struct{} // 默认模式下读过的key, 关闭追踪时从 Table.keys 中删除
+}
+
+// Table 记录开启了追踪的客户端以及它们关注的key, 并发安全
+//
+// 和redis一样, key不区分数据库, 在任意数据库中修改key都会发送失效消息
+type Table struct {
+	mu       sync.Mutex
+	hub      *pubsub.Hub                             // 查找重定向的目标客户端
+	clients  map[resp.Connection]*client             // 开启了追踪的客户端
+	keys     map[string]map[resp.Connection]struct{} // 默认模式: key -> 读过key的客户端
+	prefixes map[string]map[resp.Connection]struct{} // 广播模式: prefix -> 关注前缀的客户端
+	count    atomic.Int32                            // 开启了追踪的客户端数量, 为0时跳过所有操作
+}
+
+// NewTable 创建一个空的 Table
+func NewTable(hub *pubsub.Hub) *Table {
+	return &Table{
+		hub:      hub,
+		clients:  make(map[resp.Connection]*client),
+		keys:     make(map[string]map[resp.Connection]struct{}),
+		prefixes: make(map[string]map[resp.Connection]struct{}),
+	}
+}
+
+// Enable 开启客户端的追踪, 已经开启时更新选项并添加前缀
+func (t *Table) Enable(c resp.Connection, opts Options) error {
+	// 1. 检查选项
+	if opts.OptIn && opts.OptOut {
+		return errOptInAndOptOut
+	}
+	if opts.BCast && (opts.OptIn || opts.OptOut) {
+		return errBCastOptIn
+	}
+	if !opts.BCast && len(opts.Prefixes) > 0 {
+		return errPrefixNoBCast
+	}
+	// 和redis一样只要求目标客户端存在, 目标客户端可以之后再订阅 __redis__:invalidate
+	if opts.Redirect != 0 && !connection.Exists(opts.Redirect) {
+		return errRedirectNotExist
+	}
+	if opts.BCast && len(opts.Prefixes) == 0 {
+		opts.Prefixes = []string{""}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// 2. 已经开启追踪时不能切换模式
+	cl, ok := t.clients[c]
+	if ok {
+		if cl.BCast != opts.BCast {
+			return errSwitchBCast
+		}
+		if cl.OptIn != opts.OptIn || cl.OptOut != opts.OptOut {
+			return errSwitchOptIn
+		}
+	}
+	// 3. 同一个客户端的前缀不能重叠
+	var prefixes []string
+	if ok {
+		prefixes = slices.Clone(cl.Prefixes)
+	}
+	added := len(prefixes)
+	for _, prefix := range opts.Prefixes {
+		if slices.Contains(prefixes, prefix) {
+			continue
+		}
+		for _, other := range prefixes {
+			if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+				return errors.New("Prefix '" + prefix + "' overlaps with an existing prefix '" + other +
+					"'. Prefixes for a single client must not overlap.")
+			}
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	// 4. 保存客户端
+	if !ok {
+		cl = &client{conn: c, keys: make(map[string]struct{})}
+		t.clients[c] = cl
+		t.count.Add(1)
+	}
+	cl.Redirect, cl.BCast, cl.OptIn, cl.OptOut, cl.NoLoop = opts.Redirect, opts.BCast, opts.OptIn, opts.OptOut, opts.NoLoop
+	cl.Prefixes = prefixes
+	for _, prefix := range prefixes[added:] {
+		subs, exists := t.prefixes[prefix]
+		if !exists {
+			subs = make(map[resp.Connection]struct{})
+			t.prefixes[prefix] = subs
+		}
+		subs[c] = struct{}{}
+	}
+	return nil
+}
+
+// Disable 关闭客户端的追踪, 在客户端关闭时也要调用, 删除客户端读过的key和关注的前缀
+func (t *Table) Disable(c resp.Connection) {
+	if t.count.Load() == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cl, ok := t.clients[c]
+	if !ok {
+		return
+	}
+	for key := range cl.keys {
+		delete(t.keys[key], c)
+		if len(t.keys[key]) == 0 {
+			delete(t.keys, key)
+		}
+	}
+	for _, prefix := range cl.Prefixes {
+		delete(t.prefixes[prefix], c)
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+	delete(t.clients, c)
+	t.count.Add(-1)
+}
+
+// Caching 处理 CLIENT CACHING YES|NO, 只对下一条命令有效
+func (t *Table) Caching(c resp.Connection, yes bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cl, ok := t.clients[c]
+	if !ok || (!cl.OptIn && !cl.OptOut) {
+		return errCachingMode
+	}
+	if yes && !cl.OptIn {
+		return errCachingYes
+	}
+	if !yes && !cl.OptOut {
+		return errCachingNo
+	}
+	cl.caching = true
+	return nil
+}
+
+// GetRedirect 返回客户端重定向的目标id, 没有开启追踪返回-1, 没有重定向返回0
+func (t *Table) GetRedirect(c resp.Connection) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cl, ok := t.clients[c]
+	if !ok {
+		return -1
+	}
+	return cl.Redirect
+}
+
+// AfterCommand 在客户端执行完一条命令之后调用, 清除 CLIENT CACHING 的设置
+func (t *Table) AfterCommand(c resp.Connection) {
+	if t.count.Load() == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if cl, ok := t.clients[c]; ok {
+		cl.caching = false
+	}
+}
+
+// RememberKeys 记录默认模式的客户端读过的key, 调用者需要持有这些key的锁
+func (t *Table) RememberKeys(c resp.Connection, keys []string) {
+	if t.count.Load() == 0 || len(keys) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// 1. 广播模式不记录key
+	cl, ok := t.clients[c]
+	if !ok || cl.BCast {
+		return
+	}
+	// 2. OPTIN模式只记录 CACHING YES 之后的命令, OPTOUT模式不记录 CACHING NO 之后的命令
+	if (cl.OptIn && !cl.caching) || (cl.OptOut && cl.caching) {
+		return
+	}
+	// 3. 记录key
+	for _, key := range keys {
+		subs, exists := t.keys[key]
+		if !exists {
+			subs = make(map[resp.Connection]struct{})
+			t.keys[key] = subs
+		}
+		subs[c] = struct{}{}
+		cl.keys[key] = struct{}{}
+	}
+}
+
+// Invalidate 给关注这些key的客户端发送失效消息, caller是修改key的客户端, 可以为nil
+//
+// 默认模式下每个key只发送一次失效消息, 客户端再次读取key之后才会再次发送
+func (t *Table) Invalidate(caller resp.Connection, keys ...string) {
+	if t.count.Load() == 0 || len(keys) == 0 {
+		return
+	}
+	// 1. 在锁内收集每个客户端要失效的key
+	t.mu.Lock()
+	targets := make(map[resp.Connection]*target)
+	collect := func(cl *client, key string) {
+		tg, ok := targets[cl.conn]
+		if !ok {
+			tg = &target{conn: cl.conn, redirect: cl.Redirect}
+			targets[cl.conn] = tg
+		}
+		tg.keys = append(tg.keys, []byte(key))
+	}
+	for _, key := range keys {
+		// 1.1 默认模式, 发送之后删除记录
+		for c := range t.keys[key] {
+			cl := t.clients[c]
+			delete(cl.keys, key)
+			if cl.NoLoop && c == caller {
+				continue
+			}
+			collect(cl, key)
+		}
+		delete(t.keys, key)
+		// 1.2 广播模式
+		for prefix, subs := range t.prefixes {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			for c := range subs {
+				cl := t.clients[c]
+				if cl.NoLoop && c == caller {
+					continue
+				}
+				collect(cl, key)
+			}
+		}
+	}
+	t.mu.Unlock()
+	// 2. 在锁外发送, 防止慢客户端阻塞其他客户端
+	for _, tg := range targets {
+		t.send(tg)
+	}
+}
+
+// InvalidateAll 清空数据库时调用, 给所有开启追踪的客户端发送空的失效消息, 表示所有key都失效了
+func (t *Table) InvalidateAll() {
+	if t.count.Load() == 0 {
+		return
+	}
+	t.mu.Lock()
+	targets := make([]*target, 0, len(t.clients))
+	for c, cl := range t.clients {
+		targets = append(targets, &target{conn: c, redirect: cl.Redirect})
+		clear(cl.keys)
+	}
+	t.keys = make(map[string]map[resp.Connection]struct{})
+	t.mu.Unlock()
+
+	for _, tg := range targets {
+		t.send(tg)
+	}
+}
+
+// target 一条待发送的失效消息, 在锁内复制客户端的选项, 在锁外发送
+type target struct {
+	conn     resp.Connection
+	redirect int64
+	keys     [][]byte // 为nil时表示所有key都失效了
+}
+
+// send 发送失效消息
+//
+// 没有重定向时只给RESP3客户端发送推送消息: >2 invalidate [key ...], RESP2客户端必须使用重定向
+//
+// 重定向时以发布订阅消息的格式发送给订阅了 __redis__:invalidate 的目标客户端, 目标客户端不存在或者没有订阅时丢弃
+func (t *Table) send(tg *target) {
+	if tg.redirect == 0 {
+		if tg.conn.GetProtocol() < enum.RESP3 {
+			return
+		}
+		payload := utils.If[resp.Reply](tg.keys == nil, reply.NewNullReply(), reply.NewMultiBulkReply(tg.keys))
+		msg := reply.NewPushReply([]resp.Reply{reply.NewBulkReply(invalidateBytes), payload})
+		_, _ = tg.conn.Write(msg.Resp3Bytes())
+		return
+	}
+	redirect := t.hub.Subscriber(InvalidateChannel, tg.redirect)
+	if redirect == nil {
+		return
+	}
+	payload := utils.If[resp.Reply](tg.keys == nil, reply.NewNullReply(), reply.NewMultiBulkReply(tg.keys))
+	msg := reply.NewPushReply([]resp.Reply{
+		reply.NewBulkReply(messageBytes),
+		reply.NewBulkReply(invalidateChannelBytes),
+		payload,
+	})
+	_, _ = redirect.Write(reply.Marshal(msg, redirect.GetProtocol()))
+}
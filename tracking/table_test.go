@@ -0,0 +1,154 @@
+package tracking
+
+import (
+	"testing"
+
+	"go-redis/enum"
+	"go-redis/lib/utils"
+	"go-redis/pubsub"
+	"go-redis/resp/connection"
+)
+
+func TestDefaultMode(t *testing.T) {
+	table := NewTable(pubsub.NewHub())
+	c1, c2 := connection.NewFakeConn(), connection.NewFakeConn()
+	c1.SetProtocol(enum.RESP3)
+	c2.SetProtocol(enum.RESP3)
+	if err := table.Enable(c1, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	table.RememberKeys(c1, []string{"a", "b"})
+	table.RememberKeys(c2, []string{"a"}) // 没有开启追踪
+
+	table.Invalidate(nil, "a", "c")
+	expected := ">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\na\r\n"
+	if string(c1.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, c1.Bytes())
+	}
+	if len(c2.Bytes()) != 0 {
+		t.Errorf("unexpected message %q", c2.Bytes())
+	}
+	// 每个key只失效一次
+	c1.Clean()
+	table.Invalidate(nil, "a")
+	if len(c1.Bytes()) != 0 {
+		t.Errorf("unexpected message %q", c1.Bytes())
+	}
+	// 清空数据库
+	table.InvalidateAll()
+	expected = ">2\r\n$10\r\ninvalidate\r\n_\r\n"
+	if string(c1.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, c1.Bytes())
+	}
+	// 关闭追踪之后不再发送
+	c1.Clean()
+	table.RememberKeys(c1, []string{"b", "d"})
+	table.Disable(c1)
+	// 关闭追踪时删除客户端读过的key
+	if len(table.keys) != 0 {
+		t.Errorf("keys of disabled client should be removed, actually %v", table.keys)
+	}
+	table.Invalidate(nil, "b")
+	if len(c1.Bytes()) != 0 {
+		t.Errorf("unexpected message %q", c1.Bytes())
+	}
+}
+
+func TestBCastAndNoLoop(t *testing.T) {
+	table := NewTable(pubsub.NewHub())
+	c1, c2 := connection.NewFakeConn(), connection.NewFakeConn()
+	c1.SetProtocol(enum.RESP3)
+	c2.SetProtocol(enum.RESP3)
+	if err := table.Enable(c1, Options{BCast: true, Prefixes: []string{"user:"}, NoLoop: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Enable(c2, Options{BCast: true}); err != nil {
+		t.Fatal(err)
+	}
+	// c1修改了key, 设置了NOLOOP所以不会收到自己的消息
+	table.Invalidate(c1, "user:1", "order:1")
+	if len(c1.Bytes()) != 0 {
+		t.Errorf("unexpected message %q", c1.Bytes())
+	}
+	expected := ">2\r\n$10\r\ninvalidate\r\n*2\r\n$6\r\nuser:1\r\n$7\r\norder:1\r\n"
+	if string(c2.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, c2.Bytes())
+	}
+	// 广播模式每次修改都会发送
+	c2.Clean()
+	table.Invalidate(c2, "user:1")
+	expected = ">2\r\n$10\r\ninvalidate\r\n*1\r\n$6\r\nuser:1\r\n"
+	if string(c1.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, c1.Bytes())
+	}
+	if string(c2.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, c2.Bytes())
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	hub := pubsub.NewHub()
+	table := NewTable(hub)
+	c, target := connection.NewFakeConn(), connection.NewFakeConn()
+	if err := table.Enable(c, Options{Redirect: target.GetID() + 1000}); err != errRedirectNotExist {
+		t.Errorf("expected %v, actually %v", errRedirectNotExist, err)
+	}
+	// 目标客户端存在就可以重定向, 订阅之前的失效消息被丢弃
+	if err := table.Enable(c, Options{Redirect: target.GetID()}); err != nil {
+		t.Fatal(err)
+	}
+	if table.GetRedirect(c) != target.GetID() {
+		t.Errorf("unexpected redirect %d", table.GetRedirect(c))
+	}
+	table.RememberKeys(c, []string{"k"})
+	table.Invalidate(nil, "k")
+	if len(target.Bytes()) != 0 {
+		t.Errorf("unexpected message %q", target.Bytes())
+	}
+	hub.Subscribe(target, utils.ToCmdLine(InvalidateChannel))
+	target.Clean()
+	table.RememberKeys(c, []string{"k"})
+	table.Invalidate(nil, "k")
+	expected := "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$1\r\nk\r\n"
+	if string(target.Bytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, target.Bytes())
+	}
+	if len(c.Bytes()) != 0 {
+		t.Errorf("unexpected message %q", c.Bytes())
+	}
+	// 关闭的客户端不能作为重定向的目标
+	_ = target.Close()
+	if err := table.Enable(c, Options{Redirect: target.GetID()}); err != errRedirectNotExist {
+		t.Errorf("expected %v, actually %v", errRedirectNotExist, err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	table := NewTable(pubsub.NewHub())
+	c := connection.NewFakeConn()
+	cases := []struct {
+		opts Options
+		err  error
+	}{
+		{Options{OptIn: true, OptOut: true}, errOptInAndOptOut},
+		{Options{BCast: true, OptIn: true}, errBCastOptIn},
+		{Options{Prefixes: []string{"a"}}, errPrefixNoBCast},
+	}
+	for _, cs := range cases {
+		if err := table.Enable(c, cs.opts); err != cs.err {
+			t.Errorf("expected %v, actually %v", cs.err, err)
+		}
+	}
+	if err := table.Enable(c, Options{BCast: true, Prefixes: []string{"ab"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Enable(c, Options{BCast: true, Prefixes: []string{"abc"}}); err == nil {
+		t.Error("expected overlap error")
+	}
+	if err := table.Enable(c, Options{}); err != errSwitchBCast {
+		t.Errorf("expected %v, actually %v", errSwitchBCast, err)
+	}
+	if err := table.Caching(c, true); err != errCachingMode {
+		t.Errorf("expected %v, actually %v", errCachingMode, err)
+	}
+}